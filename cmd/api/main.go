@@ -15,6 +15,7 @@ import (
 	"github.com/opiagile/direito-lux/internal/database"
 	"github.com/opiagile/direito-lux/internal/handlers"
 	"github.com/opiagile/direito-lux/internal/middleware"
+	"github.com/opiagile/direito-lux/internal/payments"
 	"github.com/opiagile/direito-lux/internal/repository"
 	"github.com/opiagile/direito-lux/internal/services"
 	"github.com/opiagile/direito-lux/pkg/logger"
@@ -62,37 +63,159 @@ func main() {
 	}
 
 	var redisClient *redis.Client
-	var keycloakClient *auth.KeycloakClient
+	var identityProvider auth.IdentityProvider
+	var tokenValidator *auth.TokenValidator
 	var repos *repository.Repositories
 	var tenantService *services.TenantService
+	var apiKeyService *services.APIKeyService
+	var userService *services.UserService
+	var reconciliationService *services.ReconciliationService
+	var impersonationService *services.ImpersonationService
+	var sessionService *services.SessionService
+	var mfaService *services.MFAService
+	var loginGuard *services.LoginGuardService
+	var tenantDomainService *services.TenantDomainService
+	var tenantLifecycleService *services.TenantLifecycleService
+	var subscriptionService *services.SubscriptionService
+	var planService *services.PlanService
+	var usageMeter *services.UsageMeter
+	var quotaService *services.QuotaService
+	var invoiceService *services.InvoiceService
+	var paymentService *services.PaymentService
+
+	// Background jobs stop when the server shuts down
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
 
 	if !demoMode {
 		// Initialize Redis
 		redisClient = initRedis(cfg)
 		defer redisClient.Close()
 
-		// Initialize Keycloak client
-		keycloakClient = auth.NewKeycloakClient(&cfg.Keycloak)
+		// Initialize identity provider
+		identityProvider, err = initIdentityProvider(cfg)
+		if err != nil {
+			logger.Fatal("Failed to initialize identity provider", zap.Error(err))
+		}
+
+		// Initialize token validator
+		tokenValidator, err = auth.NewTokenValidator(identityProvider, redisClient, &cfg.JWT)
+		if err != nil {
+			logger.Fatal("Failed to initialize token validator", zap.Error(err))
+		}
+		logger.Info("Token validation configured", zap.String("strategy", tokenValidator.Strategy()))
+
+		impersonationSigner, err := auth.NewImpersonationSigner(cfg.Impersonation.SigningKey)
+		if err != nil {
+			logger.Fatal("Failed to initialize impersonation signer", zap.Error(err))
+		}
+		tokenValidator.EnableImpersonation(impersonationSigner)
+		tokenValidator.EnableServiceAccounts(cfg.ServiceAuth.Clients)
 
 		// Initialize repositories
 		repos = repository.NewRepositories(db)
 
 		// Initialize services
-		tenantService = services.NewTenantService(db, keycloakClient)
+		usageMeter = services.NewUsageMeter(db, redisClient, &cfg.Billing)
+		tenantService = services.NewTenantService(db, identityProvider, usageMeter, &cfg.Billing)
+		apiKeyService = services.NewAPIKeyService(db)
+		userService = services.NewUserService(db, identityProvider)
+		reconciliationService, err = services.NewReconciliationService(db, identityProvider, cfg.Reconciliation.Policy)
+		if err != nil {
+			logger.Fatal("Failed to initialize reconciliation service", zap.Error(err))
+		}
+		impersonationService = services.NewImpersonationService(db, redisClient, impersonationSigner, &cfg.Impersonation)
+		sessionService = services.NewSessionService(db, identityProvider, redisClient)
+		mfaService = services.NewMFAService(db, identityProvider, redisClient)
+		loginGuard = services.NewLoginGuardService(db, redisClient, services.NewLogNotifier(), &cfg.LoginProtection)
+		tenantDomainService = services.NewTenantDomainService(db, redisClient, &cfg.Tenancy)
+		tenantLifecycleService = services.NewTenantLifecycleService(db, identityProvider, redisClient, &cfg.Tenancy)
+		subscriptionService = services.NewSubscriptionService(db, identityProvider, tenantLifecycleService, services.NewLogNotifier(), &cfg.Billing)
+		planService = services.NewPlanService(db, subscriptionService)
+		quotaService = services.NewQuotaService(db, redisClient, usageMeter, subscriptionService)
+		subscriptionService.EnableQuotaChecks(quotaService)
+		invoiceService = services.NewInvoiceService(db, subscriptionService, &cfg.Billing)
+
+		paymentGateway, err := initPaymentGateway(cfg)
+		if err != nil {
+			logger.Fatal("Failed to initialize payment gateway", zap.Error(err))
+		}
+		if paymentGateway != nil {
+			subscriptionService.EnableGatewayBilling(paymentGateway)
+		}
+		paymentConfirmations, err := initPaymentConfirmations(cfg)
+		if err != nil {
+			logger.Fatal("Failed to initialize payment confirmations", zap.Error(err))
+		}
+		paymentService = services.NewPaymentService(db, paymentGateway, paymentConfirmations, subscriptionService, invoiceService, &cfg.Payments)
+		dunningService := services.NewDunningService(db, paymentGateway, subscriptionService, tenantLifecycleService, services.NewLogNotifier(), &cfg.Billing.Dunning)
+
+		// The seeded local admin needs its tenant row to pass ScopeTenant
+		if memoryProvider, ok := identityProvider.(*auth.MemoryIdentityProvider); ok && cfg.Identity.AdminEmail != "" {
+			if err := seedAdminTenant(context.Background(), memoryProvider, tenantService, cfg); err != nil {
+				logger.Fatal("Failed to seed admin tenant", zap.Error(err))
+			}
+		}
+
+		// Start background jobs
+		if cfg.Reconciliation.Enabled && cfg.Reconciliation.Interval > 0 {
+			go reconciliationService.Start(jobsCtx, cfg.Reconciliation.Interval)
+		}
+		if cfg.Tenancy.PurgeInterval > 0 {
+			go tenantLifecycleService.Start(jobsCtx, cfg.Tenancy.PurgeInterval)
+		}
+		if cfg.Tenancy.ProvisioningCleanupInterval > 0 {
+			go tenantService.StartProvisioningCleanup(jobsCtx, cfg.Tenancy.ProvisioningCleanupInterval, cfg.Tenancy.ProvisioningStaleAfter)
+		}
+		if cfg.Billing.SchedulerInterval > 0 {
+			go subscriptionService.Start(jobsCtx, cfg.Billing.SchedulerInterval)
+			go invoiceService.Start(jobsCtx, cfg.Billing.SchedulerInterval)
+			go dunningService.Start(jobsCtx, cfg.Billing.SchedulerInterval)
+		}
+		if cfg.Billing.UsageFlushInterval > 0 {
+			go usageMeter.Start(jobsCtx, cfg.Billing.UsageFlushInterval)
+		}
 	} else {
-		logger.Info("Skipping Redis, Keycloak, and services initialization in demo mode")
+		logger.Info("Skipping Redis, identity provider, and services initialization in demo mode")
 	}
 	// Add more services as needed
 
+	deps := &routerDeps{
+		identityProvider:    identityProvider,
+		tokenValidator:      tokenValidator,
+		redisClient:         redisClient,
+		repos:               repos,
+		apiKeyService:       apiKeyService,
+		userService:         userService,
+		mfaService:          mfaService,
+		loginGuard:          loginGuard,
+		tenantDomainService: tenantDomainService,
+		usageMeter:          usageMeter,
+		quotaService:        quotaService,
+	}
+
 	// Initialize handlers
-	var tenantHandler *handlers.TenantHandler
 	if !demoMode && tenantService != nil {
-		tenantHandler = handlers.NewTenantHandler(tenantService)
+		deps.tenantHandler = handlers.NewTenantHandler(tenantService)
+		deps.apiKeyHandler = handlers.NewAPIKeyHandler(apiKeyService, tenantService)
+		deps.userHandler = handlers.NewUserHandler(userService, tenantService)
+		deps.reconciliationHandler = handlers.NewReconciliationHandler(reconciliationService)
+		deps.impersonationHandler = handlers.NewImpersonationHandler(impersonationService)
+		deps.sessionHandler = handlers.NewSessionHandler(sessionService, tenantService)
+		deps.mfaHandler = handlers.NewMFAHandler(mfaService, tenantService)
+		deps.lockoutHandler = handlers.NewLockoutHandler(loginGuard, tenantService)
+		deps.tenantDomainHandler = handlers.NewTenantDomainHandler(tenantDomainService, tenantService)
+		deps.tenantLifecycleHandler = handlers.NewTenantLifecycleHandler(tenantLifecycleService, tenantService)
+		deps.subscriptionHandler = handlers.NewSubscriptionHandler(subscriptionService, tenantService)
+		deps.planHandler = handlers.NewPlanHandler(planService)
+		deps.usageHandler = handlers.NewUsageHandler(usageMeter, quotaService, tenantService)
+		deps.invoiceHandler = handlers.NewInvoiceHandler(invoiceService, tenantService)
+		deps.paymentHandler = handlers.NewPaymentHandler(paymentService, tenantService)
 	}
 	// Add more handlers as needed
 
 	// Setup router
-	router := setupRouter(cfg, keycloakClient, redisClient, repos, tenantHandler, demoMode)
+	router := setupRouter(cfg, deps, demoMode)
 
 	// Start server
 	srv := &http.Server{
@@ -117,6 +240,7 @@ func main() {
 	<-quit
 
 	logger.Info("Shutting down server...")
+	stopJobs()
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -137,8 +261,13 @@ func initDatabase(cfg *config.Config) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	// Scope queries on tenant-owned models to the tenant of the request context
+	if err := db.Use(database.TenantScope{}); err != nil {
+		return nil, fmt.Errorf("failed to register tenant scope: %w", err)
+	}
+
 	// Run migrations using the new migration system
-	migrationManager := database.NewMigrationManager(db)
+	migrationManager := database.NewMigrationManager(db, cfg.Billing.TrialDays)
 	if err := migrationManager.RunMigrations(); err != nil {
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
@@ -147,6 +276,93 @@ func initDatabase(cfg *config.Config) (*gorm.DB, error) {
 	return db, nil
 }
 
+// initPaymentGateway returns the configured payment gateway, or nil when card
+// payments are disabled
+func initPaymentGateway(cfg *config.Config) (payments.Gateway, error) {
+	switch cfg.Payments.Provider {
+	case "":
+		logger.Info("Payment gateway disabled")
+		return nil, nil
+	case payments.GatewayStripe:
+		if cfg.Payments.Stripe.SecretKey == "" || cfg.Payments.Stripe.WebhookSecret == "" {
+			return nil, fmt.Errorf("the stripe gateway requires a secret key and a webhook secret")
+		}
+		logger.Info("Payment gateway configured",
+			zap.String("provider", payments.GatewayStripe),
+			zap.String("apiURL", cfg.Payments.Stripe.APIURL))
+		return payments.NewStripeGateway(&cfg.Payments.Stripe), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider: %s", cfg.Payments.Provider)
+	}
+}
+
+// initPaymentConfirmations returns the provider of the Pix and boleto payment
+// confirmations, or nil when they are not received
+func initPaymentConfirmations(cfg *config.Config) (payments.ConfirmationProvider, error) {
+	confirmations := cfg.Payments.Confirmations
+	switch confirmations.Provider {
+	case "":
+		logger.Info("Payment confirmations disabled")
+		return nil, nil
+	case payments.ConfirmationsSigned:
+		if confirmations.Secret == "" {
+			return nil, fmt.Errorf("signed payment confirmations require a secret")
+		}
+		logger.Info("Payment confirmations configured", zap.String("provider", payments.ConfirmationsSigned))
+		return payments.NewSignedConfirmations(&confirmations), nil
+	default:
+		return nil, fmt.Errorf("unknown payment confirmations provider: %s", confirmations.Provider)
+	}
+}
+
+func seedAdminTenant(ctx context.Context, provider *auth.MemoryIdentityProvider, tenantService *services.TenantService, cfg *config.Config) error {
+	groupID, err := provider.TenantGroupID(cfg.Identity.AdminTenant)
+	if err != nil {
+		return err
+	}
+
+	tenant, err := tenantService.SeedTenant(ctx, cfg.Identity.AdminTenant, groupID)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Admin tenant ready", zap.String("tenant", tenant.Name), zap.String("tenantID", tenant.ID.String()))
+	return nil
+}
+
+func initIdentityProvider(cfg *config.Config) (auth.IdentityProvider, error) {
+	switch cfg.Identity.Provider {
+	case "", auth.IdentityProviderKeycloak:
+		return auth.NewKeycloakClient(&cfg.Keycloak), nil
+	case auth.IdentityProviderMemory:
+		if cfg.Server.Mode == "release" {
+			return nil, fmt.Errorf("the memory identity provider cannot run in release mode")
+		}
+
+		issuer := cfg.JWT.Issuer
+		if issuer == "" {
+			issuer = "direito-lux-memory"
+		}
+		provider, err := auth.NewMemoryIdentityProvider(issuer, cfg.Keycloak.ClientID)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.Identity.AdminEmail != "" {
+			if _, err := provider.SeedUser(context.Background(), cfg.Identity.AdminEmail, cfg.Identity.AdminPassword,
+				cfg.Identity.AdminTenant, "super_admin", "admin"); err != nil {
+				return nil, fmt.Errorf("failed to seed admin user: %w", err)
+			}
+		}
+
+		logger.Warn("Using in-memory identity provider, users and sessions are lost on restart",
+			zap.String("issuer", issuer))
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("unknown identity provider: %s", cfg.Identity.Provider)
+	}
+}
+
 func initRedis(cfg *config.Config) *redis.Client {
 	client := redis.NewClient(&redis.Options{
 		Addr:         cfg.GetRedisAddr(),
@@ -167,14 +383,38 @@ func initRedis(cfg *config.Config) *redis.Client {
 	return client
 }
 
-func setupRouter(
-	cfg *config.Config,
-	keycloakClient *auth.KeycloakClient,
-	redisClient *redis.Client,
-	repos *repository.Repositories,
-	tenantHandler *handlers.TenantHandler,
-	demoMode bool,
-) *gin.Engine {
+// routerDeps groups the clients, services and handlers used by the routes
+type routerDeps struct {
+	identityProvider    auth.IdentityProvider
+	tokenValidator      *auth.TokenValidator
+	redisClient         *redis.Client
+	repos               *repository.Repositories
+	apiKeyService       *services.APIKeyService
+	userService         *services.UserService
+	mfaService          *services.MFAService
+	loginGuard          *services.LoginGuardService
+	tenantDomainService *services.TenantDomainService
+	usageMeter          *services.UsageMeter
+	quotaService        *services.QuotaService
+
+	tenantHandler          *handlers.TenantHandler
+	apiKeyHandler          *handlers.APIKeyHandler
+	userHandler            *handlers.UserHandler
+	reconciliationHandler  *handlers.ReconciliationHandler
+	impersonationHandler   *handlers.ImpersonationHandler
+	sessionHandler         *handlers.SessionHandler
+	mfaHandler             *handlers.MFAHandler
+	lockoutHandler         *handlers.LockoutHandler
+	tenantDomainHandler    *handlers.TenantDomainHandler
+	tenantLifecycleHandler *handlers.TenantLifecycleHandler
+	subscriptionHandler    *handlers.SubscriptionHandler
+	planHandler            *handlers.PlanHandler
+	usageHandler           *handlers.UsageHandler
+	invoiceHandler         *handlers.InvoiceHandler
+	paymentHandler         *handlers.PaymentHandler
+}
+
+func setupRouter(cfg *config.Config, deps *routerDeps, demoMode bool) *gin.Engine {
 	if cfg.Server.Mode == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -202,35 +442,147 @@ func setupRouter(
 	})
 
 	if !demoMode {
+		// Scraped by Prometheus (infrastructure/prometheus/prometheus.yml)
+		router.GET("/metrics", handlers.PrometheusMetrics(deps.tokenValidator))
+
 		// API v1 routes (only in full mode)
 		v1 := router.Group("/api/v1")
+		// Tenant served at the request host, checked against the token tenant by the auth middlewares
+		v1.Use(middleware.ResolveTenant(deps.tenantDomainService))
 		{
 			// Public routes
 			public := v1.Group("")
 			{
-				public.POST("/auth/login", handlers.Login(keycloakClient))
-				public.POST("/auth/refresh", handlers.RefreshToken(keycloakClient))
-				public.POST("/auth/forgot-password", handlers.ForgotPassword(keycloakClient))
+				public.POST("/auth/login", handlers.Login(deps.identityProvider, deps.loginGuard))
+				public.POST("/auth/totp/setup", handlers.SetupTOTP(deps.identityProvider, deps.loginGuard))
+				public.POST("/auth/refresh", handlers.RefreshToken(deps.identityProvider))
+				public.POST("/auth/forgot-password", handlers.ForgotPassword(deps.identityProvider))
+				public.GET("/branding", handlers.GetBranding())
+				// Authenticated by the gateway signature
+				public.POST("/webhooks/stripe", deps.paymentHandler.StripeWebhook)
+				public.POST("/webhooks/payments", deps.paymentHandler.PaymentConfirmationWebhook)
+			}
+
+			// Tenant management (admin only). API keys and service clients only reach the
+			// read-only routes of tenantReads, with the "tenants:read" scope.
+			tenantRoutes := v1.Group("/tenants")
+			tenantRoutes.Use(middleware.AuthOrAPIKey(deps.tokenValidator, deps.apiKeyService, deps.mfaService, deps.redisClient, "tenants"))
+			tenantRoutes.Use(middleware.ScopeTenant(deps.tenantDomainService))
+			tenantRoutes.Use(middleware.EnforceQuota(deps.quotaService, services.QuotaAPICalls))
+			tenantRoutes.Use(middleware.MeterRequests(deps.usageMeter))
+
+			tenantReads := tenantRoutes.Group("")
+			tenantReads.Use(middleware.RequireRoleOrScope("admin"))
+			{
+				tenantReads.GET("/:id", deps.tenantHandler.GetTenant)
+				tenantReads.GET("/:id/usage", deps.tenantHandler.GetTenantUsage)
+				tenantReads.GET("/:id/quotas", deps.usageHandler.GetTenantQuotas)
+				tenantReads.GET("/:id/overage", deps.usageHandler.GetTenantOverage)
+				tenantReads.GET("/:id/subscription", deps.subscriptionHandler.GetSubscription)
+				tenantReads.GET("/:id/invoices", deps.invoiceHandler.ListInvoices)
+				tenantReads.GET("/:id/invoices/:invoiceId", deps.invoiceHandler.GetInvoice)
+				tenantReads.GET("/:id/invoices/:invoiceId/pdf", deps.invoiceHandler.DownloadInvoice)
+			}
+
+			tenants := tenantRoutes.Group("")
+			tenants.Use(middleware.RequireRole("admin"))
+			{
+				tenants.POST("", deps.tenantHandler.CreateTenant)
+				tenants.GET("", deps.tenantHandler.ListTenants)
+				tenants.PUT("/:id", deps.tenantHandler.UpdateTenant)
+				tenants.DELETE("/:id", deps.tenantLifecycleHandler.DeleteTenant)
+				tenants.POST("/:id/suspend", deps.tenantLifecycleHandler.SuspendTenant)
+				tenants.POST("/:id/reactivate", deps.tenantLifecycleHandler.ReactivateTenant)
+				tenants.POST("/:id/offboard", deps.tenantLifecycleHandler.OffboardTenant)
+				tenants.GET("/:id/export", deps.tenantLifecycleHandler.DownloadExport)
+				tenants.PUT("/:id/subscription/status", deps.subscriptionHandler.UpdateSubscriptionStatus)
+				tenants.POST("/:id/subscription/plan", deps.subscriptionHandler.ChangePlan)
+				tenants.GET("/:id/subscription/plan-changes", deps.subscriptionHandler.ListPlanChanges)
+				tenants.DELETE("/:id/subscription/plan-change", deps.subscriptionHandler.CancelPlanChange)
+				tenants.POST("/:id/subscription/payment-method", deps.paymentHandler.SetupPayment)
+				tenants.PUT("/:id/subscription/payment-method", deps.paymentHandler.SetPaymentMethod)
+				tenants.PUT("/:id/legal-entity", deps.invoiceHandler.SetLegalEntity)
+				tenants.POST("/:id/invoices/:invoiceId/void", deps.invoiceHandler.VoidInvoice)
+				tenants.POST("/:id/invoices/:invoiceId/pay", deps.invoiceHandler.MarkInvoicePaid)
+				tenants.GET("/:id/invoices/:invoiceId/pix", deps.paymentHandler.GetInvoicePix)
+				tenants.GET("/:id/invoices/:invoiceId/pix/qrcode", deps.paymentHandler.GetInvoicePixQRCode)
+				tenants.GET("/:id/invoices/:invoiceId/boleto", deps.paymentHandler.GetInvoiceBoleto)
+				tenants.GET("/:id/mfa", deps.mfaHandler.GetMFAPolicy)
+				tenants.PUT("/:id/mfa", deps.mfaHandler.UpdateMFAPolicy)
+				tenants.GET("/:id/domain", deps.tenantDomainHandler.GetDomain)
+				tenants.PUT("/:id/domain", deps.tenantDomainHandler.SetDomain)
+				tenants.POST("/:id/domain/verify", deps.tenantDomainHandler.VerifyDomain)
+				tenants.DELETE("/:id/domain", deps.tenantDomainHandler.RemoveDomain)
+
+				// Tenant users
+				tenants.POST("/:id/users", deps.userHandler.InviteUser)
+				tenants.GET("/:id/users", deps.userHandler.ListUsers)
+				tenants.PUT("/:id/users/:userId/role", deps.userHandler.ChangeUserRole)
+				tenants.POST("/:id/users/:userId/block", deps.userHandler.BlockUser)
+				tenants.POST("/:id/users/:userId/reactivate", deps.userHandler.ReactivateUser)
+				tenants.POST("/:id/users/:userId/unlock", deps.lockoutHandler.UnlockUser)
+
+				// User sessions
+				tenants.GET("/:id/users/:userId/sessions", deps.sessionHandler.ListSessions)
+				tenants.DELETE("/:id/users/:userId/sessions", deps.sessionHandler.RevokeAllSessions)
+				tenants.DELETE("/:id/users/:userId/sessions/:sessionId", deps.sessionHandler.RevokeSession)
+			}
+
+			// Usage metered by other services, reported with service tokens or API keys scoped to "usage"
+			usage := v1.Group("/usage")
+			usage.Use(middleware.AuthOrAPIKey(deps.tokenValidator, deps.apiKeyService, deps.mfaService, deps.redisClient, "usage"))
+			usage.Use(middleware.ScopeTenant(deps.tenantDomainService))
+			{
+				usage.POST("/events", middleware.RequireScopedPrincipal(), deps.usageHandler.RecordUsageEvent)
+				usage.PUT("/resources/:resource", middleware.RequireScopedPrincipal(), deps.usageHandler.SetResourceCount)
+				usage.GET("/quotas", middleware.RequireScopedPrincipal(), deps.usageHandler.GetQuotas)
 			}
 
 			// Protected routes
 			protected := v1.Group("")
-			protected.Use(middleware.Auth(keycloakClient, redisClient))
+			protected.Use(middleware.Auth(deps.tokenValidator, deps.redisClient, deps.mfaService))
+			protected.Use(middleware.ScopeTenant(deps.tenantDomainService))
+			protected.Use(middleware.EnforceQuota(deps.quotaService, services.QuotaAPICalls))
+			protected.Use(middleware.MeterRequests(deps.usageMeter))
 			{
-				// Tenant management (admin only)
-				admin := protected.Group("")
-				admin.Use(middleware.RequireRole("admin"))
+				// API key management (tenant admin only)
+				apiKeys := protected.Group("/api-keys")
+				apiKeys.Use(middleware.RequireRole("admin"))
+				{
+					apiKeys.POST("", deps.apiKeyHandler.CreateAPIKey)
+					apiKeys.GET("", deps.apiKeyHandler.ListAPIKeys)
+					apiKeys.POST("/:id/rotate", deps.apiKeyHandler.RotateAPIKey)
+					apiKeys.DELETE("/:id", deps.apiKeyHandler.RevokeAPIKey)
+				}
+
+				// Platform administration (super admin only)
+				superAdmin := protected.Group("/admin")
+				superAdmin.Use(middleware.RequireRole("super_admin"))
 				{
-					admin.POST("/tenants", tenantHandler.CreateTenant)
-					admin.GET("/tenants", tenantHandler.ListTenants)
-					admin.GET("/tenants/:id", tenantHandler.GetTenant)
-					admin.PUT("/tenants/:id", tenantHandler.UpdateTenant)
-					admin.GET("/tenants/:id/usage", tenantHandler.GetTenantUsage)
+					superAdmin.GET("/auth/metrics", handlers.AuthMetrics(deps.tokenValidator))
+					superAdmin.POST("/reconciliation", deps.reconciliationHandler.RunReconciliation)
+					superAdmin.GET("/reconciliation", deps.reconciliationHandler.GetLastReconciliation)
+					superAdmin.POST("/impersonations", deps.impersonationHandler.StartImpersonation)
+					superAdmin.GET("/impersonations", deps.impersonationHandler.ListImpersonations)
+					superAdmin.DELETE("/impersonations/:sessionId", deps.impersonationHandler.RevokeImpersonation)
+					superAdmin.DELETE("/lockouts/ip/:ip", deps.lockoutHandler.UnlockIP)
+					superAdmin.GET("/tenant-provisionings", deps.tenantHandler.ListProvisionings)
+					superAdmin.GET("/tenant-provisionings/:provisioningId", deps.tenantHandler.GetProvisioning)
+					superAdmin.POST("/tenant-provisionings/:provisioningId/resume", deps.tenantHandler.ResumeProvisioning)
+					superAdmin.GET("/plans", deps.planHandler.ListPlans)
+					superAdmin.POST("/plans", deps.planHandler.CreatePlan)
+					superAdmin.GET("/plans/:planId", deps.planHandler.GetPlan)
+					superAdmin.PUT("/plans/:planId", deps.planHandler.UpdatePlan)
+					superAdmin.POST("/plans/:planId/versions", deps.planHandler.CreatePlanVersion)
+					superAdmin.POST("/plans/:planId/activate", deps.planHandler.ActivatePlan)
+					superAdmin.POST("/plans/:planId/deactivate", deps.planHandler.DeactivatePlan)
+					superAdmin.POST("/plans/:planId/migrate", deps.planHandler.MigrateSubscribers)
 				}
 
 				// User profile
-				protected.GET("/profile", handlers.GetProfile())
-				protected.PUT("/profile", handlers.UpdateProfile())
+				protected.GET("/profile", handlers.GetProfile(deps.userService))
+				protected.PUT("/profile", handlers.UpdateProfile(deps.userService))
+				protected.PUT("/profile/password", middleware.DenyImpersonation(), handlers.ChangePassword(deps.userService))
 
 				// Add more protected routes as needed
 			}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// IdentityProvider is the identity backend behind tenants, users and tokens.
+// KeycloakClient is the production implementation; MemoryIdentityProvider runs
+// the API locally and in tests without Keycloak.
+type IdentityProvider interface {
+	// Groups
+	CreateTenantGroup(ctx context.Context, tenantName string) (string, error)
+	DeleteTenantGroup(ctx context.Context, groupID string) error
+	SetTenantPlan(ctx context.Context, groupID, plan string) error
+
+	// Users
+	CreateUser(ctx context.Context, email, firstName, lastName, tenantGroupID string, role string) (string, error)
+	InviteUser(ctx context.Context, email, firstName, lastName, tenantGroupID string, role string) (string, error)
+	GetUser(ctx context.Context, userID string) (*gocloak.User, error)
+	UpdateUser(ctx context.Context, userID string, user gocloak.User) error
+	DeleteUser(ctx context.Context, userID string) error
+	GetUsersByTenant(ctx context.Context, tenantGroupID string) ([]*gocloak.User, error)
+	SetUserEnabled(ctx context.Context, userID string, enabled bool) error
+	SendInviteEmail(ctx context.Context, userID string) error
+	SetTOTPRequired(ctx context.Context, userID string, required bool) (bool, error)
+
+	// Roles
+	ChangeUserRole(ctx context.Context, userID, oldRole, newRole string) error
+	GetUserRoles(ctx context.Context, userID string) ([]*gocloak.Role, error)
+
+	// Passwords
+	VerifyPassword(ctx context.Context, username, password, totp string) error
+	SetUserPassword(ctx context.Context, userID, password string, temporary bool) error
+	ResetPassword(ctx context.Context, userID string) error
+
+	// Sessions
+	GetUserSessions(ctx context.Context, userID string) ([]*gocloak.UserSessionRepresentation, error)
+	LogoutSession(ctx context.Context, sessionID string) error
+	LogoutAllSessions(ctx context.Context, userID string) error
+
+	// Tokens and keys
+	Login(ctx context.Context, username, password string) (*gocloak.JWT, error)
+	LoginWithTOTP(ctx context.Context, username, password, totp string) (*gocloak.JWT, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*gocloak.JWT, error)
+	ValidateToken(ctx context.Context, tokenString string) (*gocloak.IntroSpectTokenResult, error)
+	GetPublicKey(ctx context.Context) (string, error)
+	GetPublicKeys(ctx context.Context) (map[string]*rsa.PublicKey, error)
+}
+
+var _ IdentityProvider = (*KeycloakClient)(nil)
+
+// TOTPEnroller is implemented by identity providers that let the API enroll
+// authenticator apps; Keycloak does it in its login pages
+type TOTPEnroller interface {
+	ConfigureTOTP(ctx context.Context, username, password string) (string, error)
+}
+
+var _ TOTPEnroller = (*MemoryIdentityProvider)(nil)
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/opiagile/direito-lux/pkg/logger"
+)
+
+// ImpersonationIssuer is the "iss" claim of internal impersonation tokens
+const ImpersonationIssuer = "direito-lux-impersonation"
+
+var ErrInvalidImpersonationToken = errors.New("invalid impersonation token")
+
+// ImpersonationTarget describes the user being impersonated
+type ImpersonationTarget struct {
+	KeycloakID string
+	Email      string
+	TenantName string
+	Role       string
+}
+
+// Impersonator describes the super admin acting as the target
+type Impersonator struct {
+	ID    string
+	Email string
+}
+
+// ImpersonationSigner issues and verifies HMAC signed impersonation tokens
+type ImpersonationSigner struct {
+	key []byte
+}
+
+// NewImpersonationSigner creates a signer; an empty key generates a random one,
+// which only works for a single API instance and does not survive restarts
+func NewImpersonationSigner(signingKey string) (*ImpersonationSigner, error) {
+	key := []byte(signingKey)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate impersonation key: %w", err)
+		}
+		logger.Warn("No impersonation signing key configured, using a random key")
+	}
+
+	if len(key) < 32 {
+		return nil, fmt.Errorf("impersonation signing key must have at least 32 bytes")
+	}
+
+	return &ImpersonationSigner{key: key}, nil
+}
+
+// Sign issues a token for the target carrying the impersonator in the "act" claim (RFC 8693)
+func (s *ImpersonationSigner) Sign(sessionID string, target ImpersonationTarget, impersonator Impersonator, expiresAt time.Time) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   ImpersonationIssuer,
+		"sub":   target.KeycloakID,
+		"email": target.Email,
+		"sid":   sessionID,
+		"iat":   now.Unix(),
+		"nbf":   now.Unix(),
+		"exp":   expiresAt.Unix(),
+		"groups": []string{
+			"/" + target.TenantName,
+		},
+		"realm_access": map[string]interface{}{
+			"roles": []string{target.Role},
+		},
+		"act": map[string]interface{}{
+			"sub":   impersonator.ID,
+			"email": impersonator.Email,
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.key)
+}
+
+// Verify checks signature and expiry, returning the token claims
+func (s *ImpersonationSigner) Verify(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.key, nil
+	},
+		jwt.WithValidMethods([]string{"HS256"}),
+		jwt.WithIssuer(ImpersonationIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImpersonationToken, err)
+	}
+
+	return claims, nil
+}
+
+// ImpersonationSessionKey is the Redis key holding an active impersonation session
+func ImpersonationSessionKey(sessionID string) string {
+	return fmt.Sprintf("impersonation:%s", sessionID)
+}
+
+// ImpersonatorFromClaims returns the impersonator ID and session ID of an impersonation token.
+// Only claims of the impersonation issuer count: TokenValidator.Validate returns them
+// solely after verifying the HS256 signature of the impersonation signer.
+func ImpersonatorFromClaims(claims map[string]interface{}) (impersonatorID, sessionID string, ok bool) {
+	if issuer, _ := claims["iss"].(string); issuer != ImpersonationIssuer {
+		return "", "", false
+	}
+	act, isMap := claims["act"].(map[string]interface{})
+	if !isMap {
+		return "", "", false
+	}
+
+	impersonatorID, _ = act["sub"].(string)
+	sessionID, _ = claims["sid"].(string)
+
+	return impersonatorID, sessionID, impersonatorID != ""
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImpersonationSigner_RoundTrip(t *testing.T) {
+	signer, err := NewImpersonationSigner(strings.Repeat("k", 32))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	token, err := signer.Sign("session-1",
+		ImpersonationTarget{KeycloakID: "user-1", Email: "user@example.com", TenantName: "acme", Role: "lawyer"},
+		Impersonator{ID: "admin-1", Email: "support@example.com"},
+		time.Now().Add(10*time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	if !isImpersonationToken(token) {
+		t.Error("Expected token to be recognized as an impersonation token")
+	}
+
+	claims, err := signer.Verify(token)
+	if err != nil {
+		t.Fatalf("Expected token to verify, got %v", err)
+	}
+	if tenant, err := ExtractTenantFromToken(claims); err != nil || tenant != "acme" {
+		t.Errorf("Expected tenant acme, got %q (%v)", tenant, err)
+	}
+
+	impersonatorID, sessionID, ok := ImpersonatorFromClaims(claims)
+	if !ok || impersonatorID != "admin-1" || sessionID != "session-1" {
+		t.Errorf("Unexpected impersonator %q session %q ok=%v", impersonatorID, sessionID, ok)
+	}
+}
+
+func TestImpersonationSigner_RejectsForeignAndExpiredTokens(t *testing.T) {
+	signer, _ := NewImpersonationSigner(strings.Repeat("k", 32))
+	other, _ := NewImpersonationSigner(strings.Repeat("x", 32))
+
+	target := ImpersonationTarget{KeycloakID: "user-1", TenantName: "acme", Role: "lawyer"}
+	impersonator := Impersonator{ID: "admin-1"}
+
+	foreign, _ := other.Sign("session-1", target, impersonator, time.Now().Add(time.Minute))
+	if _, err := signer.Verify(foreign); !errors.Is(err, ErrInvalidImpersonationToken) {
+		t.Errorf("Expected foreign token to be rejected, got %v", err)
+	}
+
+	expired, _ := signer.Sign("session-1", target, impersonator, time.Now().Add(-time.Minute))
+	if _, err := signer.Verify(expired); !errors.Is(err, ErrInvalidImpersonationToken) {
+		t.Errorf("Expected expired token to be rejected, got %v", err)
+	}
+
+	if _, err := NewImpersonationSigner("short"); err == nil {
+		t.Error("Expected short signing key to be rejected")
+	}
+}
+
+func TestImpersonatorFromClaims_RequiresImpersonationIssuer(t *testing.T) {
+	act := map[string]interface{}{"sub": "admin-1"}
+
+	if _, _, ok := ImpersonatorFromClaims(map[string]interface{}{"iss": "https://keycloak/realms/direito-lux", "sid": "s", "act": act}); ok {
+		t.Error("Expected a token of another issuer carrying act not to be an impersonation")
+	}
+	if _, _, ok := ImpersonatorFromClaims(map[string]interface{}{"sid": "s", "act": act}); ok {
+		t.Error("Expected a token without issuer not to be an impersonation")
+	}
+}
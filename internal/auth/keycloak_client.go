@@ -2,12 +2,16 @@ package auth
 
 import (
 	"context"
+	"crypto/rsa"
+	"encoding/base64"
 	"fmt"
+	"math/big"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/opiagile/direito-lux/internal/config"
 	"github.com/opiagile/direito-lux/pkg/logger"
 	"go.uber.org/zap"
@@ -87,8 +91,61 @@ func (kc *KeycloakClient) CreateTenantGroup(ctx context.Context, tenantName stri
 	return groupID, nil
 }
 
+// DeleteTenantGroup deletes a tenant group; its members must be deleted first
+func (kc *KeycloakClient) DeleteTenantGroup(ctx context.Context, groupID string) error {
+	token, err := kc.getAdminToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := kc.client.DeleteGroup(ctx, token.AccessToken, kc.config.Realm, groupID); err != nil {
+		return fmt.Errorf("failed to delete tenant group: %w", err)
+	}
+
+	logger.Info("Deleted tenant group", zap.String("groupID", groupID))
+
+	return nil
+}
+
+// SetTenantPlan stores the plan name in the tenant_plan attribute of the tenant group,
+// which a user attribute mapper of the client exposes as the tenant_plan claim
+func (kc *KeycloakClient) SetTenantPlan(ctx context.Context, groupID, plan string) error {
+	token, err := kc.getAdminToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	group, err := kc.client.GetGroup(ctx, token.AccessToken, kc.config.Realm, groupID)
+	if err != nil {
+		return fmt.Errorf("failed to get tenant group: %w", err)
+	}
+	if group.Attributes == nil {
+		group.Attributes = &map[string][]string{}
+	}
+	(*group.Attributes)["tenant_plan"] = []string{plan}
+
+	if err := kc.client.UpdateGroup(ctx, token.AccessToken, kc.config.Realm, *group); err != nil {
+		return fmt.Errorf("failed to update tenant group plan: %w", err)
+	}
+
+	logger.Info("Updated tenant group plan",
+		zap.String("groupID", groupID),
+		zap.String("plan", plan))
+
+	return nil
+}
+
 // CreateUser creates a new user in Keycloak and assigns to tenant group
 func (kc *KeycloakClient) CreateUser(ctx context.Context, email, firstName, lastName, tenantGroupID string, role string) (string, error) {
+	return kc.createUser(ctx, email, firstName, lastName, tenantGroupID, role, false)
+}
+
+// InviteUser creates a user in the tenant group and emails an invite to set a password
+func (kc *KeycloakClient) InviteUser(ctx context.Context, email, firstName, lastName, tenantGroupID string, role string) (string, error) {
+	return kc.createUser(ctx, email, firstName, lastName, tenantGroupID, role, true)
+}
+
+func (kc *KeycloakClient) createUser(ctx context.Context, email, firstName, lastName, tenantGroupID, role string, invite bool) (string, error) {
 	token, err := kc.getAdminToken(ctx)
 	if err != nil {
 		return "", err
@@ -101,7 +158,6 @@ func (kc *KeycloakClient) CreateUser(ctx context.Context, email, firstName, last
 		LastName:      gocloak.StringP(lastName),
 		Enabled:       gocloak.BoolP(true),
 		EmailVerified: gocloak.BoolP(false),
-		Groups:        &[]string{tenantGroupID},
 		Attributes: &map[string][]string{
 			"tenant_group_id": {tenantGroupID},
 			"role":            {role},
@@ -113,11 +169,26 @@ func (kc *KeycloakClient) CreateUser(ctx context.Context, email, firstName, last
 		return "", fmt.Errorf("failed to create user: %w", err)
 	}
 
-	// Send verification email
-	err = kc.client.SendVerifyEmail(ctx, token.AccessToken, userID, kc.config.Realm, gocloak.SendVerificationMailParams{})
+	// Group membership is not applied from the user representation, add it explicitly
+	if err := kc.client.AddUserToGroup(ctx, token.AccessToken, kc.config.Realm, userID, tenantGroupID); err != nil {
+		if deleteErr := kc.client.DeleteUser(ctx, token.AccessToken, kc.config.Realm, userID); deleteErr != nil {
+			logger.Error("Failed to remove user after group assignment failure",
+				zap.String("userID", userID),
+				zap.Error(deleteErr))
+		}
+		return "", fmt.Errorf("failed to add user to tenant group: %w", err)
+	}
+
+	// Send invite or verification email
+	if invite {
+		err = kc.SendInviteEmail(ctx, userID)
+	} else {
+		err = kc.client.SendVerifyEmail(ctx, token.AccessToken, userID, kc.config.Realm, gocloak.SendVerificationMailParams{})
+	}
 	if err != nil {
-		logger.Warn("Failed to send verification email",
+		logger.Warn("Failed to send user email",
 			zap.String("userID", userID),
+			zap.Bool("invite", invite),
 			zap.Error(err))
 	}
 
@@ -154,6 +225,188 @@ func (kc *KeycloakClient) assignRole(ctx context.Context, token, userID, roleNam
 	return nil
 }
 
+// ChangeUserRole replaces a user's realm role
+func (kc *KeycloakClient) ChangeUserRole(ctx context.Context, userID, oldRole, newRole string) error {
+	token, err := kc.getAdminToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := kc.assignRole(ctx, token.AccessToken, userID, newRole); err != nil {
+		return err
+	}
+
+	if oldRole != "" && oldRole != newRole {
+		role, err := kc.client.GetRealmRole(ctx, token.AccessToken, kc.config.Realm, oldRole)
+		if err != nil {
+			return fmt.Errorf("failed to get role: %w", err)
+		}
+		if err := kc.client.DeleteRealmRoleFromUser(ctx, token.AccessToken, kc.config.Realm, userID, []gocloak.Role{*role}); err != nil {
+			return fmt.Errorf("failed to remove role: %w", err)
+		}
+	}
+
+	user, err := kc.client.GetUserByID(ctx, token.AccessToken, kc.config.Realm, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.Attributes == nil {
+		user.Attributes = &map[string][]string{}
+	}
+	(*user.Attributes)["role"] = []string{newRole}
+
+	if err := kc.client.UpdateUser(ctx, token.AccessToken, kc.config.Realm, *user); err != nil {
+		return fmt.Errorf("failed to update role attribute: %w", err)
+	}
+
+	return nil
+}
+
+// SetUserEnabled enables or disables a user, ending its sessions when disabled
+func (kc *KeycloakClient) SetUserEnabled(ctx context.Context, userID string, enabled bool) error {
+	token, err := kc.getAdminToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := kc.client.GetUserByID(ctx, token.AccessToken, kc.config.Realm, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	user.Enabled = gocloak.BoolP(enabled)
+	if err := kc.client.UpdateUser(ctx, token.AccessToken, kc.config.Realm, *user); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	if !enabled {
+		if err := kc.client.LogoutAllSessions(ctx, token.AccessToken, kc.config.Realm, userID); err != nil {
+			logger.Warn("Failed to end sessions of disabled user",
+				zap.String("userID", userID),
+				zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// SetTOTPRequired adds or removes the CONFIGURE_TOTP required action, returning
+// whether the user changed; users that already have an OTP credential are left alone
+func (kc *KeycloakClient) SetTOTPRequired(ctx context.Context, userID string, required bool) (bool, error) {
+	token, err := kc.getAdminToken(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	user, err := kc.client.GetUserByID(ctx, token.AccessToken, kc.config.Realm, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	actions := []string{}
+	pending := false
+	if user.RequiredActions != nil {
+		for _, action := range *user.RequiredActions {
+			if action == requiredActionConfigureTOTP {
+				pending = true
+				continue
+			}
+			actions = append(actions, action)
+		}
+	}
+
+	if required {
+		if pending {
+			return false, nil
+		}
+
+		credentials, err := kc.client.GetCredentials(ctx, token.AccessToken, kc.config.Realm, userID)
+		if err != nil {
+			return false, fmt.Errorf("failed to get user credentials: %w", err)
+		}
+		for _, credential := range credentials {
+			if credential.Type != nil && *credential.Type == "otp" {
+				return false, nil
+			}
+		}
+
+		actions = append(actions, requiredActionConfigureTOTP)
+	} else if !pending {
+		return false, nil
+	}
+
+	user.RequiredActions = &actions
+	if err := kc.client.UpdateUser(ctx, token.AccessToken, kc.config.Realm, *user); err != nil {
+		return false, fmt.Errorf("failed to update user: %w", err)
+	}
+
+	return true, nil
+}
+
+// GetUserSessions lists the active sessions of a user
+func (kc *KeycloakClient) GetUserSessions(ctx context.Context, userID string) ([]*gocloak.UserSessionRepresentation, error) {
+	token, err := kc.getAdminToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := kc.client.GetUserSessions(ctx, token.AccessToken, kc.config.Realm, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// LogoutSession ends a single user session
+func (kc *KeycloakClient) LogoutSession(ctx context.Context, sessionID string) error {
+	token, err := kc.getAdminToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := kc.client.LogoutUserSession(ctx, token.AccessToken, kc.config.Realm, sessionID); err != nil {
+		return fmt.Errorf("failed to logout session: %w", err)
+	}
+
+	return nil
+}
+
+// LogoutAllSessions ends every session of a user
+func (kc *KeycloakClient) LogoutAllSessions(ctx context.Context, userID string) error {
+	token, err := kc.getAdminToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := kc.client.LogoutAllSessions(ctx, token.AccessToken, kc.config.Realm, userID); err != nil {
+		return fmt.Errorf("failed to logout sessions: %w", err)
+	}
+
+	return nil
+}
+
+// SendInviteEmail sends an email asking the user to set a password and verify the address
+func (kc *KeycloakClient) SendInviteEmail(ctx context.Context, userID string) error {
+	token, err := kc.getAdminToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	params := gocloak.ExecuteActionsEmail{
+		UserID:   gocloak.StringP(userID),
+		ClientID: gocloak.StringP(kc.config.ClientID),
+		Lifespan: gocloak.IntP(int((72 * time.Hour).Seconds())),
+		Actions:  &[]string{"UPDATE_PASSWORD", "VERIFY_EMAIL"},
+	}
+
+	if err := kc.client.ExecuteActionsEmail(ctx, token.AccessToken, kc.config.Realm, params); err != nil {
+		return fmt.Errorf("failed to send invite email: %w", err)
+	}
+
+	return nil
+}
+
 // GetUser retrieves user by ID
 func (kc *KeycloakClient) GetUser(ctx context.Context, userID string) (*gocloak.User, error) {
 	token, err := kc.getAdminToken(ctx)
@@ -206,10 +459,22 @@ func (kc *KeycloakClient) GetUsersByTenant(ctx context.Context, tenantGroupID st
 		return nil, err
 	}
 
-	// Get group members
-	users, err := kc.client.GetGroupMembers(ctx, token.AccessToken, kc.config.Realm, tenantGroupID, gocloak.GetGroupsParams{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get group members: %w", err)
+	// Get group members, page by page
+	const pageSize = 100
+	var users []*gocloak.User
+	for first := 0; ; first += pageSize {
+		page, err := kc.client.GetGroupMembers(ctx, token.AccessToken, kc.config.Realm, tenantGroupID, gocloak.GetGroupsParams{
+			First: gocloak.IntP(first),
+			Max:   gocloak.IntP(pageSize),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get group members: %w", err)
+		}
+
+		users = append(users, page...)
+		if len(page) < pageSize {
+			break
+		}
 	}
 
 	return users, nil
@@ -246,6 +511,64 @@ func (kc *KeycloakClient) GetPublicKey(ctx context.Context) (string, error) {
 	return (*firstKey.X5c)[0], nil
 }
 
+// GetPublicKeys gets the realm signing keys indexed by key ID (kid)
+func (kc *KeycloakClient) GetPublicKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	certs, err := kc.client.GetCerts(ctx, kc.config.Realm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get realm certificates: %w", err)
+	}
+
+	if certs.Keys == nil || len(*certs.Keys) == 0 {
+		return nil, fmt.Errorf("no public keys found for realm")
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range *certs.Keys {
+		if key.Kty == nil || *key.Kty != "RSA" || key.N == nil || key.E == nil {
+			continue
+		}
+		if key.Use != nil && *key.Use != "sig" {
+			continue
+		}
+
+		publicKey, err := parseJWK(*key.N, *key.E)
+		if err != nil {
+			logger.Warn("Skipping invalid realm key", zap.Error(err))
+			continue
+		}
+
+		kid := ""
+		if key.Kid != nil {
+			kid = *key.Kid
+		}
+		keys[kid] = publicKey
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA signing keys found for realm")
+	}
+
+	return keys, nil
+}
+
+// parseJWK builds an RSA public key from base64url encoded modulus and exponent
+func parseJWK(modulus, exponent string) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(modulus)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key modulus: %w", err)
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(exponent)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key exponent: %w", err)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
 // ResetPassword sends password reset email
 func (kc *KeycloakClient) ResetPassword(ctx context.Context, userID string) error {
 	token, err := kc.getAdminToken(ctx)
@@ -261,6 +584,59 @@ func (kc *KeycloakClient) ResetPassword(ctx context.Context, userID string) erro
 	return nil
 }
 
+// Login authenticates a user with a password grant, returning the user tokens
+func (kc *KeycloakClient) Login(ctx context.Context, username, password string) (*gocloak.JWT, error) {
+	token, err := kc.client.Login(ctx, kc.config.ClientID, kc.config.ClientSecret, kc.config.Realm, username, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to login: %w", err)
+	}
+
+	return token, nil
+}
+
+// LoginWithTOTP authenticates a user with a password grant and a code of their
+// authenticator app, for realms whose direct grant flow asks for OTP
+func (kc *KeycloakClient) LoginWithTOTP(ctx context.Context, username, password, totp string) (*gocloak.JWT, error) {
+	token, err := kc.client.LoginOtp(ctx, kc.config.ClientID, kc.config.ClientSecret, kc.config.Realm, username, password, totp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to login: %w", err)
+	}
+
+	return token, nil
+}
+
+// RefreshToken exchanges a refresh token for new user tokens
+func (kc *KeycloakClient) RefreshToken(ctx context.Context, refreshToken string) (*gocloak.JWT, error) {
+	token, err := kc.client.RefreshToken(ctx, refreshToken, kc.config.ClientID, kc.config.ClientSecret, kc.config.Realm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+// VerifyPassword checks user credentials with a password grant against the realm. The
+// grant opens a session, which is logged out right away; users with an authenticator app
+// must send its code, as the realm's conditional OTP flow rejects the grant otherwise.
+func (kc *KeycloakClient) VerifyPassword(ctx context.Context, username, password, totp string) error {
+	var token *gocloak.JWT
+	var err error
+	if totp != "" {
+		token, err = kc.client.LoginOtp(ctx, kc.config.ClientID, kc.config.ClientSecret, kc.config.Realm, username, password, totp)
+	} else {
+		token, err = kc.client.Login(ctx, kc.config.ClientID, kc.config.ClientSecret, kc.config.Realm, username, password)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to verify password: %w", err)
+	}
+
+	if err := kc.client.Logout(ctx, kc.config.ClientID, kc.config.ClientSecret, kc.config.Realm, token.RefreshToken); err != nil {
+		logger.Warn("Failed to logout password verification session", zap.Error(err))
+	}
+
+	return nil
+}
+
 // SetUserPassword sets user password directly (for admin operations)
 func (kc *KeycloakClient) SetUserPassword(ctx context.Context, userID, password string, temporary bool) error {
 	token, err := kc.getAdminToken(ctx)
@@ -320,3 +696,13 @@ func ExtractTenantFromToken(claims map[string]interface{}) (string, error) {
 
 	return "", fmt.Errorf("no tenant found in token")
 }
+
+// TenantFromIssuedToken extracts the tenant of an access token the identity provider
+// has just issued to the API, so its signature is not verified again
+func TenantFromIssuedToken(accessToken string) (string, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(accessToken, claims); err != nil {
+		return "", fmt.Errorf("failed to parse token: %w", err)
+	}
+	return ExtractTenantFromToken(claims)
+}
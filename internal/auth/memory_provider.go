@@ -0,0 +1,845 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Identity provider names
+const (
+	IdentityProviderKeycloak = "keycloak"
+	IdentityProviderMemory   = "memory"
+)
+
+const (
+	memoryAccessTokenTTL         = 5 * time.Minute
+	memoryRefreshTokenTTL        = 30 * time.Minute
+	memoryKeyID                  = "memory-rs256"
+	requiredActionUpdatePassword = "UPDATE_PASSWORD"
+)
+
+var (
+	ErrIdentityNotFound    = errors.New("identity not found")
+	ErrIdentityExists      = errors.New("identity already exists")
+	ErrInvalidCredentials  = errors.New("invalid user credentials")
+	ErrAccountNotSetUp     = errors.New("account is not fully set up")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrTOTPRequired        = errors.New("TOTP code required")
+	ErrTOTPConfigured      = errors.New("TOTP already configured")
+)
+
+type memoryUser struct {
+	user         gocloak.User
+	passwordHash []byte
+	groupIDs     []string
+	roles        []string
+	otp          bool
+	// Secret of the authenticator app; enrollment completes with the first valid code
+	totpSecret string
+}
+
+type memorySession struct {
+	id         string
+	userID     string
+	startedAt  time.Time
+	lastAccess time.Time
+	mfa        bool // opened with a TOTP code
+}
+
+// MemoryIdentityProvider keeps groups, users and sessions in memory and issues
+// RS256 tokens shaped like Keycloak's. State is lost on restart.
+type MemoryIdentityProvider struct {
+	mu       sync.RWMutex
+	issuer   string
+	clientID string
+	key      *rsa.PrivateKey
+
+	groups   map[string]string // group ID -> tenant name
+	plans    map[string]string // group ID -> tenant plan
+	users    map[string]*memoryUser
+	sessions map[string]*memorySession
+}
+
+var _ IdentityProvider = (*MemoryIdentityProvider)(nil)
+
+// NewMemoryIdentityProvider creates an empty provider with a freshly generated signing key
+func NewMemoryIdentityProvider(issuer, clientID string) (*MemoryIdentityProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	return &MemoryIdentityProvider{
+		issuer:   issuer,
+		clientID: clientID,
+		key:      key,
+		groups:   make(map[string]string),
+		plans:    make(map[string]string),
+		users:    make(map[string]*memoryUser),
+		sessions: make(map[string]*memorySession),
+	}, nil
+}
+
+// SeedUser creates a ready to use user with a password, for local setups and tests
+func (p *MemoryIdentityProvider) SeedUser(ctx context.Context, email, password, tenantName string, roles ...string) (string, error) {
+	groupID, err := p.findOrCreateGroup(tenantName)
+	if err != nil {
+		return "", err
+	}
+
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return "", err
+	}
+
+	userID, err := p.CreateUser(ctx, email, "", "", groupID, "")
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user := p.users[userID]
+	user.roles = append([]string{}, roles...)
+	user.user.EmailVerified = gocloak.BoolP(true)
+	user.passwordHash = passwordHash
+
+	return userID, nil
+}
+
+// CreateTenantGroup creates a group for the tenant
+func (p *MemoryIdentityProvider) CreateTenantGroup(ctx context.Context, tenantName string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, name := range p.groups {
+		if name == tenantName {
+			return "", fmt.Errorf("%w: group %s", ErrIdentityExists, tenantName)
+		}
+	}
+
+	groupID := uuid.New().String()
+	p.groups[groupID] = tenantName
+
+	return groupID, nil
+}
+
+// DeleteTenantGroup removes the group
+func (p *MemoryIdentityProvider) DeleteTenantGroup(ctx context.Context, groupID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.groups[groupID]; !ok {
+		return fmt.Errorf("%w: group %s", ErrIdentityNotFound, groupID)
+	}
+	delete(p.groups, groupID)
+	delete(p.plans, groupID)
+
+	return nil
+}
+
+// SetTenantPlan sets the plan issued in the tenant_plan claim of the group members
+func (p *MemoryIdentityProvider) SetTenantPlan(ctx context.Context, groupID, plan string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.groups[groupID]; !ok {
+		return fmt.Errorf("%w: group %s", ErrIdentityNotFound, groupID)
+	}
+	p.plans[groupID] = plan
+
+	return nil
+}
+
+// TenantGroupID returns the group of a tenant, creating it if needed
+func (p *MemoryIdentityProvider) TenantGroupID(tenantName string) (string, error) {
+	return p.findOrCreateGroup(tenantName)
+}
+
+func (p *MemoryIdentityProvider) findOrCreateGroup(tenantName string) (string, error) {
+	p.mu.RLock()
+	for id, name := range p.groups {
+		if name == tenantName {
+			p.mu.RUnlock()
+			return id, nil
+		}
+	}
+	p.mu.RUnlock()
+
+	return p.CreateTenantGroup(context.Background(), tenantName)
+}
+
+// CreateUser creates a user in the tenant group
+func (p *MemoryIdentityProvider) CreateUser(ctx context.Context, email, firstName, lastName, tenantGroupID string, role string) (string, error) {
+	return p.createUser(email, firstName, lastName, tenantGroupID, role, nil)
+}
+
+// InviteUser creates a user that must set a password and verify the email first
+func (p *MemoryIdentityProvider) InviteUser(ctx context.Context, email, firstName, lastName, tenantGroupID string, role string) (string, error) {
+	return p.createUser(email, firstName, lastName, tenantGroupID, role, []string{requiredActionUpdatePassword, "VERIFY_EMAIL"})
+}
+
+func (p *MemoryIdentityProvider) createUser(email, firstName, lastName, tenantGroupID, role string, requiredActions []string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.groups[tenantGroupID]; !ok {
+		return "", fmt.Errorf("%w: group %s", ErrIdentityNotFound, tenantGroupID)
+	}
+
+	username := strings.ToLower(email)
+	if p.findUserLocked(username) != nil {
+		return "", fmt.Errorf("%w: user %s", ErrIdentityExists, username)
+	}
+
+	userID := uuid.New().String()
+	actions := append([]string{}, requiredActions...)
+	user := &memoryUser{
+		user: gocloak.User{
+			ID:               gocloak.StringP(userID),
+			CreatedTimestamp: gocloak.Int64P(time.Now().UnixMilli()),
+			Username:         gocloak.StringP(username),
+			Email:            gocloak.StringP(username),
+			FirstName:        gocloak.StringP(firstName),
+			LastName:         gocloak.StringP(lastName),
+			Enabled:          gocloak.BoolP(true),
+			EmailVerified:    gocloak.BoolP(false),
+			Attributes: &map[string][]string{
+				"tenant_group_id": {tenantGroupID},
+				"role":            {role},
+			},
+			RequiredActions: &actions,
+		},
+		groupIDs: []string{tenantGroupID},
+	}
+	if role != "" {
+		user.roles = []string{role}
+	}
+	p.users[userID] = user
+
+	return userID, nil
+}
+
+// GetUser returns a copy of the user
+func (p *MemoryIdentityProvider) GetUser(ctx context.Context, userID string) (*gocloak.User, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	user, ok := p.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("%w: user %s", ErrIdentityNotFound, userID)
+	}
+
+	return p.copyUser(user)
+}
+
+// UpdateUser applies the non-nil fields of the representation, like Keycloak does
+func (p *MemoryIdentityProvider) UpdateUser(ctx context.Context, userID string, update gocloak.User) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, ok := p.users[userID]
+	if !ok {
+		return fmt.Errorf("%w: user %s", ErrIdentityNotFound, userID)
+	}
+
+	if update.FirstName != nil {
+		user.user.FirstName = gocloak.StringP(*update.FirstName)
+	}
+	if update.LastName != nil {
+		user.user.LastName = gocloak.StringP(*update.LastName)
+	}
+	if update.Email != nil {
+		user.user.Email = gocloak.StringP(strings.ToLower(*update.Email))
+	}
+	if update.EmailVerified != nil {
+		user.user.EmailVerified = gocloak.BoolP(*update.EmailVerified)
+	}
+	if update.Enabled != nil {
+		user.user.Enabled = gocloak.BoolP(*update.Enabled)
+		if !*update.Enabled {
+			p.logoutUserLocked(userID)
+		}
+	}
+	if update.Attributes != nil {
+		attributes := make(map[string][]string, len(*update.Attributes))
+		for k, v := range *update.Attributes {
+			attributes[k] = append([]string{}, v...)
+		}
+		user.user.Attributes = &attributes
+	}
+	if update.RequiredActions != nil {
+		actions := append([]string{}, *update.RequiredActions...)
+		user.user.RequiredActions = &actions
+	}
+
+	return nil
+}
+
+// DeleteUser removes the user and its sessions
+func (p *MemoryIdentityProvider) DeleteUser(ctx context.Context, userID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.users[userID]; !ok {
+		return fmt.Errorf("%w: user %s", ErrIdentityNotFound, userID)
+	}
+
+	p.logoutUserLocked(userID)
+	delete(p.users, userID)
+
+	return nil
+}
+
+// GetUsersByTenant lists the members of a tenant group
+func (p *MemoryIdentityProvider) GetUsersByTenant(ctx context.Context, tenantGroupID string) ([]*gocloak.User, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	users := []*gocloak.User{}
+	for _, user := range p.users {
+		for _, groupID := range user.groupIDs {
+			if groupID == tenantGroupID {
+				copied, err := p.copyUser(user)
+				if err != nil {
+					return nil, err
+				}
+				users = append(users, copied)
+				break
+			}
+		}
+	}
+
+	return users, nil
+}
+
+// SetUserEnabled enables or disables a user, ending its sessions when disabled
+func (p *MemoryIdentityProvider) SetUserEnabled(ctx context.Context, userID string, enabled bool) error {
+	return p.UpdateUser(ctx, userID, gocloak.User{Enabled: gocloak.BoolP(enabled)})
+}
+
+// SendInviteEmail only logs, no email is sent
+func (p *MemoryIdentityProvider) SendInviteEmail(ctx context.Context, userID string) error {
+	logger.Info("Invite email skipped by in-memory identity provider", zap.String("userID", userID))
+	return nil
+}
+
+// SetTOTPRequired adds or removes the CONFIGURE_TOTP required action
+func (p *MemoryIdentityProvider) SetTOTPRequired(ctx context.Context, userID string, required bool) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, ok := p.users[userID]
+	if !ok {
+		return false, fmt.Errorf("%w: user %s", ErrIdentityNotFound, userID)
+	}
+
+	pending := user.hasRequiredAction(requiredActionConfigureTOTP)
+	switch {
+	case required && !pending && !user.otp:
+		actions := append(*user.user.RequiredActions, requiredActionConfigureTOTP)
+		user.user.RequiredActions = &actions
+		return true, nil
+	case !required && pending:
+		user.removeRequiredAction(requiredActionConfigureTOTP)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// ChangeUserRole replaces a realm role of the user
+func (p *MemoryIdentityProvider) ChangeUserRole(ctx context.Context, userID, oldRole, newRole string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, ok := p.users[userID]
+	if !ok {
+		return fmt.Errorf("%w: user %s", ErrIdentityNotFound, userID)
+	}
+
+	roles := []string{newRole}
+	for _, role := range user.roles {
+		if role != oldRole && role != newRole {
+			roles = append(roles, role)
+		}
+	}
+	user.roles = roles
+
+	if user.user.Attributes != nil {
+		(*user.user.Attributes)["role"] = []string{newRole}
+	}
+
+	return nil
+}
+
+// GetUserRoles lists the realm roles of the user
+func (p *MemoryIdentityProvider) GetUserRoles(ctx context.Context, userID string) ([]*gocloak.Role, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	user, ok := p.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("%w: user %s", ErrIdentityNotFound, userID)
+	}
+
+	roles := make([]*gocloak.Role, 0, len(user.roles))
+	for _, role := range user.roles {
+		roles = append(roles, &gocloak.Role{Name: gocloak.StringP(role)})
+	}
+
+	return roles, nil
+}
+
+// VerifyPassword checks the user credentials without opening a session. Like Keycloak,
+// users with an authenticator app must also send a valid code.
+func (p *MemoryIdentityProvider) VerifyPassword(ctx context.Context, username, password, totp string) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	user, err := p.authenticateLocked(username, password)
+	if err != nil {
+		return fmt.Errorf("failed to verify password: %w", err)
+	}
+	if user.otp && !VerifyTOTP(user.totpSecret, totp, time.Now()) {
+		return fmt.Errorf("failed to verify password: %w", ErrTOTPRequired)
+	}
+
+	return nil
+}
+
+// SetUserPassword sets the user password; a permanent password completes UPDATE_PASSWORD
+func (p *MemoryIdentityProvider) SetUserPassword(ctx context.Context, userID, password string, temporary bool) error {
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, ok := p.users[userID]
+	if !ok {
+		return fmt.Errorf("%w: user %s", ErrIdentityNotFound, userID)
+	}
+
+	user.passwordHash = passwordHash
+	if temporary {
+		if !user.hasRequiredAction(requiredActionUpdatePassword) {
+			actions := append(*user.user.RequiredActions, requiredActionUpdatePassword)
+			user.user.RequiredActions = &actions
+		}
+	} else {
+		user.removeRequiredAction(requiredActionUpdatePassword)
+	}
+
+	return nil
+}
+
+// ResetPassword only logs, no email is sent
+func (p *MemoryIdentityProvider) ResetPassword(ctx context.Context, userID string) error {
+	logger.Info("Password reset email skipped by in-memory identity provider", zap.String("userID", userID))
+	return nil
+}
+
+// GetUserSessions lists the active sessions of the user
+func (p *MemoryIdentityProvider) GetUserSessions(ctx context.Context, userID string) ([]*gocloak.UserSessionRepresentation, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	sessions := []*gocloak.UserSessionRepresentation{}
+	for _, session := range p.sessions {
+		if session.userID != userID {
+			continue
+		}
+		clients := map[string]string{p.clientID: p.clientID}
+		sessions = append(sessions, &gocloak.UserSessionRepresentation{
+			ID:         gocloak.StringP(session.id),
+			UserID:     gocloak.StringP(session.userID),
+			Username:   p.users[userID].user.Username,
+			Start:      gocloak.Int64P(session.startedAt.UnixMilli()),
+			LastAccess: gocloak.Int64P(session.lastAccess.UnixMilli()),
+			Clients:    &clients,
+		})
+	}
+
+	return sessions, nil
+}
+
+// LogoutSession ends a session; its tokens stop introspecting as active
+func (p *MemoryIdentityProvider) LogoutSession(ctx context.Context, sessionID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.sessions, sessionID)
+	return nil
+}
+
+// LogoutAllSessions ends every session of the user
+func (p *MemoryIdentityProvider) LogoutAllSessions(ctx context.Context, userID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.logoutUserLocked(userID)
+	return nil
+}
+
+// Login authenticates with a password and opens a session. Users with an
+// authenticator app must log in with LoginWithTOTP, as Keycloak's conditional OTP
+// flow requires.
+func (p *MemoryIdentityProvider) Login(ctx context.Context, username, password string) (*gocloak.JWT, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, err := p.authenticateLocked(username, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to login: %w", err)
+	}
+	if user.otp {
+		return nil, fmt.Errorf("failed to login: %w", ErrTOTPRequired)
+	}
+	if user.user.RequiredActions != nil && len(*user.user.RequiredActions) > 0 {
+		return nil, fmt.Errorf("failed to login: %w", ErrAccountNotSetUp)
+	}
+
+	return p.openSessionLocked(user, false)
+}
+
+// LoginWithTOTP authenticates with a password and a code of the user's
+// authenticator app, issuing tokens with the MFA acr and amr. The first valid
+// code after ConfigureTOTP completes the enrollment.
+func (p *MemoryIdentityProvider) LoginWithTOTP(ctx context.Context, username, password, totp string) (*gocloak.JWT, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, err := p.authenticateLocked(username, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to login: %w", err)
+	}
+	if user.totpSecret == "" || !VerifyTOTP(user.totpSecret, totp, time.Now()) {
+		return nil, fmt.Errorf("failed to login: %w", ErrInvalidCredentials)
+	}
+	if !user.otp {
+		user.otp = true
+		user.removeRequiredAction(requiredActionConfigureTOTP)
+	}
+	if user.user.RequiredActions != nil && len(*user.user.RequiredActions) > 0 {
+		return nil, fmt.Errorf("failed to login: %w", ErrAccountNotSetUp)
+	}
+
+	return p.openSessionLocked(user, true)
+}
+
+// ConfigureTOTP starts the enrollment of an authenticator app, the part of the
+// CONFIGURE_TOTP required action Keycloak runs in its login pages. It returns the
+// secret to enroll; LoginWithTOTP with a code of it completes the enrollment.
+func (p *MemoryIdentityProvider) ConfigureTOTP(ctx context.Context, username, password string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, err := p.authenticateLocked(username, password)
+	if err != nil {
+		return "", fmt.Errorf("failed to configure TOTP: %w", err)
+	}
+	// Replacing an enrolled app takes the current one, like in Keycloak's account console
+	if user.otp {
+		return "", fmt.Errorf("failed to configure TOTP: %w", ErrTOTPConfigured)
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	user.totpSecret = secret
+	return secret, nil
+}
+
+func (p *MemoryIdentityProvider) openSessionLocked(user *memoryUser, mfa bool) (*gocloak.JWT, error) {
+	now := time.Now()
+	session := &memorySession{
+		id:         uuid.New().String(),
+		userID:     *user.user.ID,
+		startedAt:  now,
+		lastAccess: now,
+		mfa:        mfa,
+	}
+	p.sessions[session.id] = session
+
+	return p.issueTokensLocked(user, session)
+}
+
+// RefreshToken issues new tokens for an active session
+func (p *MemoryIdentityProvider) RefreshToken(ctx context.Context, refreshToken string) (*gocloak.JWT, error) {
+	claims, err := p.parse(refreshToken)
+	if err != nil || claims["typ"] != "Refresh" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sessionID, _ := claims["sid"].(string)
+	session, ok := p.sessions[sessionID]
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+	user, ok := p.users[session.userID]
+	if !ok || user.user.Enabled == nil || !*user.user.Enabled {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session.lastAccess = time.Now()
+
+	return p.issueTokensLocked(user, session)
+}
+
+// ValidateToken introspects a token: active when the signature is valid, it has
+// not expired, and its session and user are still active
+func (p *MemoryIdentityProvider) ValidateToken(ctx context.Context, tokenString string) (*gocloak.IntroSpectTokenResult, error) {
+	inactive := &gocloak.IntroSpectTokenResult{Active: gocloak.BoolP(false)}
+
+	claims, err := p.parse(tokenString)
+	if err != nil {
+		return inactive, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sessionID, _ := claims["sid"].(string)
+	session, ok := p.sessions[sessionID]
+	if !ok {
+		return inactive, nil
+	}
+	user, ok := p.users[session.userID]
+	if !ok || user.user.Enabled == nil || !*user.user.Enabled {
+		return inactive, nil
+	}
+
+	session.lastAccess = time.Now()
+
+	result := &gocloak.IntroSpectTokenResult{
+		Active: gocloak.BoolP(true),
+		Type:   gocloak.StringP("Bearer"),
+	}
+	if jti, ok := claims["jti"].(string); ok {
+		result.Jti = gocloak.StringP(jti)
+	}
+	if exp, _ := claims.GetExpirationTime(); exp != nil {
+		result.Exp = gocloak.IntP(int(exp.Unix()))
+	}
+	if iat, _ := claims.GetIssuedAt(); iat != nil {
+		result.Iat = gocloak.IntP(int(iat.Unix()))
+	}
+
+	return result, nil
+}
+
+// GetPublicKey returns the base64 DER encoded signing key
+func (p *MemoryIdentityProvider) GetPublicKey(ctx context.Context) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(&p.key.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+// GetPublicKeys returns the signing key by key ID
+func (p *MemoryIdentityProvider) GetPublicKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	return map[string]*rsa.PublicKey{memoryKeyID: &p.key.PublicKey}, nil
+}
+
+// issueTokensLocked signs access and refresh tokens with Keycloak's claim layout
+func (p *MemoryIdentityProvider) issueTokensLocked(user *memoryUser, session *memorySession) (*gocloak.JWT, error) {
+	now := time.Now()
+
+	// Keycloak maps the OTP step to level of assurance 2, see mfaACRValues
+	acr, amr := "1", []string{"pwd"}
+	if session.mfa {
+		acr, amr = "2", []string{"pwd", "otp"}
+	}
+
+	groups := make([]string, 0, len(user.groupIDs))
+	var plan string
+	for _, groupID := range user.groupIDs {
+		if name, ok := p.groups[groupID]; ok {
+			groups = append(groups, "/"+name)
+		}
+		if groupPlan, ok := p.plans[groupID]; ok {
+			plan = groupPlan
+		}
+	}
+
+	access := jwt.MapClaims{
+		"iss":                p.issuer,
+		"sub":                *user.user.ID,
+		"azp":                p.clientID,
+		"typ":                "Bearer",
+		"jti":                uuid.New().String(),
+		"sid":                session.id,
+		"iat":                now.Unix(),
+		"exp":                now.Add(memoryAccessTokenTTL).Unix(),
+		"email":              *user.user.Email,
+		"email_verified":     user.user.EmailVerified != nil && *user.user.EmailVerified,
+		"preferred_username": *user.user.Username,
+		"given_name":         *user.user.FirstName,
+		"family_name":        *user.user.LastName,
+		"groups":             groups,
+		"realm_access":       map[string]interface{}{"roles": user.roles},
+		"acr":                acr,
+		"amr":                amr,
+	}
+	if plan != "" {
+		access["tenant_plan"] = plan
+	}
+	refresh := jwt.MapClaims{
+		"iss": p.issuer,
+		"sub": *user.user.ID,
+		"typ": "Refresh",
+		"jti": uuid.New().String(),
+		"sid": session.id,
+		"iat": now.Unix(),
+		"exp": now.Add(memoryRefreshTokenTTL).Unix(),
+	}
+
+	accessToken, err := p.sign(access)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := p.sign(refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gocloak.JWT{
+		AccessToken:      accessToken,
+		ExpiresIn:        int(memoryAccessTokenTTL.Seconds()),
+		RefreshToken:     refreshToken,
+		RefreshExpiresIn: int(memoryRefreshTokenTTL.Seconds()),
+		TokenType:        "Bearer",
+		SessionState:     session.id,
+		Scope:            "openid email profile",
+	}, nil
+}
+
+func (p *MemoryIdentityProvider) sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = memoryKeyID
+
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+func (p *MemoryIdentityProvider) parse(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return &p.key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(p.issuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (p *MemoryIdentityProvider) authenticateLocked(username, password string) (*memoryUser, error) {
+	user := p.findUserLocked(strings.ToLower(username))
+	if user == nil || user.passwordHash == nil ||
+		bcrypt.CompareHashAndPassword(user.passwordHash, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if user.user.Enabled == nil || !*user.user.Enabled {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+func (p *MemoryIdentityProvider) findUserLocked(username string) *memoryUser {
+	for _, user := range p.users {
+		if user.user.Username != nil && *user.user.Username == username {
+			return user
+		}
+	}
+	return nil
+}
+
+func (p *MemoryIdentityProvider) logoutUserLocked(userID string) {
+	for id, session := range p.sessions {
+		if session.userID == userID {
+			delete(p.sessions, id)
+		}
+	}
+}
+
+// copyUser deep copies the representation so callers cannot mutate provider state
+func (p *MemoryIdentityProvider) copyUser(user *memoryUser) (*gocloak.User, error) {
+	data, err := json.Marshal(user.user)
+	if err != nil {
+		return nil, err
+	}
+
+	var copied gocloak.User
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil, err
+	}
+
+	roles := append([]string{}, user.roles...)
+	copied.RealmRoles = &roles
+	copied.Totp = gocloak.BoolP(user.otp)
+
+	return &copied, nil
+}
+
+func (u *memoryUser) hasRequiredAction(action string) bool {
+	if u.user.RequiredActions == nil {
+		return false
+	}
+	for _, a := range *u.user.RequiredActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *memoryUser) removeRequiredAction(action string) {
+	if u.user.RequiredActions == nil {
+		return
+	}
+	actions := []string{}
+	for _, a := range *u.user.RequiredActions {
+		if a != action {
+			actions = append(actions, a)
+		}
+	}
+	u.user.RequiredActions = &actions
+}
+
+// hashPassword salts and hashes a password with bcrypt
+func hashPassword(password string) ([]byte, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	return hash, nil
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/opiagile/direito-lux/internal/config"
+)
+
+func TestMemoryIdentityProvider_TokensValidate(t *testing.T) {
+	ctx := context.Background()
+
+	provider, err := NewMemoryIdentityProvider("http://memory/realms/test", "direito-lux-app")
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	userID, err := provider.SeedUser(ctx, "Lawyer@Example.com", "secret-password", "acme", "lawyer")
+	if err != nil {
+		t.Fatalf("Failed to seed user: %v", err)
+	}
+
+	if _, err := provider.Login(ctx, "lawyer@example.com", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected invalid credentials, got %v", err)
+	}
+
+	token, err := provider.Login(ctx, "lawyer@example.com", "secret-password")
+	if err != nil {
+		t.Fatalf("Failed to login: %v", err)
+	}
+
+	for _, strategy := range []string{ValidationStrategyLocal, ValidationStrategyIntrospection} {
+		validator, err := NewTokenValidator(provider, nil, &config.JWTConfig{
+			ValidationStrategy: strategy,
+			Issuer:             "http://memory/realms/test",
+		})
+		if err != nil {
+			t.Fatalf("Failed to create validator: %v", err)
+		}
+
+		claims, err := validator.Validate(ctx, token.AccessToken)
+		if err != nil {
+			t.Fatalf("%s: expected token to validate, got %v", strategy, err)
+		}
+		if claims["sub"] != userID {
+			t.Errorf("%s: expected sub %s, got %v", strategy, userID, claims["sub"])
+		}
+		if tenant, err := ExtractTenantFromToken(claims); err != nil || tenant != "acme" {
+			t.Errorf("%s: expected tenant acme, got %q (%v)", strategy, tenant, err)
+		}
+	}
+
+	refreshed, err := provider.RefreshToken(ctx, token.RefreshToken)
+	if err != nil {
+		t.Fatalf("Failed to refresh token: %v", err)
+	}
+
+	// Ending the session deactivates every token issued for it
+	if err := provider.LogoutAllSessions(ctx, userID); err != nil {
+		t.Fatalf("Failed to logout: %v", err)
+	}
+	result, err := provider.ValidateToken(ctx, refreshed.AccessToken)
+	if err != nil || *result.Active {
+		t.Errorf("Expected token to be inactive after logout, got %v (%v)", *result.Active, err)
+	}
+	if _, err := provider.RefreshToken(ctx, token.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Expected refresh to fail after logout, got %v", err)
+	}
+}
+
+func TestMemoryIdentityProvider_InvitedUserMustSetPassword(t *testing.T) {
+	ctx := context.Background()
+	provider, _ := NewMemoryIdentityProvider("memory", "direito-lux-app")
+
+	groupID, _ := provider.CreateTenantGroup(ctx, "acme")
+	userID, err := provider.InviteUser(ctx, "new@example.com", "New", "User", groupID, "secretary")
+	if err != nil {
+		t.Fatalf("Failed to invite user: %v", err)
+	}
+
+	_ = provider.SetUserPassword(ctx, userID, "secret-password", false)
+	if _, err := provider.Login(ctx, "new@example.com", "secret-password"); !errors.Is(err, ErrAccountNotSetUp) {
+		t.Errorf("Expected pending email verification to block login, got %v", err)
+	}
+
+	_ = provider.UpdateUser(ctx, userID, gocloak.User{RequiredActions: &[]string{}})
+	if _, err := provider.Login(ctx, "new@example.com", "secret-password"); err != nil {
+		t.Errorf("Expected login after completing required actions, got %v", err)
+	}
+}
+
+func TestMemoryIdentityProvider_TOTPEnrollmentIssuesMFATokens(t *testing.T) {
+	ctx := context.Background()
+	provider, _ := NewMemoryIdentityProvider("memory", "direito-lux-app")
+
+	userID, err := provider.SeedUser(ctx, "admin@example.com", "secret-password", "acme", "admin")
+	if err != nil {
+		t.Fatalf("Failed to seed user: %v", err)
+	}
+	if _, err := provider.SetTOTPRequired(ctx, userID, true); err != nil {
+		t.Fatalf("Failed to require TOTP: %v", err)
+	}
+	if _, err := provider.Login(ctx, "admin@example.com", "secret-password"); !errors.Is(err, ErrAccountNotSetUp) {
+		t.Errorf("Expected login to wait for the TOTP setup, got %v", err)
+	}
+
+	secret, err := provider.ConfigureTOTP(ctx, "admin@example.com", "secret-password")
+	if err != nil {
+		t.Fatalf("Failed to configure TOTP: %v", err)
+	}
+	if _, err := provider.LoginWithTOTP(ctx, "admin@example.com", "secret-password", "000000"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected a wrong code to be rejected, got %v", err)
+	}
+
+	code, _ := TOTPCode(secret, time.Now())
+	token, err := provider.LoginWithTOTP(ctx, "admin@example.com", "secret-password", code)
+	if err != nil {
+		t.Fatalf("Failed to login with TOTP: %v", err)
+	}
+	claims, err := provider.parse(token.AccessToken)
+	if err != nil || !HasMFA(claims) {
+		t.Errorf("Expected an MFA token, got %v (%v)", claims["acr"], err)
+	}
+
+	refreshed, err := provider.RefreshToken(ctx, token.RefreshToken)
+	if err != nil {
+		t.Fatalf("Failed to refresh token: %v", err)
+	}
+	if claims, _ := provider.parse(refreshed.AccessToken); !HasMFA(claims) {
+		t.Error("Expected refreshed tokens to keep the MFA level")
+	}
+
+	// Enrolled users need the code, and cannot replace the app with the password alone
+	if _, err := provider.Login(ctx, "admin@example.com", "secret-password"); !errors.Is(err, ErrTOTPRequired) {
+		t.Errorf("Expected ErrTOTPRequired, got %v", err)
+	}
+	if _, err := provider.ConfigureTOTP(ctx, "admin@example.com", "secret-password"); !errors.Is(err, ErrTOTPConfigured) {
+		t.Errorf("Expected ErrTOTPConfigured, got %v", err)
+	}
+	if err := provider.VerifyPassword(ctx, "admin@example.com", "secret-password", ""); !errors.Is(err, ErrTOTPRequired) {
+		t.Errorf("Expected password verification to require the code, got %v", err)
+	}
+	if err := provider.VerifyPassword(ctx, "admin@example.com", "secret-password", code); err != nil {
+		t.Errorf("Expected password verification with the code to pass, got %v", err)
+	}
+}
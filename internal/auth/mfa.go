@@ -0,0 +1,36 @@
+package auth
+
+// requiredActionConfigureTOTP makes Keycloak ask the user to enroll an authenticator app
+const requiredActionConfigureTOTP = "CONFIGURE_TOTP"
+
+// mfaMethods are "amr" values (RFC 8176) proving a second factor
+var mfaMethods = map[string]bool{
+	"otp": true,
+	"mfa": true,
+	"hwk": true,
+	"swk": true,
+}
+
+// mfaACRValues are "acr" values Keycloak issues for logins with a second factor,
+// given the realm maps level of assurance 2 to the OTP step
+var mfaACRValues = map[string]bool{
+	"2":   true,
+	"mfa": true,
+}
+
+// HasMFA reports whether the token claims show the user logged in with a second factor
+func HasMFA(claims map[string]interface{}) bool {
+	if amr, ok := claims["amr"].([]interface{}); ok {
+		for _, method := range amr {
+			if m, ok := method.(string); ok && mfaMethods[m] {
+				return true
+			}
+		}
+	}
+
+	if acr, ok := claims["acr"].(string); ok && mfaACRValues[acr] {
+		return true
+	}
+
+	return false
+}
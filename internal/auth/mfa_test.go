@@ -0,0 +1,24 @@
+package auth
+
+import "testing"
+
+func TestHasMFA(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   bool
+	}{
+		{"password only", map[string]interface{}{"acr": "1", "amr": []interface{}{"pwd"}}, false},
+		{"otp in amr", map[string]interface{}{"acr": "1", "amr": []interface{}{"pwd", "otp"}}, true},
+		{"step-up acr", map[string]interface{}{"acr": "2"}, true},
+		{"no claims", map[string]interface{}{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasMFA(tt.claims); got != tt.want {
+				t.Errorf("HasMFA() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/opiagile/direito-lux/internal/config"
+)
+
+// serviceAccountUsernamePrefix is how Keycloak names the user behind a client's service account
+const serviceAccountUsernamePrefix = "service-account-"
+
+// ServiceClient is a Keycloak client allowed to call the API with client credentials
+type ServiceClient struct {
+	ClientID string
+	Scopes   []string
+	Tenants  []string
+}
+
+// HasScope checks if the client grants a scope such as "tenants:read",
+// with the same wildcards as API keys ("tenants:*" and "*")
+func (s *ServiceClient) HasScope(scope string) bool {
+	resource := strings.SplitN(scope, ":", 2)[0]
+	for _, granted := range s.Scopes {
+		if granted == "*" || granted == scope || granted == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// CanActFor checks if the client is registered to act for a tenant
+func (s *ServiceClient) CanActFor(tenantName string) bool {
+	for _, allowed := range s.Tenants {
+		if allowed == tenantName {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceAccountFromClaims returns the client ID of a client credentials token.
+// Keycloak issues these for the "service-account-<client>" user and adds the
+// client ID claim ("client_id", or "clientId" before Keycloak 24), which user
+// tokens never carry.
+func ServiceAccountFromClaims(claims map[string]interface{}) (string, bool) {
+	username, _ := claims["preferred_username"].(string)
+	if !strings.HasPrefix(username, serviceAccountUsernamePrefix) {
+		return "", false
+	}
+
+	clientID, _ := claims["client_id"].(string)
+	if clientID == "" {
+		clientID, _ = claims["clientId"].(string)
+	}
+	if clientID == "" {
+		return "", false
+	}
+
+	if azp, _ := claims["azp"].(string); azp != "" && azp != clientID {
+		return "", false
+	}
+
+	return clientID, true
+}
+
+// EnableServiceAccounts accepts client credentials tokens of the configured clients
+func (v *TokenValidator) EnableServiceAccounts(clients []config.ServiceClientConfig) {
+	v.serviceClients = make(map[string]*ServiceClient, len(clients))
+	for _, client := range clients {
+		if client.ClientID == "" {
+			continue
+		}
+		v.serviceClients[client.ClientID] = &ServiceClient{
+			ClientID: client.ClientID,
+			Scopes:   client.Scopes,
+			Tenants:  client.Tenants,
+		}
+	}
+}
+
+// ServiceClient returns the registration of a service client, if any
+func (v *TokenValidator) ServiceClient(clientID string) (*ServiceClient, bool) {
+	client, ok := v.serviceClients[clientID]
+	return client, ok
+}
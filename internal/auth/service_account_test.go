@@ -0,0 +1,75 @@
+package auth
+
+import "testing"
+
+func TestServiceAccountFromClaims(t *testing.T) {
+	tests := []struct {
+		name     string
+		claims   map[string]interface{}
+		clientID string
+		ok       bool
+	}{
+		{
+			name:     "client credentials token",
+			claims:   map[string]interface{}{"preferred_username": "service-account-consulta-service", "azp": "consulta-service", "client_id": "consulta-service"},
+			clientID: "consulta-service",
+			ok:       true,
+		},
+		{
+			name:     "legacy clientId claim",
+			claims:   map[string]interface{}{"preferred_username": "service-account-ia-juridica", "azp": "ia-juridica", "clientId": "ia-juridica"},
+			clientID: "ia-juridica",
+			ok:       true,
+		},
+		{
+			name:   "user token",
+			claims: map[string]interface{}{"preferred_username": "ana@acme.com", "azp": "direito-lux-app"},
+		},
+		{
+			name:   "user named like a service account",
+			claims: map[string]interface{}{"preferred_username": "service-account-consulta-service", "azp": "direito-lux-app"},
+		},
+		{
+			name:   "client ID does not match authorized party",
+			claims: map[string]interface{}{"preferred_username": "service-account-x", "azp": "direito-lux-app", "client_id": "consulta-service"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientID, ok := ServiceAccountFromClaims(tt.claims)
+			if clientID != tt.clientID || ok != tt.ok {
+				t.Errorf("ServiceAccountFromClaims() = %q, %v, want %q, %v", clientID, ok, tt.clientID, tt.ok)
+			}
+		})
+	}
+}
+
+func TestServiceClientHasScope(t *testing.T) {
+	client := &ServiceClient{ClientID: "consulta-service", Scopes: []string{"tenants:read", "usage:*"}}
+
+	for scope, want := range map[string]bool{
+		"tenants:read":  true,
+		"tenants:write": false,
+		"usage:write":   true,
+		"users:read":    false,
+	} {
+		if got := client.HasScope(scope); got != want {
+			t.Errorf("HasScope(%q) = %v, want %v", scope, got, want)
+		}
+	}
+}
+
+func TestServiceClientCanActFor(t *testing.T) {
+	client := &ServiceClient{ClientID: "consulta-service", Tenants: []string{"acme"}}
+
+	for tenant, want := range map[string]bool{
+		"acme":   true,
+		"globex": false,
+		"":       false,
+	} {
+		if got := client.CanActFor(tenant); got != want {
+			t.Errorf("CanActFor(%q) = %v, want %v", tenant, got, want)
+		}
+	}
+}
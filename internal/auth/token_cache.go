@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TokenCacheKey is the Redis key caching the claims of a validated token
+func TokenCacheKey(token string) string {
+	return fmt.Sprintf("token:%s", token)
+}
+
+// TokenBlacklistKey is the Redis key marking a token as revoked
+func TokenBlacklistKey(token string) string {
+	return fmt.Sprintf("blacklist:%s", token)
+}
+
+// SessionTokensKey is the Redis set of tokens seen for a Keycloak session
+func SessionTokensKey(sessionID string) string {
+	return fmt.Sprintf("session_tokens:%s", sessionID)
+}
+
+// RevokedSessionKey is the Redis key marking a Keycloak session as revoked
+func RevokedSessionKey(sessionID string) string {
+	return fmt.Sprintf("revoked_session:%s", sessionID)
+}
+
+// SessionTokenTTL bounds how long tokens are indexed under their session and how
+// long a revoked session is remembered; it must cover the access token lifetime
+const SessionTokenTTL = 24 * time.Hour
+
+// IndexSessionToken records a token under its Keycloak session so it can be
+// purged when the session is revoked; ttl should cover the token lifetime. The
+// set expires with its longest-lived token, a shorter ttl never cuts it down.
+func IndexSessionToken(ctx context.Context, redisClient *redis.Client, sessionID, token string, ttl time.Duration) error {
+	key := SessionTokensKey(sessionID)
+
+	current, err := redisClient.TTL(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.SAdd(ctx, key, token)
+	if ttl > current {
+		pipe.Expire(ctx, key, ttl)
+	}
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+// IsSessionRevoked reports whether the Keycloak session of a token was revoked
+func IsSessionRevoked(ctx context.Context, redisClient *redis.Client, sessionID string) (bool, error) {
+	if sessionID == "" {
+		return false, nil
+	}
+	revoked, err := redisClient.Exists(ctx, RevokedSessionKey(sessionID)).Result()
+	return revoked > 0, err
+}
+
+// PurgeSessionTokens marks the session as revoked, so tokens never indexed under
+// it are rejected too, then drops the cached claims of every token seen for the
+// session and blacklists them until they expire, returning how many tokens were purged
+func PurgeSessionTokens(ctx context.Context, redisClient *redis.Client, sessionID string) (int, error) {
+	key := SessionTokensKey(sessionID)
+
+	if err := redisClient.Set(ctx, RevokedSessionKey(sessionID), "revoked", SessionTokenTTL).Err(); err != nil {
+		return 0, err
+	}
+
+	tokens, err := redisClient.SMembers(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(tokens) == 0 {
+		return 0, nil
+	}
+
+	ttl, err := redisClient.TTL(ctx, key).Result()
+	if err != nil || ttl <= 0 {
+		ttl = time.Hour
+	}
+
+	pipe := redisClient.TxPipeline()
+	for _, token := range tokens {
+		pipe.Del(ctx, TokenCacheKey(token))
+		pipe.Set(ctx, TokenBlacklistKey(token), "revoked", ttl)
+	}
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return len(tokens), nil
+}
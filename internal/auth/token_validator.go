@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/opiagile/direito-lux/internal/config"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Token validation strategies
+const (
+	// ValidationStrategyLocal verifies the token signature against the realm keys only
+	ValidationStrategyLocal = "local"
+	// ValidationStrategyIntrospection asks Keycloak about every token
+	ValidationStrategyIntrospection = "introspection"
+	// ValidationStrategyHybrid verifies locally and introspects long-lived tokens periodically
+	ValidationStrategyHybrid = "hybrid"
+
+	// DefaultValidationStrategy applies when none is configured; config.Load
+	// defaults jwt.validationStrategy to the same value
+	DefaultValidationStrategy = ValidationStrategyHybrid
+)
+
+var (
+	ErrTokenInactive       = errors.New("token is not active")
+	ErrUnknownSigningKey   = errors.New("unknown token signing key")
+	ErrInvalidStrategy     = errors.New("invalid token validation strategy")
+	ErrInvalidAudience     = errors.New("token was not issued for this client")
+	minKeysRefreshInterval = 30 * time.Second
+)
+
+// TokenValidator validates bearer tokens according to the configured strategy
+type TokenValidator struct {
+	identityProvider      IdentityProvider
+	redisClient           *redis.Client
+	strategy              string
+	introspectionInterval time.Duration
+	keysRefreshInterval   time.Duration
+	clockSkew             time.Duration
+	issuer                string
+	audience              string
+
+	keysMutex     sync.RWMutex
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+
+	impersonation  *ImpersonationSigner
+	serviceClients map[string]*ServiceClient
+
+	metrics *ValidationMetrics
+}
+
+// NewTokenValidator creates a validator for the configured strategy
+func NewTokenValidator(identityProvider IdentityProvider, redisClient *redis.Client, cfg *config.JWTConfig) (*TokenValidator, error) {
+	strategy := cfg.ValidationStrategy
+	if strategy == "" {
+		strategy = DefaultValidationStrategy
+	}
+
+	switch strategy {
+	case ValidationStrategyLocal, ValidationStrategyIntrospection, ValidationStrategyHybrid:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidStrategy, strategy)
+	}
+
+	keysRefreshInterval := cfg.KeysRefreshInterval
+	if keysRefreshInterval <= 0 {
+		keysRefreshInterval = 1 * time.Hour
+	}
+
+	return &TokenValidator{
+		identityProvider:      identityProvider,
+		redisClient:           redisClient,
+		strategy:              strategy,
+		introspectionInterval: cfg.IntrospectionInterval,
+		keysRefreshInterval:   keysRefreshInterval,
+		clockSkew:             cfg.ClockSkewLeeway,
+		issuer:                cfg.Issuer,
+		audience:              cfg.Audience,
+		metrics:               NewValidationMetrics(),
+	}, nil
+}
+
+// EnableImpersonation accepts internal impersonation tokens signed by the signer
+func (v *TokenValidator) EnableImpersonation(signer *ImpersonationSigner) {
+	v.impersonation = signer
+}
+
+// Strategy returns the active validation strategy
+func (v *TokenValidator) Strategy() string {
+	return v.strategy
+}
+
+// Metrics returns the validator latency metrics
+func (v *TokenValidator) Metrics() *ValidationMetrics {
+	return v.metrics
+}
+
+// Validate validates a token and returns its claims
+func (v *TokenValidator) Validate(ctx context.Context, tokenString string) (map[string]interface{}, error) {
+	start := time.Now()
+
+	// Impersonation tokens are issued by the API itself and never reach Keycloak
+	if v.impersonation != nil && isImpersonationToken(tokenString) {
+		claims, err := v.validateImpersonation(ctx, tokenString)
+		v.metrics.Observe("impersonation", time.Since(start), err)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}(claims), nil
+	}
+
+	var claims jwt.MapClaims
+	var err error
+
+	switch v.strategy {
+	case ValidationStrategyLocal:
+		claims, err = v.verifyLocally(ctx, tokenString)
+	case ValidationStrategyIntrospection:
+		claims, err = v.validateByIntrospection(ctx, tokenString)
+	case ValidationStrategyHybrid:
+		claims, err = v.validateHybrid(ctx, tokenString)
+	default:
+		err = ErrInvalidStrategy
+	}
+	// The impersonation issuer is reserved to tokens verified by the signer above
+	if err == nil && claims["iss"] == ImpersonationIssuer {
+		claims, err = nil, ErrInvalidImpersonationToken
+	}
+
+	v.metrics.Observe(v.strategy, time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}(claims), nil
+}
+
+// validateImpersonation verifies an impersonation token and checks its session was not revoked
+func (v *TokenValidator) validateImpersonation(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	claims, err := v.impersonation.Verify(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, _ := claims["sid"].(string)
+	if sessionID == "" || v.redisClient == nil {
+		return nil, ErrInvalidImpersonationToken
+	}
+
+	active, err := v.redisClient.Exists(ctx, ImpersonationSessionKey(sessionID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if active == 0 {
+		return nil, ErrTokenInactive
+	}
+
+	return claims, nil
+}
+
+// isImpersonationToken peeks at the unverified issuer
+func isImpersonationToken(tokenString string) bool {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return false
+	}
+	issuer, _ := claims.GetIssuer()
+	return issuer == ImpersonationIssuer
+}
+
+// validateByIntrospection trusts Keycloak's introspection result for every request
+func (v *TokenValidator) validateByIntrospection(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	if err := v.introspect(ctx, tokenString); err != nil {
+		return nil, err
+	}
+
+	// Keycloak vouched for the token, claims can be read without verifying again
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	return claims, nil
+}
+
+// validateHybrid verifies locally and introspects tokens that have been alive
+// longer than the introspection interval, at most once per interval
+func (v *TokenValidator) validateHybrid(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	claims, err := v.verifyLocally(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.introspectionInterval <= 0 {
+		return claims, nil
+	}
+
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil || time.Since(issuedAt.Time) < v.introspectionInterval {
+		return claims, nil
+	}
+
+	checkedKey := fmt.Sprintf("introspected:%s", tokenString)
+	if v.redisClient != nil {
+		if checked, _ := v.redisClient.Exists(ctx, checkedKey).Result(); checked > 0 {
+			return claims, nil
+		}
+	}
+
+	if err := v.introspect(ctx, tokenString); err != nil {
+		return nil, err
+	}
+
+	if v.redisClient != nil {
+		v.redisClient.Set(ctx, checkedKey, "1", v.introspectionInterval)
+	}
+
+	return claims, nil
+}
+
+// introspect asks Keycloak whether the token is still active
+func (v *TokenValidator) introspect(ctx context.Context, tokenString string) error {
+	start := time.Now()
+	result, err := v.identityProvider.ValidateToken(ctx, tokenString)
+	if err == nil && (result.Active == nil || !*result.Active) {
+		err = ErrTokenInactive
+	}
+	v.metrics.Observe("keycloak_introspection", time.Since(start), err)
+
+	return err
+}
+
+// verifyLocally verifies the token signature and standard claims against the realm keys
+func (v *TokenValidator) verifyLocally(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithLeeway(v.clockSkew),
+		jwt.WithExpirationRequired(),
+	}
+	if v.issuer != "" {
+		options = append(options, jwt.WithIssuer(v.issuer))
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.getKey(ctx, kid)
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	if !v.acceptsAudience(claims) {
+		return nil, ErrInvalidAudience
+	}
+
+	return claims, nil
+}
+
+// acceptsAudience checks the token was issued for our client or a registered service client.
+// Keycloak access tokens name the requesting client in "azp" and often carry only "account" in "aud".
+func (v *TokenValidator) acceptsAudience(claims jwt.MapClaims) bool {
+	if v.audience == "" {
+		return true
+	}
+
+	audiences, _ := claims.GetAudience()
+	for _, audience := range audiences {
+		if audience == v.audience {
+			return true
+		}
+	}
+
+	azp, _ := claims["azp"].(string)
+	if azp == v.audience {
+		return true
+	}
+	_, registered := v.serviceClients[azp]
+	return azp != "" && registered
+}
+
+// getKey returns the realm key for a kid, refreshing the key set when the kid is unknown
+func (v *TokenValidator) getKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.keysMutex.RLock()
+	key, found := v.keys[kid]
+	fresh := time.Since(v.keysFetchedAt) < v.keysRefreshInterval
+	recentlyFetched := time.Since(v.keysFetchedAt) < minKeysRefreshInterval
+	v.keysMutex.RUnlock()
+
+	if found && fresh {
+		return key, nil
+	}
+
+	// Avoid hammering Keycloak with tokens signed by unknown keys
+	if !found && recentlyFetched {
+		return nil, ErrUnknownSigningKey
+	}
+
+	if err := v.refreshKeys(ctx); err != nil {
+		if found {
+			logger.Warn("Failed to refresh realm keys, using cached key", zap.Error(err))
+			return key, nil
+		}
+		return nil, err
+	}
+
+	v.keysMutex.RLock()
+	defer v.keysMutex.RUnlock()
+
+	key, found = v.keys[kid]
+	if !found {
+		return nil, ErrUnknownSigningKey
+	}
+
+	return key, nil
+}
+
+// refreshKeys reloads the realm signing keys
+func (v *TokenValidator) refreshKeys(ctx context.Context) error {
+	keys, err := v.identityProvider.GetPublicKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	v.keysMutex.Lock()
+	defer v.keysMutex.Unlock()
+
+	v.keys = keys
+	v.keysFetchedAt = time.Now()
+
+	logger.Info("Loaded realm signing keys", zap.Int("count", len(keys)))
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/opiagile/direito-lux/internal/config"
+)
+
+func newTestValidator(t *testing.T, key *rsa.PrivateKey) *TokenValidator {
+	validator, err := NewTokenValidator(nil, nil, &config.JWTConfig{
+		ValidationStrategy: ValidationStrategyLocal,
+		ClockSkewLeeway:    time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create validator: %v", err)
+	}
+
+	validator.keys = map[string]*rsa.PublicKey{"test-key": &key.PublicKey}
+	validator.keysFetchedAt = time.Now()
+
+	return validator
+}
+
+func signTestToken(t *testing.T, key *rsa.PrivateKey, kid string, expiresIn time.Duration) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub": "user-1",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(expiresIn).Unix(),
+	})
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	return signed
+}
+
+func TestValidateLocal(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	validator := newTestValidator(t, key)
+
+	claims, err := validator.Validate(context.Background(), signTestToken(t, key, "test-key", time.Minute))
+	if err != nil {
+		t.Fatalf("Expected valid token, got %v", err)
+	}
+	if claims["sub"] != "user-1" {
+		t.Errorf("Expected sub user-1, got %v", claims["sub"])
+	}
+
+	if _, err := validator.Validate(context.Background(), signTestToken(t, key, "test-key", -time.Minute)); err == nil {
+		t.Error("Expected expired token to be rejected")
+	}
+
+	if _, err := validator.Validate(context.Background(), signTestToken(t, key, "other-key", time.Minute)); !errors.Is(err, ErrUnknownSigningKey) {
+		t.Errorf("Expected ErrUnknownSigningKey, got %v", err)
+	}
+
+	stats := validator.Metrics().Snapshot()[ValidationStrategyLocal]
+	if stats.Count != 3 || stats.Failures != 2 {
+		t.Errorf("Expected 3 validations with 2 failures, got %d/%d", stats.Count, stats.Failures)
+	}
+}
+
+func TestNewTokenValidatorRejectsUnknownStrategy(t *testing.T) {
+	_, err := NewTokenValidator(nil, nil, &config.JWTConfig{ValidationStrategy: "magic"})
+	if !errors.Is(err, ErrInvalidStrategy) {
+		t.Errorf("Expected ErrInvalidStrategy, got %v", err)
+	}
+}
+
+func TestNewTokenValidatorDefaultStrategy(t *testing.T) {
+	validator, err := NewTokenValidator(nil, nil, &config.JWTConfig{})
+	if err != nil {
+		t.Fatalf("NewTokenValidator() error = %v", err)
+	}
+	if validator.Strategy() != ValidationStrategyHybrid {
+		t.Errorf("Strategy() = %q, want %q as config defaults to", validator.Strategy(), ValidationStrategyHybrid)
+	}
+}
+
+func TestValidateRejectsImpersonationIssuerOutsideSigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	validator := newTestValidator(t, key)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": ImpersonationIssuer,
+		"sub": "user-1",
+		"sid": "session-1",
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"act": map[string]interface{}{"sub": "admin-1"},
+	})
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	if _, err := validator.Validate(context.Background(), signed); !errors.Is(err, ErrInvalidImpersonationToken) {
+		t.Errorf("Expected ErrInvalidImpersonationToken, got %v", err)
+	}
+}
+
+func TestValidateLocalChecksAudience(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	validator := newTestValidator(t, key)
+	validator.audience = "direito-lux-app"
+	validator.EnableServiceAccounts([]config.ServiceClientConfig{{ClientID: "billing-worker"}})
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   error
+	}{
+		{"audience", jwt.MapClaims{"aud": []string{"account", "direito-lux-app"}}, nil},
+		{"authorized party", jwt.MapClaims{"aud": "account", "azp": "direito-lux-app"}, nil},
+		{"service client", jwt.MapClaims{"azp": "billing-worker"}, nil},
+		{"other client", jwt.MapClaims{"aud": "account", "azp": "other-app"}, ErrInvalidAudience},
+		{"no audience", jwt.MapClaims{}, ErrInvalidAudience},
+	}
+
+	for _, tt := range tests {
+		tt.claims["sub"] = "user-1"
+		tt.claims["exp"] = time.Now().Add(time.Minute).Unix()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, tt.claims)
+		token.Header["kid"] = "test-key"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+
+		if _, err := validator.Validate(context.Background(), signed); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Validate() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidationMetricsWritePrometheus(t *testing.T) {
+	metrics := NewValidationMetrics()
+	metrics.Observe(ValidationStrategyLocal, 3*time.Millisecond, nil)
+	metrics.Observe(ValidationStrategyLocal, 2*time.Second, ErrTokenInactive)
+
+	var out strings.Builder
+	if err := metrics.WritePrometheus(&out); err != nil {
+		t.Fatalf("WritePrometheus() error = %v", err)
+	}
+
+	for _, want := range []string{
+		`direito_lux_token_validation_duration_seconds_bucket{operation="local",le="0.005"} 1`,
+		`direito_lux_token_validation_duration_seconds_bucket{operation="local",le="2.5"} 2`,
+		`direito_lux_token_validation_duration_seconds_bucket{operation="local",le="+Inf"} 2`,
+		`direito_lux_token_validation_duration_seconds_count{operation="local"} 2`,
+		`direito_lux_token_validation_failures_total{operation="local"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected exposition to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1 secret, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(secret, time.Unix(tt.unix, 0))
+		if err != nil || got != tt.want {
+			t.Errorf("TOTPCode(%d) = %q, %v, want %q", tt.unix, got, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+	now := time.Now()
+	code, _ := TOTPCode(secret, now)
+
+	if !VerifyTOTP(secret, code, now.Add(totpPeriod)) {
+		t.Error("Expected the code of the previous period to be accepted")
+	}
+	if VerifyTOTP(secret, code, now.Add(3*totpPeriod)) {
+		t.Error("Expected an old code to be rejected")
+	}
+	if VerifyTOTP(secret, "12345", now) {
+		t.Error("Expected a short code to be rejected")
+	}
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the Prometheus latency histogram
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// LatencyStats holds latency statistics for a validation operation
+type LatencyStats struct {
+	Count        uint64  `json:"count"`
+	Failures     uint64  `json:"failures"`
+	AverageMs    float64 `json:"average_ms"`
+	MaxMs        float64 `json:"max_ms"`
+	LastMs       float64 `json:"last_ms"`
+	totalLatency time.Duration
+	buckets      []uint64
+}
+
+// ValidationMetrics collects latency metrics per validation strategy
+type ValidationMetrics struct {
+	mutex sync.Mutex
+	stats map[string]*LatencyStats
+}
+
+// NewValidationMetrics creates an empty metrics collector
+func NewValidationMetrics() *ValidationMetrics {
+	return &ValidationMetrics{
+		stats: make(map[string]*LatencyStats),
+	}
+}
+
+// Observe records the latency and outcome of an operation
+func (m *ValidationMetrics) Observe(operation string, latency time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats, exists := m.stats[operation]
+	if !exists {
+		stats = &LatencyStats{buckets: make([]uint64, len(latencyBuckets))}
+		m.stats[operation] = stats
+	}
+
+	latencyMs := float64(latency) / float64(time.Millisecond)
+
+	stats.Count++
+	if err != nil {
+		stats.Failures++
+	}
+	stats.totalLatency += latency
+	stats.AverageMs = float64(stats.totalLatency) / float64(time.Millisecond) / float64(stats.Count)
+	stats.LastMs = latencyMs
+	if latencyMs > stats.MaxMs {
+		stats.MaxMs = latencyMs
+	}
+	for i, bound := range latencyBuckets {
+		if latency.Seconds() <= bound {
+			stats.buckets[i]++
+		}
+	}
+}
+
+// Snapshot returns a copy of the current metrics
+func (m *ValidationMetrics) Snapshot() map[string]LatencyStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	snapshot := make(map[string]LatencyStats, len(m.stats))
+	for operation, stats := range m.stats {
+		snapshot[operation] = *stats
+	}
+
+	return snapshot
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format
+func (m *ValidationMetrics) WritePrometheus(w io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	operations := make([]string, 0, len(m.stats))
+	for operation := range m.stats {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+
+	lines := []string{
+		"# HELP direito_lux_token_validation_duration_seconds Token validation latency per strategy",
+		"# TYPE direito_lux_token_validation_duration_seconds histogram",
+	}
+	for _, operation := range operations {
+		stats := m.stats[operation]
+		for i, bound := range latencyBuckets {
+			lines = append(lines, fmt.Sprintf(`direito_lux_token_validation_duration_seconds_bucket{operation=%q,le="%s"} %d`,
+				operation, strconv.FormatFloat(bound, 'f', -1, 64), stats.buckets[i]))
+		}
+		lines = append(lines,
+			fmt.Sprintf(`direito_lux_token_validation_duration_seconds_bucket{operation=%q,le="+Inf"} %d`, operation, stats.Count),
+			fmt.Sprintf(`direito_lux_token_validation_duration_seconds_sum{operation=%q} %s`,
+				operation, strconv.FormatFloat(stats.totalLatency.Seconds(), 'g', -1, 64)),
+			fmt.Sprintf(`direito_lux_token_validation_duration_seconds_count{operation=%q} %d`, operation, stats.Count))
+	}
+
+	lines = append(lines,
+		"# HELP direito_lux_token_validation_failures_total Rejected token validations per strategy",
+		"# TYPE direito_lux_token_validation_failures_total counter")
+	for _, operation := range operations {
+		lines = append(lines, fmt.Sprintf(`direito_lux_token_validation_failures_total{operation=%q} %d`,
+			operation, m.stats[operation].Failures))
+	}
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
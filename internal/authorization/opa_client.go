@@ -58,6 +58,8 @@ type User struct {
 	Email        string   `json:"email"`
 	Groups       []string `json:"groups,omitempty"`
 	IsSuperAdmin bool     `json:"is_super_admin"`
+	// ImpersonatedBy is the super admin acting as this user, if any
+	ImpersonatedBy string `json:"impersonated_by,omitempty"`
 }
 
 // Resource represents the resource being accessed
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -16,6 +17,14 @@ type Config struct {
 	JWT             JWTConfig
 	Logger          LoggerConfig
 	ConsultaService ConsultaServiceConfig
+	Reconciliation  ReconciliationConfig
+	Impersonation   ImpersonationConfig
+	Identity        IdentityConfig
+	LoginProtection LoginProtectionConfig
+	ServiceAuth     ServiceAuthConfig
+	Tenancy         TenancyConfig
+	Billing         BillingConfig
+	Payments        PaymentsConfig
 }
 
 type ServerConfig struct {
@@ -53,9 +62,14 @@ type KeycloakConfig struct {
 }
 
 type JWTConfig struct {
-	PublicKeyPath   string
-	CacheDuration   time.Duration
-	ClockSkewLeeway time.Duration
+	PublicKeyPath         string
+	CacheDuration         time.Duration
+	ClockSkewLeeway       time.Duration
+	ValidationStrategy    string        // local, introspection, hybrid (default, as auth.DefaultValidationStrategy)
+	IntrospectionInterval time.Duration // hybrid: re-introspect tokens older than this
+	KeysRefreshInterval   time.Duration
+	Issuer                string // expected "iss" claim, defaults to the Keycloak realm URL
+	Audience              string // expected "aud" or "azp" claim, defaults to the Keycloak client
 }
 
 type LoggerConfig struct {
@@ -68,6 +82,128 @@ type ConsultaServiceConfig struct {
 	Port string
 }
 
+type ReconciliationConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	Policy   string // report, keycloak (Keycloak wins) or database (database wins)
+}
+
+type IdentityConfig struct {
+	Provider string // keycloak, or memory for local development and tests
+	// Super admin seeded into the memory provider
+	AdminEmail    string
+	AdminPassword string
+	AdminTenant   string
+}
+
+type LoginProtectionConfig struct {
+	MaxAttemptsPerEmail int           // failures before the account is locked
+	MaxAttemptsPerIP    int           // failures before the client IP is locked
+	Window              time.Duration // failures older than this are forgotten
+	LockoutDuration     time.Duration
+	BaseDelay           time.Duration // delay after the first failure, doubled on each further failure
+	MaxDelay            time.Duration
+}
+
+type TenancyConfig struct {
+	// Tenants are reachable at <name>.<BaseDomain>, or at a verified custom domain
+	BaseDomain         string
+	ReservedSubdomains []string // subdomains of BaseDomain that are not tenants, e.g. www, api
+	// Offboarded tenants are hard deleted once the grace period ends
+	OffboardingGracePeriod time.Duration
+	ExportDir              string // where offboarding writes the tenant data exports
+	PurgeInterval          time.Duration
+	// Failed or interrupted tenant provisionings are rolled back once stale for this long
+	ProvisioningStaleAfter      time.Duration
+	ProvisioningCleanupInterval time.Duration
+}
+
+type BillingConfig struct {
+	TrialDays         int   // length of the trial new tenants start with
+	TrialReminderDays []int // days before the trial ends on which the tenant admins are reminded
+	SchedulerInterval time.Duration
+	// Usage counters live in Redis and are copied to Postgres at this interval
+	UsageFlushInterval  time.Duration
+	UsageHistoryPeriods int // periods returned in the tenant usage history
+	InvoiceDueDays      int // days from issue to due date
+	Issuer              InvoiceIssuerConfig
+	Dunning             DunningConfig
+}
+
+// DunningConfig is the schedule followed once a subscription is past due, in days
+// since the payment failed or the invoice became overdue
+type DunningConfig struct {
+	RetryDays         []int // days on which the charge is retried and the tenant admins notified
+	ReadOnlyAfterDays int   // the tenant becomes read-only, its subscription unpaid
+	SuspendAfterDays  int   // end of the grace period: the tenant is suspended
+}
+
+// InvoiceIssuerConfig is the company issuing the tenant invoices
+type InvoiceIssuerConfig struct {
+	Code      string // prefixes the invoice numbers, which are sequential per code
+	LegalName string
+	TaxID     string // CNPJ
+	Email     string
+	Address   []string // lines printed on invoices
+}
+
+type PaymentsConfig struct {
+	Provider string // stripe, or empty to disable card payments
+	Stripe   StripeConfig
+	Pix      PixConfig
+	Boleto   BoletoConfig
+	// Bank or PSP confirming Pix and boleto payments
+	Confirmations PaymentConfirmationsConfig
+}
+
+type StripeConfig struct {
+	APIURL        string // Stripe API base URL, pointed at a local fake in tests
+	SecretKey     string
+	WebhookSecret string // signs the webhook events, whsec_...
+	// Webhook events signed longer ago than this are refused as replays
+	WebhookTolerance time.Duration
+}
+
+// PixConfig is the account receiving Pix payments; Pix is disabled without a key
+type PixConfig struct {
+	Key          string // Pix key: CNPJ, e-mail, phone or random key
+	MerchantName string // up to 25 characters
+	MerchantCity string // up to 15 characters
+}
+
+// BoletoConfig is the bank account collecting boletos; boletos are disabled
+// without an agency and account
+type BoletoConfig struct {
+	BankCode string // only 237 (Bradesco) is supported
+	Agency   string
+	Wallet   string // carteira
+	Account  string
+}
+
+type PaymentConfirmationsConfig struct {
+	Provider string // signed, or empty to disable the confirmation webhook
+	// HMAC key of the signed provider; signatures older than the tolerance are refused
+	Secret    string
+	Tolerance time.Duration
+}
+
+type ServiceAuthConfig struct {
+	// Keycloak clients allowed to call the API with client credentials
+	Clients []ServiceClientConfig
+}
+
+type ServiceClientConfig struct {
+	ClientID string
+	Scopes   []string // same format as API key scopes, e.g. "tenants:read"
+	Tenants  []string // names of the tenants the client may act for
+}
+
+type ImpersonationConfig struct {
+	SigningKey string // HMAC key for impersonation tokens, at least 32 bytes
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+}
+
 func Load() (*Config, error) {
 	// Set defaults first
 	setDefaults()
@@ -101,12 +237,30 @@ func Load() (*Config, error) {
 	viper.BindEnv("redis.host", "DIREITO_LUX_REDIS_HOST")
 	viper.BindEnv("redis.port", "DIREITO_LUX_REDIS_PORT")
 	viper.BindEnv("redis.password", "DIREITO_LUX_REDIS_PASSWORD")
+	viper.BindEnv("jwt.validationStrategy", "DIREITO_LUX_JWT_VALIDATION_STRATEGY")
+	viper.BindEnv("jwt.issuer", "DIREITO_LUX_JWT_ISSUER")
+	viper.BindEnv("jwt.audience", "DIREITO_LUX_JWT_AUDIENCE")
+	viper.BindEnv("impersonation.signingKey", "DIREITO_LUX_IMPERSONATION_SIGNING_KEY")
+	viper.BindEnv("identity.provider", "DIREITO_LUX_IDENTITY_PROVIDER")
+	viper.BindEnv("identity.adminEmail", "DIREITO_LUX_IDENTITY_ADMIN_EMAIL")
+	viper.BindEnv("identity.adminPassword", "DIREITO_LUX_IDENTITY_ADMIN_PASSWORD")
+	viper.BindEnv("payments.stripe.secretKey", "DIREITO_LUX_STRIPE_SECRET_KEY")
+	viper.BindEnv("payments.stripe.webhookSecret", "DIREITO_LUX_STRIPE_WEBHOOK_SECRET")
+	viper.BindEnv("payments.confirmations.secret", "DIREITO_LUX_PAYMENT_CONFIRMATIONS_SECRET")
 
 	var config Config
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("unable to decode config: %w", err)
 	}
 
+	// Tokens are only trusted when issued by our realm for our client
+	if config.JWT.Issuer == "" {
+		config.JWT.Issuer = strings.TrimRight(config.Keycloak.BaseURL, "/") + "/realms/" + config.Keycloak.Realm
+	}
+	if config.JWT.Audience == "" {
+		config.JWT.Audience = config.Keycloak.ClientID
+	}
+
 	return &config, nil
 }
 
@@ -142,6 +296,9 @@ func setDefaults() {
 	// JWT defaults
 	viper.SetDefault("jwt.cacheDuration", "5m")
 	viper.SetDefault("jwt.clockSkewLeeway", "5m")
+	viper.SetDefault("jwt.validationStrategy", "hybrid")
+	viper.SetDefault("jwt.introspectionInterval", "5m")
+	viper.SetDefault("jwt.keysRefreshInterval", "1h")
 
 	// Logger defaults
 	viper.SetDefault("logger.level", "info")
@@ -150,6 +307,55 @@ func setDefaults() {
 
 	// Consulta Service defaults
 	viper.SetDefault("consultaService.port", "9002")
+
+	// Reconciliation defaults
+	viper.SetDefault("reconciliation.enabled", true)
+	viper.SetDefault("reconciliation.interval", "1h")
+	viper.SetDefault("reconciliation.policy", "report")
+
+	// Identity defaults
+	viper.SetDefault("identity.provider", "keycloak")
+	viper.SetDefault("identity.adminTenant", "direito-lux")
+
+	// Login protection defaults
+	viper.SetDefault("loginProtection.maxAttemptsPerEmail", 5)
+	viper.SetDefault("loginProtection.maxAttemptsPerIP", 20)
+	viper.SetDefault("loginProtection.window", "15m")
+	viper.SetDefault("loginProtection.lockoutDuration", "15m")
+	viper.SetDefault("loginProtection.baseDelay", "500ms")
+	viper.SetDefault("loginProtection.maxDelay", "5s")
+
+	// Tenancy defaults
+	viper.SetDefault("tenancy.baseDomain", "direitolux.com.br")
+	viper.SetDefault("tenancy.reservedSubdomains", []string{"www", "api", "app", "admin", "auth"})
+	viper.SetDefault("tenancy.offboardingGracePeriod", "720h")
+	viper.SetDefault("tenancy.exportDir", "exports")
+	viper.SetDefault("tenancy.purgeInterval", "1h")
+	viper.SetDefault("tenancy.provisioningStaleAfter", "1h")
+	viper.SetDefault("tenancy.provisioningCleanupInterval", "10m")
+
+	// Billing defaults
+	viper.SetDefault("billing.trialDays", 14)
+	viper.SetDefault("billing.trialReminderDays", []int{7, 3, 1})
+	viper.SetDefault("billing.schedulerInterval", "1h")
+	viper.SetDefault("billing.usageFlushInterval", "1m")
+	viper.SetDefault("billing.usageHistoryPeriods", 12)
+	viper.SetDefault("billing.invoiceDueDays", 10)
+	viper.SetDefault("billing.issuer.code", "DLX")
+	viper.SetDefault("billing.issuer.legalName", "Direito Lux Tecnologia Ltda")
+	viper.SetDefault("billing.dunning.retryDays", []int{1, 3, 7})
+	viper.SetDefault("billing.dunning.readOnlyAfterDays", 10)
+	viper.SetDefault("billing.dunning.suspendAfterDays", 20)
+
+	// Payments defaults
+	viper.SetDefault("payments.stripe.apiURL", "https://api.stripe.com")
+	viper.SetDefault("payments.stripe.webhookTolerance", "5m")
+	viper.SetDefault("payments.boleto.bankCode", "237")
+	viper.SetDefault("payments.confirmations.tolerance", "5m")
+
+	// Impersonation defaults
+	viper.SetDefault("impersonation.defaultTTL", "30m")
+	viper.SetDefault("impersonation.maxTTL", "2h")
 }
 
 func (c *Config) GetDSN() string {
@@ -79,4 +79,17 @@ func TestIsProduction(t *testing.T) {
 	if cfg.IsProduction() {
 		t.Error("Expected IsProduction to return false for debug mode")
 	}
-}
\ No newline at end of file
+}
+func TestLoadDefaultsTokenIssuerAndAudience(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if want := "http://localhost:8080/realms/direito-lux"; cfg.JWT.Issuer != want {
+		t.Errorf("Expected issuer %s, got %s", want, cfg.JWT.Issuer)
+	}
+	if want := "direito-lux-app"; cfg.JWT.Audience != want {
+		t.Errorf("Expected audience %s, got %s", want, cfg.JWT.Audience)
+	}
+}
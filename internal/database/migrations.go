@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -32,13 +33,16 @@ type Migration struct {
 type MigrationManager struct {
 	db         *gorm.DB
 	migrations []Migration
+	trialDays  int // duração configurada do trial, usada ao preencher dados antigos
 }
 
 // NewMigrationManager cria uma nova instância do gerenciador de migrations
-func NewMigrationManager(db *gorm.DB) *MigrationManager {
+func NewMigrationManager(db *gorm.DB, trialDays int) *MigrationManager {
+	// Migrations operam no banco inteiro, fora do escopo de um tenant
 	manager := &MigrationManager{
-		db:         db,
+		db:         db.WithContext(domain.CrossTenant(context.Background())),
 		migrations: make([]Migration, 0),
+		trialDays:  trialDays,
 	}
 
 	// Registra todas as migrations
@@ -134,8 +138,346 @@ func (m *MigrationManager) registerMigrations() {
 			return nil
 		},
 	})
+
+	// Migration 004: Prefixo das API keys
+	m.addMigration(Migration{
+		Version:     "004_add_api_key_prefix",
+		Description: "Adicionar prefixo às API keys para identificação sem expor o segredo",
+		Checksum:    "sha256:jkl012mno345",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&domain.APIKey{})
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropColumn(&domain.APIKey{}, "prefix")
+		},
+	})
+
+	// Migration 005: Verificação de domínio customizado
+	m.addMigration(Migration{
+		Version:     "005_add_tenant_domain_verification",
+		Description: "Adicionar verificação DNS de domínio customizado e permitir vários tenants sem domínio",
+		Checksum:    "sha256:mno345pqr678",
+		Up: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&domain.Tenant{}); err != nil {
+				return err
+			}
+
+			// Tenants sem domínio guardam string vazia, que não pode colidir no índice único
+			if err := db.Exec("UPDATE tenants SET domain = lower(domain) WHERE domain <> ''").Error; err != nil {
+				return err
+			}
+			if err := db.Exec("DROP INDEX IF EXISTS idx_tenants_domain").Error; err != nil {
+				return err
+			}
+			return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_tenants_domain ON tenants(domain) WHERE domain <> '' AND deleted_at IS NULL").Error
+		},
+		Down: func(db *gorm.DB) error {
+			db.Exec("DROP INDEX IF EXISTS idx_tenants_domain")
+			db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_tenants_domain ON tenants(domain)")
+			db.Migrator().DropColumn(&domain.Tenant{}, "domain_verification_token")
+			return db.Migrator().DropColumn(&domain.Tenant{}, "domain_verified_at")
+		},
+	})
+
+	// Migration 006: Row-level security por tenant
+	m.addMigration(Migration{
+		Version:     "006_enable_tenant_row_level_security",
+		Description: "Habilitar RLS nas tabelas de tenant, filtrando pelas variáveis app.tenant_id e app.cross_tenant",
+		Checksum:    "sha256:pqr678stu901",
+		Up: func(db *gorm.DB) error {
+			for _, table := range tenantOwnedTables {
+				if err := enableRowLevelSecurity(db, table); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			for _, table := range tenantOwnedTables {
+				disableRowLevelSecurity(db, table)
+			}
+			return nil
+		},
+	})
+
+	// Migration 007: Ciclo de vida do tenant
+	m.addMigration(Migration{
+		Version:     "007_add_tenant_lifecycle",
+		Description: "Adicionar suspensão, offboarding e exclusão agendada de tenants",
+		Checksum:    "sha256:stu901vwx234",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&domain.Tenant{})
+		},
+		Down: func(db *gorm.DB) error {
+			for _, column := range []string{"status_reason", "suspended_at", "offboarded_at", "deletion_scheduled_at", "data_export_path"} {
+				if err := db.Migrator().DropColumn(&domain.Tenant{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+
+	// Migration 008: Saga de criação de tenants
+	m.addMigration(Migration{
+		Version:     "008_create_tenant_provisionings",
+		Description: "Criar tabela tenant_provisionings com os passos e compensações da criação de tenants",
+		Checksum:    "sha256:vwx234yza567",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&domain.TenantProvisioning{})
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropTable(&domain.TenantProvisioning{})
+		},
+	})
+
+	// Migration 009: Ciclo de vida das assinaturas
+	m.addMigration(Migration{
+		Version:     "009_add_subscription_trial_reminders",
+		Description: "Adicionar lembretes de fim de trial e preencher trial_ends_at das assinaturas em trial",
+		Checksum:    "sha256:yza567bcd890",
+		Up: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&domain.Subscription{}); err != nil {
+				return err
+			}
+			// Trials criados antes desta versão não tinham data de fim.
+			// A transação da migration enxerga todos os tenants apesar do RLS.
+			if err := db.Exec("SET LOCAL app.cross_tenant = 'on'").Error; err != nil {
+				return err
+			}
+			return db.Exec("UPDATE subscriptions SET trial_ends_at = start_date + make_interval(days => ?) WHERE status = ? AND trial_ends_at IS NULL",
+				m.trialDays, domain.SubscriptionStatusTrialing).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropColumn(&domain.Subscription{}, "trial_reminders_sent")
+		},
+	})
+
+	// Migration 010: Troca de plano com proração
+	m.addMigration(Migration{
+		Version:     "010_create_plan_changes",
+		Description: "Criar tabela plan_changes e adicionar período de cobrança e plano pendente às assinaturas",
+		Checksum:    "sha256:bcd890efg123",
+		Up: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&domain.Subscription{}, &domain.PlanChange{}); err != nil {
+				return err
+			}
+			return enableRowLevelSecurity(db, "plan_changes")
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Migrator().DropTable(&domain.PlanChange{}); err != nil {
+				return err
+			}
+			for _, column := range []string{"current_period_start", "current_period_end", "pending_plan_id"} {
+				if err := db.Migrator().DropColumn(&domain.Subscription{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+
+	// Migration 011: Versões de planos
+	m.addMigration(Migration{
+		Version:     "011_add_plan_versions",
+		Description: "Versionar planos: o nome deixa de ser único e passa a ser único junto com a versão",
+		Checksum:    "sha256:efg123hij456",
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec("DROP INDEX IF EXISTS idx_plans_name").Error; err != nil {
+				return err
+			}
+			// Os planos existentes viram a versão 1
+			return db.AutoMigrate(&domain.Plan{})
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Exec("DELETE FROM plans WHERE version > 1").Error; err != nil {
+				return err
+			}
+			if err := db.Exec("DROP INDEX IF EXISTS idx_plans_name_version").Error; err != nil {
+				return err
+			}
+			if err := db.Migrator().DropColumn(&domain.Plan{}, "version"); err != nil {
+				return err
+			}
+			return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_plans_name ON plans (name)").Error
+		},
+	})
+
+	// Migration 012: Medição de uso por tenant
+	m.addMigration(Migration{
+		Version:     "012_create_usage_records",
+		Description: "Criar tabela usage_records com os contadores de uso por tenant, período e métrica",
+		Checksum:    "sha256:hij456klm789",
+		Up: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&domain.UsageRecord{}); err != nil {
+				return err
+			}
+			return enableRowLevelSecurity(db, "usage_records")
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropTable(&domain.UsageRecord{})
+		},
+	})
+
+	// Migration 013: Faturas
+	m.addMigration(Migration{
+		Version:     "013_create_invoices",
+		Description: "Criar tabelas invoices e invoice_sequences e adicionar dados fiscais aos tenants e saldo de crédito às assinaturas",
+		Checksum:    "sha256:klm789nop012",
+		Up: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&domain.Tenant{}, &domain.Subscription{}, &domain.PlanChange{}, &domain.Invoice{}, &domain.InvoiceSequence{}); err != nil {
+				return err
+			}
+			// Números são únicos por emissor; rascunhos ainda não têm número
+			if err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_issuer_number ON invoices (issuer, number) WHERE number <> ''").Error; err != nil {
+				return err
+			}
+			// Uma fatura por período, fora as canceladas
+			if err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_period ON invoices (subscription_id, period_start) WHERE status <> 'void' AND deleted_at IS NULL").Error; err != nil {
+				return err
+			}
+			return enableRowLevelSecurity(db, "invoices")
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Migrator().DropTable(&domain.Invoice{}, &domain.InvoiceSequence{}); err != nil {
+				return err
+			}
+			db.Migrator().DropColumn(&domain.PlanChange{}, "invoice_id")
+			db.Migrator().DropColumn(&domain.Subscription{}, "credit_balance")
+			return db.Migrator().DropColumn(&domain.Tenant{}, "legal_entity")
+		},
+	})
+
+	// Migration 014: Gateway de pagamento
+	m.addMigration(Migration{
+		Version:     "014_create_payment_events",
+		Description: "Criar tabela payment_events e adicionar identificadores do gateway de pagamento a planos e assinaturas",
+		Checksum:    "sha256:nop012qrs345",
+		Up: func(db *gorm.DB) error {
+			// payment_events não pertence a um tenant: o webhook só descobre o tenant depois de registrar o evento
+			return db.AutoMigrate(&domain.Plan{}, &domain.Subscription{}, &domain.PaymentEvent{})
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Migrator().DropTable(&domain.PaymentEvent{}); err != nil {
+				return err
+			}
+			db.Migrator().DropIndex(&domain.Subscription{}, "StripeSubID")
+			db.Migrator().DropColumn(&domain.Subscription{}, "stripe_customer_id")
+			return db.Migrator().DropColumn(&domain.Plan{}, "stripe_price_id")
+		},
+	})
+
+	// Migration 015: Pagamento de faturas por Pix e boleto
+	m.addMigration(Migration{
+		Version:     "015_add_invoice_payment_references",
+		Description: "Adicionar txid Pix e nosso número de boleto às faturas",
+		Checksum:    "sha256:qrs345tuv678",
+		Up: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&domain.Invoice{}); err != nil {
+				return err
+			}
+			// Faturas já emitidas: mesmas referências que InvoiceService atribui ao emitir.
+			// A transação da migration enxerga todos os tenants apesar do RLS.
+			if err := db.Exec("SET LOCAL app.cross_tenant = 'on'").Error; err != nil {
+				return err
+			}
+			return db.Exec(`UPDATE invoices SET
+				pix_tx_id = regexp_replace(number, '[^A-Za-z0-9]', '', 'g'),
+				boleto_number = lpad(right(regexp_replace(number, '\D', '', 'g'), 11), 11, '0')
+				WHERE number <> '' AND coalesce(pix_tx_id, '') = ''`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			db.Migrator().DropColumn(&domain.Invoice{}, "pix_tx_id")
+			return db.Migrator().DropColumn(&domain.Invoice{}, "boleto_number")
+		},
+	})
+
+	// Migration 016: Régua de cobrança
+	m.addMigration(Migration{
+		Version:     "016_add_dunning",
+		Description: "Adicionar estado de cobrança de assinaturas em atraso e modo somente leitura de tenants",
+		Checksum:    "sha256:tuv678wxy901",
+		Up: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&domain.Tenant{}, &domain.Subscription{}); err != nil {
+				return err
+			}
+			// Assinaturas já em atraso entram na régua de cobrança a partir da última alteração
+			if err := db.Exec("SET LOCAL app.cross_tenant = 'on'").Error; err != nil {
+				return err
+			}
+			return db.Exec(`UPDATE subscriptions SET past_due_since = updated_at
+				WHERE status IN ('past_due', 'unpaid') AND past_due_since IS NULL`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			db.Migrator().DropColumn(&domain.Subscription{}, "past_due_since")
+			db.Migrator().DropColumn(&domain.Subscription{}, "dunning_retries_done")
+			db.Migrator().DropColumn(&domain.Subscription{}, "stripe_invoice_id")
+			return db.Migrator().DropColumn(&domain.Tenant{}, "read_only")
+		},
+	})
+
+	// Migration 017: Cobrança de excedente
+	m.addMigration(Migration{
+		Version:     "017_add_usage_record_invoice",
+		Description: "Adicionar a fatura que cobrou o excedente a usage_records",
+		Checksum:    "sha256:wxy901zab234",
+		Up: func(db *gorm.DB) error {
+			// Os preços de excedente ficam nos limites do plano, já serializados em JSON
+			return db.AutoMigrate(&domain.UsageRecord{})
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropColumn(&domain.UsageRecord{}, "invoice_id")
+		},
+	})
+
+	// Migration 018: Faturas cobradas pelo gateway
+	m.addMigration(Migration{
+		Version:     "018_add_invoice_charged_by",
+		Description: "Adicionar a invoices o gateway que cobra a fatura no cartão",
+		Checksum:    "sha256:zab234cde567",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&domain.Invoice{})
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropColumn(&domain.Invoice{}, "charged_by")
+		},
+	})
+}
+
+// tenantOwnedTables são as tabelas dos modelos domain.TenantOwned existentes na
+// migration 006; tabelas criadas depois habilitam RLS na própria migration
+var tenantOwnedTables = []string{"users", "subscriptions", "audit_logs", "api_keys"}
+
+// enableRowLevelSecurity restringe a tabela às linhas do tenant da conexão
+func enableRowLevelSecurity(db *gorm.DB, table string) error {
+	statements := []string{
+		fmt.Sprintf("ALTER TABLE %s ENABLE ROW LEVEL SECURITY", table),
+		// Vale também para o dono da tabela; superusuários continuam ignorando RLS
+		fmt.Sprintf("ALTER TABLE %s FORCE ROW LEVEL SECURITY", table),
+		fmt.Sprintf("DROP POLICY IF EXISTS tenant_isolation ON %s", table),
+		fmt.Sprintf(`CREATE POLICY tenant_isolation ON %s
+			USING (%[2]s)
+			WITH CHECK (%[2]s)`, table, tenantIsolationPredicate),
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// disableRowLevelSecurity desfaz enableRowLevelSecurity
+func disableRowLevelSecurity(db *gorm.DB, table string) {
+	db.Exec(fmt.Sprintf("DROP POLICY IF EXISTS tenant_isolation ON %s", table))
+	db.Exec(fmt.Sprintf("ALTER TABLE %s NO FORCE ROW LEVEL SECURITY", table))
+	db.Exec(fmt.Sprintf("ALTER TABLE %s DISABLE ROW LEVEL SECURITY", table))
+}
+
+// tenantIsolationPredicate libera as linhas do tenant definido em app.tenant_id pelo
+// plugin TenantScope, ou todas quando a query foi marcada com domain.CrossTenant
+const tenantIsolationPredicate = "current_setting('app.cross_tenant', true) = 'on' OR tenant_id::text = current_setting('app.tenant_id', true)"
+
 // addMigration adiciona uma migration à lista
 func (m *MigrationManager) addMigration(migration Migration) {
 	m.migrations = append(m.migrations, migration)
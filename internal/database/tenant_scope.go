@@ -0,0 +1,230 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	// ErrMissingTenantScope é retornado por queries em modelos de tenant sem tenant no contexto
+	ErrMissingTenantScope = errors.New("query on tenant-owned model without tenant scope")
+	// ErrTenantMismatch é retornado ao gravar um registro de outro tenant
+	ErrTenantMismatch = errors.New("record belongs to another tenant")
+	// ErrRowQueryOutsideTransaction é retornado por Row/Rows/Scan em modelos de tenant fora de uma transação
+	ErrRowQueryOutsideTransaction = errors.New("row queries on tenant-owned models must run inside a transaction")
+)
+
+const (
+	tenantScopeStartedTx = "tenant_scope:started_transaction"
+	tenantIDField        = "TenantID"
+)
+
+// TenantScope é um plugin GORM que restringe toda query em modelos domain.TenantOwned
+// ao tenant do contexto (domain.WithTenantID). Queries sem tenant falham, a menos
+// que o contexto seja marcado com domain.CrossTenant.
+//
+// Como segunda camada, cada comando roda numa transação com as variáveis
+// app.tenant_id e app.cross_tenant, usadas pelas políticas RLS do Postgres
+// (migration 006).
+type TenantScope struct{}
+
+func (TenantScope) Name() string {
+	return "direito-lux:tenant_scope"
+}
+
+func (p TenantScope) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+
+	if err := callbacks.Query().Before("gorm:query").Register("tenant_scope:query", p.begin(p.scopeWhere)); err != nil {
+		return err
+	}
+	if err := callbacks.Query().After("gorm:after_query").Register("tenant_scope:commit_query", p.commit); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("tenant_scope:row", p.scopeRow); err != nil {
+		return err
+	}
+
+	// Create, update e delete já rodam na transação padrão do GORM
+	if err := callbacks.Create().After("gorm:begin_transaction").Before("gorm:create").Register("tenant_scope:create", p.begin(p.assignTenant)); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:begin_transaction").Before("gorm:update").Register("tenant_scope:update", p.begin(p.scopeWhere)); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().After("gorm:begin_transaction").Before("gorm:delete").Register("tenant_scope:delete", p.begin(p.scopeWhere)); err != nil {
+		return err
+	}
+	for _, register := range []func() error{
+		func() error {
+			return callbacks.Create().After("gorm:commit_or_rollback_transaction").Register("tenant_scope:commit_create", p.commit)
+		},
+		func() error {
+			return callbacks.Update().After("gorm:commit_or_rollback_transaction").Register("tenant_scope:commit_update", p.commit)
+		},
+		func() error {
+			return callbacks.Delete().After("gorm:commit_or_rollback_transaction").Register("tenant_scope:commit_delete", p.commit)
+		},
+	} {
+		if err := register(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// begin aplica o escopo do tenant e define as variáveis da sessão usadas pelo RLS
+func (p TenantScope) begin(scope func(db *gorm.DB, tenantID uuid.UUID)) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Error != nil || !isTenantOwned(db.Statement.Schema) {
+			return
+		}
+
+		tenantID, crossTenant, ok := tenantFromStatement(db)
+		if !ok {
+			return
+		}
+		if !crossTenant {
+			scope(db, tenantID)
+			if db.Error != nil {
+				return
+			}
+		}
+
+		if db.DryRun {
+			return
+		}
+
+		// Fora de uma transação as variáveis locais valeriam só para o SET
+		if _, inTransaction := db.Statement.ConnPool.(gorm.TxCommitter); !inTransaction {
+			tx := db.Begin()
+			if tx.Error != nil {
+				db.AddError(tx.Error)
+				return
+			}
+			db.Statement.ConnPool = tx.Statement.ConnPool
+			db.InstanceSet(tenantScopeStartedTx, true)
+		}
+
+		setSessionTenant(db, tenantID, crossTenant)
+	}
+}
+
+// commit encerra a transação aberta por begin
+func (TenantScope) commit(db *gorm.DB) {
+	if _, started := db.InstanceGet(tenantScopeStartedTx); !started {
+		return
+	}
+
+	if db.Error != nil {
+		db.Rollback()
+	} else {
+		db.Commit()
+	}
+	db.Statement.ConnPool = db.ConnPool
+}
+
+// scopeRow aplica o escopo em Row/Rows/Scan; o resultado é lido depois dos callbacks,
+// então a transação precisa ser do chamador
+func (p TenantScope) scopeRow(db *gorm.DB) {
+	if db.Error != nil || !isTenantOwned(db.Statement.Schema) {
+		return
+	}
+
+	tenantID, crossTenant, ok := tenantFromStatement(db)
+	if !ok {
+		return
+	}
+	if !crossTenant {
+		p.scopeWhere(db, tenantID)
+	}
+
+	if db.DryRun {
+		return
+	}
+	if _, inTransaction := db.Statement.ConnPool.(gorm.TxCommitter); !inTransaction {
+		db.AddError(ErrRowQueryOutsideTransaction)
+		return
+	}
+	setSessionTenant(db, tenantID, crossTenant)
+}
+
+// scopeWhere adiciona tenant_id = ? à query
+func (TenantScope) scopeWhere(db *gorm.DB, tenantID uuid.UUID) {
+	field := db.Statement.Schema.LookUpField(tenantIDField)
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: tenantID},
+	}})
+}
+
+// assignTenant preenche o TenantID vazio dos registros criados e recusa registros de outro tenant
+func (TenantScope) assignTenant(db *gorm.DB, tenantID uuid.UUID) {
+	field := db.Statement.Schema.LookUpField(tenantIDField)
+	ctx := db.Statement.Context
+
+	assign := func(rv reflect.Value) {
+		value, isZero := field.ValueOf(ctx, rv)
+		if isZero {
+			if err := field.Set(ctx, rv, tenantID); err != nil {
+				db.AddError(err)
+			}
+			return
+		}
+		if owner, ok := value.(uuid.UUID); !ok || owner != tenantID {
+			db.AddError(fmt.Errorf("%w: %s", ErrTenantMismatch, db.Statement.Schema.Table))
+		}
+	}
+
+	switch rv := reflect.Indirect(db.Statement.ReflectValue); rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			assign(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		assign(rv)
+	}
+}
+
+// tenantFromStatement lê o tenant do contexto, registrando erro quando não há escopo
+func tenantFromStatement(db *gorm.DB) (tenantID uuid.UUID, crossTenant, ok bool) {
+	ctx := db.Statement.Context
+	if domain.IsCrossTenant(ctx) {
+		return uuid.Nil, true, true
+	}
+
+	tenantID, ok = domain.TenantIDFromContext(ctx)
+	if !ok {
+		db.AddError(fmt.Errorf("%w: %s", ErrMissingTenantScope, db.Statement.Schema.Table))
+		return uuid.Nil, false, false
+	}
+	return tenantID, false, true
+}
+
+func setSessionTenant(db *gorm.DB, tenantID uuid.UUID, crossTenant bool) {
+	tenant, cross := tenantID.String(), "off"
+	if crossTenant {
+		tenant, cross = "", "on"
+	}
+
+	_, err := db.Statement.ConnPool.ExecContext(db.Statement.Context,
+		"SELECT set_config('app.tenant_id', $1, true), set_config('app.cross_tenant', $2, true)", tenant, cross)
+	if err != nil {
+		db.AddError(fmt.Errorf("failed to set tenant for row-level security: %w", err))
+	}
+}
+
+func isTenantOwned(s *schema.Schema) bool {
+	if s == nil || s.LookUpField(tenantIDField) == nil {
+		return false
+	}
+	_, ok := reflect.New(s.ModelType).Interface().(domain.TenantOwned)
+	return ok
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// newDryRunDB builds statements without a database connection
+func newDryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost dbname=test"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open dry run database: %v", err)
+	}
+	if err := db.Use(TenantScope{}); err != nil {
+		t.Fatalf("failed to register tenant scope: %v", err)
+	}
+	return db
+}
+
+func TestTenantScopeAddsTenantCondition(t *testing.T) {
+	db := newDryRunDB(t)
+	tenantID := uuid.New()
+	ctx := domain.WithTenantID(context.Background(), tenantID)
+
+	var users []domain.User
+	stmt := db.WithContext(ctx).Where("email = ?", "ana@silva.adv.br").Find(&users).Statement
+	if stmt.Error != nil {
+		t.Fatalf("Find() error = %v", stmt.Error)
+	}
+	if sql := stmt.SQL.String(); !strings.Contains(sql, `"users"."tenant_id" = $`) {
+		t.Errorf("query not scoped to tenant: %s", sql)
+	}
+
+	var found bool
+	for _, v := range stmt.Vars {
+		if v == tenantID {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("tenant ID not bound in %v", stmt.Vars)
+	}
+}
+
+func TestTenantScopeRefusesUnscopedQueries(t *testing.T) {
+	db := newDryRunDB(t)
+
+	var users []domain.User
+	err := db.WithContext(context.Background()).Find(&users).Error
+	if !errors.Is(err, ErrMissingTenantScope) {
+		t.Fatalf("Find() error = %v, want ErrMissingTenantScope", err)
+	}
+
+	// Tenants themselves are not tenant-owned
+	var tenants []domain.Tenant
+	if err := db.Find(&tenants).Error; err != nil {
+		t.Fatalf("Find(tenants) error = %v", err)
+	}
+}
+
+func TestTenantScopeCrossTenant(t *testing.T) {
+	db := newDryRunDB(t)
+
+	var users []domain.User
+	stmt := db.WithContext(domain.CrossTenant(context.Background())).Find(&users).Statement
+	if stmt.Error != nil {
+		t.Fatalf("Find() error = %v", stmt.Error)
+	}
+	if sql := stmt.SQL.String(); strings.Contains(sql, "tenant_id") {
+		t.Errorf("cross-tenant query was scoped: %s", sql)
+	}
+}
+
+func TestTenantScopeAssignsTenantOnCreate(t *testing.T) {
+	db := newDryRunDB(t)
+	tenantID := uuid.New()
+	ctx := domain.WithTenantID(context.Background(), tenantID)
+
+	audit := &domain.AuditLog{Action: "user.invited"}
+	if err := db.WithContext(ctx).Create(audit).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if audit.TenantID != tenantID {
+		t.Errorf("TenantID = %s, want %s", audit.TenantID, tenantID)
+	}
+
+	other := &domain.AuditLog{TenantID: uuid.New(), Action: "user.invited"}
+	if err := db.WithContext(ctx).Create(other).Error; !errors.Is(err, ErrTenantMismatch) {
+		t.Errorf("Create() error = %v, want ErrTenantMismatch", err)
+	}
+}
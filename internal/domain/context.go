@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type contextKey string
+
+const (
+	impersonationContextKey contextKey = "impersonation"
+	tenantContextKey        contextKey = "tenant_id"
+	crossTenantContextKey   contextKey = "cross_tenant"
+)
+
+// Impersonation identifies a super admin acting as another user
+type Impersonation struct {
+	ImpersonatorID string
+	SessionID      string
+}
+
+// WithImpersonation returns a context carrying the impersonation
+func WithImpersonation(ctx context.Context, impersonation Impersonation) context.Context {
+	return context.WithValue(ctx, impersonationContextKey, impersonation)
+}
+
+// ImpersonationFromContext returns the impersonation carried by the context, if any
+func ImpersonationFromContext(ctx context.Context) (Impersonation, bool) {
+	if ctx == nil {
+		return Impersonation{}, false
+	}
+	impersonation, ok := ctx.Value(impersonationContextKey).(Impersonation)
+	return impersonation, ok
+}
+
+// WithTenantID scopes queries on tenant-owned models made with the context to the tenant
+func WithTenantID(ctx context.Context, tenantID uuid.UUID) context.Context {
+	return context.WithValue(ctx, tenantContextKey, tenantID)
+}
+
+// OnlyTenant scopes the context to the tenant, replacing a CrossTenant marker, for
+// super admin requests acting on a single tenant
+func OnlyTenant(ctx context.Context, tenantID uuid.UUID) context.Context {
+	return WithTenantID(context.WithValue(ctx, crossTenantContextKey, false), tenantID)
+}
+
+// TenantIDFromContext returns the tenant queries made with the context are scoped to, if any
+func TenantIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	if ctx == nil || IsCrossTenant(ctx) {
+		return uuid.Nil, false
+	}
+	tenantID, ok := ctx.Value(tenantContextKey).(uuid.UUID)
+	return tenantID, ok && tenantID != uuid.Nil
+}
+
+// CrossTenant marks the context for queries that deliberately span tenants, such as
+// background jobs, super admin listings and lookups that authenticate a request
+func CrossTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, crossTenantContextKey, true)
+}
+
+// IsCrossTenant reports whether the context was marked with CrossTenant
+func IsCrossTenant(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	crossTenant, _ := ctx.Value(crossTenantContextKey).(bool)
+	return crossTenant
+}
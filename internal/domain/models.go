@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,18 +24,33 @@ func (b *BaseModel) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// TenantOwned marks models whose rows belong to a tenant. Queries on them are
+// scoped to the tenant of the query context (see database.TenantScope).
+type TenantOwned interface {
+	tenantOwned()
+}
+
 // Tenant represents a law firm or legal professional (multi-tenant isolation)
 type Tenant struct {
 	BaseModel
-	Name            string         `gorm:"not null;uniqueIndex" json:"name"`
-	DisplayName     string         `json:"display_name"`
-	Domain          string         `gorm:"uniqueIndex" json:"domain,omitempty"`
-	KeycloakGroupID string         `gorm:"not null" json:"keycloak_group_id"`
-	Plan            Plan           `json:"plan"`
-	PlanID          uuid.UUID      `json:"plan_id"`
-	Status          TenantStatus   `gorm:"default:'active'" json:"status"`
-	Settings        TenantSettings `gorm:"serializer:json" json:"settings"`
-	Subscription    *Subscription  `json:"subscription,omitempty"`
+	Name                    string         `gorm:"not null;uniqueIndex" json:"name"`
+	DisplayName             string         `json:"display_name"`
+	Domain                  string         `gorm:"uniqueIndex" json:"domain,omitempty"` // custom domain, served only once verified
+	DomainVerificationToken string         `json:"-"`                                   // expected in the domain's DNS TXT record
+	DomainVerifiedAt        *time.Time     `json:"domain_verified_at,omitempty"`
+	KeycloakGroupID         string         `gorm:"not null" json:"keycloak_group_id"`
+	Plan                    Plan           `json:"plan"`
+	PlanID                  uuid.UUID      `json:"plan_id"`
+	Status                  TenantStatus   `gorm:"default:'active'" json:"status"`
+	StatusReason            string         `json:"status_reason,omitempty"` // why the tenant was suspended or offboarded
+	SuspendedAt             *time.Time     `json:"suspended_at,omitempty"`
+	ReadOnly                bool           `gorm:"not null;default:false" json:"read_only"` // unpaid subscription: data can be read, not changed
+	OffboardedAt            *time.Time     `json:"offboarded_at,omitempty"`
+	DeletionScheduledAt     *time.Time     `json:"deletion_scheduled_at,omitempty"` // hard delete once the offboarding grace period ends
+	DataExportPath          string         `json:"-"`
+	Settings                TenantSettings `gorm:"serializer:json" json:"settings"`
+	LegalEntity             LegalEntity    `gorm:"serializer:json" json:"legal_entity"` // printed on the tenant invoices
+	Subscription            *Subscription  `json:"subscription,omitempty"`
 }
 
 type TenantStatus string
@@ -44,6 +60,8 @@ const (
 	TenantStatusSuspended TenantStatus = "suspended"
 	TenantStatusTrial     TenantStatus = "trial"
 	TenantStatusInactive  TenantStatus = "inactive"
+	// TenantStatusOffboarding tenants are exported and wait for their scheduled hard delete
+	TenantStatusOffboarding TenantStatus = "offboarding"
 )
 
 type TenantSettings struct {
@@ -53,6 +71,29 @@ type TenantSettings struct {
 	CurrencyCode      string                  `json:"currency_code"`
 	NotificationPrefs NotificationPreferences `json:"notification_prefs"`
 	Features          map[string]bool         `json:"features"`
+	MFA               MFAPolicy               `json:"mfa"`
+}
+
+// MFAPolicy requires two-factor authentication (TOTP) for tenant users
+type MFAPolicy struct {
+	Required bool     `json:"required"`
+	Roles    []string `json:"roles,omitempty"` // empty applies to every role
+}
+
+// AppliesTo reports whether users with the role must use MFA
+func (p MFAPolicy) AppliesTo(role string) bool {
+	if !p.Required {
+		return false
+	}
+	if len(p.Roles) == 0 {
+		return true
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
 }
 
 type NotificationPreferences struct {
@@ -64,10 +105,12 @@ type NotificationPreferences struct {
 	EmailTypes      []string `json:"email_types"`
 }
 
-// Plan represents subscription plans
+// Plan represents subscription plans. Each version of a plan is a row of its own
+// sharing the name; tenants stay on their version until they are migrated.
 type Plan struct {
 	BaseModel
-	Name         string                 `gorm:"not null;uniqueIndex" json:"name"`
+	Name         string                 `gorm:"not null;uniqueIndex:idx_plans_name_version" json:"name"`
+	Version      int                    `gorm:"not null;default:1;uniqueIndex:idx_plans_name_version" json:"version"`
 	DisplayName  string                 `json:"display_name"`
 	Description  string                 `json:"description"`
 	Price        float64                `json:"price"`
@@ -76,6 +119,8 @@ type Plan struct {
 	Features     map[string]interface{} `gorm:"serializer:json" json:"features"`
 	Limits       PlanLimits             `gorm:"serializer:json" json:"limits"`
 	IsActive     bool                   `gorm:"default:true" json:"is_active"`
+	// Price charging the plan at the payment gateway; plans without one cannot be paid by card
+	StripePriceID string `json:"stripe_price_id,omitempty"`
 }
 
 type BillingCycle string
@@ -95,8 +140,89 @@ type PlanLimits struct {
 	AIRequestsMonth   int  `json:"ai_requests_month"`
 	MessagesMonth     int  `json:"messages_month"`
 	AllowCustomDomain bool `json:"allow_custom_domain"`
+	// Percentages of a limit at which tenant admins are warned; 80 and 100 when empty
+	QuotaWarnings []int `json:"quota_warnings,omitempty"`
+	// Monthly usage billed beyond the limit instead of refused, per metric
+	Overage map[UsageMetric]OveragePrice `json:"overage,omitempty"`
+}
+
+// OveragePrice prices the usage of a metric beyond its monthly plan limit
+type OveragePrice struct {
+	UnitPrice float64 `json:"unit_price"` // in the plan currency
+	// Most billed for the metric in a usage period; usage stops once it is reached
+	SpendingCap float64 `json:"spending_cap"`
+}
+
+// MaxUnits returns the units beyond the limit the spending cap pays for
+func (p OveragePrice) MaxUnits() int64 {
+	if p.UnitPrice <= 0 || p.SpendingCap <= 0 {
+		return 0
+	}
+	// Tolerates the float error of caps that are a multiple of the price
+	return int64(p.SpendingCap/p.UnitPrice + 1e-9)
+}
+
+// Unlimited marks a PlanLimits value without a cap
+const Unlimited = -1
+
+// WithinLimit reports whether a usage value fits a plan limit
+func WithinLimit(usage int64, limit int) bool {
+	return limit == Unlimited || usage <= int64(limit)
+}
+
+// TenantProvisioning records the steps of a tenant creation so that a failed one
+// can be resumed or compensated. It is not tenant-owned: the tenant may not exist yet.
+type TenantProvisioning struct {
+	BaseModel
+	TenantID    uuid.UUID           `gorm:"type:uuid;not null;index" json:"tenant_id"` // ID the tenant is created with
+	TenantName  string              `gorm:"not null;index" json:"tenant_name"`
+	Status      ProvisioningStatus  `gorm:"not null;index" json:"status"`
+	Request     ProvisioningRequest `gorm:"serializer:json" json:"request"`
+	GroupID     string              `json:"keycloak_group_id,omitempty"`
+	AdminUserID string              `json:"keycloak_admin_user_id,omitempty"`
+	Steps       []ProvisioningStep  `gorm:"serializer:json" json:"steps"`
+	Error       string              `json:"error,omitempty"`
+	CompletedAt *time.Time          `json:"completed_at,omitempty"`
 }
 
+type ProvisioningStatus string
+
+const (
+	ProvisioningStatusInProgress   ProvisioningStatus = "in_progress"
+	ProvisioningStatusCompleted    ProvisioningStatus = "completed"
+	ProvisioningStatusFailed       ProvisioningStatus = "failed" // stopped with resources left, resumable
+	ProvisioningStatusCompensating ProvisioningStatus = "compensating"
+	ProvisioningStatusRolledBack   ProvisioningStatus = "rolled_back"
+)
+
+// ProvisioningRequest is the tenant creation request, without the admin password
+type ProvisioningRequest struct {
+	DisplayName    string         `json:"display_name"`
+	Domain         string         `json:"domain,omitempty"`
+	PlanID         uuid.UUID      `json:"plan_id"`
+	PlanName       string         `json:"plan_name,omitempty"`
+	AdminEmail     string         `json:"admin_email"`
+	AdminFirstName string         `json:"admin_first_name"`
+	AdminLastName  string         `json:"admin_last_name"`
+	Settings       TenantSettings `json:"settings"`
+}
+
+type ProvisioningStep struct {
+	Name      string                 `json:"name"`
+	Status    ProvisioningStepStatus `json:"status"`
+	Error     string                 `json:"error,omitempty"`
+	UpdatedAt *time.Time             `json:"updated_at,omitempty"`
+}
+
+type ProvisioningStepStatus string
+
+const (
+	ProvisioningStepPending     ProvisioningStepStatus = "pending"
+	ProvisioningStepCompleted   ProvisioningStepStatus = "completed"
+	ProvisioningStepFailed      ProvisioningStepStatus = "failed"
+	ProvisioningStepCompensated ProvisioningStepStatus = "compensated"
+)
+
 // Subscription tracks tenant subscriptions
 type Subscription struct {
 	BaseModel
@@ -108,10 +234,216 @@ type Subscription struct {
 	TrialEndsAt   *time.Time         `json:"trial_ends_at,omitempty"`
 	CancelledAt   *time.Time         `json:"cancelled_at,omitempty"`
 	PaymentMethod string             `json:"payment_method,omitempty"`
-	StripeSubID   string             `json:"stripe_subscription_id,omitempty"`
+	StripeSubID   string             `gorm:"index" json:"stripe_subscription_id,omitempty"`
 	Usage         map[string]int     `gorm:"serializer:json" json:"usage"`
+	// Customer paying the subscription at the payment gateway
+	StripeCustomerID string `json:"stripe_customer_id,omitempty"`
+	// Days before TrialEndsAt whose reminder was already sent
+	TrialRemindersSent []int `gorm:"serializer:json" json:"-"`
+	// Billing period being charged; unset until the first plan change, the period then follows StartDate
+	CurrentPeriodStart *time.Time `json:"current_period_start,omitempty"`
+	CurrentPeriodEnd   *time.Time `json:"current_period_end,omitempty"`
+	// Plan taking over at CurrentPeriodEnd, see PlanChange
+	PendingPlanID *uuid.UUID `gorm:"type:uuid" json:"pending_plan_id,omitempty"`
+	// Credit left over from invoices whose credits exceeded the charges, deducted from the next invoice
+	CreditBalance float64 `gorm:"not null;default:0" json:"credit_balance"`
+	// Dunning: when the subscription fell past due, the retry days already run and
+	// the gateway invoice whose charge failed
+	PastDueSince       *time.Time `json:"past_due_since,omitempty"`
+	DunningRetriesDone []int      `gorm:"serializer:json" json:"-"`
+	StripeInvoiceID    string     `json:"-"`
+}
+
+func (Subscription) tenantOwned() {}
+
+// PlanChange records an upgrade or downgrade and its proration. Changes at cycle end
+// stay scheduled until the period ends.
+type PlanChange struct {
+	BaseModel
+	TenantID       uuid.UUID        `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	SubscriptionID uuid.UUID        `gorm:"type:uuid;not null;index" json:"subscription_id"`
+	FromPlanID     uuid.UUID        `gorm:"type:uuid;not null" json:"from_plan_id"`
+	ToPlanID       uuid.UUID        `gorm:"type:uuid;not null" json:"to_plan_id"`
+	Timing         PlanChangeTiming `gorm:"not null" json:"timing"`
+	Status         PlanChangeStatus `gorm:"not null;index" json:"status"`
+	EffectiveAt    time.Time        `json:"effective_at"`
+	// Unused share of the current plan credited and share of the new plan charged
+	// for the rest of the period; Amount is Charge minus Credit
+	Credit      float64    `json:"credit"`
+	Charge      float64    `json:"charge"`
+	Amount      float64    `json:"amount"`
+	Currency    string     `json:"currency"`
+	RequestedBy string     `json:"requested_by"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	InvoiceID   *uuid.UUID `gorm:"type:uuid;index" json:"invoice_id,omitempty"` // invoice billing Amount
+}
+
+func (PlanChange) tenantOwned() {}
+
+// UsageRecord is a tenant usage counter for one metric in one usage period,
+// copied from the Redis counters by services.UsageMeter
+type UsageRecord struct {
+	BaseModel
+	TenantID    uuid.UUID   `gorm:"type:uuid;not null;uniqueIndex:idx_usage_records_period" json:"tenant_id"`
+	PeriodStart time.Time   `gorm:"not null;uniqueIndex:idx_usage_records_period" json:"period_start"`
+	PeriodEnd   time.Time   `gorm:"not null" json:"period_end"`
+	Metric      UsageMetric `gorm:"not null;uniqueIndex:idx_usage_records_period" json:"metric"`
+	Value       int64       `gorm:"not null" json:"value"`
+	// Invoice that billed the overage of the period, set once the period ended
+	InvoiceID *uuid.UUID `gorm:"type:uuid;index" json:"invoice_id,omitempty"`
+}
+
+func (UsageRecord) tenantOwned() {}
+
+// Invoice bills a tenant for one billing period. Drafts have no number; issuing
+// one assigns the next number of the issuer and freezes the parties' legal data.
+type Invoice struct {
+	BaseModel
+	TenantID       uuid.UUID     `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	SubscriptionID uuid.UUID     `gorm:"type:uuid;not null;index" json:"subscription_id"`
+	Issuer         string        `gorm:"not null" json:"issuer"` // code of the issuing entity, numbers are sequential per issuer
+	Number         string        `json:"number,omitempty"`
+	Status         InvoiceStatus `gorm:"not null;index" json:"status"`
+	PeriodStart    time.Time     `gorm:"not null" json:"period_start"`
+	PeriodEnd      time.Time     `gorm:"not null" json:"period_end"`
+	Currency       string        `gorm:"not null" json:"currency"`
+	Lines          []InvoiceLine `gorm:"serializer:json" json:"lines"`
+	Total          float64       `gorm:"not null" json:"total"`
+	IssuerDetails  InvoiceParty  `gorm:"serializer:json" json:"issuer_details"`
+	Customer       InvoiceParty  `gorm:"serializer:json" json:"customer"`
+	IssuedAt       *time.Time    `json:"issued_at,omitempty"`
+	DueAt          *time.Time    `json:"due_at,omitempty"`
+	PaidAt         *time.Time    `json:"paid_at,omitempty"`
+	VoidedAt       *time.Time    `json:"voided_at,omitempty"`
+	VoidReason     string        `json:"void_reason,omitempty"`
+	// Pix txid and boleto our number (nosso número) of the invoice, matched against payment confirmations
+	PixTxID      string `gorm:"index" json:"pix_txid,omitempty"`
+	BoletoNumber string `gorm:"index" json:"boleto_number,omitempty"`
+	// Payment gateway charging the invoice with the card subscription; its webhooks settle it
+	ChargedBy string `json:"charged_by,omitempty"`
+}
+
+func (Invoice) tenantOwned() {}
+
+type InvoiceStatus string
+
+const (
+	InvoiceStatusDraft InvoiceStatus = "draft" // waits for the tenant legal data
+	InvoiceStatusOpen  InvoiceStatus = "open"
+	InvoiceStatusPaid  InvoiceStatus = "paid"
+	InvoiceStatusVoid  InvoiceStatus = "void"
+)
+
+// InvoiceLine is an amount billed on an invoice; credits are negative
+type InvoiceLine struct {
+	Kind         InvoiceLineKind `json:"kind"`
+	Description  string          `json:"description"`
+	Quantity     int64           `json:"quantity"`
+	UnitPrice    float64         `json:"unit_price"`
+	Amount       float64         `json:"amount"`
+	PlanChangeID *uuid.UUID      `json:"plan_change_id,omitempty"`
+}
+
+type InvoiceLineKind string
+
+const (
+	InvoiceLineKindPlanFee   InvoiceLineKind = "plan_fee"
+	InvoiceLineKindProration InvoiceLineKind = "proration"
+	InvoiceLineKindOverage   InvoiceLineKind = "overage"
+	// Credit balance of the subscription deducted, or carried over when positive
+	InvoiceLineKindCredit InvoiceLineKind = "credit"
+)
+
+// InvoiceParty is the legal data of an invoice party as printed on it
+type InvoiceParty struct {
+	LegalName string   `json:"legal_name"`
+	TaxID     string   `json:"tax_id"`
+	Email     string   `json:"email,omitempty"`
+	Address   []string `json:"address,omitempty"`
+}
+
+// InvoiceSequence holds the last invoice number of an issuer
+type InvoiceSequence struct {
+	Issuer     string `gorm:"primaryKey"`
+	LastNumber int64  `gorm:"not null"`
 }
 
+// PaymentEvent records a payment gateway webhook event already processed, so
+// that redeliveries are acknowledged without being applied twice
+type PaymentEvent struct {
+	BaseModel
+	Gateway string `gorm:"not null;uniqueIndex:idx_payment_events_gateway_event" json:"gateway"`
+	EventID string `gorm:"not null;uniqueIndex:idx_payment_events_gateway_event" json:"event_id"`
+	Type    string `json:"type"`
+}
+
+// LegalEntity is the legal identification of a tenant
+type LegalEntity struct {
+	LegalName string        `json:"legal_name"`
+	TaxID     string        `json:"tax_id"` // CPF or CNPJ, digits only
+	Email     string        `json:"email,omitempty"`
+	Address   PostalAddress `json:"address"`
+}
+
+type PostalAddress struct {
+	Street     string `json:"street"`
+	Number     string `json:"number"`
+	Complement string `json:"complement,omitempty"`
+	District   string `json:"district"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	PostalCode string `json:"postal_code"`
+}
+
+// Lines returns the address as printed on documents
+func (a PostalAddress) Lines() []string {
+	street := a.Street
+	if a.Number != "" {
+		street += ", " + a.Number
+	}
+	if a.Complement != "" {
+		street += " - " + a.Complement
+	}
+	city := a.City
+	if a.State != "" {
+		city += " - " + a.State
+	}
+
+	var lines []string
+	for _, line := range []string{street, a.District, city, a.PostalCode} {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+type UsageMetric string
+
+const (
+	UsageMetricAPICalls   UsageMetric = "api_calls"
+	UsageMetricConsultas  UsageMetric = "consultas"
+	UsageMetricAIRequests UsageMetric = "ai_requests"
+	UsageMetricMessages   UsageMetric = "messages"
+)
+
+type PlanChangeTiming string
+
+const (
+	PlanChangeImmediate PlanChangeTiming = "immediate"
+	PlanChangeCycleEnd  PlanChangeTiming = "cycle_end"
+)
+
+type PlanChangeStatus string
+
+const (
+	PlanChangeStatusScheduled PlanChangeStatus = "scheduled"
+	PlanChangeStatusApplied   PlanChangeStatus = "applied"
+	PlanChangeStatusCanceled  PlanChangeStatus = "canceled"
+	PlanChangeStatusFailed    PlanChangeStatus = "failed" // the tenant outgrew the target plan before cycle end
+)
+
 type SubscriptionStatus string
 
 const (
@@ -137,6 +469,8 @@ type User struct {
 	Tenant      Tenant          `json:"tenant,omitempty"`
 }
 
+func (User) tenantOwned() {}
+
 type UserRole string
 
 const (
@@ -177,11 +511,22 @@ type AuditLog struct {
 	CreatedAt  time.Time              `json:"created_at"`
 }
 
-// BeforeCreate ensures UUID is generated for AuditLog
+func (AuditLog) tenantOwned() {}
+
+// BeforeCreate ensures UUID is generated for AuditLog and tags
+// entries written during an impersonated request
 func (a *AuditLog) BeforeCreate(tx *gorm.DB) error {
 	if a.ID == uuid.Nil {
 		a.ID = uuid.New()
 	}
+
+	if impersonation, ok := ImpersonationFromContext(tx.Statement.Context); ok {
+		if a.Details == nil {
+			a.Details = map[string]interface{}{}
+		}
+		a.Details["impersonated_by"] = impersonation.ImpersonatorID
+		a.Details["impersonation_session_id"] = impersonation.SessionID
+	}
 	return nil
 }
 
@@ -190,13 +535,30 @@ type APIKey struct {
 	BaseModel
 	TenantID   uuid.UUID  `gorm:"not null;index" json:"tenant_id"`
 	Name       string     `gorm:"not null" json:"name"`
-	Key        string     `gorm:"not null;uniqueIndex" json:"-"`
+	Key        string     `gorm:"not null;uniqueIndex" json:"-"` // SHA-256 hash of the key
+	Prefix     string     `gorm:"index" json:"prefix"`           // first characters of the key, for identification
 	Scopes     []string   `gorm:"serializer:json" json:"scopes"`
 	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
 	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
 	IsActive   bool       `gorm:"default:true" json:"is_active"`
 }
 
+func (APIKey) tenantOwned() {}
+
+// HasScope checks if the key grants a scope such as "tenants:read"
+func (k *APIKey) HasScope(scope string) bool {
+	resource := strings.SplitN(scope, ":", 2)[0]
+	for _, s := range k.Scopes {
+		if s == APIKeyScopeAll || s == scope || s == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyScopeAll grants every scope
+const APIKeyScopeAll = "*"
+
 // === MÓDULO 3 - CONSULTA JURÍDICA ===
 
 // ConsultaProcesso representa uma consulta de processo judicial
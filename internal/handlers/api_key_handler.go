@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type APIKeyHandler struct {
+	apiKeyService *services.APIKeyService
+	tenantService *services.TenantService
+}
+
+func NewAPIKeyHandler(apiKeyService *services.APIKeyService, tenantService *services.TenantService) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+		tenantService: tenantService,
+	}
+}
+
+// CreateAPIKey handles POST /api/v1/api-keys
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	tenant, ok := currentTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	var req services.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	apiKey, rawKey, err := h.apiKeyService.CreateAPIKey(c.Request.Context(), tenant.ID, c.GetString("userID"), &req)
+	if err != nil {
+		h.handleError(c, "Failed to create API key", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "API key created successfully. Store the key now, it will not be shown again",
+		"data":    apiKey,
+		"key":     rawKey,
+	})
+}
+
+// ListAPIKeys handles GET /api/v1/api-keys
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	tenant, ok := currentTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	keys, err := h.apiKeyService.ListAPIKeys(c.Request.Context(), tenant.ID)
+	if err != nil {
+		h.handleError(c, "Failed to list API keys", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+// RotateAPIKey handles POST /api/v1/api-keys/:id/rotate
+func (h *APIKeyHandler) RotateAPIKey(c *gin.Context) {
+	tenant, ok := currentTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	keyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	apiKey, rawKey, err := h.apiKeyService.RotateAPIKey(c.Request.Context(), tenant.ID, keyID, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to rotate API key", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "API key rotated successfully. Store the key now, it will not be shown again",
+		"data":    apiKey,
+		"key":     rawKey,
+	})
+}
+
+// RevokeAPIKey handles DELETE /api/v1/api-keys/:id
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	tenant, ok := currentTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	keyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	if err := h.apiKeyService.RevokeAPIKey(c.Request.Context(), tenant.ID, keyID, c.GetString("userID")); err != nil {
+		h.handleError(c, "Failed to revoke API key", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+func (h *APIKeyHandler) handleError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+	case errors.Is(err, services.ErrInvalidScope):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAPIKeyExpired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiration date must be in the future"})
+	case errors.Is(err, services.ErrInvalidAPIKey):
+		c.JSON(http.StatusConflict, gin.H{"error": "API key has been revoked"})
+	default:
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// currentTenant loads the tenant of the authenticated user, writing the error response if it fails
+func currentTenant(c *gin.Context, tenantService *services.TenantService) (*domain.Tenant, bool) {
+	tenant, err := tenantService.GetTenantByName(c.Request.Context(), c.GetString("tenant"))
+	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No tenant association found"})
+			return nil, false
+		}
+		logger.Error("Failed to load current tenant",
+			zap.String("tenant", c.GetString("tenant")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tenant"})
+		return nil, false
+	}
+	return tenant, true
+}
@@ -1,18 +1,32 @@
 package handlers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/Nerzal/gocloak/v13"
 	"github.com/gin-gonic/gin"
 	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/middleware"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
 )
 
-// Login handles user authentication
-func Login(keycloakClient *auth.KeycloakClient) gin.HandlerFunc {
+// totpIssuer names the accounts enrolled in authenticator apps
+const totpIssuer = "Direito Lux"
+
+// Login handles user authentication, throttling repeated failures. Users with an
+// authenticator app send its current code as totp.
+func Login(identityProvider auth.IdentityProvider, loginGuard *services.LoginGuardService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
 			Email    string `json:"email" binding:"required,email"`
 			Password string `json:"password" binding:"required"`
+			TOTP     string `json:"totp,omitempty"`
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -20,15 +34,129 @@ func Login(keycloakClient *auth.KeycloakClient) gin.HandlerFunc {
 			return
 		}
 
-		// TODO: Implement actual login logic with Keycloak
+		ctx := c.Request.Context()
+		if !waitLoginGuard(c, loginGuard, req.Email) {
+			return
+		}
+
+		var token *gocloak.JWT
+		var err error
+		if req.TOTP != "" {
+			token, err = identityProvider.LoginWithTOTP(ctx, req.Email, req.Password, req.TOTP)
+		} else {
+			token, err = identityProvider.Login(ctx, req.Email, req.Password)
+		}
+		if err != nil {
+			logger.Info("Login failed",
+				zap.String("requestID", c.GetString("requestID")),
+				zap.Error(err))
+			loginGuard.RecordFailure(ctx, req.Email, c.ClientIP())
+			if errors.Is(err, auth.ErrTOTPRequired) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Authenticator code required", "code": "totp_required"})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+
+		// On a tenant host, credentials of another tenant are as good as wrong ones
+		if !issuedForHostTenant(c, token) {
+			if token.SessionState != "" {
+				if err := identityProvider.LogoutSession(ctx, token.SessionState); err != nil {
+					logger.Warn("Failed to end session of another tenant", zap.Error(err))
+				}
+			}
+			loginGuard.RecordFailure(ctx, req.Email, c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+
+		loginGuard.RecordSuccess(ctx, req.Email)
+
+		c.JSON(http.StatusOK, tokenResponse(token))
+	}
+}
+
+// SetupTOTP starts the enrollment of an authenticator app for users whose account
+// requires one, returning the secret to enroll; logging in with a code of it
+// completes the enrollment. Keycloak runs this step in its own login pages.
+func SetupTOTP(identityProvider auth.IdentityProvider, loginGuard *services.LoginGuardService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		enroller, ok := identityProvider.(auth.TOTPEnroller)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Authenticator apps are set up on the identity provider login page"})
+			return
+		}
+
+		var req struct {
+			Email    string `json:"email" binding:"required,email"`
+			Password string `json:"password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credentials"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		if !waitLoginGuard(c, loginGuard, req.Email) {
+			return
+		}
+
+		secret, err := enroller.ConfigureTOTP(ctx, req.Email, req.Password)
+		if err != nil {
+			if errors.Is(err, auth.ErrTOTPConfigured) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Authenticator app already configured"})
+				return
+			}
+			logger.Info("TOTP setup failed",
+				zap.String("requestID", c.GetString("requestID")),
+				zap.Error(err))
+			loginGuard.RecordFailure(ctx, req.Email, c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
-			"message": "Login endpoint - to be implemented",
+			"message":     "Enroll the secret in an authenticator app, then log in with its code",
+			"secret":      secret,
+			"otpauth_uri": auth.TOTPURI(totpIssuer, req.Email, secret),
 		})
 	}
 }
 
+// waitLoginGuard refuses locked out credentials and applies the progressive delay
+// after previous failures; it writes the response and reports false when the
+// attempt may not continue
+func waitLoginGuard(c *gin.Context, loginGuard *services.LoginGuardService, email string) bool {
+	ctx := c.Request.Context()
+	delay, err := loginGuard.Check(ctx, email, c.ClientIP())
+	if err != nil {
+		var locked *services.LoginLockedError
+		if errors.As(err, &locked) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": "Too many failed login attempts, try again later",
+				"code":  "login_locked",
+			})
+			return false
+		}
+		logger.Error("Failed to check login attempts", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+		return false
+	}
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
 // RefreshToken handles token refresh
-func RefreshToken(keycloakClient *auth.KeycloakClient) gin.HandlerFunc {
+func RefreshToken(identityProvider auth.IdentityProvider) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
 			RefreshToken string `json:"refresh_token" binding:"required"`
@@ -39,15 +167,44 @@ func RefreshToken(keycloakClient *auth.KeycloakClient) gin.HandlerFunc {
 			return
 		}
 
-		// TODO: Implement token refresh logic
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Refresh token endpoint - to be implemented",
-		})
+		token, err := identityProvider.RefreshToken(c.Request.Context(), req.RefreshToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+			return
+		}
+
+		if !issuedForHostTenant(c, token) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, tokenResponse(token))
+	}
+}
+
+// issuedForHostTenant checks that tokens issued on a tenant host belong to that tenant
+func issuedForHostTenant(c *gin.Context, token *gocloak.JWT) bool {
+	hostTenant, ok := middleware.HostTenant(c)
+	if !ok {
+		return true
+	}
+
+	tenantName, err := auth.TenantFromIssuedToken(token.AccessToken)
+	return err == nil && tenantName == hostTenant.Name
+}
+
+func tokenResponse(token *gocloak.JWT) gin.H {
+	return gin.H{
+		"access_token":       token.AccessToken,
+		"refresh_token":      token.RefreshToken,
+		"token_type":         token.TokenType,
+		"expires_in":         token.ExpiresIn,
+		"refresh_expires_in": token.RefreshExpiresIn,
 	}
 }
 
 // ForgotPassword handles password reset requests
-func ForgotPassword(keycloakClient *auth.KeycloakClient) gin.HandlerFunc {
+func ForgotPassword(identityProvider auth.IdentityProvider) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
 			Email string `json:"email" binding:"required,email"`
@@ -66,7 +223,7 @@ func ForgotPassword(keycloakClient *auth.KeycloakClient) gin.HandlerFunc {
 }
 
 // GetProfile returns user profile
-func GetProfile() gin.HandlerFunc {
+func GetProfile(userService *services.UserService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get user from context (set by auth middleware)
 		userID, exists := c.Get("userID")
@@ -75,28 +232,100 @@ func GetProfile() gin.HandlerFunc {
 			return
 		}
 
-		// TODO: Fetch user profile from database
-		c.JSON(http.StatusOK, gin.H{
-			"data": gin.H{
-				"id":      userID,
-				"message": "Profile endpoint - to be implemented",
-			},
-		})
+		user, err := userService.GetProfile(c.Request.Context(), userID.(string))
+		if err != nil {
+			handleProfileError(c, "Failed to retrieve profile", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": user})
 	}
 }
 
 // UpdateProfile updates user profile
-func UpdateProfile() gin.HandlerFunc {
+func UpdateProfile(userService *services.UserService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var req map[string]interface{}
+		var req services.UpdateProfileRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request data",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		user, err := userService.UpdateProfile(c.Request.Context(), c.GetString("userID"), &req)
+		if err != nil {
+			handleProfileError(c, "Failed to update profile", err)
 			return
 		}
 
-		// TODO: Implement profile update logic
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Profile updated successfully",
+			"data":    user,
+		})
+	}
+}
+
+// ChangePassword changes the authenticated user's password
+func ChangePassword(userService *services.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req services.ChangePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request data",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		if err := userService.ChangePassword(c.Request.Context(), c.GetString("userID"), &req); err != nil {
+			handleProfileError(c, "Failed to change password", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+	}
+}
+
+// handleProfileError maps profile service errors to responses
+func handleProfileError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, services.ErrInvalidProfileData):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+	case errors.Is(err, services.ErrPasswordUnchanged):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "New password must differ from the current one"})
+	default:
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// AuthMetrics returns token validation latency metrics per strategy
+func AuthMetrics(validator *auth.TokenValidator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"data": gin.H{
+				"strategy": validator.Strategy(),
+				"latency":  validator.Metrics().Snapshot(),
+			},
 		})
 	}
 }
+
+// PrometheusMetrics exposes the token validation metrics to the Prometheus scraper
+func PrometheusMetrics(validator *auth.TokenValidator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := validator.Metrics().WritePrometheus(c.Writer); err != nil {
+			logger.Warn("Failed to write metrics", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type ImpersonationHandler struct {
+	impersonationService *services.ImpersonationService
+}
+
+func NewImpersonationHandler(impersonationService *services.ImpersonationService) *ImpersonationHandler {
+	return &ImpersonationHandler{
+		impersonationService: impersonationService,
+	}
+}
+
+// StartImpersonation handles POST /api/v1/admin/impersonations
+func (h *ImpersonationHandler) StartImpersonation(c *gin.Context) {
+	var req services.StartImpersonationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	impersonator := auth.Impersonator{
+		ID:    c.GetString("userID"),
+		Email: c.GetString("email"),
+	}
+
+	session, token, err := h.impersonationService.Start(c.Request.Context(), impersonator, &req)
+	if err != nil {
+		h.handleError(c, "Failed to start impersonation", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Impersonation started",
+		"data":         session,
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(session.ExpiresAt.Sub(session.StartedAt).Seconds()),
+	})
+}
+
+// ListImpersonations handles GET /api/v1/admin/impersonations
+func (h *ImpersonationHandler) ListImpersonations(c *gin.Context) {
+	sessions, err := h.impersonationService.ListActive(c.Request.Context())
+	if err != nil {
+		h.handleError(c, "Failed to list impersonation sessions", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sessions})
+}
+
+// RevokeImpersonation handles DELETE /api/v1/admin/impersonations/:sessionId
+func (h *ImpersonationHandler) RevokeImpersonation(c *gin.Context) {
+	if err := h.impersonationService.Revoke(c.Request.Context(), c.Param("sessionId"), c.GetString("userID")); err != nil {
+		h.handleError(c, "Failed to revoke impersonation session", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation session revoked"})
+}
+
+func (h *ImpersonationHandler) handleError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, services.ErrImpersonationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Impersonation session not found"})
+	case errors.Is(err, services.ErrImpersonationNotAllowed):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "User cannot be impersonated"})
+	case errors.Is(err, services.ErrInvalidImpersonationTTL), errors.Is(err, services.ErrImpersonationReasonEmpty):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
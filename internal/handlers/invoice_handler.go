@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type InvoiceHandler struct {
+	invoiceService *services.InvoiceService
+	tenantService  *services.TenantService
+}
+
+func NewInvoiceHandler(invoiceService *services.InvoiceService, tenantService *services.TenantService) *InvoiceHandler {
+	return &InvoiceHandler{
+		invoiceService: invoiceService,
+		tenantService:  tenantService,
+	}
+}
+
+type voidInvoiceRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// ListInvoices handles GET /api/v1/tenants/:id/invoices
+func (h *InvoiceHandler) ListInvoices(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	invoices, total, err := h.invoiceService.ListInvoices(c.Request.Context(), tenant.ID, (page-1)*limit, limit, c.Query("status"))
+	if err != nil {
+		h.handleError(c, "Failed to list invoices", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": invoices,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// GetInvoice handles GET /api/v1/tenants/:id/invoices/:invoiceId
+func (h *InvoiceHandler) GetInvoice(c *gin.Context) {
+	tenant, invoiceID, ok := h.invoicePath(c)
+	if !ok {
+		return
+	}
+
+	invoice, err := h.invoiceService.GetInvoice(c.Request.Context(), tenant.ID, invoiceID)
+	if err != nil {
+		h.handleError(c, "Failed to get invoice", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": invoice})
+}
+
+// DownloadInvoice handles GET /api/v1/tenants/:id/invoices/:invoiceId/pdf
+func (h *InvoiceHandler) DownloadInvoice(c *gin.Context) {
+	tenant, invoiceID, ok := h.invoicePath(c)
+	if !ok {
+		return
+	}
+
+	invoice, document, err := h.invoiceService.InvoicePDF(c.Request.Context(), tenant.ID, invoiceID)
+	if err != nil {
+		h.handleError(c, "Failed to render invoice", err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, invoice.Number))
+	c.Data(http.StatusOK, "application/pdf", document)
+}
+
+// VoidInvoice handles POST /api/v1/tenants/:id/invoices/:invoiceId/void
+func (h *InvoiceHandler) VoidInvoice(c *gin.Context) {
+	if !requireSuperAdmin(c) {
+		return
+	}
+	tenant, invoiceID, ok := h.invoicePath(c)
+	if !ok {
+		return
+	}
+
+	var req voidInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	invoice, err := h.invoiceService.VoidInvoice(c.Request.Context(), tenant.ID, invoiceID, req.Reason, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to void invoice", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Invoice voided",
+		"data":    invoice,
+	})
+}
+
+// MarkInvoicePaid handles POST /api/v1/tenants/:id/invoices/:invoiceId/pay, for
+// payments settled outside the platform such as bank transfers
+func (h *InvoiceHandler) MarkInvoicePaid(c *gin.Context) {
+	if !requireSuperAdmin(c) {
+		return
+	}
+	tenant, invoiceID, ok := h.invoicePath(c)
+	if !ok {
+		return
+	}
+
+	invoice, err := h.invoiceService.MarkPaid(c.Request.Context(), tenant.ID, invoiceID, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to mark invoice as paid", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Invoice marked as paid",
+		"data":    invoice,
+	})
+}
+
+// SetLegalEntity handles PUT /api/v1/tenants/:id/legal-entity
+func (h *InvoiceHandler) SetLegalEntity(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	var req domain.LegalEntity
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	entity, err := h.invoiceService.SetLegalEntity(c.Request.Context(), tenant.ID, &req, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to update legal entity", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Legal entity updated successfully",
+		"data":    entity,
+	})
+}
+
+func (h *InvoiceHandler) invoicePath(c *gin.Context) (*domain.Tenant, uuid.UUID, bool) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return nil, uuid.Nil, false
+	}
+	invoiceID, err := uuid.Parse(c.Param("invoiceId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return nil, uuid.Nil, false
+	}
+	return tenant, invoiceID, true
+}
+
+func (h *InvoiceHandler) handleError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvoiceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+	case errors.Is(err, services.ErrTenantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+	case errors.Is(err, services.ErrInvoiceNotIssued):
+		c.JSON(http.StatusConflict, gin.H{"error": "Invoice is a draft waiting for the tenant legal entity"})
+	case errors.Is(err, services.ErrInvalidInvoiceTransition):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Invalid invoice status transition",
+			"details": err.Error(),
+		})
+	case errors.Is(err, services.ErrInvalidLegalEntity):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid legal entity",
+			"details": err.Error(),
+		})
+	default:
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
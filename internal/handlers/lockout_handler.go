@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type LockoutHandler struct {
+	loginGuard    *services.LoginGuardService
+	tenantService *services.TenantService
+}
+
+func NewLockoutHandler(loginGuard *services.LoginGuardService, tenantService *services.TenantService) *LockoutHandler {
+	return &LockoutHandler{
+		loginGuard:    loginGuard,
+		tenantService: tenantService,
+	}
+}
+
+// UnlockUser handles POST /api/v1/tenants/:id/users/:userId/unlock
+func (h *LockoutHandler) UnlockUser(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.loginGuard.UnlockUser(c.Request.Context(), tenant.ID, userID, c.GetString("userID")); err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		logger.Error("Failed to unlock user",
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
+
+// UnlockIP handles DELETE /api/v1/admin/lockouts/ip/:ip
+func (h *LockoutHandler) UnlockIP(c *gin.Context) {
+	ip := net.ParseIP(c.Param("ip"))
+	if ip == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+		return
+	}
+
+	if err := h.loginGuard.UnlockIP(c.Request.Context(), ip.String(), c.GetString("userID")); err != nil {
+		logger.Error("Failed to unlock IP",
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock IP"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "IP unlocked successfully"})
+}
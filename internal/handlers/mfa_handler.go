@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type MFAHandler struct {
+	mfaService    *services.MFAService
+	tenantService *services.TenantService
+}
+
+func NewMFAHandler(mfaService *services.MFAService, tenantService *services.TenantService) *MFAHandler {
+	return &MFAHandler{
+		mfaService:    mfaService,
+		tenantService: tenantService,
+	}
+}
+
+// GetMFAPolicy handles GET /api/v1/tenants/:id/mfa
+func (h *MFAHandler) GetMFAPolicy(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": tenant.Settings.MFA})
+}
+
+// UpdateMFAPolicy handles PUT /api/v1/tenants/:id/mfa
+func (h *MFAHandler) UpdateMFAPolicy(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	var req domain.MFAPolicy
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.mfaService.UpdatePolicy(c.Request.Context(), tenant.ID, c.GetString("userID"), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidMFAPolicy):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrTenantNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		default:
+			logger.Error("Failed to update MFA policy",
+				zap.String("requestID", c.GetString("requestID")),
+				zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update MFA policy"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "MFA policy updated successfully",
+		"data":    result,
+	})
+}
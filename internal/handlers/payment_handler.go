@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/payments"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// maxWebhookBody bounds the webhook payloads read into memory
+const maxWebhookBody = 1 << 20
+
+type PaymentHandler struct {
+	paymentService *services.PaymentService
+	tenantService  *services.TenantService
+}
+
+func NewPaymentHandler(paymentService *services.PaymentService, tenantService *services.TenantService) *PaymentHandler {
+	return &PaymentHandler{
+		paymentService: paymentService,
+		tenantService:  tenantService,
+	}
+}
+
+// SetupPayment handles POST /api/v1/tenants/:id/subscription/payment-method
+func (h *PaymentHandler) SetupPayment(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	var req services.SetupPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	subscription, err := h.paymentService.SetupPayment(c.Request.Context(), tenant.ID, &req, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to set up payment", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Payment method set up successfully",
+		"data":    subscription,
+	})
+}
+
+// SetPaymentMethod handles PUT /api/v1/tenants/:id/subscription/payment-method
+func (h *PaymentHandler) SetPaymentMethod(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	var req services.SetPaymentMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	subscription, err := h.paymentService.SetPaymentMethod(c.Request.Context(), tenant.ID, &req, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to change payment method", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Payment method changed successfully",
+		"data":    subscription,
+	})
+}
+
+// GetInvoicePix handles GET /api/v1/tenants/:id/invoices/:invoiceId/pix
+func (h *PaymentHandler) GetInvoicePix(c *gin.Context) {
+	tenant, invoiceID, ok := h.invoicePath(c)
+	if !ok {
+		return
+	}
+
+	pix, err := h.paymentService.InvoicePix(c.Request.Context(), tenant.ID, invoiceID)
+	if err != nil {
+		h.handleError(c, "Failed to create Pix charge", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": pix})
+}
+
+// GetInvoicePixQRCode handles GET /api/v1/tenants/:id/invoices/:invoiceId/pix/qrcode
+func (h *PaymentHandler) GetInvoicePixQRCode(c *gin.Context) {
+	tenant, invoiceID, ok := h.invoicePath(c)
+	if !ok {
+		return
+	}
+
+	pix, image, err := h.paymentService.InvoicePixQRCode(c.Request.Context(), tenant.ID, invoiceID)
+	if err != nil {
+		h.handleError(c, "Failed to render Pix QR code", err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s-pix.png"`, pix.Number))
+	c.Data(http.StatusOK, "image/png", image)
+}
+
+// GetInvoiceBoleto handles GET /api/v1/tenants/:id/invoices/:invoiceId/boleto
+func (h *PaymentHandler) GetInvoiceBoleto(c *gin.Context) {
+	tenant, invoiceID, ok := h.invoicePath(c)
+	if !ok {
+		return
+	}
+
+	boleto, err := h.paymentService.InvoiceBoleto(c.Request.Context(), tenant.ID, invoiceID)
+	if err != nil {
+		h.handleError(c, "Failed to create boleto", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": boleto})
+}
+
+// StripeWebhook handles POST /api/v1/webhooks/stripe. The gateway retries the
+// deliveries answered with an error.
+func (h *PaymentHandler) StripeWebhook(c *gin.Context) {
+	payload, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Payload too large"})
+		return
+	}
+
+	if err := h.paymentService.HandleWebhook(c.Request.Context(), payload, c.Request.Header); err != nil {
+		h.handleError(c, "Failed to process webhook", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+// PaymentConfirmationWebhook handles POST /api/v1/webhooks/payments, where the
+// bank or PSP reports the Pix and boleto payments received
+func (h *PaymentHandler) PaymentConfirmationWebhook(c *gin.Context) {
+	payload, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Payload too large"})
+		return
+	}
+
+	if err := h.paymentService.HandleConfirmation(c.Request.Context(), payload, c.Request.Header); err != nil {
+		h.handleError(c, "Failed to process payment confirmation", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+func (h *PaymentHandler) invoicePath(c *gin.Context) (*domain.Tenant, uuid.UUID, bool) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return nil, uuid.Nil, false
+	}
+	invoiceID, err := uuid.Parse(c.Param("invoiceId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return nil, uuid.Nil, false
+	}
+	return tenant, invoiceID, true
+}
+
+func (h *PaymentHandler) handleError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidWebhookEvent):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook event"})
+	case errors.Is(err, services.ErrPaymentsDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payments are not enabled"})
+	case errors.Is(err, services.ErrSubscriptionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+	case errors.Is(err, services.ErrTenantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+	case errors.Is(err, services.ErrPaymentAlreadySetUp):
+		c.JSON(http.StatusConflict, gin.H{"error": "Subscription already has a payment method"})
+	case errors.Is(err, services.ErrPlanNotPayable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Plan cannot be paid by card"})
+	case errors.Is(err, services.ErrPaymentMethodDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payment method is not enabled"})
+	case errors.Is(err, services.ErrInvoiceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+	case errors.Is(err, services.ErrInvoiceNotPayable):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Invoice cannot be paid",
+			"details": err.Error(),
+		})
+	case errors.Is(err, payments.ErrGatewayRequest):
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":   "Payment gateway refused the request",
+			"details": err.Error(),
+		})
+	default:
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type PlanHandler struct {
+	planService *services.PlanService
+}
+
+func NewPlanHandler(planService *services.PlanService) *PlanHandler {
+	return &PlanHandler{
+		planService: planService,
+	}
+}
+
+// ListPlans handles GET /api/v1/admin/plans
+func (h *PlanHandler) ListPlans(c *gin.Context) {
+	var active *bool
+	if value := c.Query("active"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid active filter"})
+			return
+		}
+		active = &parsed
+	}
+
+	plans, err := h.planService.ListPlans(c.Request.Context(), c.Query("name"), active)
+	if err != nil {
+		h.handleError(c, "Failed to list plans", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": plans})
+}
+
+// GetPlan handles GET /api/v1/admin/plans/:planId
+func (h *PlanHandler) GetPlan(c *gin.Context) {
+	planID, ok := parsePlanID(c)
+	if !ok {
+		return
+	}
+
+	plan, err := h.planService.GetPlan(c.Request.Context(), planID)
+	if err != nil {
+		h.handleError(c, "Failed to get plan", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": plan})
+}
+
+// CreatePlan handles POST /api/v1/admin/plans
+func (h *PlanHandler) CreatePlan(c *gin.Context) {
+	var req services.CreatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	plan, err := h.planService.CreatePlan(c.Request.Context(), &req, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to create plan", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Plan created, activate it to offer it",
+		"data":    plan,
+	})
+}
+
+// UpdatePlan handles PUT /api/v1/admin/plans/:planId
+func (h *PlanHandler) UpdatePlan(c *gin.Context) {
+	planID, ok := parsePlanID(c)
+	if !ok {
+		return
+	}
+
+	var req services.UpdatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	plan, err := h.planService.UpdatePlan(c.Request.Context(), planID, &req, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to update plan", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Plan updated successfully",
+		"data":    plan,
+	})
+}
+
+// CreatePlanVersion handles POST /api/v1/admin/plans/:planId/versions
+func (h *PlanHandler) CreatePlanVersion(c *gin.Context) {
+	planID, ok := parsePlanID(c)
+	if !ok {
+		return
+	}
+
+	var req services.UpdatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	plan, err := h.planService.CreateVersion(c.Request.Context(), planID, &req, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to create plan version", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Plan version created, activate it to offer it",
+		"data":    plan,
+	})
+}
+
+// ActivatePlan handles POST /api/v1/admin/plans/:planId/activate
+func (h *PlanHandler) ActivatePlan(c *gin.Context) {
+	planID, ok := parsePlanID(c)
+	if !ok {
+		return
+	}
+
+	plan, err := h.planService.ActivatePlan(c.Request.Context(), planID, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to activate plan", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Plan activated successfully",
+		"data":    plan,
+	})
+}
+
+// DeactivatePlan handles POST /api/v1/admin/plans/:planId/deactivate
+func (h *PlanHandler) DeactivatePlan(c *gin.Context) {
+	planID, ok := parsePlanID(c)
+	if !ok {
+		return
+	}
+
+	plan, err := h.planService.DeactivatePlan(c.Request.Context(), planID, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to deactivate plan", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Plan deactivated successfully",
+		"data":    plan,
+	})
+}
+
+// MigrateSubscribers handles POST /api/v1/admin/plans/:planId/migrate
+func (h *PlanHandler) MigrateSubscribers(c *gin.Context) {
+	planID, ok := parsePlanID(c)
+	if !ok {
+		return
+	}
+
+	var req services.MigratePlanRequest
+	// The body is optional: without tenant_ids every subscriber of older versions moves
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request data",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	result, err := h.planService.MigrateSubscribers(c.Request.Context(), planID, &req, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to migrate plan subscribers", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+func parsePlanID(c *gin.Context) (uuid.UUID, bool) {
+	planID, err := uuid.Parse(c.Param("planId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
+		return uuid.Nil, false
+	}
+	return planID, true
+}
+
+func (h *PlanHandler) handleError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrPlanNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+	case errors.Is(err, services.ErrInvalidPlan):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid plan",
+			"details": err.Error(),
+		})
+	case errors.Is(err, services.ErrPlanNameExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "Plan name already exists"})
+	case errors.Is(err, services.ErrPlanInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": "Plan version has subscribers, create a new version instead"})
+	case errors.Is(err, services.ErrPlanInactive):
+		c.JSON(http.StatusConflict, gin.H{"error": "Plan version is not active"})
+	default:
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type ReconciliationHandler struct {
+	reconciliationService *services.ReconciliationService
+}
+
+func NewReconciliationHandler(reconciliationService *services.ReconciliationService) *ReconciliationHandler {
+	return &ReconciliationHandler{
+		reconciliationService: reconciliationService,
+	}
+}
+
+// RunReconciliation handles POST /api/v1/admin/reconciliation
+func (h *ReconciliationHandler) RunReconciliation(c *gin.Context) {
+	var req struct {
+		TenantID string `json:"tenant_id,omitempty"`
+		Policy   string `json:"policy,omitempty"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	var tenantID *uuid.UUID
+	if req.TenantID != "" {
+		id, err := uuid.Parse(req.TenantID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+			return
+		}
+		tenantID = &id
+	}
+
+	policy := h.reconciliationService.Policy()
+	if req.Policy != "" {
+		p, err := services.ParseReconciliationPolicy(req.Policy)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Policy must be report, keycloak or database"})
+			return
+		}
+		policy = p
+	}
+
+	report, err := h.reconciliationService.Reconcile(c.Request.Context(), tenantID, policy)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrReconciliationRunning):
+			c.JSON(http.StatusConflict, gin.H{"error": "Reconciliation already running"})
+		case errors.Is(err, services.ErrTenantNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		default:
+			logger.Error("Failed to run reconciliation",
+				zap.String("requestID", c.GetString("requestID")),
+				zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run reconciliation"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
+
+// GetLastReconciliation handles GET /api/v1/admin/reconciliation
+func (h *ReconciliationHandler) GetLastReconciliation(c *gin.Context) {
+	report := h.reconciliationService.LastReport()
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No reconciliation has run yet"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
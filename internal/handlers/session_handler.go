@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type SessionHandler struct {
+	sessionService *services.SessionService
+	tenantService  *services.TenantService
+}
+
+func NewSessionHandler(sessionService *services.SessionService, tenantService *services.TenantService) *SessionHandler {
+	return &SessionHandler{
+		sessionService: sessionService,
+		tenantService:  tenantService,
+	}
+}
+
+// ListSessions handles GET /api/v1/tenants/:id/users/:userId/sessions
+func (h *SessionHandler) ListSessions(c *gin.Context) {
+	tenantID, userID, ok := h.parseParams(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.sessionService.ListSessions(c.Request.Context(), tenantID, userID)
+	if err != nil {
+		h.handleError(c, "Failed to list sessions", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sessions})
+}
+
+// RevokeSession handles DELETE /api/v1/tenants/:id/users/:userId/sessions/:sessionId
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	tenantID, userID, ok := h.parseParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.sessionService.RevokeSession(c.Request.Context(), tenantID, userID, c.Param("sessionId"), c.GetString("userID")); err != nil {
+		h.handleError(c, "Failed to revoke session", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// RevokeAllSessions handles DELETE /api/v1/tenants/:id/users/:userId/sessions
+func (h *SessionHandler) RevokeAllSessions(c *gin.Context) {
+	tenantID, userID, ok := h.parseParams(c)
+	if !ok {
+		return
+	}
+
+	revoked, err := h.sessionService.RevokeAllSessions(c.Request.Context(), tenantID, userID, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to revoke sessions", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Sessions revoked successfully",
+		"revoked": revoked,
+	})
+}
+
+func (h *SessionHandler) parseParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return tenant.ID, userID, true
+}
+
+func (h *SessionHandler) handleError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, services.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+	default:
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
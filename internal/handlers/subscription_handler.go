@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type SubscriptionHandler struct {
+	subscriptionService *services.SubscriptionService
+	tenantService       *services.TenantService
+}
+
+func NewSubscriptionHandler(subscriptionService *services.SubscriptionService, tenantService *services.TenantService) *SubscriptionHandler {
+	return &SubscriptionHandler{
+		subscriptionService: subscriptionService,
+		tenantService:       tenantService,
+	}
+}
+
+type subscriptionStatusRequest struct {
+	Status domain.SubscriptionStatus `json:"status" binding:"required,oneof=active past_due unpaid canceled"`
+	Reason string                    `json:"reason" binding:"required,max=500"`
+}
+
+// GetSubscription handles GET /api/v1/tenants/:id/subscription
+func (h *SubscriptionHandler) GetSubscription(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	subscription, err := h.subscriptionService.GetSubscription(c.Request.Context(), tenant.ID)
+	if err != nil {
+		h.handleError(c, "Failed to get subscription", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": subscription})
+}
+
+// UpdateSubscriptionStatus handles PUT /api/v1/tenants/:id/subscription/status
+func (h *SubscriptionHandler) UpdateSubscriptionStatus(c *gin.Context) {
+	if !requireSuperAdmin(c) {
+		return
+	}
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	var req subscriptionStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	subscription, err := h.subscriptionService.Transition(c.Request.Context(), tenant.ID, req.Status, req.Reason, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to update subscription status", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Subscription status updated successfully",
+		"data":    subscription,
+	})
+}
+
+// ChangePlan handles POST /api/v1/tenants/:id/subscription/plan
+func (h *SubscriptionHandler) ChangePlan(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	var req services.ChangePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	change, err := h.subscriptionService.ChangePlan(c.Request.Context(), tenant.ID, &req, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to change plan", err)
+		return
+	}
+
+	message := "Plan changed successfully"
+	if change.Status == domain.PlanChangeStatusScheduled {
+		message = "Plan change scheduled for the end of the billing period"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"data":    change,
+	})
+}
+
+// ListPlanChanges handles GET /api/v1/tenants/:id/subscription/plan-changes
+func (h *SubscriptionHandler) ListPlanChanges(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	changes, err := h.subscriptionService.ListPlanChanges(c.Request.Context(), tenant.ID)
+	if err != nil {
+		h.handleError(c, "Failed to list plan changes", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": changes})
+}
+
+// CancelPlanChange handles DELETE /api/v1/tenants/:id/subscription/plan-change
+func (h *SubscriptionHandler) CancelPlanChange(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	if err := h.subscriptionService.CancelScheduledPlanChange(c.Request.Context(), tenant.ID, c.GetString("userID")); err != nil {
+		h.handleError(c, "Failed to cancel plan change", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Scheduled plan change canceled"})
+}
+
+func (h *SubscriptionHandler) handleError(c *gin.Context, message string, err error) {
+	var limitErr *services.PlanLimitError
+	switch {
+	case errors.As(err, &limitErr):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Current usage exceeds the limits of the target plan",
+			"plan":       limitErr.Plan,
+			"violations": limitErr.Violations,
+		})
+	case errors.Is(err, services.ErrSubscriptionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+	case errors.Is(err, services.ErrTenantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+	case errors.Is(err, services.ErrPlanNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+	case errors.Is(err, services.ErrPlanChangeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "No scheduled plan change"})
+	case errors.Is(err, services.ErrSamePlan):
+		c.JSON(http.StatusConflict, gin.H{"error": "Tenant is already on this plan"})
+	case errors.Is(err, services.ErrPlanCurrencyMismatch):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Plan is billed in another currency",
+			"details": err.Error(),
+		})
+	case errors.Is(err, services.ErrSubscriptionNotActive):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Subscription does not allow plan changes",
+			"details": err.Error(),
+		})
+	case errors.Is(err, services.ErrInvalidSubscriptionTransition):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Invalid subscription status transition",
+			"details": err.Error(),
+		})
+	default:
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/opiagile/direito-lux/internal/middleware"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type TenantDomainHandler struct {
+	domainService *services.TenantDomainService
+	tenantService *services.TenantService
+}
+
+func NewTenantDomainHandler(domainService *services.TenantDomainService, tenantService *services.TenantService) *TenantDomainHandler {
+	return &TenantDomainHandler{
+		domainService: domainService,
+		tenantService: tenantService,
+	}
+}
+
+// GetDomain handles GET /api/v1/tenants/:id/domain
+func (h *TenantDomainHandler) GetDomain(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	verification, err := h.domainService.GetCustomDomain(c.Request.Context(), tenant.ID)
+	if err != nil {
+		h.handleError(c, "Failed to get custom domain", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": verification})
+}
+
+// SetDomain handles PUT /api/v1/tenants/:id/domain
+func (h *TenantDomainHandler) SetDomain(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Domain string `json:"domain" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	verification, err := h.domainService.SetCustomDomain(c.Request.Context(), tenant.ID, req.Domain, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to set custom domain", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Custom domain saved, publish the TXT record and verify it",
+		"data":    verification,
+	})
+}
+
+// VerifyDomain handles POST /api/v1/tenants/:id/domain/verify
+func (h *TenantDomainHandler) VerifyDomain(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	verification, err := h.domainService.VerifyCustomDomain(c.Request.Context(), tenant.ID, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to verify custom domain", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Custom domain verified successfully",
+		"data":    verification,
+	})
+}
+
+// RemoveDomain handles DELETE /api/v1/tenants/:id/domain
+func (h *TenantDomainHandler) RemoveDomain(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	if err := h.domainService.RemoveCustomDomain(c.Request.Context(), tenant.ID, c.GetString("userID")); err != nil {
+		h.handleError(c, "Failed to remove custom domain", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Custom domain removed successfully"})
+}
+
+func (h *TenantDomainHandler) handleError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrTenantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+	case errors.Is(err, services.ErrNoCustomDomain):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant has no custom domain"})
+	case errors.Is(err, services.ErrInvalidDomain):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain"})
+	case errors.Is(err, services.ErrCustomDomainNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Plan does not allow custom domains"})
+	case errors.Is(err, services.ErrDomainTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Domain already in use"})
+	case errors.Is(err, services.ErrDomainVerificationFailed):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Verification TXT record not found"})
+	default:
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// GetBranding handles GET /api/v1/branding, the public branding of the tenant served at the host
+func GetBranding() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := middleware.HostTenant(c)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No tenant is served at this host"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": gin.H{
+				"name":          tenant.Name,
+				"display_name":  tenant.DisplayName,
+				"language":      tenant.Settings.Language,
+				"timezone":      tenant.Settings.Timezone,
+				"date_format":   tenant.Settings.DateFormat,
+				"currency_code": tenant.Settings.CurrencyCode,
+			},
+		})
+	}
+}
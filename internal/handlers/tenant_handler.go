@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -23,6 +24,10 @@ func NewTenantHandler(tenantService *services.TenantService) *TenantHandler {
 
 // CreateTenant handles POST /api/v1/tenants
 func (h *TenantHandler) CreateTenant(c *gin.Context) {
+	if !requireSuperAdmin(c) {
+		return
+	}
+
 	var req services.CreateTenantRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -38,13 +43,30 @@ func (h *TenantHandler) CreateTenant(c *gin.Context) {
 			zap.String("requestID", c.GetString("requestID")),
 			zap.Error(err))
 
+		var provisioningErr *services.ProvisioningError
+		if errors.As(err, &provisioningErr) {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":           "Failed to create tenant",
+				"provisioning_id": provisioningErr.ProvisioningID,
+				"failed_step":     provisioningErr.Step,
+				"rolled_back":     provisioningErr.RolledBack,
+			})
+			return
+		}
+
 		switch err {
 		case services.ErrTenantNameExists:
 			c.JSON(http.StatusConflict, gin.H{"error": "Tenant name already exists"})
+		case services.ErrProvisioningPending:
+			c.JSON(http.StatusConflict, gin.H{"error": "An unfinished provisioning exists for this tenant name, resume it or wait for its cleanup"})
 		case services.ErrPlanNotFound:
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
 		case services.ErrInvalidTenantData:
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant data"})
+		case services.ErrInvalidDomain:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain"})
+		case services.ErrCustomDomainNotAllowed:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Plan does not allow custom domains"})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tenant"})
 		}
@@ -59,23 +81,8 @@ func (h *TenantHandler) CreateTenant(c *gin.Context) {
 
 // GetTenant handles GET /api/v1/tenants/:id
 func (h *TenantHandler) GetTenant(c *gin.Context) {
-	idStr := c.Param("id")
-	tenantID, err := uuid.Parse(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
-		return
-	}
-
-	tenant, err := h.tenantService.GetTenant(c.Request.Context(), tenantID)
-	if err != nil {
-		if err == services.ErrTenantNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
-			return
-		}
-		logger.Error("Failed to get tenant",
-			zap.String("tenantID", idStr),
-			zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tenant"})
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
 		return
 	}
 
@@ -84,6 +91,10 @@ func (h *TenantHandler) GetTenant(c *gin.Context) {
 
 // ListTenants handles GET /api/v1/tenants
 func (h *TenantHandler) ListTenants(c *gin.Context) {
+	if !requireSuperAdmin(c) {
+		return
+	}
+
 	// Parse query parameters
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
@@ -118,10 +129,8 @@ func (h *TenantHandler) ListTenants(c *gin.Context) {
 
 // UpdateTenant handles PUT /api/v1/tenants/:id
 func (h *TenantHandler) UpdateTenant(c *gin.Context) {
-	idStr := c.Param("id")
-	tenantID, err := uuid.Parse(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+	current, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
 		return
 	}
 
@@ -131,14 +140,14 @@ func (h *TenantHandler) UpdateTenant(c *gin.Context) {
 		return
 	}
 
-	tenant, err := h.tenantService.UpdateTenant(c.Request.Context(), tenantID, updates)
+	tenant, err := h.tenantService.UpdateTenant(c.Request.Context(), current.ID, updates)
 	if err != nil {
 		if err == services.ErrTenantNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
 			return
 		}
 		logger.Error("Failed to update tenant",
-			zap.String("tenantID", idStr),
+			zap.String("tenantID", current.ID.String()),
 			zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tenant"})
 		return
@@ -152,21 +161,19 @@ func (h *TenantHandler) UpdateTenant(c *gin.Context) {
 
 // GetTenantUsage handles GET /api/v1/tenants/:id/usage
 func (h *TenantHandler) GetTenantUsage(c *gin.Context) {
-	idStr := c.Param("id")
-	tenantID, err := uuid.Parse(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
 		return
 	}
 
-	usage, err := h.tenantService.GetTenantUsage(c.Request.Context(), tenantID)
+	usage, err := h.tenantService.GetTenantUsage(c.Request.Context(), tenant.ID)
 	if err != nil {
 		if err == services.ErrTenantNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
 			return
 		}
 		logger.Error("Failed to get tenant usage",
-			zap.String("tenantID", idStr),
+			zap.String("tenantID", tenant.ID.String()),
 			zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve usage data"})
 		return
@@ -174,3 +181,100 @@ func (h *TenantHandler) GetTenantUsage(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"data": usage})
 }
+
+// ListProvisionings handles GET /api/v1/admin/tenant-provisionings
+func (h *TenantHandler) ListProvisionings(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	status := c.Query("status")
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	offset := (page - 1) * limit
+
+	provisionings, total, err := h.tenantService.ListProvisionings(c.Request.Context(), offset, limit, status)
+	if err != nil {
+		logger.Error("Failed to list tenant provisionings", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tenant provisionings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": provisionings,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// GetProvisioning handles GET /api/v1/admin/tenant-provisionings/:provisioningId
+func (h *TenantHandler) GetProvisioning(c *gin.Context) {
+	provisioningID, err := uuid.Parse(c.Param("provisioningId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provisioning ID"})
+		return
+	}
+
+	provisioning, err := h.tenantService.GetProvisioning(c.Request.Context(), provisioningID)
+	if err != nil {
+		h.handleProvisioningError(c, "Failed to get tenant provisioning", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": provisioning})
+}
+
+// ResumeProvisioning handles POST /api/v1/admin/tenant-provisionings/:provisioningId/resume
+func (h *TenantHandler) ResumeProvisioning(c *gin.Context) {
+	provisioningID, err := uuid.Parse(c.Param("provisioningId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provisioning ID"})
+		return
+	}
+
+	tenant, err := h.tenantService.ResumeProvisioning(c.Request.Context(), provisioningID)
+	if err != nil {
+		h.handleProvisioningError(c, "Failed to resume tenant provisioning", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tenant created successfully, the admin user was sent a password reset email",
+		"data":    tenant,
+	})
+}
+
+func (h *TenantHandler) handleProvisioningError(c *gin.Context, message string, err error) {
+	var provisioningErr *services.ProvisioningError
+	switch {
+	case errors.Is(err, services.ErrProvisioningNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant provisioning not found"})
+	case errors.Is(err, services.ErrProvisioningNotResumable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Only failed or rolled back provisionings can be resumed"})
+	case errors.Is(err, services.ErrTenantNameExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "Tenant name already exists"})
+	case errors.As(err, &provisioningErr):
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":           message,
+			"provisioning_id": provisioningErr.ProvisioningID,
+			"failed_step":     provisioningErr.Step,
+			"rolled_back":     provisioningErr.RolledBack,
+		})
+	default:
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
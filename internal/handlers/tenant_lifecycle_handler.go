@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type TenantLifecycleHandler struct {
+	lifecycleService *services.TenantLifecycleService
+	tenantService    *services.TenantService
+}
+
+func NewTenantLifecycleHandler(lifecycleService *services.TenantLifecycleService, tenantService *services.TenantService) *TenantLifecycleHandler {
+	return &TenantLifecycleHandler{
+		lifecycleService: lifecycleService,
+		tenantService:    tenantService,
+	}
+}
+
+type tenantStatusRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// SuspendTenant handles POST /api/v1/tenants/:id/suspend
+func (h *TenantLifecycleHandler) SuspendTenant(c *gin.Context) {
+	if !requireSuperAdmin(c) {
+		return
+	}
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	var req tenantStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.lifecycleService.Suspend(c.Request.Context(), tenant.ID, req.Reason, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to suspend tenant", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tenant suspended successfully",
+		"data":    result,
+	})
+}
+
+// ReactivateTenant handles POST /api/v1/tenants/:id/reactivate
+func (h *TenantLifecycleHandler) ReactivateTenant(c *gin.Context) {
+	if !requireSuperAdmin(c) {
+		return
+	}
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	result, err := h.lifecycleService.Reactivate(c.Request.Context(), tenant.ID, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to reactivate tenant", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tenant reactivated successfully",
+		"data":    result,
+	})
+}
+
+// OffboardTenant handles POST /api/v1/tenants/:id/offboard
+func (h *TenantLifecycleHandler) OffboardTenant(c *gin.Context) {
+	if !requireSuperAdmin(c) {
+		return
+	}
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	var req tenantStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.lifecycleService.Offboard(c.Request.Context(), tenant.ID, req.Reason, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to offboard tenant", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tenant offboarded, data export available until the scheduled deletion",
+		"data":    result,
+	})
+}
+
+// DownloadExport handles GET /api/v1/tenants/:id/export
+func (h *TenantLifecycleHandler) DownloadExport(c *gin.Context) {
+	if !requireSuperAdmin(c) {
+		return
+	}
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	path, err := h.lifecycleService.ExportPath(c.Request.Context(), tenant.ID)
+	if err != nil {
+		h.handleError(c, "Failed to get tenant data export", err)
+		return
+	}
+
+	c.FileAttachment(path, filepath.Base(path))
+}
+
+// DeleteTenant handles DELETE /api/v1/tenants/:id, the immediate hard delete of an offboarding tenant
+func (h *TenantLifecycleHandler) DeleteTenant(c *gin.Context) {
+	if !requireSuperAdmin(c) {
+		return
+	}
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	if err := h.lifecycleService.Delete(c.Request.Context(), tenant.ID, c.GetString("userID")); err != nil {
+		h.handleError(c, "Failed to delete tenant", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tenant deleted successfully"})
+}
+
+func (h *TenantLifecycleHandler) handleError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrTenantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+	case errors.Is(err, services.ErrInvalidTenantTransition):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Invalid tenant status transition",
+			"details": err.Error(),
+		})
+	case errors.Is(err, services.ErrNoDataExport):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant has no data export"})
+	default:
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/middleware"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type UsageHandler struct {
+	usageMeter    *services.UsageMeter
+	quotaService  *services.QuotaService
+	tenantService *services.TenantService
+}
+
+func NewUsageHandler(usageMeter *services.UsageMeter, quotaService *services.QuotaService, tenantService *services.TenantService) *UsageHandler {
+	return &UsageHandler{
+		usageMeter:    usageMeter,
+		quotaService:  quotaService,
+		tenantService: tenantService,
+	}
+}
+
+// usageEventRequest reports usage metered outside the API, such as consultas run
+// by the consulta service; API calls are metered by the API itself. Services report
+// usage before serving it, so that they can refuse it when a quota is used up.
+type usageEventRequest struct {
+	Metric   domain.UsageMetric `json:"metric" binding:"required,oneof=consultas ai_requests messages"`
+	Quantity int64              `json:"quantity" binding:"omitempty,min=1,max=10000"`
+}
+
+// resourceCountRequest reports how many resources limited by the plan, such as
+// cases, a tenant keeps in the service owning them
+type resourceCountRequest struct {
+	Count *int64 `json:"count" binding:"required,min=0"`
+}
+
+// RecordUsageEvent handles POST /api/v1/usage/events
+func (h *UsageHandler) RecordUsageEvent(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.GetString("tenantID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Usage events must be reported for a tenant"})
+		return
+	}
+
+	var req usageEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	if quota, ok := services.QuotaForUsageMetric(req.Metric); ok {
+		status, err := h.quotaService.Check(c.Request.Context(), tenantID, quota, req.Quantity)
+		switch {
+		case errors.Is(err, services.ErrQuotaExceeded):
+			middleware.SetQuotaHeaders(c, status)
+			middleware.AbortQuotaExceeded(c, status)
+			return
+		case err != nil:
+			// As in EnforceQuota, usage that cannot be checked goes through
+			logger.Warn("Failed to check quota",
+				zap.String("requestID", c.GetString("requestID")),
+				zap.String("tenantID", tenantID.String()),
+				zap.String("quota", string(quota)),
+				zap.Error(err))
+		default:
+			middleware.SetQuotaHeaders(c, status)
+		}
+	}
+
+	if err := h.usageMeter.Record(c.Request.Context(), tenantID, req.Metric, req.Quantity); err != nil {
+		logger.Error("Failed to record usage event",
+			zap.String("requestID", c.GetString("requestID")),
+			zap.String("tenantID", tenantID.String()),
+			zap.String("metric", string(req.Metric)),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record usage event"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Usage recorded"})
+}
+
+// SetResourceCount handles PUT /api/v1/usage/resources/:resource
+func (h *UsageHandler) SetResourceCount(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.GetString("tenantID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Resource counts must be reported for a tenant"})
+		return
+	}
+
+	var req resourceCountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	quota := services.Quota(c.Param("resource"))
+	if err := h.quotaService.SetResourceCount(c.Request.Context(), tenantID, quota, *req.Count); err != nil {
+		if errors.Is(err, services.ErrUnknownQuota) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown resource"})
+			return
+		}
+		var exceeded *services.QuotaExceededError
+		if errors.As(err, &exceeded) {
+			middleware.AbortQuotaExceeded(c, &exceeded.Status)
+			return
+		}
+		logger.Error("Failed to set resource count",
+			zap.String("requestID", c.GetString("requestID")),
+			zap.String("tenantID", tenantID.String()),
+			zap.String("resource", string(quota)),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set resource count"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Resource count updated"})
+}
+
+// GetQuotas handles GET /api/v1/usage/quotas, for services checking a quota
+// before creating resources it limits
+func (h *UsageHandler) GetQuotas(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.GetString("tenantID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quotas must be requested for a tenant"})
+		return
+	}
+	h.respondQuotas(c, tenantID)
+}
+
+// GetTenantQuotas handles GET /api/v1/tenants/:id/quotas
+func (h *UsageHandler) GetTenantQuotas(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+	h.respondQuotas(c, tenant.ID)
+}
+
+// GetTenantOverage handles GET /api/v1/tenants/:id/overage, the overage of the
+// current usage period and where it is heading
+func (h *UsageHandler) GetTenantOverage(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	projection, err := h.quotaService.ProjectedOverage(c.Request.Context(), tenant.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+			return
+		}
+		logger.Error("Failed to project overage",
+			zap.String("requestID", c.GetString("requestID")),
+			zap.String("tenantID", tenant.ID.String()),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to project overage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": projection})
+}
+
+func (h *UsageHandler) respondQuotas(c *gin.Context, tenantID uuid.UUID) {
+	statuses, err := h.quotaService.Statuses(c.Request.Context(), tenantID)
+	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+			return
+		}
+		logger.Error("Failed to get quotas",
+			zap.String("requestID", c.GetString("requestID")),
+			zap.String("tenantID", tenantID.String()),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve quotas"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": statuses})
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/middleware"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type UserHandler struct {
+	userService   *services.UserService
+	tenantService *services.TenantService
+}
+
+func NewUserHandler(userService *services.UserService, tenantService *services.TenantService) *UserHandler {
+	return &UserHandler{
+		userService:   userService,
+		tenantService: tenantService,
+	}
+}
+
+// InviteUser handles POST /api/v1/tenants/:id/users
+func (h *UserHandler) InviteUser(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	var req services.InviteUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.userService.InviteUser(c.Request.Context(), tenant.ID, c.GetString("userID"), &req)
+	if err != nil {
+		h.handleError(c, "Failed to invite user", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "User invited successfully",
+		"data":    user,
+	})
+}
+
+// ListUsers handles GET /api/v1/tenants/:id/users
+func (h *UserHandler) ListUsers(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	filter := services.ListUsersFilter{
+		Role:   c.Query("role"),
+		Status: c.Query("status"),
+		Search: c.Query("search"),
+		Offset: (page - 1) * limit,
+		Limit:  limit,
+	}
+
+	users, total, err := h.userService.ListUsers(c.Request.Context(), tenant.ID, filter)
+	if err != nil {
+		h.handleError(c, "Failed to list users", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": users,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// ChangeUserRole handles PUT /api/v1/tenants/:id/users/:userId/role
+func (h *UserHandler) ChangeUserRole(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Role domain.UserRole `json:"role" binding:"required,oneof=admin lawyer secretary client"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.userService.ChangeUserRole(c.Request.Context(), tenant.ID, userID, c.GetString("userID"), req.Role)
+	if err != nil {
+		h.handleError(c, "Failed to change user role", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User role updated successfully",
+		"data":    user,
+	})
+}
+
+// BlockUser handles POST /api/v1/tenants/:id/users/:userId/block
+func (h *UserHandler) BlockUser(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := h.userService.BlockUser(c.Request.Context(), tenant.ID, userID, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to block user", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User blocked successfully",
+		"data":    user,
+	})
+}
+
+// ReactivateUser handles POST /api/v1/tenants/:id/users/:userId/reactivate
+func (h *UserHandler) ReactivateUser(c *gin.Context) {
+	tenant, ok := authorizedTenant(c, h.tenantService)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := h.userService.ReactivateUser(c.Request.Context(), tenant.ID, userID, c.GetString("userID"))
+	if err != nil {
+		h.handleError(c, "Failed to reactivate user", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User reactivated successfully",
+		"data":    user,
+	})
+}
+
+func (h *UserHandler) handleError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrTenantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, services.ErrUserEmailExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "A user with this email already exists"})
+	case errors.Is(err, services.ErrUserLimitReached):
+		c.JSON(http.StatusPaymentRequired, gin.H{
+			"error":   "User limit reached",
+			"message": "Your plan does not allow more users",
+		})
+	case errors.Is(err, services.ErrLastAdmin):
+		c.JSON(http.StatusConflict, gin.H{"error": "Tenant must keep at least one active admin"})
+	case errors.Is(err, services.ErrInvalidUserStatus):
+		c.JSON(http.StatusConflict, gin.H{"error": "User is not blocked or inactive"})
+	default:
+		logger.Error(message,
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// authorizedTenant loads the tenant from the :id path parameter and checks the caller
+// belongs to it; super admins may act on any tenant
+func authorizedTenant(c *gin.Context, tenantService *services.TenantService) (*domain.Tenant, bool) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+		return nil, false
+	}
+
+	tenant, err := tenantService.GetTenant(c.Request.Context(), tenantID)
+	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+			return nil, false
+		}
+		logger.Error("Failed to get tenant",
+			zap.String("tenantID", tenantID.String()),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tenant"})
+		return nil, false
+	}
+
+	if tenant.Name != c.GetString("tenant") && !middleware.HasRole(c, "super_admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to tenant"})
+		return nil, false
+	}
+
+	// Queries of the handler run in the tenant of the path only; for super admins
+	// this replaces the cross-tenant scope ScopeTenant set
+	c.Request = c.Request.WithContext(domain.OnlyTenant(c.Request.Context(), tenant.ID))
+
+	return tenant, true
+}
+
+// requireSuperAdmin writes a 403 and reports false unless the caller is a super admin.
+// Tenant admins pass RequireRole("admin"), so platform-wide handlers check the role themselves.
+func requireSuperAdmin(c *gin.Context) bool {
+	if middleware.HasRole(c, "super_admin") {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	return false
+}
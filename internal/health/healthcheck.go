@@ -334,14 +334,14 @@ func (r *RedisChecker) Check(ctx context.Context) Check {
 
 // KeycloakChecker checks Keycloak health
 type KeycloakChecker struct {
-	keycloakClient *auth.KeycloakClient
-	baseURL        string
+	identityProvider auth.IdentityProvider
+	baseURL          string
 }
 
-func NewKeycloakChecker(client *auth.KeycloakClient, baseURL string) *KeycloakChecker {
+func NewKeycloakChecker(client auth.IdentityProvider, baseURL string) *KeycloakChecker {
 	return &KeycloakChecker{
-		keycloakClient: client,
-		baseURL:        baseURL,
+		identityProvider: client,
+		baseURL:          baseURL,
 	}
 }
 
@@ -349,7 +349,7 @@ func (k *KeycloakChecker) Check(ctx context.Context) Check {
 	start := time.Now()
 
 	// Try to get public key (lightweight operation)
-	_, err := k.keycloakClient.GetPublicKey(ctx)
+	_, err := k.identityProvider.GetPublicKey(ctx)
 
 	status := StatusHealthy
 	message := "Keycloak is healthy"
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const authTypeAPIKey = "api_key"
+
+// AuthOrAPIKey accepts either a Bearer token or an X-API-Key header.
+// API keys, and service principals, must grant the route group's scope ("<scope>:read" for GET/HEAD,
+// "<scope>:write" otherwise).
+func AuthOrAPIKey(validator *auth.TokenValidator, apiKeyService *services.APIKeyService, mfaService *services.MFAService, redisClient *redis.Client, scope string) gin.HandlerFunc {
+	tokenAuth := authenticate(validator, redisClient, mfaService, scope)
+
+	return func(c *gin.Context) {
+		rawKey := c.GetHeader("X-API-Key")
+		if rawKey == "" {
+			tokenAuth(c)
+			return
+		}
+
+		apiKey, tenant, err := apiKeyService.Authenticate(c.Request.Context(), rawKey)
+		if err != nil {
+			logger.Warn("Invalid API key",
+				zap.String("requestID", c.GetString("requestID")),
+				zap.Error(err))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired API key"})
+			c.Abort()
+			return
+		}
+
+		requiredScope := scopeForMethod(scope, c.Request.Method)
+		if !apiKey.HasScope(requiredScope) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "API key scope required",
+				"scope": requiredScope,
+			})
+			c.Abort()
+			return
+		}
+
+		// Same context as Auth, with a synthetic principal for the key
+		c.Set("userID", apiKey.ID.String())
+		c.Set("email", "")
+		c.Set("tenant", tenant.Name)
+		c.Set("claims", map[string]interface{}{
+			"sub":    apiKey.ID.String(),
+			"groups": []interface{}{"/" + tenant.Name},
+			"scopes": apiKey.Scopes,
+		})
+		c.Set("authType", authTypeAPIKey)
+		c.Set("apiKeyID", apiKey.ID.String())
+
+		if !matchHostTenant(c) {
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// scopeForMethod maps a route group scope to the read or write scope for the HTTP method
+func scopeForMethod(scope, method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return scope + ":read"
+	default:
+		return scope + ":write"
+	}
+}
@@ -2,27 +2,31 @@ package middleware
 
 import (
 	"context"
-	"crypto/rsa"
-	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"math/big"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/services"
 	"github.com/opiagile/direito-lux/pkg/logger"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
-var publicKeyCache *rsa.PublicKey
-var publicKeyCacheTime time.Time
+// Auth middleware validates JWT tokens and enforces the tenant MFA policy.
+// Keycloak client credentials tokens authenticate as service principals.
+func Auth(validator *auth.TokenValidator, redisClient *redis.Client, mfaService *services.MFAService) gin.HandlerFunc {
+	return authenticate(validator, redisClient, mfaService, "")
+}
 
-// Auth middleware validates JWT tokens
-func Auth(keycloakClient *auth.KeycloakClient, redisClient *redis.Client) gin.HandlerFunc {
+// authenticate validates the bearer token; scope is the route group scope that
+// service principals must be registered with, empty for routes without one
+func authenticate(validator *auth.TokenValidator, redisClient *redis.Client, mfaService *services.MFAService, scope string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := extractToken(c)
 		if token == "" {
@@ -33,75 +37,108 @@ func Auth(keycloakClient *auth.KeycloakClient, redisClient *redis.Client) gin.Ha
 
 		// Check token blacklist in Redis
 		ctx := context.Background()
-		blacklisted, _ := redisClient.Get(ctx, fmt.Sprintf("blacklist:%s", token)).Result()
+		blacklisted, _ := redisClient.Get(ctx, auth.TokenBlacklistKey(token)).Result()
 		if blacklisted != "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
 			c.Abort()
 			return
 		}
 
-		// Check token cache in Redis
-		cachedClaims, err := redisClient.Get(ctx, fmt.Sprintf("token:%s", token)).Result()
+		// Check token cache in Redis. Impersonation tokens are never cached, their
+		// claims are only trusted straight from the validator.
+		cachedClaims, err := redisClient.Get(ctx, auth.TokenCacheKey(token)).Result()
 		if err == nil && cachedClaims != "" {
-			// Token is cached and valid
-			setClaims(c, cachedClaims)
-			c.Next()
-			return
+			var claims map[string]interface{}
+			if err := json.Unmarshal([]byte(cachedClaims), &claims); err == nil && claims["iss"] != auth.ImpersonationIssuer {
+				if !sessionRevoked(c, redisClient, claims) && authorizePrincipal(c, validator, mfaService, claims, scope) && matchHostTenant(c) {
+					c.Next()
+				}
+				return
+			}
 		}
 
-		// Validate token with Keycloak
-		introspectResult, err := keycloakClient.ValidateToken(ctx, token)
-		if err != nil || !*introspectResult.Active {
+		// Validate token using the configured strategy
+		claims, err := validator.Validate(ctx, token)
+		if err != nil {
 			logger.Warn("Invalid token",
 				zap.String("requestID", c.GetString("requestID")),
+				zap.String("strategy", validator.Strategy()),
 				zap.Error(err))
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
-		// Parse JWT to get claims
-		claims, err := parseJWT(token, keycloakClient)
-		if err != nil {
-			logger.Error("Failed to parse JWT",
-				zap.String("requestID", c.GetString("requestID")),
-				zap.Error(err))
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to parse token"})
-			c.Abort()
+		if sessionRevoked(c, redisClient, claims) || !authorizePrincipal(c, validator, mfaService, claims, scope) || !matchHostTenant(c) {
 			return
 		}
 
-		// Extract user info from claims
-		userID, _ := claims["sub"].(string)
-		email, _ := claims["email"].(string)
+		// Cache the claims (5 minutes, never beyond token expiry).
+		// Impersonation tokens are not cached so revocation takes effect immediately.
+		if c.GetString("impersonatorID") == "" {
+			if data, err := json.Marshal(claims); err == nil {
+				redisClient.Set(ctx, auth.TokenCacheKey(token), data, cacheTTL(claims, 5*time.Minute))
+			}
 
-		// Extract tenant from token
-		tenantName, err := auth.ExtractTenantFromToken(claims)
-		if err != nil {
-			logger.Warn("No tenant found in token",
-				zap.String("userID", userID),
-				zap.Error(err))
-			c.JSON(http.StatusForbidden, gin.H{"error": "No tenant association found"})
-			c.Abort()
-			return
+			// Index the token under its Keycloak session so session revocation can purge it
+			if sessionID, _ := claims["sid"].(string); sessionID != "" {
+				if err := auth.IndexSessionToken(ctx, redisClient, sessionID, token, cacheTTL(claims, auth.SessionTokenTTL)); err != nil {
+					logger.Warn("Failed to index session token", zap.Error(err))
+				}
+			}
 		}
 
-		// Set user context
-		c.Set("userID", userID)
-		c.Set("email", email)
-		c.Set("tenant", tenantName)
-		c.Set("claims", claims)
+		c.Next()
+	}
+}
 
-		// Cache the token (5 minutes)
-		redisClient.Set(ctx, fmt.Sprintf("token:%s", token), userID, 5*time.Minute)
+// sessionRevoked rejects tokens of a revoked Keycloak session, writing the error
+// response. Like the token blacklist, the check is skipped when Redis fails.
+func sessionRevoked(c *gin.Context, redisClient *redis.Client, claims map[string]interface{}) bool {
+	sessionID, _ := claims["sid"].(string)
+	revoked, err := auth.IsSessionRevoked(c.Request.Context(), redisClient, sessionID)
+	if err != nil {
+		logger.Warn("Failed to check session revocation",
+			zap.String("requestID", c.GetString("requestID")),
+			zap.Error(err))
+	}
+	if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+		c.Abort()
+		return true
+	}
+	return false
+}
 
-		c.Next()
+// authorizePrincipal sets the request principal from valid claims, writing the
+// error response and reporting false when the request may not continue
+func authorizePrincipal(c *gin.Context, validator *auth.TokenValidator, mfaService *services.MFAService, claims map[string]interface{}, scope string) bool {
+	if clientID, ok := auth.ServiceAccountFromClaims(claims); ok {
+		return setServicePrincipal(c, validator, clientID, claims, scope)
 	}
+
+	if err := setClaims(c, claims); err != nil {
+		logger.Warn("No tenant found in token",
+			zap.String("userID", c.GetString("userID")),
+			zap.Error(err))
+		c.JSON(http.StatusForbidden, gin.H{"error": "No tenant association found"})
+		c.Abort()
+		return false
+	}
+
+	return enforceMFA(c, mfaService, claims)
 }
 
-// RequireRole checks if user has required role
+// RequireRole checks if user has required role. API keys and service principals
+// carry no roles and are denied; routes open to them use RequireRoleOrScope.
 func RequireRole(requiredRole string) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if isScopedPrincipal(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Role '%s' required, not available to API keys or service clients", requiredRole)})
+			c.Abort()
+			return
+		}
+
 		claims, exists := c.Get("claims")
 		if !exists {
 			c.JSON(http.StatusForbidden, gin.H{"error": "No claims found"})
@@ -116,35 +153,87 @@ func RequireRole(requiredRole string) gin.HandlerFunc {
 			return
 		}
 
-		// Check realm roles
-		if realmAccess, ok := claimsMap["realm_access"].(map[string]interface{}); ok {
-			if roles, ok := realmAccess["roles"].([]interface{}); ok {
-				for _, role := range roles {
-					if roleStr, ok := role.(string); ok && roleStr == requiredRole {
-						c.Next()
-						return
-					}
+		if claimsHaveRole(claimsMap, requiredRole) {
+			c.Next()
+			return
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Role '%s' required", requiredRole)})
+		c.Abort()
+	}
+}
+
+// RequireRoleOrScope checks the role of users, and lets through API keys and
+// service principals, which AuthOrAPIKey already checked for the route group scope.
+// It is meant for the routes of a group that they may use; the others keep RequireRole.
+func RequireRoleOrScope(requiredRole string) gin.HandlerFunc {
+	requireRole := RequireRole(requiredRole)
+	return func(c *gin.Context) {
+		if isScopedPrincipal(c) {
+			c.Next()
+			return
+		}
+		requireRole(c)
+	}
+}
+
+// RequireScopedPrincipal only lets through API keys and service principals, for
+// routes where services report on their own resources and users have no business
+func RequireScopedPrincipal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isScopedPrincipal(c) {
+			c.Next()
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only available to API keys and service clients"})
+		c.Abort()
+	}
+}
+
+// isScopedPrincipal reports whether the request is authorized by scope rather
+// than by role: an API key or a service principal
+func isScopedPrincipal(c *gin.Context) bool {
+	authType := c.GetString("authType")
+	return authType == authTypeAPIKey || authType == authTypeService
+}
+
+// HasRole checks if the authenticated user has a realm or client role
+func HasRole(c *gin.Context, role string) bool {
+	claims, _ := c.Get("claims")
+	claimsMap, ok := claims.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	return claimsHaveRole(claimsMap, role)
+}
+
+// claimsHaveRole checks realm and client roles in token claims
+func claimsHaveRole(claims map[string]interface{}, requiredRole string) bool {
+	// Check realm roles
+	if realmAccess, ok := claims["realm_access"].(map[string]interface{}); ok {
+		if roles, ok := realmAccess["roles"].([]interface{}); ok {
+			for _, role := range roles {
+				if roleStr, ok := role.(string); ok && roleStr == requiredRole {
+					return true
 				}
 			}
 		}
+	}
 
-		// Check resource roles
-		if resourceAccess, ok := claimsMap["resource_access"].(map[string]interface{}); ok {
-			if clientAccess, ok := resourceAccess["direito-lux-app"].(map[string]interface{}); ok {
-				if roles, ok := clientAccess["roles"].([]interface{}); ok {
-					for _, role := range roles {
-						if roleStr, ok := role.(string); ok && roleStr == requiredRole {
-							c.Next()
-							return
-						}
+	// Check resource roles
+	if resourceAccess, ok := claims["resource_access"].(map[string]interface{}); ok {
+		if clientAccess, ok := resourceAccess["direito-lux-app"].(map[string]interface{}); ok {
+			if roles, ok := clientAccess["roles"].([]interface{}); ok {
+				for _, role := range roles {
+					if roleStr, ok := role.(string); ok && roleStr == requiredRole {
+						return true
 					}
 				}
 			}
 		}
-
-		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Role '%s' required", requiredRole)})
-		c.Abort()
 	}
+
+	return false
 }
 
 // extractToken extracts JWT token from Authorization header
@@ -162,87 +251,107 @@ func extractToken(c *gin.Context) string {
 	return parts[1]
 }
 
-// parseJWT parses and validates JWT token
-func parseJWT(tokenString string, keycloakClient *auth.KeycloakClient) (map[string]interface{}, error) {
-	// Get public key
-	publicKey, err := getPublicKey(keycloakClient)
-	if err != nil {
-		return nil, err
-	}
-
-	// Parse token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return publicKey, nil
-	})
+// setClaims sets user context from token claims
+func setClaims(c *gin.Context, claims map[string]interface{}) error {
+	userID, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	c.Set("userID", userID)
 
+	// Extract tenant from token
+	tenantName, err := auth.ExtractTenantFromToken(claims)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
+	c.Set("email", email)
+	c.Set("tenant", tenantName)
+	c.Set("claims", claims)
+
+	// Tag impersonated requests for logs and audit entries
+	if impersonatorID, sessionID, ok := auth.ImpersonatorFromClaims(claims); ok {
+		c.Set("impersonatorID", impersonatorID)
+		c.Set("impersonationSessionID", sessionID)
+		c.Request = c.Request.WithContext(domain.WithImpersonation(c.Request.Context(), domain.Impersonation{
+			ImpersonatorID: impersonatorID,
+			SessionID:      sessionID,
+		}))
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("invalid claims format")
-	}
-
-	return claims, nil
+	return nil
 }
 
-// getPublicKey gets or caches the realm public key
-func getPublicKey(keycloakClient *auth.KeycloakClient) (*rsa.PublicKey, error) {
-	// Check cache
-	if publicKeyCache != nil && time.Since(publicKeyCacheTime) < 1*time.Hour {
-		return publicKeyCache, nil
+// enforceMFA rejects tokens without a second factor when the tenant policy requires one
+// for the user's role, writing the error response; it reports whether the request may continue
+func enforceMFA(c *gin.Context, mfaService *services.MFAService, claims map[string]interface{}) bool {
+	// Impersonation tokens are issued by the API, the super admin authenticated separately
+	if mfaService == nil || c.GetString("impersonatorID") != "" {
+		return true
 	}
 
-	// Get public key from Keycloak
-	publicKeyStr, err := keycloakClient.GetPublicKey(context.Background())
+	// A tenant without a row has no policy to enforce; ScopeTenant decides
+	// whether the request may use it
+	policy, err := mfaService.GetPolicy(c.Request.Context(), c.GetString("tenant"))
+	if errors.Is(err, services.ErrTenantNotFound) {
+		return true
+	}
 	if err != nil {
-		return nil, err
+		logger.Error("Failed to load tenant MFA policy",
+			zap.String("tenant", c.GetString("tenant")),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify authentication policy"})
+		c.Abort()
+		return false
 	}
 
-	// Convert to RSA public key
-	publicKey, err := parseRSAPublicKey(publicKeyStr)
-	if err != nil {
-		return nil, err
+	if !policy.Required || auth.HasMFA(claims) {
+		return true
 	}
 
-	// Cache the key
-	publicKeyCache = publicKey
-	publicKeyCacheTime = time.Now()
+	applies := len(policy.Roles) == 0
+	for _, role := range policy.Roles {
+		if claimsHaveRole(claims, role) {
+			applies = true
+			break
+		}
+	}
+	if !applies {
+		return true
+	}
 
-	return publicKey, nil
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "Two-factor authentication required",
+		"code":  "mfa_required",
+	})
+	c.Abort()
+	return false
 }
 
-// parseRSAPublicKey converts base64 encoded public key to RSA public key
-func parseRSAPublicKey(base64Key string) (*rsa.PublicKey, error) {
-	// Decode base64
-	decoded, err := base64.StdEncoding.DecodeString(base64Key)
-	if err != nil {
-		return nil, err
+// DenyImpersonation rejects requests made with an impersonation token
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("impersonatorID") != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed while impersonating a user"})
+			c.Abort()
+			return
+		}
+		c.Next()
 	}
+}
 
-	// Parse modulus and exponent (simplified - real implementation would need proper ASN.1 parsing)
-	// This is a placeholder - you should use proper X.509 parsing
-	n := new(big.Int)
-	n.SetBytes(decoded)
-
-	publicKey := &rsa.PublicKey{
-		N: n,
-		E: 65537, // Common RSA exponent
+// cacheTTL limits a cache duration to the token's remaining lifetime
+func cacheTTL(claims map[string]interface{}, maxTTL time.Duration) time.Duration {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return maxTTL
 	}
 
-	return publicKey, nil
-}
+	remaining := time.Until(time.Unix(int64(exp), 0))
+	if remaining <= 0 {
+		return time.Second
+	}
+	if remaining < maxTTL {
+		return remaining
+	}
 
-// setClaims sets claims in context (for cached tokens)
-func setClaims(c *gin.Context, userID string) {
-	c.Set("userID", userID)
-	// In a real implementation, you would deserialize full claims from cache
+	return maxTTL
 }
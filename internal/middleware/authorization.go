@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
@@ -156,11 +157,7 @@ func FeatureAuthorizationMiddleware(opaClient *authorization.OPAClient, feature
 		claims, _ := c.Get("claims")
 		claimsMap := claims.(map[string]interface{})
 
-		// Get tenant plan from claims or database
-		tenantPlan := "starter" // Default
-		if plan, ok := claimsMap["tenant_plan"].(string); ok {
-			tenantPlan = plan
-		}
+		tenantPlan := resolveTenantPlan(c, claimsMap)
 
 		// Check feature availability
 		allowed, err := opaClient.CheckFeature(c.Request.Context(), tenantPlan, feature)
@@ -188,6 +185,18 @@ func FeatureAuthorizationMiddleware(opaClient *authorization.OPAClient, feature
 	}
 }
 
+// resolveTenantPlan returns the plan ScopeTenant loaded for the tenant, falling back to
+// the tenant_plan claim, which lags plan changes until the token is refreshed
+func resolveTenantPlan(c *gin.Context, claims map[string]interface{}) string {
+	if plan := c.GetString("tenantPlan"); plan != "" {
+		return plan
+	}
+	if plan, ok := claims["tenant_plan"].(string); ok && plan != "" {
+		return plan
+	}
+	return "starter"
+}
+
 // buildAuthzInput builds the authorization input from request context
 func buildAuthzInput(c *gin.Context, userID, tenantName string, claims interface{}) authorization.AuthzInput {
 	claimsMap, _ := claims.(map[string]interface{})
@@ -197,11 +206,7 @@ func buildAuthzInput(c *gin.Context, userID, tenantName string, claims interface
 	role := extractRole(claimsMap)
 	groups := extractGroups(claimsMap)
 
-	// Get tenant plan (would typically come from database)
-	tenantPlan := "starter"
-	if plan, ok := claimsMap["tenant_plan"].(string); ok {
-		tenantPlan = plan
-	}
+	tenantPlan := resolveTenantPlan(c, claimsMap)
 
 	// Build path segments
 	pathSegments := strings.Split(strings.Trim(c.Request.URL.Path, "/"), "/")
@@ -223,6 +228,8 @@ func buildAuthzInput(c *gin.Context, userID, tenantName string, claims interface
 			Email:        email,
 			Groups:       groups,
 			IsSuperAdmin: role == "super_admin",
+			// The role comes from the target user, never from the impersonator
+			ImpersonatedBy: c.GetString("impersonatorID"),
 		},
 		Resource: authorization.Resource{
 			Type:     extractResourceType(c.Request.URL.Path),
@@ -390,8 +397,12 @@ func logAuditTrail(db *gorm.DB, input authorization.AuthzInput, result *authoriz
 			"denial_reason": result.DenialReason,
 		},
 	}
+	if input.User.ImpersonatedBy != "" {
+		audit.Details["impersonated_by"] = input.User.ImpersonatedBy
+	}
 
-	if err := db.Create(audit).Error; err != nil {
+	ctx := domain.WithTenantID(context.Background(), audit.TenantID)
+	if err := db.WithContext(ctx).Create(audit).Error; err != nil {
 		logger.Error("Failed to create audit log",
 			zap.Error(err),
 			zap.String("user_id", input.User.ID),
@@ -40,6 +40,19 @@ func Logger() gin.HandlerFunc {
 			zap.String("userAgent", c.Request.UserAgent()),
 		}
 
+		if impersonatorID := c.GetString("impersonatorID"); impersonatorID != "" {
+			fields = append(fields,
+				zap.String("impersonatorID", impersonatorID),
+				zap.String("impersonationSessionID", c.GetString("impersonationSessionID")),
+				zap.String("impersonatedUserID", c.GetString("userID")))
+		}
+
+		if serviceClientID := c.GetString("serviceClientID"); serviceClientID != "" {
+			fields = append(fields,
+				zap.String("serviceClientID", serviceClientID),
+				zap.String("tenant", c.GetString("tenant")))
+		}
+
 		if errorMessage != "" {
 			fields = append(fields, zap.String("error", errorMessage))
 		}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// MeterRequests counts the request as an API call of the request tenant once the
+// handler ran; it must run after ScopeTenant. Super admin requests, which act
+// across tenants, are not metered.
+func MeterRequests(meter *services.UsageMeter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		tenantID, err := uuid.Parse(c.GetString("tenantID"))
+		if err != nil {
+			return
+		}
+
+		// Counted even when the client went away before the response
+		ctx := context.WithoutCancel(c.Request.Context())
+		if err := meter.Record(ctx, tenantID, domain.UsageMetricAPICalls, 1); err != nil {
+			logger.Warn("Failed to meter API call",
+				zap.String("requestID", c.GetString("requestID")),
+				zap.String("tenantID", tenantID.String()),
+				zap.Error(err))
+		}
+	}
+}
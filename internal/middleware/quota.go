@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// EnforceQuota refuses the request when the tenant used up a quota of its plan;
+// it must run after ScopeTenant. Requests go through when the usage cannot be
+// read, so that a Redis outage does not take the API down.
+func EnforceQuota(quotaService *services.QuotaService, quota services.Quota) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, err := uuid.Parse(c.GetString("tenantID"))
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		status, err := quotaService.Check(c.Request.Context(), tenantID, quota, 1)
+		if err != nil && !errors.Is(err, services.ErrQuotaExceeded) {
+			logger.Warn("Failed to check quota",
+				zap.String("requestID", c.GetString("requestID")),
+				zap.String("tenantID", tenantID.String()),
+				zap.String("quota", string(quota)),
+				zap.Error(err))
+			c.Next()
+			return
+		}
+
+		SetQuotaHeaders(c, status)
+		if err != nil {
+			AbortQuotaExceeded(c, status)
+			return
+		}
+		c.Next()
+	}
+}
+
+// SetQuotaHeaders reports a quota of the tenant in the X-Quota-* response headers
+func SetQuotaHeaders(c *gin.Context, status *services.QuotaStatus) {
+	if status.Unlimited() {
+		return
+	}
+	c.Header("X-Quota-Limit", strconv.FormatInt(status.Limit, 10))
+	c.Header("X-Quota-Remaining", strconv.FormatInt(status.Remaining, 10))
+	if status.ResetsAt != nil {
+		c.Header("X-Quota-Reset", strconv.FormatInt(status.ResetsAt.Unix(), 10))
+	}
+	// Usage billed beyond the limit, up to the spending cap of the plan
+	if status.OverageLimit > 0 {
+		c.Header("X-Quota-Overage", strconv.FormatInt(status.Overage, 10))
+		c.Header("X-Quota-Overage-Limit", strconv.FormatInt(status.OverageLimit, 10))
+	}
+}
+
+// AbortQuotaExceeded refuses a request over a quota: monthly quotas answer 429
+// until the period ends, resource quotas 402 as only a plan upgrade raises them
+func AbortQuotaExceeded(c *gin.Context, status *services.QuotaStatus) {
+	body := gin.H{
+		"error": "Quota exceeded",
+		"quota": status.Quota,
+		"limit": status.Limit,
+		"used":  status.Used,
+	}
+	if status.OverageLimit > 0 {
+		body["error"] = "Overage spending cap reached"
+		body["overage_limit"] = status.OverageLimit
+	}
+
+	if status.ResetsAt == nil {
+		c.AbortWithStatusJSON(http.StatusPaymentRequired, body)
+		return
+	}
+
+	retryAfter := int64(math.Ceil(time.Until(*status.ResetsAt).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	body["resets_at"] = status.ResetsAt
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, body)
+}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/services"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const hostTenantKey = "hostTenant"
+
+// ResolveTenant resolves the tenant served at the request host, a subdomain of the
+// base domain or a verified custom domain. Requests to platform hosts continue
+// without a host tenant; unknown tenant subdomains get 404.
+func ResolveTenant(resolver *services.TenantDomainService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, err := resolver.ResolveHost(c.Request.Context(), c.Request.Host)
+		if err != nil {
+			if errors.Is(err, services.ErrTenantNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+				c.Abort()
+				return
+			}
+			logger.Error("Failed to resolve tenant from host",
+				zap.String("requestID", c.GetString("requestID")),
+				zap.String("host", c.Request.Host),
+				zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve tenant"})
+			c.Abort()
+			return
+		}
+
+		if tenant != nil {
+			c.Set(hostTenantKey, tenant)
+		}
+
+		c.Next()
+	}
+}
+
+// HostTenant returns the tenant resolved from the request host, if any
+func HostTenant(c *gin.Context) (*domain.Tenant, bool) {
+	value, exists := c.Get(hostTenantKey)
+	if !exists {
+		return nil, false
+	}
+	tenant, ok := value.(*domain.Tenant)
+	return tenant, ok
+}
+
+// matchHostTenant rejects principals of another tenant on a tenant host, writing the
+// error response; super admins may use any host. It reports whether the request may continue.
+func matchHostTenant(c *gin.Context) bool {
+	hostTenant, ok := HostTenant(c)
+	if !ok || hostTenant.Name == c.GetString("tenant") || HasRole(c, "super_admin") {
+		return true
+	}
+
+	logger.Warn("Token tenant does not match host tenant",
+		zap.String("requestID", c.GetString("requestID")),
+		zap.String("hostTenant", hostTenant.Name),
+		zap.String("tenant", c.GetString("tenant")))
+	c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to tenant"})
+	c.Abort()
+	return false
+}
+
+// ScopeTenant scopes the database queries of the request to the tenant of the
+// authenticated principal and rejects tenants that are suspended or being
+// offboarded, and the changes of read-only tenants; it must run after Auth or
+// AuthOrAPIKey. Super admins act across tenants, handlers narrow the scope to the
+// tenant they operate on.
+func ScopeTenant(resolver *services.TenantDomainService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if HasRole(c, "super_admin") {
+			c.Request = c.Request.WithContext(domain.CrossTenant(c.Request.Context()))
+			c.Next()
+			return
+		}
+
+		tenant, err := resolver.ResolveName(c.Request.Context(), c.GetString("tenant"))
+		if err != nil {
+			if errors.Is(err, services.ErrTenantNotFound) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "No tenant association found"})
+				c.Abort()
+				return
+			}
+			logger.Error("Failed to resolve tenant",
+				zap.String("requestID", c.GetString("requestID")),
+				zap.String("tenant", c.GetString("tenant")),
+				zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve tenant"})
+			c.Abort()
+			return
+		}
+
+		switch tenant.Status {
+		case domain.TenantStatusSuspended:
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Tenant is suspended",
+				"code":  "tenant_suspended",
+			})
+			c.Abort()
+			return
+		case domain.TenantStatusOffboarding:
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Tenant is being offboarded",
+				"code":  "tenant_offboarding",
+			})
+			c.Abort()
+			return
+		}
+
+		if tenant.ReadOnly && !readOnlyAllowed(c) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Tenant is read-only until its subscription is paid",
+				"code":  "tenant_read_only",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("tenantID", tenant.ID.String())
+		c.Set("tenantPlan", tenant.Plan)
+		c.Request = c.Request.WithContext(domain.WithTenantID(c.Request.Context(), tenant.ID))
+
+		c.Next()
+	}
+}
+
+// readOnlyWritableRoutes are the routes read-only tenants may still change: paying
+// the subscription and securing their accounts
+var readOnlyWritableRoutes = map[string]bool{
+	"/api/v1/tenants/:id/subscription/payment-method": true,
+	"/api/v1/profile/password":                        true,
+}
+
+func readOnlyAllowed(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return readOnlyWritableRoutes[c.FullPath()]
+}
@@ -0,0 +1,144 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidBoleto = errors.New("invalid boleto")
+
+// boletoBaseDate is day zero of the due date factor; the factor restarts at 1000
+// after reaching 9999 on 2025-02-21
+var boletoBaseDate = time.Date(1997, 10, 7, 0, 0, 0, 0, time.UTC)
+
+// Boleto is a bank slip in the FEBRABAN layout
+type Boleto struct {
+	BankCode string // 3 digits
+	DueDate  time.Time
+	Amount   int64 // in cents
+	// FreeField is the 25 digit bank specific part of the barcode, see BradescoFreeField
+	FreeField string
+}
+
+// Barcode returns the 44 digits encoded in the barcode of the slip
+func (b *Boleto) Barcode() (string, error) {
+	if !isDigits(b.BankCode, 3) || !isDigits(b.FreeField, 25) {
+		return "", fmt.Errorf("%w: bank code must have 3 digits and the free field 25", ErrInvalidBoleto)
+	}
+	if b.Amount <= 0 || b.Amount > 99999999_99 {
+		return "", fmt.Errorf("%w: amount out of range", ErrInvalidBoleto)
+	}
+	factor, err := boletoDueFactor(b.DueDate)
+	if err != nil {
+		return "", err
+	}
+
+	// Bank, currency (9 is BRL), due date factor, amount and free field around the check digit
+	body := fmt.Sprintf("%s9%04d%010d%s", b.BankCode, factor, b.Amount, b.FreeField)
+	return body[:4] + strconv.Itoa(boletoBarcodeDigit(body)) + body[4:], nil
+}
+
+// LinhaDigitavel returns the 47 digit line typed by payers, formatted as
+// AAAAA.AAAAA BBBBB.BBBBBB CCCCC.CCCCCC D EEEEEEEEEEEEEE
+func (b *Boleto) LinhaDigitavel() (string, error) {
+	barcode, err := b.Barcode()
+	if err != nil {
+		return "", err
+	}
+	return LinhaDigitavel(barcode)
+}
+
+// LinhaDigitavel converts a 44 digit barcode to its typed line
+func LinhaDigitavel(barcode string) (string, error) {
+	if !isDigits(barcode, 44) {
+		return "", fmt.Errorf("%w: barcode must have 44 digits", ErrInvalidBoleto)
+	}
+
+	field1 := barcode[0:4] + barcode[19:24]
+	field2 := barcode[24:34]
+	field3 := barcode[34:44]
+	field1 += strconv.Itoa(modulo10(field1))
+	field2 += strconv.Itoa(modulo10(field2))
+	field3 += strconv.Itoa(modulo10(field3))
+
+	return fmt.Sprintf("%s.%s %s.%s %s.%s %s %s",
+		field1[:5], field1[5:],
+		field2[:5], field2[5:],
+		field3[:5], field3[5:],
+		barcode[4:5],
+		barcode[5:19]), nil
+}
+
+// BradescoFreeField builds the free field of Bradesco (bank 237) slips: agency,
+// wallet, our number, account and a trailing zero
+func BradescoFreeField(agency, wallet string, ourNumber int64, account string) (string, error) {
+	agency, wallet, account = onlyDigits(agency), onlyDigits(wallet), onlyDigits(account)
+	if len(agency) == 0 || len(agency) > 4 || len(wallet) == 0 || len(wallet) > 2 || len(account) == 0 || len(account) > 7 {
+		return "", fmt.Errorf("%w: agency, wallet or account too long", ErrInvalidBoleto)
+	}
+	if ourNumber <= 0 || ourNumber > 99999999999 {
+		return "", fmt.Errorf("%w: our number must have up to 11 digits", ErrInvalidBoleto)
+	}
+	return zeroPad(agency, 4) + zeroPad(wallet, 2) + fmt.Sprintf("%011d", ourNumber) + zeroPad(account, 7) + "0", nil
+}
+
+func boletoDueFactor(due time.Time) (int, error) {
+	due = time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, time.UTC)
+	days := int(due.Sub(boletoBaseDate).Hours() / 24)
+	if days < 1000 {
+		return 0, fmt.Errorf("%w: due date too early", ErrInvalidBoleto)
+	}
+	if days > 9999 {
+		days = (days-10000)%9000 + 1000
+	}
+	return days, nil
+}
+
+// boletoBarcodeDigit is the modulo 11 check digit of the 43 other barcode digits,
+// weights 2 to 9 from the right; 10 and 11 become 1
+func boletoBarcodeDigit(digits string) int {
+	sum, weight := 0, 2
+	for i := len(digits) - 1; i >= 0; i-- {
+		sum += int(digits[i]-'0') * weight
+		if weight++; weight > 9 {
+			weight = 2
+		}
+	}
+	digit := 11 - sum%11
+	if digit > 9 {
+		return 1
+	}
+	return digit
+}
+
+// modulo10 is the check digit of the typed line fields: weights 2 and 1 from
+// the right, adding the digits of two digit products
+func modulo10(digits string) int {
+	sum, weight := 0, 2
+	for i := len(digits) - 1; i >= 0; i-- {
+		product := int(digits[i]-'0') * weight
+		sum += product/10 + product%10
+		weight = 3 - weight
+	}
+	return (10 - sum%10) % 10
+}
+
+func isDigits(s string, length int) bool {
+	return len(s) == length && strings.Trim(s, "0123456789") == ""
+}
+
+func zeroPad(s string, length int) string {
+	return strings.Repeat("0", length-len(s)) + s
+}
+
+func onlyDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,75 @@
+package payments
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCRC16CCITT(t *testing.T) {
+	if got := crc16CCITT([]byte("123456789")); got != 0x29B1 {
+		t.Errorf("crc16CCITT() = %04X, want 29B1", got)
+	}
+}
+
+func TestPixPayload(t *testing.T) {
+	charge := &PixCharge{
+		Key:          "12345678000195",
+		MerchantName: "Direito Lux Tecnologia Ltda ME",
+		MerchantCity: "São Paulo",
+		Amount:       19990,
+		TxID:         "DLX000042",
+	}
+	payload, err := charge.Payload()
+	if err != nil {
+		t.Fatalf("Payload() error = %v", err)
+	}
+
+	want := "000201010212" +
+		"26360014br.gov.bcb.pix011412345678000195" +
+		"52040000530398654061" + "99.90" + "5802BR" +
+		"5925Direito Lux Tecnologia Lt" + "6009Sao Paulo" +
+		"62130509DLX000042" + "6304"
+	if payload[:len(payload)-4] != want {
+		t.Fatalf("Payload() = %s, want prefix %s", payload, want)
+	}
+	if crc := payload[len(payload)-4:]; crc != fmt.Sprintf("%04X", crc16CCITT([]byte(want))) {
+		t.Errorf("CRC = %s", crc)
+	}
+
+	charge.TxID = "DLX-000042"
+	if _, err := charge.Payload(); err == nil {
+		t.Error("Payload() accepted a txid with a hyphen")
+	}
+}
+
+func TestBoletoLinhaDigitavel(t *testing.T) {
+	// Published Banco do Brasil sample
+	line, err := LinhaDigitavel("00193373700000001000500940144816060680935031")
+	if err != nil {
+		t.Fatalf("LinhaDigitavel() error = %v", err)
+	}
+	if want := "00190.50095 40144.816069 06809.350314 3 37370000000100"; line != want {
+		t.Errorf("LinhaDigitavel() = %s, want %s", line, want)
+	}
+	if digit := boletoBarcodeDigit("0019" + "3737000000010005009401448160606809350" + "31"); digit != 3 {
+		t.Errorf("boletoBarcodeDigit() = %d, want 3", digit)
+	}
+
+	free, err := BradescoFreeField("1234", "9", 42, "12345-6")
+	if err != nil {
+		t.Fatalf("BradescoFreeField() error = %v", err)
+	}
+	boleto := &Boleto{BankCode: "237", DueDate: time.Date(2025, 2, 22, 0, 0, 0, 0, time.UTC), Amount: 19990, FreeField: free}
+	barcode, err := boleto.Barcode()
+	if err != nil {
+		t.Fatalf("Barcode() error = %v", err)
+	}
+	// The due date factor restarted at 1000 on 2025-02-22
+	if barcode[5:19] != "10000000019990" || barcode[19:] != "1234090000000004201234560" {
+		t.Errorf("Barcode() = %s", barcode)
+	}
+	if digit := boletoBarcodeDigit(barcode[:4] + barcode[5:]); barcode[4] != byte('0'+digit) {
+		t.Errorf("barcode check digit = %c, want %d", barcode[4], digit)
+	}
+}
@@ -0,0 +1,83 @@
+package payments
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/opiagile/direito-lux/internal/config"
+)
+
+// Confirmation providers
+const (
+	ConfirmationsSigned = "signed"
+)
+
+// Payment methods confirmed by a ConfirmationProvider
+const (
+	MethodPix    = "pix"
+	MethodBoleto = "boleto"
+)
+
+const confirmationSignatureHeader = "X-Payment-Signature"
+
+// ConfirmationProvider is a bank or PSP notifying the Pix and boleto payments
+// it received. Implementations verify the authenticity of the webhook requests.
+type ConfirmationProvider interface {
+	Name() string
+	ParseConfirmation(payload []byte, header http.Header) (*Confirmation, error)
+}
+
+// Confirmation is a Pix or boleto payment received
+type Confirmation struct {
+	ID        string    `json:"id"`        // unique per provider; redeliveries repeat it
+	Method    string    `json:"method"`    // pix or boleto
+	Reference string    `json:"reference"` // Pix txid or boleto our number
+	Amount    int64     `json:"amount"`    // in cents
+	PaidAt    time.Time `json:"paid_at"`
+}
+
+// SignedConfirmations accepts confirmations posted as JSON Confirmation objects
+// and signed with a shared secret in the X-Payment-Signature header, in the
+// scheme of SignPayload. It lets a bank integration or a PSP adapter be plugged
+// in without changing the API.
+type SignedConfirmations struct {
+	secret    string
+	tolerance time.Duration
+}
+
+var _ ConfirmationProvider = (*SignedConfirmations)(nil)
+
+func NewSignedConfirmations(cfg *config.PaymentConfirmationsConfig) *SignedConfirmations {
+	tolerance := cfg.Tolerance
+	if tolerance <= 0 {
+		tolerance = 5 * time.Minute
+	}
+	return &SignedConfirmations{secret: cfg.Secret, tolerance: tolerance}
+}
+
+func (p *SignedConfirmations) Name() string {
+	return ConfirmationsSigned
+}
+
+func (p *SignedConfirmations) ParseConfirmation(payload []byte, header http.Header) (*Confirmation, error) {
+	if err := verifySignature(payload, header.Get(confirmationSignatureHeader), p.secret, p.tolerance, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var confirmation Confirmation
+	if err := json.Unmarshal(payload, &confirmation); err != nil {
+		return nil, fmt.Errorf("invalid confirmation payload: %w", err)
+	}
+	if confirmation.ID == "" || confirmation.Reference == "" || confirmation.Amount <= 0 {
+		return nil, fmt.Errorf("confirmation requires an id, a reference and a positive amount")
+	}
+	if confirmation.Method != MethodPix && confirmation.Method != MethodBoleto {
+		return nil, fmt.Errorf("unknown payment method: %s", confirmation.Method)
+	}
+	if confirmation.PaidAt.IsZero() {
+		confirmation.PaidAt = time.Now()
+	}
+	return &confirmation, nil
+}
@@ -0,0 +1,110 @@
+// Package payments talks to the payment processors charging tenant subscriptions.
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Gateway names
+const (
+	GatewayStripe = "stripe"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrGatewayRequest   = errors.New("payment gateway request failed")
+)
+
+// Gateway is a card payment processor billing recurring subscriptions. StripeGateway
+// implements it against the Stripe API or any server speaking it.
+type Gateway interface {
+	Name() string
+
+	CreateCustomer(ctx context.Context, params CustomerParams) (string, error)
+	AttachPaymentMethod(ctx context.Context, customerID, paymentMethodID string) error
+	CreateSubscription(ctx context.Context, params SubscriptionParams) (*Subscription, error)
+	CancelSubscription(ctx context.Context, subscriptionID string) error
+	// ChangeSubscriptionPrice moves a subscription to the price of another plan without
+	// prorating it; the API bills the proration itself, as invoice items
+	ChangeSubscriptionPrice(ctx context.Context, subscriptionID, priceID, idempotencyKey string) error
+	// AddInvoiceItem adds a charge, or a credit when negative, to the next invoice
+	// of a subscription
+	AddInvoiceItem(ctx context.Context, params InvoiceItemParams) error
+	// RetryPayment charges a gateway invoice whose payment failed again
+	RetryPayment(ctx context.Context, invoiceID, idempotencyKey string) error
+
+	// ParseWebhook verifies the signature of a webhook request and decodes its event
+	ParseWebhook(payload []byte, header http.Header) (*Event, error)
+}
+
+// CustomerParams describes the tenant paying a subscription
+type CustomerParams struct {
+	TenantID string
+	Name     string
+	Email    string
+	// IdempotencyKey makes a retried request return the customer created first
+	IdempotencyKey string
+}
+
+type SubscriptionParams struct {
+	CustomerID      string
+	PriceID         string // gateway price of the plan
+	PaymentMethodID string
+	TenantID        string
+	// TrialEnd defers the first charge until the trial ends
+	TrialEnd       *time.Time
+	IdempotencyKey string
+}
+
+// InvoiceItemParams describes a one-off charge billed with a subscription
+type InvoiceItemParams struct {
+	CustomerID     string
+	SubscriptionID string
+	Amount         int64  // in cents, negative for credits
+	Currency       string // ISO 4217 code
+	Description    string
+	IdempotencyKey string
+}
+
+// Subscription is a subscription as the gateway sees it
+type Subscription struct {
+	ID         string
+	CustomerID string
+	Status     string // trialing, active, past_due, unpaid, canceled, incomplete or incomplete_expired
+}
+
+// EventType is a gateway event the API acts on; other events are acknowledged
+// and ignored
+type EventType string
+
+const (
+	EventSubscriptionUpdated  EventType = "subscription.updated"
+	EventSubscriptionDeleted  EventType = "subscription.deleted"
+	EventInvoicePaid          EventType = "invoice.paid"
+	EventInvoicePaymentFailed EventType = "invoice.payment_failed"
+)
+
+// Event is a verified webhook event
+type Event struct {
+	ID      string
+	Type    EventType // empty for events the API ignores
+	RawType string    // event type as named by the gateway
+	// Set for subscription events
+	Subscription *Subscription
+	// Set for invoice events
+	Payment *Payment
+}
+
+// Payment is the outcome of a charge of a gateway invoice
+type Payment struct {
+	InvoiceID      string
+	SubscriptionID string
+	CustomerID     string
+	Amount         int64  // in cents; paid for invoice.paid, due otherwise
+	Currency       string // upper case ISO 4217 code
+	AttemptCount   int
+	NextAttempt    *time.Time // next automatic retry of a failed charge, if any
+}
@@ -0,0 +1,98 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrInvalidPix = errors.New("invalid pix charge")
+
+// PixCharge is a Pix payment request for a fixed amount, encoded as an EMV BR Code
+// ("copia e cola") following the Banco Central manual
+type PixCharge struct {
+	Key          string // Pix key receiving the payment
+	MerchantName string
+	MerchantCity string
+	Amount       int64  // in cents
+	TxID         string // identifies the payment in the confirmation, up to 25 letters and digits
+	Description  string // shown to the payer, optional
+}
+
+// Payload returns the BR Code of the charge, ending with its CRC16
+func (p *PixCharge) Payload() (string, error) {
+	if p.Key == "" || p.MerchantName == "" || p.MerchantCity == "" {
+		return "", fmt.Errorf("%w: key, merchant name and city are required", ErrInvalidPix)
+	}
+	if p.Amount <= 0 {
+		return "", fmt.Errorf("%w: amount must be positive", ErrInvalidPix)
+	}
+	txID := p.TxID
+	if txID == "" {
+		txID = "***"
+	} else if len(txID) > 25 || strings.IndexFunc(txID, func(r rune) bool { return r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r) }) >= 0 {
+		return "", fmt.Errorf("%w: txid must be up to 25 letters and digits", ErrInvalidPix)
+	}
+
+	account := emvField("00", "br.gov.bcb.pix") + emvField("01", p.Key)
+	if description := pixText(p.Description, 99-len(account)-4); description != "" {
+		account += emvField("02", description)
+	}
+
+	var b strings.Builder
+	b.WriteString(emvField("00", "01"))    // payload format
+	b.WriteString(emvField("01", "12"))    // single use
+	b.WriteString(emvField("26", account)) // merchant account
+	b.WriteString(emvField("52", "0000"))  // merchant category
+	b.WriteString(emvField("53", "986"))   // BRL
+	b.WriteString(emvField("54", fmt.Sprintf("%d.%02d", p.Amount/100, p.Amount%100)))
+	b.WriteString(emvField("58", "BR"))
+	b.WriteString(emvField("59", pixText(p.MerchantName, 25)))
+	b.WriteString(emvField("60", pixText(p.MerchantCity, 15)))
+	b.WriteString(emvField("62", emvField("05", txID)))
+	b.WriteString("6304")
+	return b.String() + fmt.Sprintf("%04X", crc16CCITT([]byte(b.String()))), nil
+}
+
+func emvField(id, value string) string {
+	return fmt.Sprintf("%s%02d%s", id, len(value), value)
+}
+
+// pixText strips accents and characters readers reject, and truncates to max bytes
+func pixText(s string, max int) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if r < 0x20 || r > 0x7E {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	text := strings.TrimSpace(b.String())
+	if max < 0 {
+		max = 0
+	}
+	if len(text) > max {
+		text = strings.TrimSpace(text[:max])
+	}
+	return text
+}
+
+// crc16CCITT is the CRC-16/CCITT-FALSE checksum of the BR Code: polynomial
+// 0x1021, initial value 0xFFFF
+func crc16CCITT(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
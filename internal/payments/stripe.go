@@ -0,0 +1,268 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opiagile/direito-lux/internal/config"
+)
+
+const stripeSignatureHeader = "Stripe-Signature"
+
+var stripeEventTypes = map[string]EventType{
+	"customer.subscription.created": EventSubscriptionUpdated,
+	"customer.subscription.updated": EventSubscriptionUpdated,
+	"customer.subscription.deleted": EventSubscriptionDeleted,
+	"invoice.paid":                  EventInvoicePaid,
+	"invoice.payment_failed":        EventInvoicePaymentFailed,
+}
+
+// StripeGateway calls the Stripe API at the configured URL, so that tests and
+// local development can point it at a fake server
+type StripeGateway struct {
+	cfg        config.StripeConfig
+	httpClient *http.Client
+}
+
+var _ Gateway = (*StripeGateway)(nil)
+
+func NewStripeGateway(cfg *config.StripeConfig) *StripeGateway {
+	gateway := &StripeGateway{
+		cfg:        *cfg,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+	if gateway.cfg.APIURL == "" {
+		gateway.cfg.APIURL = "https://api.stripe.com"
+	}
+	if gateway.cfg.WebhookTolerance <= 0 {
+		gateway.cfg.WebhookTolerance = 5 * time.Minute
+	}
+	return gateway
+}
+
+func (g *StripeGateway) Name() string {
+	return GatewayStripe
+}
+
+func (g *StripeGateway) CreateCustomer(ctx context.Context, params CustomerParams) (string, error) {
+	form := url.Values{}
+	form.Set("name", params.Name)
+	form.Set("email", params.Email)
+	form.Set("metadata[tenant_id]", params.TenantID)
+
+	var customer struct {
+		ID string `json:"id"`
+	}
+	if err := g.post(ctx, "/v1/customers", form, params.IdempotencyKey, &customer); err != nil {
+		return "", err
+	}
+	return customer.ID, nil
+}
+
+func (g *StripeGateway) AttachPaymentMethod(ctx context.Context, customerID, paymentMethodID string) error {
+	form := url.Values{}
+	form.Set("customer", customerID)
+	return g.post(ctx, "/v1/payment_methods/"+url.PathEscape(paymentMethodID)+"/attach", form, "", nil)
+}
+
+func (g *StripeGateway) CreateSubscription(ctx context.Context, params SubscriptionParams) (*Subscription, error) {
+	form := url.Values{}
+	form.Set("customer", params.CustomerID)
+	form.Set("items[0][price]", params.PriceID)
+	form.Set("default_payment_method", params.PaymentMethodID)
+	form.Set("metadata[tenant_id]", params.TenantID)
+	if params.TrialEnd != nil && params.TrialEnd.After(time.Now()) {
+		form.Set("trial_end", strconv.FormatInt(params.TrialEnd.Unix(), 10))
+	}
+
+	var subscription stripeSubscription
+	if err := g.post(ctx, "/v1/subscriptions", form, params.IdempotencyKey, &subscription); err != nil {
+		return nil, err
+	}
+	return subscription.toSubscription(), nil
+}
+
+func (g *StripeGateway) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	return g.do(ctx, http.MethodDelete, "/v1/subscriptions/"+url.PathEscape(subscriptionID), nil, "", nil)
+}
+
+// ChangeSubscriptionPrice replaces the price of the subscription's only item
+func (g *StripeGateway) ChangeSubscriptionPrice(ctx context.Context, subscriptionID, priceID, idempotencyKey string) error {
+	var subscription struct {
+		Items struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		} `json:"items"`
+	}
+	path := "/v1/subscriptions/" + url.PathEscape(subscriptionID)
+	if err := g.do(ctx, http.MethodGet, path, nil, "", &subscription); err != nil {
+		return err
+	}
+	if len(subscription.Items.Data) == 0 {
+		return fmt.Errorf("%w: subscription %s has no items", ErrGatewayRequest, subscriptionID)
+	}
+
+	form := url.Values{}
+	form.Set("items[0][id]", subscription.Items.Data[0].ID)
+	form.Set("items[0][price]", priceID)
+	form.Set("proration_behavior", "none")
+	return g.post(ctx, path, form, idempotencyKey, nil)
+}
+
+func (g *StripeGateway) AddInvoiceItem(ctx context.Context, params InvoiceItemParams) error {
+	form := url.Values{}
+	form.Set("customer", params.CustomerID)
+	form.Set("subscription", params.SubscriptionID)
+	form.Set("amount", strconv.FormatInt(params.Amount, 10))
+	form.Set("currency", strings.ToLower(params.Currency))
+	form.Set("description", params.Description)
+	return g.post(ctx, "/v1/invoiceitems", form, params.IdempotencyKey, nil)
+}
+
+func (g *StripeGateway) RetryPayment(ctx context.Context, invoiceID, idempotencyKey string) error {
+	return g.post(ctx, "/v1/invoices/"+url.PathEscape(invoiceID)+"/pay", url.Values{}, idempotencyKey, nil)
+}
+
+// ParseWebhook checks the Stripe-Signature header, an HMAC-SHA256 of the
+// timestamp and payload, and refuses events signed outside the tolerance
+func (g *StripeGateway) ParseWebhook(payload []byte, header http.Header) (*Event, error) {
+	if err := verifySignature(payload, header.Get(stripeSignatureHeader), g.cfg.WebhookSecret, g.cfg.WebhookTolerance, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var raw struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+
+	event := &Event{ID: raw.ID, Type: stripeEventTypes[raw.Type], RawType: raw.Type}
+	switch event.Type {
+	case EventSubscriptionUpdated, EventSubscriptionDeleted:
+		var subscription stripeSubscription
+		if err := json.Unmarshal(raw.Data.Object, &subscription); err != nil {
+			return nil, fmt.Errorf("invalid subscription in webhook: %w", err)
+		}
+		event.Subscription = subscription.toSubscription()
+	case EventInvoicePaid, EventInvoicePaymentFailed:
+		var invoice stripeInvoice
+		if err := json.Unmarshal(raw.Data.Object, &invoice); err != nil {
+			return nil, fmt.Errorf("invalid invoice in webhook: %w", err)
+		}
+		event.Payment = invoice.toPayment(event.Type == EventInvoicePaid)
+	}
+	return event, nil
+}
+
+type stripeSubscription struct {
+	ID       string `json:"id"`
+	Customer string `json:"customer"`
+	Status   string `json:"status"`
+}
+
+func (s *stripeSubscription) toSubscription() *Subscription {
+	return &Subscription{ID: s.ID, CustomerID: s.Customer, Status: s.Status}
+}
+
+type stripeInvoice struct {
+	ID                 string `json:"id"`
+	Customer           string `json:"customer"`
+	Subscription       string `json:"subscription"`
+	AmountPaid         int64  `json:"amount_paid"`
+	AmountDue          int64  `json:"amount_due"`
+	Currency           string `json:"currency"`
+	AttemptCount       int    `json:"attempt_count"`
+	NextPaymentAttempt *int64 `json:"next_payment_attempt"`
+	// Newer API versions moved the subscription here
+	Parent struct {
+		SubscriptionDetails struct {
+			Subscription string `json:"subscription"`
+		} `json:"subscription_details"`
+	} `json:"parent"`
+}
+
+func (i *stripeInvoice) toPayment(paid bool) *Payment {
+	payment := &Payment{
+		InvoiceID:      i.ID,
+		SubscriptionID: i.Subscription,
+		CustomerID:     i.Customer,
+		Amount:         i.AmountDue,
+		Currency:       strings.ToUpper(i.Currency),
+		AttemptCount:   i.AttemptCount,
+	}
+	if payment.SubscriptionID == "" {
+		payment.SubscriptionID = i.Parent.SubscriptionDetails.Subscription
+	}
+	if paid {
+		payment.Amount = i.AmountPaid
+	}
+	if i.NextPaymentAttempt != nil {
+		next := time.Unix(*i.NextPaymentAttempt, 0)
+		payment.NextAttempt = &next
+	}
+	return payment
+}
+
+func (g *StripeGateway) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	return g.do(ctx, http.MethodPost, path, form, idempotencyKey, out)
+}
+
+func (g *StripeGateway) do(ctx context.Context, method, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(g.cfg.APIURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.cfg.SecretKey)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrGatewayRequest, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrGatewayRequest, err)
+	}
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+				Code    string `json:"code"`
+			} `json:"error"`
+		}
+		json.Unmarshal(data, &failure)
+		return fmt.Errorf("%w: %s %s returned %d: %s", ErrGatewayRequest, method, path, resp.StatusCode, failure.Error.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%w: invalid response: %v", ErrGatewayRequest, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/opiagile/direito-lux/internal/config"
+)
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"id":"evt_1","type":"invoice.paid"}`)
+	now := time.Unix(1700000000, 0)
+	signed := SignPayload(payload, "whsec_test", now)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		header  string
+		secret  string
+		at      time.Time
+		wantErr bool
+	}{
+		{"valid", payload, signed, "whsec_test", now, false},
+		{"valid among rotated secrets", payload, signed + ",v1=00ff", "whsec_test", now, false},
+		{"wrong secret", payload, signed, "whsec_other", now, true},
+		{"tampered payload", []byte(`{"id":"evt_2","type":"invoice.paid"}`), signed, "whsec_test", now, true},
+		{"replayed", payload, signed, "whsec_test", now.Add(6 * time.Minute), true},
+		{"missing header", payload, "", "whsec_test", now, true},
+		{"no secret configured", payload, signed, "", now, true},
+	}
+
+	for _, tt := range tests {
+		err := verifySignature(tt.payload, tt.header, tt.secret, 5*time.Minute, tt.at)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: verifySignature() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: error %v is not ErrInvalidSignature", tt.name, err)
+		}
+	}
+}
+
+func TestParseWebhookInvoice(t *testing.T) {
+	gateway := NewStripeGateway(&config.StripeConfig{WebhookSecret: "whsec_test"})
+	payload := []byte(`{"id":"evt_1","type":"invoice.payment_failed","data":{"object":{
+		"id":"in_1","customer":"cus_1","amount_due":4990,"currency":"brl","attempt_count":2,
+		"next_payment_attempt":1700003600,
+		"parent":{"subscription_details":{"subscription":"sub_1"}}}}}`)
+
+	header := http.Header{}
+	header.Set(stripeSignatureHeader, SignPayload(payload, "whsec_test", time.Now()))
+	event, err := gateway.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("ParseWebhook() error = %v", err)
+	}
+
+	if event.Type != EventInvoicePaymentFailed || event.Payment == nil {
+		t.Fatalf("ParseWebhook() = %+v, want a failed payment", event)
+	}
+	payment := event.Payment
+	if payment.SubscriptionID != "sub_1" || payment.Amount != 4990 || payment.Currency != "BRL" || payment.AttemptCount != 2 {
+		t.Errorf("payment = %+v", payment)
+	}
+	if payment.NextAttempt == nil || payment.NextAttempt.Unix() != 1700003600 {
+		t.Errorf("NextAttempt = %v, want 1700003600", payment.NextAttempt)
+	}
+}
+
+func TestChangeSubscriptionPrice(t *testing.T) {
+	var update url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"id":"sub_1","items":{"data":[{"id":"si_1"}]}}`))
+		case http.MethodPost:
+			r.ParseForm()
+			update = r.PostForm
+			w.Write([]byte(`{"id":"sub_1"}`))
+		}
+	}))
+	defer server.Close()
+
+	gateway := NewStripeGateway(&config.StripeConfig{APIURL: server.URL})
+	if err := gateway.ChangeSubscriptionPrice(context.Background(), "sub_1", "price_pro", "key"); err != nil {
+		t.Fatalf("ChangeSubscriptionPrice() error = %v", err)
+	}
+	if update.Get("items[0][id]") != "si_1" || update.Get("items[0][price]") != "price_pro" || update.Get("proration_behavior") != "none" {
+		t.Errorf("update = %v", update)
+	}
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+	ErrAPIKeyExpired  = errors.New("api key expired")
+	ErrInvalidScope   = errors.New("invalid api key scope")
+)
+
+const (
+	apiKeyPrefix       = "dlx_"
+	apiKeyPrefixLength = 12
+	apiKeyTouchPeriod  = 1 * time.Minute
+)
+
+type APIKeyService struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyService(db *gorm.DB) *APIKeyService {
+	return &APIKeyService{
+		db: db,
+	}
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,min=3,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateAPIKey creates a key for the tenant and returns it with the plaintext secret,
+// which is never stored and cannot be retrieved again
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, tenantID uuid.UUID, actorID string, req *CreateAPIKeyRequest) (*domain.APIKey, string, error) {
+	for _, scope := range req.Scopes {
+		if !isValidScope(scope) {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		return nil, "", ErrAPIKeyExpired
+	}
+
+	rawKey, err := generateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiKey := &domain.APIKey{
+		TenantID:  tenantID,
+		Name:      strings.TrimSpace(req.Name),
+		Key:       hashAPIKey(rawKey),
+		Prefix:    rawKey[:apiKeyPrefixLength],
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+		IsActive:  true,
+	}
+
+	if err := s.db.WithContext(ctx).Create(apiKey).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	s.audit(ctx, tenantID, actorID, "api_key.created", apiKey)
+
+	return apiKey, rawKey, nil
+}
+
+// ListAPIKeys lists the keys of a tenant
+func (s *APIKeyService) ListAPIKeys(ctx context.Context, tenantID uuid.UUID) ([]*domain.APIKey, error) {
+	var keys []*domain.APIKey
+	err := s.db.WithContext(ctx).
+		Where("tenant_id = ?", tenantID).
+		Order("created_at DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+// RotateAPIKey replaces the secret of an active key, keeping name and scopes
+func (s *APIKeyService) RotateAPIKey(ctx context.Context, tenantID, keyID uuid.UUID, actorID string) (*domain.APIKey, string, error) {
+	apiKey, err := s.getTenantKey(ctx, tenantID, keyID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !apiKey.IsActive {
+		return nil, "", ErrInvalidAPIKey
+	}
+
+	rawKey, err := generateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	updates := map[string]interface{}{
+		"key":    hashAPIKey(rawKey),
+		"prefix": rawKey[:apiKeyPrefixLength],
+	}
+	if err := s.db.WithContext(ctx).Model(apiKey).Updates(updates).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to rotate api key: %w", err)
+	}
+
+	s.audit(ctx, tenantID, actorID, "api_key.rotated", apiKey)
+
+	return apiKey, rawKey, nil
+}
+
+// RevokeAPIKey deactivates a key
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, tenantID, keyID uuid.UUID, actorID string) error {
+	apiKey, err := s.getTenantKey(ctx, tenantID, keyID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.WithContext(ctx).Model(apiKey).Update("is_active", false).Error; err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	s.audit(ctx, tenantID, actorID, "api_key.revoked", apiKey)
+
+	return nil
+}
+
+// Authenticate resolves a plaintext key to its record and tenant
+func (s *APIKeyService) Authenticate(ctx context.Context, rawKey string) (*domain.APIKey, *domain.Tenant, error) {
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	var apiKey domain.APIKey
+	// The tenant is not known until the key is found
+	err := s.db.WithContext(domain.CrossTenant(ctx)).Where("key = ? AND is_active = ?", hashAPIKey(rawKey), true).First(&apiKey).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+
+	if apiKey.ExpiresAt != nil && apiKey.ExpiresAt.Before(time.Now()) {
+		return nil, nil, ErrAPIKeyExpired
+	}
+
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).First(&tenant, apiKey.TenantID).Error; err != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	if apiKey.LastUsedAt == nil || time.Since(*apiKey.LastUsedAt) > apiKeyTouchPeriod {
+		go s.touchLastUsed(apiKey.TenantID, apiKey.ID)
+	}
+
+	return &apiKey, &tenant, nil
+}
+
+// touchLastUsed records key usage outside the request path
+func (s *APIKeyService) touchLastUsed(tenantID, keyID uuid.UUID) {
+	err := s.db.WithContext(domain.WithTenantID(context.Background(), tenantID)).Model(&domain.APIKey{}).
+		Where("id = ?", keyID).
+		UpdateColumn("last_used_at", time.Now()).Error
+	if err != nil {
+		logger.Warn("Failed to update api key last usage",
+			zap.String("apiKeyID", keyID.String()),
+			zap.Error(err))
+	}
+}
+
+func (s *APIKeyService) getTenantKey(ctx context.Context, tenantID, keyID uuid.UUID) (*domain.APIKey, error) {
+	var apiKey domain.APIKey
+	err := s.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", keyID, tenantID).First(&apiKey).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+func (s *APIKeyService) audit(ctx context.Context, tenantID uuid.UUID, actorID, action string, apiKey *domain.APIKey) {
+	audit := &domain.AuditLog{
+		TenantID:   tenantID,
+		Action:     action,
+		Resource:   "api_key",
+		ResourceID: apiKey.ID.String(),
+		Details: map[string]interface{}{
+			"name":   apiKey.Name,
+			"prefix": apiKey.Prefix,
+			"scopes": apiKey.Scopes,
+			"actor":  actorID,
+		},
+	}
+	if err := s.db.WithContext(ctx).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+}
+
+// generateAPIKey creates a random key such as dlx_Xk3v9...
+func generateAPIKey() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashAPIKey returns the stored representation of a key
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// isValidScope accepts "*" or "<resource>:<read|write|*>"
+func isValidScope(scope string) bool {
+	if scope == domain.APIKeyScopeAll {
+		return true
+	}
+
+	parts := strings.Split(scope, ":")
+	if len(parts) != 2 || parts[0] == "" {
+		return false
+	}
+
+	switch parts[1] {
+	case "read", "write", "*":
+		return true
+	}
+	return false
+}
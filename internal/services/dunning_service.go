@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/config"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/payments"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Reasons recorded when dunning moves a subscription
+const (
+	SubscriptionReasonInvoiceOverdue = "invoice_overdue"
+	SubscriptionReasonDunningExpired = "dunning_retries_exhausted"
+)
+
+// Dunning steps, recorded in the notices and audit log
+const (
+	dunningStepRetry    = "retry"
+	dunningStepReadOnly = "read_only"
+	dunningStepSuspend  = "suspend"
+)
+
+// DunningService chases past due subscriptions: it retries the failed charge and
+// notifies the tenant admins on the configured days, makes the tenant read-only
+// and finally suspends it. Paying the subscription, through a webhook or a Pix or
+// boleto confirmation, makes it active again, which lifts both restrictions.
+type DunningService struct {
+	db            *gorm.DB
+	gateway       payments.Gateway
+	subscriptions *SubscriptionService
+	lifecycle     *TenantLifecycleService
+	notifier      Notifier
+	cfg           config.DunningConfig
+}
+
+// NewDunningService creates the service; without a gateway card charges are not
+// retried and the tenants are only notified
+func NewDunningService(db *gorm.DB, gateway payments.Gateway, subscriptions *SubscriptionService, lifecycle *TenantLifecycleService, notifier Notifier, cfg *config.DunningConfig) *DunningService {
+	return &DunningService{
+		db:            db,
+		gateway:       gateway,
+		subscriptions: subscriptions,
+		lifecycle:     lifecycle,
+		notifier:      notifier,
+		cfg:           *cfg,
+	}
+}
+
+// Start runs the dunning steps that are due every interval until ctx is cancelled
+func (s *DunningService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger.Info("Dunning scheduler started", zap.Duration("interval", interval))
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Dunning scheduler stopped")
+			return
+		case <-ticker.C:
+			if err := s.RunDunning(ctx); err != nil {
+				logger.Error("Dunning scheduler failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// RunDunning moves the subscriptions with overdue invoices to past due, then runs
+// the step each past due or unpaid subscription has reached
+func (s *DunningService) RunDunning(ctx context.Context) error {
+	now := time.Now()
+	if err := s.markOverdue(ctx, now); err != nil {
+		return err
+	}
+
+	var subscriptions []domain.Subscription
+	err := s.db.WithContext(domain.CrossTenant(ctx)).
+		Where("status IN ?", []domain.SubscriptionStatus{domain.SubscriptionStatusPastDue, domain.SubscriptionStatusUnpaid}).
+		Find(&subscriptions).Error
+	if err != nil {
+		return fmt.Errorf("failed to list past due subscriptions: %w", err)
+	}
+
+	for i := range subscriptions {
+		if err := s.dun(ctx, &subscriptions[i], now); err != nil {
+			logger.Error("Failed to run dunning step",
+				zap.String("tenantID", subscriptions[i].TenantID.String()),
+				zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// markOverdue makes past due the active subscriptions with an open invoice past
+// its due date, typically a Pix or boleto that was not paid
+func (s *DunningService) markOverdue(ctx context.Context, now time.Time) error {
+	var tenantIDs []uuid.UUID
+	err := s.db.WithContext(domain.CrossTenant(ctx)).Model(&domain.Invoice{}).
+		// Card invoices go past due on the gateway's failed payment webhook
+		Where("status = ? AND due_at < ? AND charged_by = ''", domain.InvoiceStatusOpen, now).
+		Distinct().
+		Pluck("tenant_id", &tenantIDs).Error
+	if err != nil {
+		return fmt.Errorf("failed to list overdue invoices: %w", err)
+	}
+
+	for _, tenantID := range tenantIDs {
+		subscription, err := s.subscriptions.GetSubscription(ctx, tenantID)
+		if err != nil || subscription.Status != domain.SubscriptionStatusActive {
+			continue
+		}
+		if _, err := s.subscriptions.Transition(ctx, tenantID, domain.SubscriptionStatusPastDue, SubscriptionReasonInvoiceOverdue, "system"); err != nil {
+			logger.Error("Failed to mark subscription past due",
+				zap.String("tenantID", tenantID.String()),
+				zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (s *DunningService) dun(ctx context.Context, subscription *domain.Subscription, now time.Time) error {
+	ctx = domain.WithTenantID(ctx, subscription.TenantID)
+	since := subscription.UpdatedAt
+	if subscription.PastDueSince != nil {
+		since = *subscription.PastDueSince
+	}
+	days := int(now.Sub(since).Hours() / 24)
+
+	switch subscription.Status {
+	case domain.SubscriptionStatusPastDue:
+		if s.cfg.ReadOnlyAfterDays > 0 && days >= s.cfg.ReadOnlyAfterDays {
+			return s.makeReadOnly(ctx, subscription, since)
+		}
+		return s.retry(ctx, subscription, since, days)
+
+	case domain.SubscriptionStatusUnpaid:
+		if s.cfg.SuspendAfterDays > 0 && days >= s.cfg.SuspendAfterDays {
+			return s.suspend(ctx, subscription, days)
+		}
+	}
+	return nil
+}
+
+// retry runs the latest retry day reached, retrying the failed card charge, and
+// notifies the tenant admins. Retry days skipped while the scheduler was down are
+// not run late.
+func (s *DunningService) retry(ctx context.Context, subscription *domain.Subscription, since time.Time, days int) error {
+	due, done := dueDunningRetries(s.cfg.RetryDays, subscription.DunningRetriesDone, days)
+	if len(due) == 0 {
+		return nil
+	}
+	day := due[len(due)-1]
+
+	result := "not_retried"
+	var chargeErr error
+	if s.gateway != nil && subscription.PaymentMethod == PaymentMethodCard && subscription.StripeInvoiceID != "" {
+		key := fmt.Sprintf("dunning-%s-%d", subscription.StripeInvoiceID, day)
+		if chargeErr = s.gateway.RetryPayment(ctx, subscription.StripeInvoiceID, key); chargeErr != nil {
+			result = "failed"
+		} else {
+			// The gateway confirms the payment with a webhook, which restores the subscription
+			result = "charged"
+		}
+	}
+
+	subscription.DunningRetriesDone = done
+	if err := s.db.WithContext(ctx).Model(subscription).Select("dunning_retries_done").Updates(subscription).Error; err != nil {
+		return err
+	}
+
+	details := map[string]interface{}{
+		"day":    day,
+		"result": result,
+		"actor":  "system",
+	}
+	if chargeErr != nil {
+		details["error"] = chargeErr.Error()
+	}
+	s.subscriptions.audit(ctx, subscription, "subscription.dunning_retry", details)
+
+	logger.Info("Dunning retry",
+		zap.String("tenantID", subscription.TenantID.String()),
+		zap.Int("day", day),
+		zap.String("result", result))
+
+	if result == "charged" {
+		return nil
+	}
+
+	readOnlyOn := since.AddDate(0, 0, s.cfg.ReadOnlyAfterDays).Format("02/01/2006")
+	subject := "Payment of your subscription is overdue"
+	body := fmt.Sprintf("We could not collect the payment of your subscription. Update your payment method or pay the open invoices; otherwise your account becomes read-only on %s.", readOnlyOn)
+	if day == maxReminderDay(s.cfg.RetryDays) {
+		subject = "Final notice: your account becomes read-only on " + readOnlyOn
+		body = fmt.Sprintf("This is the last attempt to collect the payment of your subscription. On %s your account becomes read-only, and it is suspended if the payment is still missing after that.", readOnlyOn)
+	}
+	s.notify(ctx, subscription, dunningStepRetry, subject, body)
+	return nil
+}
+
+// makeReadOnly ends the retries: the subscription becomes unpaid, which makes the
+// tenant read-only
+func (s *DunningService) makeReadOnly(ctx context.Context, subscription *domain.Subscription, since time.Time) error {
+	if _, err := s.subscriptions.Transition(ctx, subscription.TenantID, domain.SubscriptionStatusUnpaid, SubscriptionReasonDunningExpired, "system"); err != nil {
+		return err
+	}
+
+	suspendOn := since.AddDate(0, 0, s.cfg.SuspendAfterDays).Format("02/01/2006")
+	s.notify(ctx, subscription, dunningStepReadOnly, "Your account is now read-only",
+		fmt.Sprintf("The payment of your subscription is still missing, so your team can view but no longer change its data. Your account will be suspended on %s unless the subscription is paid.", suspendOn))
+	return nil
+}
+
+// suspend ends the grace period of an unpaid subscription by suspending the tenant
+func (s *DunningService) suspend(ctx context.Context, subscription *domain.Subscription, days int) error {
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).Select("id", "status").First(&tenant, subscription.TenantID).Error; err != nil {
+		return err
+	}
+	if tenant.Status != domain.TenantStatusActive && tenant.Status != domain.TenantStatusTrial {
+		return nil
+	}
+
+	if _, err := s.lifecycle.Suspend(ctx, subscription.TenantID, subscriptionUnpaidReason, "system"); err != nil {
+		return err
+	}
+	s.subscriptions.audit(ctx, subscription, "subscription.dunning_suspended", map[string]interface{}{
+		"days_past_due": days,
+		"actor":         "system",
+	})
+
+	s.notify(ctx, subscription, dunningStepSuspend, "Your account is suspended",
+		"The grace period to pay your subscription ended and your account was suspended. Access is restored as soon as the payment is confirmed.")
+	return nil
+}
+
+func (s *DunningService) notify(ctx context.Context, subscription *domain.Subscription, step, subject, body string) {
+	admins, err := s.subscriptions.tenantAdmins(ctx, subscription.TenantID)
+	if err != nil {
+		logger.Error("Failed to load tenant admins for dunning notice",
+			zap.String("tenantID", subscription.TenantID.String()),
+			zap.Error(err))
+		return
+	}
+	if s.notifier == nil || len(admins) == 0 {
+		return
+	}
+
+	err = s.notifier.Notify(ctx, Notification{
+		TenantID:   subscription.TenantID,
+		Type:       "subscription.dunning_" + step,
+		Recipients: admins,
+		Subject:    subject,
+		Body:       body,
+	})
+	if err != nil {
+		logger.Error("Failed to send dunning notice",
+			zap.String("tenantID", subscription.TenantID.String()),
+			zap.String("step", step),
+			zap.Error(err))
+		return
+	}
+
+	s.subscriptions.audit(ctx, subscription, "subscription.dunning_notice_sent", map[string]interface{}{
+		"step":       step,
+		"subject":    subject,
+		"recipients": len(admins),
+	})
+}
+
+// dueDunningRetries returns the configured retry days reached by daysPastDue that
+// were not run yet, and the updated list of days run
+func dueDunningRetries(configured, done []int, daysPastDue int) (due, updated []int) {
+	alreadyDone := make(map[int]bool, len(done))
+	for _, day := range done {
+		alreadyDone[day] = true
+	}
+
+	updated = append(updated, done...)
+	sorted := append([]int(nil), configured...)
+	sort.Ints(sorted)
+	for _, day := range sorted {
+		if day <= daysPastDue && !alreadyDone[day] {
+			due = append(due, day)
+			updated = append(updated, day)
+			alreadyDone[day] = true
+		}
+	}
+	sort.Ints(updated)
+	return due, updated
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDueDunningRetries(t *testing.T) {
+	configured := []int{1, 3, 7}
+
+	if due, _ := dueDunningRetries(configured, nil, 0); len(due) != 0 {
+		t.Errorf("day 0: due = %v, want none", due)
+	}
+
+	due, done := dueDunningRetries(configured, nil, 2)
+	if !reflect.DeepEqual(due, []int{1}) || !reflect.DeepEqual(done, []int{1}) {
+		t.Errorf("day 2: due = %v, done = %v", due, done)
+	}
+
+	// The scheduler was down through the day 3 retry
+	due, done = dueDunningRetries(configured, done, 8)
+	if !reflect.DeepEqual(due, []int{3, 7}) || !reflect.DeepEqual(done, []int{1, 3, 7}) {
+		t.Errorf("day 8: due = %v, done = %v", due, done)
+	}
+
+	if due, _ = dueDunningRetries(configured, done, 9); len(due) != 0 {
+		t.Errorf("retries run twice: %v", due)
+	}
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/config"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrImpersonationNotFound    = errors.New("impersonation session not found")
+	ErrInvalidImpersonationTTL  = errors.New("invalid impersonation duration")
+	ErrImpersonationNotAllowed  = errors.New("user cannot be impersonated")
+	ErrImpersonationReasonEmpty = errors.New("impersonation reason is required")
+)
+
+// ImpersonationSession is an active impersonation, stored in Redis until it expires or is revoked
+type ImpersonationSession struct {
+	ID                string    `json:"id"`
+	ImpersonatorID    string    `json:"impersonator_id"`
+	ImpersonatorEmail string    `json:"impersonator_email"`
+	TargetUserID      uuid.UUID `json:"target_user_id"`
+	TargetKeycloakID  string    `json:"target_keycloak_id"`
+	TargetEmail       string    `json:"target_email"`
+	TenantID          uuid.UUID `json:"tenant_id"`
+	Reason            string    `json:"reason"`
+	StartedAt         time.Time `json:"started_at"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+type StartImpersonationRequest struct {
+	UserID          string `json:"user_id" binding:"required,uuid"`
+	Reason          string `json:"reason" binding:"required,max=500"`
+	DurationMinutes int    `json:"duration_minutes,omitempty" binding:"omitempty,min=1"`
+}
+
+type ImpersonationService struct {
+	db          *gorm.DB
+	redisClient *redis.Client
+	signer      *auth.ImpersonationSigner
+	defaultTTL  time.Duration
+	maxTTL      time.Duration
+}
+
+func NewImpersonationService(db *gorm.DB, redisClient *redis.Client, signer *auth.ImpersonationSigner, cfg *config.ImpersonationConfig) *ImpersonationService {
+	defaultTTL := cfg.DefaultTTL
+	if defaultTTL <= 0 {
+		defaultTTL = 30 * time.Minute
+	}
+	maxTTL := cfg.MaxTTL
+	if maxTTL < defaultTTL {
+		maxTTL = defaultTTL
+	}
+
+	return &ImpersonationService{
+		db:          db,
+		redisClient: redisClient,
+		signer:      signer,
+		defaultTTL:  defaultTTL,
+		maxTTL:      maxTTL,
+	}
+}
+
+// Start opens an impersonation session for the target user and returns its token
+func (s *ImpersonationService) Start(ctx context.Context, impersonator auth.Impersonator, req *StartImpersonationRequest) (*ImpersonationSession, string, error) {
+	if req.Reason == "" {
+		return nil, "", ErrImpersonationReasonEmpty
+	}
+
+	ttl := s.defaultTTL
+	if req.DurationMinutes > 0 {
+		ttl = time.Duration(req.DurationMinutes) * time.Minute
+	}
+	if ttl > s.maxTTL {
+		return nil, "", fmt.Errorf("%w: maximum is %s", ErrInvalidImpersonationTTL, s.maxTTL)
+	}
+
+	userID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return nil, "", ErrUserNotFound
+	}
+
+	var user domain.User
+	err = s.db.WithContext(domain.CrossTenant(ctx)).Preload("Tenant").Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrUserNotFound
+		}
+		return nil, "", err
+	}
+
+	// Only active users of tenants that are not suspended, and never the impersonator themselves
+	if user.Status != domain.UserStatusActive || user.KeycloakID == "" ||
+		user.KeycloakID == impersonator.ID || user.Tenant.Status == domain.TenantStatusSuspended {
+		return nil, "", ErrImpersonationNotAllowed
+	}
+
+	now := time.Now()
+	session := &ImpersonationSession{
+		ID:                uuid.New().String(),
+		ImpersonatorID:    impersonator.ID,
+		ImpersonatorEmail: impersonator.Email,
+		TargetUserID:      user.ID,
+		TargetKeycloakID:  user.KeycloakID,
+		TargetEmail:       user.Email,
+		TenantID:          user.TenantID,
+		Reason:            req.Reason,
+		StartedAt:         now,
+		ExpiresAt:         now.Add(ttl),
+	}
+
+	token, err := s.signer.Sign(session.ID, auth.ImpersonationTarget{
+		KeycloakID: user.KeycloakID,
+		Email:      user.Email,
+		TenantName: user.Tenant.Name,
+		Role:       string(user.Role),
+	}, impersonator, session.ExpiresAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to sign impersonation token: %w", err)
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.redisClient.Set(ctx, auth.ImpersonationSessionKey(session.ID), data, ttl).Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to store impersonation session: %w", err)
+	}
+
+	s.audit(ctx, "impersonation.started", session, impersonator.ID)
+
+	logger.Info("Impersonation started",
+		zap.String("sessionID", session.ID),
+		zap.String("impersonatorID", impersonator.ID),
+		zap.String("targetUserID", user.ID.String()),
+		zap.Duration("ttl", ttl))
+
+	return session, token, nil
+}
+
+// Revoke ends an impersonation session before it expires
+func (s *ImpersonationService) Revoke(ctx context.Context, sessionID, actorID string) error {
+	session, err := s.get(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.redisClient.Del(ctx, auth.ImpersonationSessionKey(sessionID)).Err(); err != nil {
+		return fmt.Errorf("failed to revoke impersonation session: %w", err)
+	}
+
+	s.audit(ctx, "impersonation.revoked", session, actorID)
+
+	return nil
+}
+
+// ListActive returns the sessions that have not expired or been revoked
+func (s *ImpersonationService) ListActive(ctx context.Context) ([]*ImpersonationSession, error) {
+	sessions := []*ImpersonationSession{}
+
+	iter := s.redisClient.Scan(ctx, 0, auth.ImpersonationSessionKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		data, err := s.redisClient.Get(ctx, iter.Val()).Bytes()
+		if err != nil {
+			// Expired between SCAN and GET
+			continue
+		}
+		var session ImpersonationSession
+		if err := json.Unmarshal(data, &session); err != nil {
+			continue
+		}
+		sessions = append(sessions, &session)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (s *ImpersonationService) get(ctx context.Context, sessionID string) (*ImpersonationSession, error) {
+	data, err := s.redisClient.Get(ctx, auth.ImpersonationSessionKey(sessionID)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrImpersonationNotFound
+		}
+		return nil, err
+	}
+
+	var session ImpersonationSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *ImpersonationService) audit(ctx context.Context, action string, session *ImpersonationSession, actorID string) {
+	audit := &domain.AuditLog{
+		TenantID:   session.TenantID,
+		UserID:     session.TargetUserID,
+		Action:     action,
+		Resource:   "impersonation",
+		ResourceID: session.ID,
+		Details: map[string]interface{}{
+			"actor_id":           actorID,
+			"impersonator_id":    session.ImpersonatorID,
+			"impersonator_email": session.ImpersonatorEmail,
+			"reason":             session.Reason,
+			"expires_at":         session.ExpiresAt,
+		},
+	}
+	if err := s.db.WithContext(domain.WithTenantID(ctx, session.TenantID)).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+}
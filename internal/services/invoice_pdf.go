@@ -0,0 +1,147 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/pdf"
+)
+
+const (
+	invoiceMargin     = 50.0
+	invoiceLineHeight = 14.0
+	// Lines below this start a new page
+	invoicePageBottom = pdf.PageHeight - 70
+)
+
+// renderInvoicePDF lays out an issued invoice on A4 pages
+func renderInvoicePDF(invoice *domain.Invoice) []byte {
+	doc := pdf.New()
+	doc.AddPage()
+	right := pdf.PageWidth - invoiceMargin
+
+	doc.Text(invoiceMargin, 70, pdf.HelveticaBold, 20, "INVOICE")
+	doc.TextRight(right, 70, pdf.HelveticaBold, 12, invoice.Number)
+
+	// Issuer on the left, invoice dates on the right
+	y := 105.0
+	partyEnd := writeInvoiceParty(doc, invoiceMargin, y, &invoice.IssuerDetails)
+	dates := [][2]string{
+		{"Issued", formatInvoiceDate(invoice.IssuedAt)},
+		{"Due", formatInvoiceDate(invoice.DueAt)},
+		{"Period", invoice.PeriodStart.Format("02/01/2006") + " - " + invoice.PeriodEnd.Format("02/01/2006")},
+		{"Status", strings.ToUpper(string(invoice.Status))},
+	}
+	for i, date := range dates {
+		doc.Text(350, y+float64(i)*invoiceLineHeight, pdf.HelveticaBold, 9, date[0])
+		doc.TextRight(right, y+float64(i)*invoiceLineHeight, pdf.Helvetica, 9, date[1])
+	}
+
+	y = math.Max(partyEnd, y+float64(len(dates))*invoiceLineHeight) + 20
+	doc.Text(invoiceMargin, y, pdf.HelveticaBold, 10, "Bill to")
+	y = writeInvoiceParty(doc, invoiceMargin, y+invoiceLineHeight, &invoice.Customer) + 20
+
+	header := func(y float64) float64 {
+		doc.Text(invoiceMargin, y, pdf.HelveticaBold, 9, "Description")
+		doc.TextRight(360, y, pdf.HelveticaBold, 9, "Qty")
+		doc.TextRight(450, y, pdf.HelveticaBold, 9, "Unit price")
+		doc.TextRight(right, y, pdf.HelveticaBold, 9, "Amount")
+		doc.Line(invoiceMargin, y+5, right, y+5, 0.5)
+		return y + invoiceLineHeight + 4
+	}
+	y = header(y)
+
+	for _, line := range invoice.Lines {
+		if y > invoicePageBottom {
+			doc.AddPage()
+			y = header(70)
+		}
+		doc.Text(invoiceMargin, y, pdf.Helvetica, 9, truncateText(line.Description, 270, 9))
+		doc.TextRight(360, y, pdf.Helvetica, 9, strconv.FormatInt(line.Quantity, 10))
+		doc.TextRight(450, y, pdf.Helvetica, 9, formatMoney(line.UnitPrice, invoice.Currency))
+		doc.TextRight(right, y, pdf.Helvetica, 9, formatMoney(line.Amount, invoice.Currency))
+		y += invoiceLineHeight
+	}
+
+	doc.Line(invoiceMargin, y-6, right, y-6, 0.5)
+	y += 6
+	doc.Text(350, y, pdf.HelveticaBold, 11, "Total")
+	doc.TextRight(right, y, pdf.HelveticaBold, 11, formatMoney(invoice.Total, invoice.Currency))
+
+	switch invoice.Status {
+	case domain.InvoiceStatusPaid:
+		doc.Text(invoiceMargin, y+30, pdf.HelveticaBold, 10, "Paid on "+formatInvoiceDate(invoice.PaidAt))
+	case domain.InvoiceStatusVoid:
+		doc.Text(invoiceMargin, y+30, pdf.HelveticaBold, 10, truncateText("Void: "+invoice.VoidReason, right-invoiceMargin, 10))
+	}
+
+	return doc.Bytes()
+}
+
+// writeInvoiceParty writes the legal data of a party and returns the y below it
+func writeInvoiceParty(doc *pdf.Document, x, y float64, party *domain.InvoiceParty) float64 {
+	doc.Text(x, y, pdf.HelveticaBold, 10, party.LegalName)
+	y += invoiceLineHeight
+
+	lines := []string{}
+	if party.TaxID != "" {
+		label := "CNPJ"
+		if len(onlyDigits(party.TaxID)) == 11 {
+			label = "CPF"
+		}
+		lines = append(lines, label+" "+party.TaxID)
+	}
+	lines = append(lines, party.Address...)
+	if party.Email != "" {
+		lines = append(lines, party.Email)
+	}
+	for _, line := range lines {
+		doc.Text(x, y, pdf.Helvetica, 9, line)
+		y += invoiceLineHeight - 2
+	}
+	return y
+}
+
+// truncateText shortens s with an ellipsis to fit width
+func truncateText(s string, width, size float64) string {
+	if pdf.TextWidth(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && pdf.TextWidth(string(runes)+"...", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+func formatInvoiceDate(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format("02/01/2006")
+}
+
+// formatMoney writes an amount the Brazilian way, e.g. R$ 1.234,56
+func formatMoney(amount float64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	cents := int64(math.Round(amount * 100))
+	units := strconv.FormatInt(cents/100, 10)
+	for i := len(units) - 3; i > 0; i -= 3 {
+		units = units[:i] + "." + units[i:]
+	}
+
+	symbol := currency
+	if currency == "BRL" {
+		symbol = "R$"
+	}
+	return fmt.Sprintf("%s%s %s,%02d", sign, symbol, units, cents%100)
+}
@@ -0,0 +1,844 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/config"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/payments"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvoiceNotFound          = errors.New("invoice not found")
+	ErrInvoiceNotIssued         = errors.New("invoice not issued yet")
+	ErrInvalidInvoiceTransition = errors.New("invalid invoice status transition")
+	ErrInvalidLegalEntity       = errors.New("invalid legal entity")
+)
+
+// invoicedStatuses are the subscription statuses billed every period; trials are
+// free and unpaid or canceled subscriptions are no longer served
+var invoicedStatuses = []domain.SubscriptionStatus{
+	domain.SubscriptionStatusActive,
+	domain.SubscriptionStatusPastDue,
+}
+
+var postalCodePattern = regexp.MustCompile(`^\d{5}-?\d{3}$`)
+
+// InvoiceService bills subscriptions: at the start of each billing period it
+// invoices the plan fee with the proration of the plan changes since the last
+// invoice. Invoices stay drafts until the tenant legal data is complete.
+type InvoiceService struct {
+	db            *gorm.DB
+	subscriptions *SubscriptionService
+	cfg           config.BillingConfig
+}
+
+func NewInvoiceService(db *gorm.DB, subscriptions *SubscriptionService, cfg *config.BillingConfig) *InvoiceService {
+	return &InvoiceService{
+		db:            db,
+		subscriptions: subscriptions,
+		cfg:           *cfg,
+	}
+}
+
+// ListInvoices returns the invoices of a tenant, newest first
+func (s *InvoiceService) ListInvoices(ctx context.Context, tenantID uuid.UUID, offset, limit int, status string) ([]domain.Invoice, int64, error) {
+	query := s.db.WithContext(domain.WithTenantID(ctx, tenantID)).Model(&domain.Invoice{}).Where("tenant_id = ?", tenantID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var invoices []domain.Invoice
+	if err := query.Order("period_start DESC, created_at DESC").Offset(offset).Limit(limit).Find(&invoices).Error; err != nil {
+		return nil, 0, err
+	}
+	return invoices, total, nil
+}
+
+// GetInvoice returns an invoice of the tenant
+func (s *InvoiceService) GetInvoice(ctx context.Context, tenantID, invoiceID uuid.UUID) (*domain.Invoice, error) {
+	var invoice domain.Invoice
+	err := s.db.WithContext(domain.WithTenantID(ctx, tenantID)).
+		Where("id = ? AND tenant_id = ?", invoiceID, tenantID).
+		First(&invoice).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvoiceNotFound
+		}
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// InvoicePDF renders an issued invoice of the tenant as a PDF document
+func (s *InvoiceService) InvoicePDF(ctx context.Context, tenantID, invoiceID uuid.UUID) (*domain.Invoice, []byte, error) {
+	invoice, err := s.GetInvoice(ctx, tenantID, invoiceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if invoice.Number == "" {
+		return nil, nil, ErrInvoiceNotIssued
+	}
+	return invoice, renderInvoicePDF(invoice), nil
+}
+
+// SetLegalEntity updates the legal data printed on the tenant invoices and issues
+// the drafts that were waiting for it
+func (s *InvoiceService) SetLegalEntity(ctx context.Context, tenantID uuid.UUID, entity *domain.LegalEntity, actorID string) (*domain.LegalEntity, error) {
+	if err := normalizeLegalEntity(entity); err != nil {
+		return nil, err
+	}
+
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).First(&tenant, tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+
+	tenant.LegalEntity = *entity
+	if err := s.db.WithContext(ctx).Model(&tenant).Select("legal_entity").Updates(&tenant).Error; err != nil {
+		return nil, err
+	}
+
+	audit := &domain.AuditLog{
+		TenantID:   tenantID,
+		Action:     "tenant.legal_entity_updated",
+		Resource:   "tenant",
+		ResourceID: tenantID.String(),
+		Details: map[string]interface{}{
+			"legal_name": entity.LegalName,
+			"tax_id":     entity.TaxID,
+			"actor":      actorID,
+		},
+	}
+	if err := s.db.WithContext(domain.WithTenantID(ctx, tenantID)).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+
+	if err := s.issueDrafts(ctx, &tenant); err != nil {
+		logger.Error("Failed to issue draft invoices",
+			zap.String("tenantID", tenantID.String()),
+			zap.Error(err))
+	}
+	return entity, nil
+}
+
+// VoidInvoice cancels an unpaid invoice. Its plan changes and credits return to
+// the subscription, so a voided invoice of the current period is issued again by
+// the next billing run.
+func (s *InvoiceService) VoidInvoice(ctx context.Context, tenantID, invoiceID uuid.UUID, reason, actorID string) (*domain.Invoice, error) {
+	ctx = domain.WithTenantID(ctx, tenantID)
+	invoice, err := s.GetInvoice(ctx, tenantID, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	from := invoice.Status
+	if from != domain.InvoiceStatusDraft && from != domain.InvoiceStatusOpen {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidInvoiceTransition, from, domain.InvoiceStatusVoid)
+	}
+
+	now := time.Now()
+	invoice.Status = domain.InvoiceStatusVoid
+	invoice.VoidedAt = &now
+	invoice.VoidReason = reason
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(invoice).Where("status = ?", from).Select("status", "voided_at", "void_reason").Updates(invoice)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: invoice changed concurrently", ErrInvalidInvoiceTransition)
+		}
+
+		if err := tx.Model(&domain.PlanChange{}).Where("invoice_id = ?", invoice.ID).Update("invoice_id", nil).Error; err != nil {
+			return err
+		}
+		if credit := -invoiceLinesTotal(invoice.Lines, domain.InvoiceLineKindCredit); credit != 0 {
+			return tx.Model(&domain.Subscription{}).Where("id = ?", invoice.SubscriptionID).
+				Update("credit_balance", gorm.Expr("credit_balance + ?", credit)).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, invoice, "invoice.voided", map[string]interface{}{
+		"number": invoice.Number,
+		"reason": reason,
+		"actor":  actorID,
+	})
+	return invoice, nil
+}
+
+// MarkPaid settles an open invoice
+func (s *InvoiceService) MarkPaid(ctx context.Context, tenantID, invoiceID uuid.UUID, actorID string) (*domain.Invoice, error) {
+	ctx = domain.WithTenantID(ctx, tenantID)
+	invoice, err := s.GetInvoice(ctx, tenantID, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.Status != domain.InvoiceStatusOpen {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidInvoiceTransition, invoice.Status, domain.InvoiceStatusPaid)
+	}
+
+	now := time.Now()
+	invoice.Status = domain.InvoiceStatusPaid
+	invoice.PaidAt = &now
+	result := s.db.WithContext(ctx).Model(invoice).
+		Where("status = ?", domain.InvoiceStatusOpen).
+		Select("status", "paid_at").
+		Updates(invoice)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: invoice changed concurrently", ErrInvalidInvoiceTransition)
+	}
+
+	s.audit(ctx, invoice, "invoice.paid", map[string]interface{}{
+		"number": invoice.Number,
+		"total":  invoice.Total,
+		"actor":  actorID,
+	})
+	return invoice, nil
+}
+
+// SettleOpenInvoices marks the open invoices of a tenant in the currency as paid,
+// oldest first, while the amount received covers them, and returns the invoices settled
+func (s *InvoiceService) SettleOpenInvoices(ctx context.Context, tenantID uuid.UUID, amount float64, currency, actorID string) ([]domain.Invoice, error) {
+	ctx = domain.WithTenantID(ctx, tenantID)
+	var invoices []domain.Invoice
+	err := s.db.WithContext(ctx).
+		Where("tenant_id = ? AND status = ? AND currency = ?", tenantID, domain.InvoiceStatusOpen, currency).
+		Order("issued_at ASC").
+		Find(&invoices).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var settled []domain.Invoice
+	// Amounts arrive in cents; a cent of rounding must not leave an invoice open
+	remaining := amount + 0.005
+	for _, invoice := range invoices {
+		if invoice.Total > remaining {
+			break
+		}
+		paid, err := s.MarkPaid(ctx, tenantID, invoice.ID, actorID)
+		if errors.Is(err, ErrInvalidInvoiceTransition) {
+			continue // settled or voided meanwhile
+		}
+		if err != nil {
+			return settled, err
+		}
+		remaining -= paid.Total
+		settled = append(settled, *paid)
+	}
+	return settled, nil
+}
+
+// Start issues the invoices of the billing periods that began, every interval
+// until ctx is cancelled
+func (s *InvoiceService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger.Info("Invoice scheduler started", zap.Duration("interval", interval))
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Invoice scheduler stopped")
+			return
+		case <-ticker.C:
+			if err := s.IssueDueInvoices(ctx); err != nil {
+				logger.Error("Invoice scheduler failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// IssueDueInvoices invoices every billed subscription whose current period has
+// no invoice yet
+func (s *InvoiceService) IssueDueInvoices(ctx context.Context) error {
+	var subscriptions []domain.Subscription
+	err := s.db.WithContext(domain.CrossTenant(ctx)).
+		Where("status IN ?", invoicedStatuses).
+		Find(&subscriptions).Error
+	if err != nil {
+		return fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+
+	for i := range subscriptions {
+		if err := s.invoiceSubscription(ctx, &subscriptions[i], time.Now()); err != nil {
+			logger.Error("Failed to invoice subscription",
+				zap.String("tenantID", subscriptions[i].TenantID.String()),
+				zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (s *InvoiceService) invoiceSubscription(ctx context.Context, subscription *domain.Subscription, now time.Time) error {
+	ctx = domain.WithTenantID(ctx, subscription.TenantID)
+
+	// A plan change due at the end of the last period is applied first, the new
+	// period is billed on the new plan
+	if subscription.PendingPlanID != nil {
+		var due int64
+		err := s.db.WithContext(ctx).Model(&domain.PlanChange{}).
+			Where("subscription_id = ? AND status = ? AND effective_at <= ?", subscription.ID, domain.PlanChangeStatusScheduled, now).
+			Count(&due).Error
+		if err != nil {
+			return err
+		}
+		if due > 0 {
+			return nil
+		}
+	}
+
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).Preload("Plan").First(&tenant, subscription.TenantID).Error; err != nil {
+		return err
+	}
+	period := currentBillingPeriod(subscription, tenant.Plan.BillingCycle, now)
+
+	query := s.db.WithContext(ctx).Model(&domain.Invoice{}).
+		Where("subscription_id = ? AND status <> ?", subscription.ID, domain.InvoiceStatusVoid)
+	// One-time plans are billed once
+	if tenant.Plan.BillingCycle != domain.BillingCycleOneTime {
+		query = query.Where("period_start = ?", period.Start)
+	}
+	var invoiced int64
+	if err := query.Count(&invoiced).Error; err != nil {
+		return err
+	}
+	if invoiced > 0 {
+		return nil
+	}
+
+	var changes []domain.PlanChange
+	err := s.db.WithContext(ctx).
+		Where("subscription_id = ? AND status = ? AND invoice_id IS NULL AND (credit <> 0 OR charge <> 0)",
+			subscription.ID, domain.PlanChangeStatusApplied).
+		Order("applied_at").
+		Find(&changes).Error
+	if err != nil {
+		return err
+	}
+	planNames, err := s.planNames(ctx, changes)
+	if err != nil {
+		return err
+	}
+
+	usage, err := s.unbilledUsage(ctx, &tenant, period, now)
+	if err != nil {
+		return err
+	}
+	history, err := s.planHistory(ctx, subscription, &tenant.Plan)
+	if err != nil {
+		return err
+	}
+
+	lines, total, balance := invoiceLines(&tenant.Plan, changes, planNames, overageLines(history, usage), period, subscription.CreditBalance)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	invoice := &domain.Invoice{
+		TenantID:       tenant.ID,
+		SubscriptionID: subscription.ID,
+		Issuer:         s.cfg.Issuer.Code,
+		Status:         domain.InvoiceStatusDraft,
+		PeriodStart:    period.Start,
+		PeriodEnd:      period.End,
+		Currency:       tenant.Plan.Currency,
+		Lines:          lines,
+		Total:          total,
+	}
+	if s.subscriptions.gatewayBilled(subscription) {
+		if err := s.addGatewayItems(ctx, subscription, invoice); err != nil {
+			return fmt.Errorf("failed to bill invoice items to the gateway: %w", err)
+		}
+		invoice.ChargedBy = s.subscriptions.gateway.Name()
+	}
+	issue := legalEntityComplete(&tenant.LegalEntity)
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(invoice).Error; err != nil {
+			return err
+		}
+		if len(changes) > 0 {
+			ids := make([]uuid.UUID, len(changes))
+			for i := range changes {
+				ids[i] = changes[i].ID
+			}
+			if err := tx.Model(&domain.PlanChange{}).Where("id IN ?", ids).Update("invoice_id", invoice.ID).Error; err != nil {
+				return err
+			}
+		}
+		if len(usage) > 0 {
+			ids := make([]uuid.UUID, len(usage))
+			for i := range usage {
+				ids[i] = usage[i].ID
+			}
+			if err := tx.Model(&domain.UsageRecord{}).Where("id IN ?", ids).Update("invoice_id", invoice.ID).Error; err != nil {
+				return err
+			}
+		}
+		if balance != subscription.CreditBalance {
+			subscription.CreditBalance = balance
+			if err := tx.Model(subscription).Select("credit_balance").Updates(subscription).Error; err != nil {
+				return err
+			}
+		}
+		if issue {
+			return s.issue(tx, invoice, &tenant)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !issue {
+		s.audit(ctx, invoice, "invoice.drafted", map[string]interface{}{"total": invoice.Total})
+		s.notify(ctx, tenant.ID, "invoice.legal_entity_missing",
+			"Complete your billing data",
+			"An invoice of your subscription is waiting for the legal name, CPF or CNPJ and address of your firm. It is issued as soon as they are filled in.")
+		return nil
+	}
+	s.issued(ctx, invoice)
+	return nil
+}
+
+// addGatewayItems bills the lines of a card subscription beyond the plan fee, which
+// the gateway subscription charges itself, as items of the next gateway invoice.
+// Keys derived from the lines keep a retried billing run from adding them twice.
+func (s *InvoiceService) addGatewayItems(ctx context.Context, subscription *domain.Subscription, invoice *domain.Invoice) error {
+	for _, line := range invoice.Lines {
+		if line.Kind == domain.InvoiceLineKindPlanFee {
+			continue
+		}
+		key := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%.2f", line.Kind, line.Description, line.Amount)))
+		err := s.subscriptions.gateway.AddInvoiceItem(ctx, payments.InvoiceItemParams{
+			CustomerID:     subscription.StripeCustomerID,
+			SubscriptionID: subscription.StripeSubID,
+			Amount:         int64(math.Round(line.Amount * 100)),
+			Currency:       invoice.Currency,
+			Description:    line.Description,
+			IdempotencyKey: fmt.Sprintf("invoice-item-%s-%s-%x", subscription.ID, invoice.PeriodStart.Format("20060102"), key[:8]),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unbilledUsage returns the usage records of the periods ended before the billing
+// period whose overage no invoice billed yet. Periods that ended moments ago wait
+// for the next invoice, their last counts may not be flushed yet.
+func (s *InvoiceService) unbilledUsage(ctx context.Context, tenant *domain.Tenant, period billingPeriod, now time.Time) ([]domain.UsageRecord, error) {
+	ended := period.Start
+	if flushed := now.Add(-2 * s.cfg.UsageFlushInterval); flushed.Before(ended) {
+		ended = flushed
+	}
+	var records []domain.UsageRecord
+	err := s.db.WithContext(ctx).
+		Where("tenant_id = ? AND metric IN ? AND period_end <= ? AND invoice_id IS NULL", tenant.ID, overageMetrics, ended).
+		Order("period_start, metric").
+		Find(&records).Error
+	return records, err
+}
+
+// issueDrafts issues the drafts of a tenant whose legal data became complete
+func (s *InvoiceService) issueDrafts(ctx context.Context, tenant *domain.Tenant) error {
+	if !legalEntityComplete(&tenant.LegalEntity) {
+		return nil
+	}
+	ctx = domain.WithTenantID(ctx, tenant.ID)
+
+	var drafts []domain.Invoice
+	err := s.db.WithContext(ctx).
+		Where("tenant_id = ? AND status = ?", tenant.ID, domain.InvoiceStatusDraft).
+		Order("period_start").
+		Find(&drafts).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range drafts {
+		invoice := &drafts[i]
+		if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return s.issue(tx, invoice, tenant)
+		}); err != nil {
+			return err
+		}
+		s.issued(ctx, invoice)
+	}
+	return nil
+}
+
+// issue numbers a draft and freezes the legal data of both parties. Invoices with
+// nothing to pay are settled right away.
+func (s *InvoiceService) issue(tx *gorm.DB, invoice *domain.Invoice, tenant *domain.Tenant) error {
+	number, err := nextInvoiceNumber(tx, invoice.Issuer)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	due := now.AddDate(0, 0, s.cfg.InvoiceDueDays)
+	invoice.Number = number
+	// Invoices charged by the gateway are not paid by Pix or boleto
+	if invoice.ChargedBy == "" {
+		invoice.PixTxID, invoice.BoletoNumber = invoicePaymentReferences(number)
+	}
+	invoice.Status = domain.InvoiceStatusOpen
+	invoice.IssuedAt = &now
+	invoice.DueAt = &due
+	invoice.IssuerDetails = domain.InvoiceParty{
+		LegalName: s.cfg.Issuer.LegalName,
+		TaxID:     formatTaxID(s.cfg.Issuer.TaxID),
+		Email:     s.cfg.Issuer.Email,
+		Address:   s.cfg.Issuer.Address,
+	}
+	invoice.Customer = domain.InvoiceParty{
+		LegalName: tenant.LegalEntity.LegalName,
+		TaxID:     formatTaxID(tenant.LegalEntity.TaxID),
+		Email:     tenant.LegalEntity.Email,
+		Address:   tenant.LegalEntity.Address.Lines(),
+	}
+	if invoice.Total == 0 {
+		invoice.Status = domain.InvoiceStatusPaid
+		invoice.PaidAt = &now
+	}
+
+	return tx.Model(invoice).
+		Select("number", "pix_tx_id", "boleto_number", "status", "issued_at", "due_at", "paid_at", "issuer_details", "customer").
+		Updates(invoice).Error
+}
+
+func (s *InvoiceService) issued(ctx context.Context, invoice *domain.Invoice) {
+	s.audit(ctx, invoice, "invoice.issued", map[string]interface{}{
+		"number": invoice.Number,
+		"total":  invoice.Total,
+	})
+	payment := "is due on " + invoice.DueAt.Format("02/01/2006")
+	if invoice.ChargedBy != "" {
+		payment = "is charged to your card"
+	}
+	s.notify(ctx, invoice.TenantID, "invoice.issued",
+		fmt.Sprintf("Invoice %s issued", invoice.Number),
+		fmt.Sprintf("Invoice %s of %s, for the period from %s to %s, %s.",
+			invoice.Number, formatMoney(invoice.Total, invoice.Currency),
+			invoice.PeriodStart.Format("02/01/2006"), invoice.PeriodEnd.Format("02/01/2006"),
+			payment))
+
+	logger.Info("Invoice issued",
+		zap.String("tenantID", invoice.TenantID.String()),
+		zap.String("number", invoice.Number),
+		zap.Float64("total", invoice.Total))
+}
+
+// nextInvoiceNumber takes the next number of the issuer. The sequence row stays
+// locked until the transaction ends, so numbers have no gaps.
+func nextInvoiceNumber(tx *gorm.DB, issuer string) (string, error) {
+	var last int64
+	err := tx.Raw(`INSERT INTO invoice_sequences (issuer, last_number) VALUES (?, 1)
+		ON CONFLICT (issuer) DO UPDATE SET last_number = invoice_sequences.last_number + 1
+		RETURNING last_number`, issuer).Scan(&last).Error
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%06d", issuer, last), nil
+}
+
+// invoicePaymentReferences derives the Pix txid, the letters and digits of the
+// number, and the 11 digit boleto our number, the sequential part of the number
+func invoicePaymentReferences(number string) (pixTxID, boletoNumber string) {
+	pixTxID = strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' {
+			return r
+		}
+		return -1
+	}, number)
+	digits := onlyDigits(number)
+	if len(digits) > 11 {
+		digits = digits[len(digits)-11:]
+	}
+	return pixTxID, strings.Repeat("0", 11-len(digits)) + digits
+}
+
+// planHistory loads the plans the subscription went through, to price the usage
+// of past periods with the limits of their plan
+func (s *InvoiceService) planHistory(ctx context.Context, subscription *domain.Subscription, current *domain.Plan) (*planHistory, error) {
+	history := &planHistory{current: current, plans: map[uuid.UUID]*domain.Plan{current.ID: current}}
+	err := s.db.WithContext(ctx).
+		Where("subscription_id = ? AND status = ?", subscription.ID, domain.PlanChangeStatusApplied).
+		Order("effective_at").
+		Find(&history.changes).Error
+	if err != nil || len(history.changes) == 0 {
+		return history, err
+	}
+
+	var ids []uuid.UUID
+	for _, change := range history.changes {
+		ids = append(ids, change.FromPlanID, change.ToPlanID)
+	}
+	var plans []domain.Plan
+	if err := s.db.WithContext(ctx).Where("id IN ?", ids).Find(&plans).Error; err != nil {
+		return nil, err
+	}
+	for i := range plans {
+		history.plans[plans[i].ID] = &plans[i]
+	}
+	return history, nil
+}
+
+func (s *InvoiceService) planNames(ctx context.Context, changes []domain.PlanChange) (map[uuid.UUID]string, error) {
+	names := make(map[uuid.UUID]string)
+	if len(changes) == 0 {
+		return names, nil
+	}
+
+	var ids []uuid.UUID
+	for _, change := range changes {
+		ids = append(ids, change.FromPlanID, change.ToPlanID)
+	}
+	var plans []domain.Plan
+	if err := s.db.WithContext(ctx).Select("id", "display_name").Where("id IN ?", ids).Find(&plans).Error; err != nil {
+		return nil, err
+	}
+	for _, plan := range plans {
+		names[plan.ID] = plan.DisplayName
+	}
+	return names, nil
+}
+
+func (s *InvoiceService) notify(ctx context.Context, tenantID uuid.UUID, notificationType, subject, body string) {
+	if s.subscriptions == nil || s.subscriptions.notifier == nil {
+		return
+	}
+	admins, err := s.subscriptions.tenantAdmins(ctx, tenantID)
+	if err != nil || len(admins) == 0 {
+		return
+	}
+
+	err = s.subscriptions.notifier.Notify(ctx, Notification{
+		TenantID:   tenantID,
+		Type:       notificationType,
+		Recipients: admins,
+		Subject:    subject,
+		Body:       body,
+	})
+	if err != nil {
+		logger.Error("Failed to send invoice notification",
+			zap.String("tenantID", tenantID.String()),
+			zap.String("type", notificationType),
+			zap.Error(err))
+	}
+}
+
+func (s *InvoiceService) audit(ctx context.Context, invoice *domain.Invoice, action string, details map[string]interface{}) {
+	audit := &domain.AuditLog{
+		TenantID:   invoice.TenantID,
+		Action:     action,
+		Resource:   "invoice",
+		ResourceID: invoice.ID.String(),
+		Details:    details,
+	}
+	if err := s.db.WithContext(domain.WithTenantID(ctx, invoice.TenantID)).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+}
+
+// invoiceLines bills the plan fee of the period, the plan changes not billed yet
+// and the overage lines, and applies the credit balance. Credits beyond the
+// charges are carried to the next invoice; it returns the lines, the total and the
+// new credit balance.
+func invoiceLines(plan *domain.Plan, changes []domain.PlanChange, planNames map[uuid.UUID]string, overage []domain.InvoiceLine, period billingPeriod, creditBalance float64) ([]domain.InvoiceLine, float64, float64) {
+	var lines []domain.InvoiceLine
+	if plan.Price > 0 {
+		lines = append(lines, domain.InvoiceLine{
+			Kind: domain.InvoiceLineKindPlanFee,
+			Description: fmt.Sprintf("%s plan, %s to %s", plan.DisplayName,
+				period.Start.Format("02/01/2006"), period.End.Format("02/01/2006")),
+			Quantity:  1,
+			UnitPrice: plan.Price,
+			Amount:    plan.Price,
+		})
+	}
+
+	for i := range changes {
+		change := &changes[i]
+		if change.Credit != 0 {
+			lines = append(lines, prorationLine(change, "Unused time on "+planNames[change.FromPlanID], -change.Credit))
+		}
+		// A change of billing cycle starts the period, whose fee already charges it
+		if change.Charge != 0 && !change.EffectiveAt.Equal(period.Start) {
+			lines = append(lines, prorationLine(change, "Remaining time on "+planNames[change.ToPlanID], change.Charge))
+		}
+	}
+	lines = append(lines, overage...)
+
+	total := invoiceLinesTotal(lines, "")
+	if creditBalance > 0 && total > 0 {
+		applied := roundCents(minFloat(creditBalance, total))
+		lines = append(lines, domain.InvoiceLine{
+			Kind:        domain.InvoiceLineKindCredit,
+			Description: "Credit balance",
+			Quantity:    1,
+			UnitPrice:   -applied,
+			Amount:      -applied,
+		})
+		creditBalance = roundCents(creditBalance - applied)
+		total = roundCents(total - applied)
+	}
+	if total < 0 {
+		lines = append(lines, domain.InvoiceLine{
+			Kind:        domain.InvoiceLineKindCredit,
+			Description: "Credit carried to the next invoice",
+			Quantity:    1,
+			UnitPrice:   -total,
+			Amount:      -total,
+		})
+		creditBalance = roundCents(creditBalance - total)
+		total = 0
+	}
+	return lines, total, creditBalance
+}
+
+func prorationLine(change *domain.PlanChange, description string, amount float64) domain.InvoiceLine {
+	id := change.ID
+	return domain.InvoiceLine{
+		Kind:         domain.InvoiceLineKindProration,
+		Description:  fmt.Sprintf("%s (plan change on %s)", description, change.EffectiveAt.Format("02/01/2006")),
+		Quantity:     1,
+		UnitPrice:    amount,
+		Amount:       amount,
+		PlanChangeID: &id,
+	}
+}
+
+// invoiceLinesTotal sums the lines of a kind, or all lines when kind is empty
+func invoiceLinesTotal(lines []domain.InvoiceLine, kind domain.InvoiceLineKind) float64 {
+	total := 0.0
+	for _, line := range lines {
+		if kind == "" || line.Kind == kind {
+			total += line.Amount
+		}
+	}
+	return roundCents(total)
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// normalizeLegalEntity keeps the digits of the tax ID and postal code and checks
+// the fields printed on invoices
+func normalizeLegalEntity(entity *domain.LegalEntity) error {
+	entity.LegalName = strings.TrimSpace(entity.LegalName)
+	entity.TaxID = onlyDigits(entity.TaxID)
+	entity.Address.State = strings.ToUpper(strings.TrimSpace(entity.Address.State))
+
+	switch {
+	case entity.LegalName == "":
+		return fmt.Errorf("%w: legal_name is required", ErrInvalidLegalEntity)
+	case !validTaxID(entity.TaxID):
+		return fmt.Errorf("%w: tax_id must be a valid CPF or CNPJ", ErrInvalidLegalEntity)
+	case strings.TrimSpace(entity.Address.Street) == "" || strings.TrimSpace(entity.Address.City) == "":
+		return fmt.Errorf("%w: address street and city are required", ErrInvalidLegalEntity)
+	case len(entity.Address.State) != 2:
+		return fmt.Errorf("%w: address state must be a two-letter code such as SP", ErrInvalidLegalEntity)
+	case !postalCodePattern.MatchString(entity.Address.PostalCode):
+		return fmt.Errorf("%w: address postal_code must be a CEP such as 01310-100", ErrInvalidLegalEntity)
+	}
+	return nil
+}
+
+// legalEntityComplete reports whether invoices can be issued to the entity
+func legalEntityComplete(entity *domain.LegalEntity) bool {
+	copied := *entity
+	return normalizeLegalEntity(&copied) == nil
+}
+
+// validTaxID checks the check digits of a CPF (11 digits) or CNPJ (14 digits)
+func validTaxID(digits string) bool {
+	var weights [][]int
+	switch len(digits) {
+	case 11:
+		weights = [][]int{{10, 9, 8, 7, 6, 5, 4, 3, 2}, {11, 10, 9, 8, 7, 6, 5, 4, 3, 2}}
+	case 14:
+		weights = [][]int{{5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}, {6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}}
+	default:
+		return false
+	}
+	// Repeated digits pass the check but are never assigned
+	if strings.Count(digits, digits[:1]) == len(digits) {
+		return false
+	}
+
+	for _, w := range weights {
+		sum := 0
+		for i, weight := range w {
+			sum += int(digits[i]-'0') * weight
+		}
+		check := sum % 11
+		if check < 2 {
+			check = 0
+		} else {
+			check = 11 - check
+		}
+		if int(digits[len(w)]-'0') != check {
+			return false
+		}
+	}
+	return true
+}
+
+// formatTaxID punctuates a CPF or CNPJ as printed on documents
+func formatTaxID(taxID string) string {
+	digits := onlyDigits(taxID)
+	switch len(digits) {
+	case 11:
+		return digits[0:3] + "." + digits[3:6] + "." + digits[6:9] + "-" + digits[9:]
+	case 14:
+		return digits[0:2] + "." + digits[2:5] + "." + digits[5:8] + "/" + digits[8:12] + "-" + digits[12:]
+	}
+	return taxID
+}
+
+func onlyDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
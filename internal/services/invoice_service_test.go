@@ -0,0 +1,80 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+)
+
+func TestValidTaxID(t *testing.T) {
+	valid := []string{"52998224725", "11222333000181"}
+	invalid := []string{"52998224724", "11222333000180", "11111111111", "123", ""}
+
+	for _, taxID := range valid {
+		if !validTaxID(taxID) {
+			t.Errorf("validTaxID(%q) = false, want true", taxID)
+		}
+	}
+	for _, taxID := range invalid {
+		if validTaxID(taxID) {
+			t.Errorf("validTaxID(%q) = true, want false", taxID)
+		}
+	}
+
+	if got := formatTaxID("11222333000181"); got != "11.222.333/0001-81" {
+		t.Errorf("formatTaxID() = %q", got)
+	}
+}
+
+func TestInvoiceLines(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	period := billingPeriod{Start: start, End: start.AddDate(0, 1, 0)}
+	plan := &domain.Plan{DisplayName: "Professional", Price: 300}
+	fromID, toID := uuid.New(), uuid.New()
+	names := map[uuid.UUID]string{fromID: "Starter", toID: "Professional"}
+	upgrade := domain.PlanChange{FromPlanID: fromID, ToPlanID: toID, Credit: 50, Charge: 150, EffectiveAt: start.AddDate(0, 0, -15)}
+
+	lines, total, balance := invoiceLines(plan, []domain.PlanChange{upgrade}, names, nil, period, 0)
+	if len(lines) != 3 || total != 400 || balance != 0 {
+		t.Errorf("upgrade: %d lines, total = %v, balance = %v", len(lines), total, balance)
+	}
+
+	// The credit balance is deducted, what is left over carried again
+	lines, total, balance = invoiceLines(plan, nil, names, nil, period, 500)
+	if total != 0 || balance != 200 || lines[len(lines)-1].Amount != -300 {
+		t.Errorf("credit balance: total = %v, balance = %v, lines = %+v", total, balance, lines)
+	}
+
+	// A downgrade crediting more than the fee carries the rest over
+	downgrade := domain.PlanChange{FromPlanID: toID, ToPlanID: fromID, Credit: 450, EffectiveAt: start.AddDate(0, 0, -3)}
+	lines, total, balance = invoiceLines(&domain.Plan{DisplayName: "Starter", Price: 100}, []domain.PlanChange{downgrade}, names, nil, period, 0)
+	if total != 0 || balance != 350 || invoiceLinesTotal(lines, "") != 0 {
+		t.Errorf("downgrade: total = %v, balance = %v, lines = %+v", total, balance, lines)
+	}
+
+	// A change of billing cycle started the period, the plan fee charges it
+	cycleChange := domain.PlanChange{FromPlanID: fromID, ToPlanID: toID, Credit: 20, Charge: 300, EffectiveAt: start}
+	lines, total, _ = invoiceLines(plan, []domain.PlanChange{cycleChange}, names, nil, period, 0)
+	if len(lines) != 2 || total != 280 {
+		t.Errorf("cycle change: %d lines, total = %v", len(lines), total)
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	tests := map[string]struct {
+		amount   float64
+		currency string
+	}{
+		"R$ 1.234,56":      {1234.56, "BRL"},
+		"R$ 0,10":          {0.1, "BRL"},
+		"-R$ 50,00":        {-50, "BRL"},
+		"USD 1.000.000,00": {1000000, "USD"},
+	}
+	for want, tt := range tests {
+		if got := formatMoney(tt.amount, tt.currency); got != want {
+			t.Errorf("formatMoney(%v, %s) = %q, want %q", tt.amount, tt.currency, got, want)
+		}
+	}
+}
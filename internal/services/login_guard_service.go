@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/config"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ErrLoginLocked is returned while an email or client IP is locked out
+var ErrLoginLocked = errors.New("too many failed login attempts")
+
+// LoginLockedError carries how long the lockout lasts
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrLoginLocked, e.RetryAfter)
+}
+
+func (e *LoginLockedError) Unwrap() error {
+	return ErrLoginLocked
+}
+
+// LoginGuardService counts failed logins per email and per client IP in Redis,
+// slowing down and then locking out repeated failures
+type LoginGuardService struct {
+	db          *gorm.DB
+	redisClient *redis.Client
+	notifier    Notifier
+	cfg         config.LoginProtectionConfig
+}
+
+func NewLoginGuardService(db *gorm.DB, redisClient *redis.Client, notifier Notifier, cfg *config.LoginProtectionConfig) *LoginGuardService {
+	return &LoginGuardService{
+		db:          db,
+		redisClient: redisClient,
+		notifier:    notifier,
+		cfg:         *cfg,
+	}
+}
+
+// Check returns a LoginLockedError when the email or IP is locked, otherwise the
+// delay to apply before attempting the login
+func (s *LoginGuardService) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	email = normalizeEmail(email)
+
+	for _, key := range []string{loginLockKey("email", email), loginLockKey("ip", ip)} {
+		ttl, err := s.redisClient.TTL(ctx, key).Result()
+		if err != nil {
+			return 0, err
+		}
+		if ttl > 0 {
+			return 0, &LoginLockedError{RetryAfter: ttl}
+		}
+	}
+
+	failures, err := s.redisClient.Get(ctx, loginFailuresKey("email", email)).Int()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+
+	return progressiveDelay(failures, s.cfg.BaseDelay, s.cfg.MaxDelay), nil
+}
+
+// RecordFailure counts a failed login and locks the email or IP once a threshold is reached
+func (s *LoginGuardService) RecordFailure(ctx context.Context, email, ip string) {
+	email = normalizeEmail(email)
+
+	emailFailures, err := s.increment(ctx, loginFailuresKey("email", email))
+	if err != nil {
+		logger.Error("Failed to record login failure", zap.Error(err))
+		return
+	}
+	ipFailures, err := s.increment(ctx, loginFailuresKey("ip", ip))
+	if err != nil {
+		logger.Error("Failed to record login failure", zap.Error(err))
+		return
+	}
+
+	if s.cfg.MaxAttemptsPerEmail > 0 && emailFailures >= int64(s.cfg.MaxAttemptsPerEmail) {
+		s.lock(ctx, "email", email, ip, emailFailures)
+	}
+	if s.cfg.MaxAttemptsPerIP > 0 && ipFailures >= int64(s.cfg.MaxAttemptsPerIP) {
+		s.lock(ctx, "ip", ip, ip, ipFailures)
+	}
+}
+
+// RecordSuccess clears the failures of the email; IP failures expire on their own
+func (s *LoginGuardService) RecordSuccess(ctx context.Context, email string) {
+	s.redisClient.Del(ctx, loginFailuresKey("email", normalizeEmail(email)))
+}
+
+// UnlockUser lifts the lockout of a tenant user
+func (s *LoginGuardService) UnlockUser(ctx context.Context, tenantID, userID uuid.UUID, actorID string) error {
+	var user domain.User
+	err := s.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", userID, tenantID).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	email := normalizeEmail(user.Email)
+	if err := s.redisClient.Del(ctx, loginLockKey("email", email), loginFailuresKey("email", email)).Err(); err != nil {
+		return err
+	}
+
+	s.audit(ctx, "auth.account_unlocked", &user, map[string]interface{}{
+		"email": email,
+		"actor": actorID,
+	})
+
+	return nil
+}
+
+// UnlockIP lifts the lockout of a client IP
+func (s *LoginGuardService) UnlockIP(ctx context.Context, ip, actorID string) error {
+	if err := s.redisClient.Del(ctx, loginLockKey("ip", ip), loginFailuresKey("ip", ip)).Err(); err != nil {
+		return err
+	}
+
+	s.audit(ctx, "auth.ip_unlocked", nil, map[string]interface{}{
+		"ip":    ip,
+		"actor": actorID,
+	})
+
+	return nil
+}
+
+func (s *LoginGuardService) increment(ctx context.Context, key string) (int64, error) {
+	pipe := s.redisClient.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, s.cfg.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// lock sets the lockout key once; later failures while locked are rejected before reaching here
+func (s *LoginGuardService) lock(ctx context.Context, kind, value, ip string, failures int64) {
+	locked, err := s.redisClient.SetNX(ctx, loginLockKey(kind, value), failures, s.cfg.LockoutDuration).Result()
+	if err != nil {
+		logger.Error("Failed to lock login", zap.String("kind", kind), zap.Error(err))
+		return
+	}
+	if !locked {
+		return
+	}
+
+	logger.Warn("Login locked after repeated failures",
+		zap.String("kind", kind),
+		zap.String("value", value),
+		zap.Int64("failures", failures),
+		zap.Duration("duration", s.cfg.LockoutDuration))
+
+	details := map[string]interface{}{
+		"kind":         kind,
+		"ip":           ip,
+		"failures":     failures,
+		"locked_until": time.Now().Add(s.cfg.LockoutDuration),
+	}
+
+	if kind != "email" {
+		s.audit(ctx, "auth.ip_locked", nil, details)
+		return
+	}
+
+	details["email"] = value
+
+	var user domain.User
+	// Logins happen before the tenant is known
+	if err := s.db.WithContext(domain.CrossTenant(ctx)).Where("email = ?", value).First(&user).Error; err != nil {
+		// Unknown emails are locked too, so lockouts do not reveal which accounts exist
+		s.audit(ctx, "auth.account_locked", nil, details)
+		return
+	}
+
+	s.audit(ctx, "auth.account_locked", &user, details)
+	s.notifyAdmins(ctx, &user, details)
+}
+
+// notifyAdmins tells the tenant admins that one of their accounts was locked
+func (s *LoginGuardService) notifyAdmins(ctx context.Context, user *domain.User, details map[string]interface{}) {
+	if s.notifier == nil {
+		return
+	}
+
+	var admins []string
+	err := s.db.WithContext(domain.WithTenantID(ctx, user.TenantID)).Model(&domain.User{}).
+		Where("tenant_id = ? AND role = ? AND status = ?", user.TenantID, domain.UserRoleAdmin, domain.UserStatusActive).
+		Pluck("email", &admins).Error
+	if err != nil || len(admins) == 0 {
+		return
+	}
+
+	err = s.notifier.Notify(ctx, Notification{
+		TenantID:   user.TenantID,
+		Type:       "auth.account_locked",
+		Recipients: admins,
+		Subject:    "Account locked after failed login attempts",
+		Body: fmt.Sprintf("The account %s was locked for %s after %v failed login attempts from %v.",
+			user.Email, s.cfg.LockoutDuration, details["failures"], details["ip"]),
+	})
+	if err != nil {
+		logger.Error("Failed to notify tenant admins of lockout", zap.Error(err))
+	}
+}
+
+func (s *LoginGuardService) audit(ctx context.Context, action string, user *domain.User, details map[string]interface{}) {
+	audit := &domain.AuditLog{
+		Action:   action,
+		Resource: "auth",
+		Details:  details,
+	}
+	if ip, ok := details["ip"].(string); ok {
+		audit.IPAddress = ip
+	}
+	// IP lockouts and unknown emails belong to no tenant
+	scoped := domain.CrossTenant(ctx)
+	if user != nil {
+		audit.TenantID = user.TenantID
+		audit.UserID = user.ID
+		audit.ResourceID = user.ID.String()
+		scoped = domain.WithTenantID(ctx, user.TenantID)
+	}
+
+	if err := s.db.WithContext(scoped).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+}
+
+// progressiveDelay doubles the base delay for each failure after the first, up to max
+func progressiveDelay(failures int, base, max time.Duration) time.Duration {
+	if failures <= 0 || base <= 0 {
+		return 0
+	}
+
+	delay := base
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+
+	return delay
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginFailuresKey(kind, value string) string {
+	return fmt.Sprintf("login_failures:%s:%s", kind, value)
+}
+
+func loginLockKey(kind, value string) string {
+	return fmt.Sprintf("login_lock:%s:%s", kind, value)
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidMFAPolicy = errors.New("invalid MFA policy")
+
+const mfaPolicyCacheTTL = 5 * time.Minute
+
+// MFAEnforcementResult reports how many users were flagged for TOTP enrollment
+type MFAEnforcementResult struct {
+	Policy  domain.MFAPolicy `json:"policy"`
+	Flagged int              `json:"flagged"`
+	Cleared int              `json:"cleared"`
+	Failed  int              `json:"failed"`
+}
+
+type MFAService struct {
+	db               *gorm.DB
+	identityProvider auth.IdentityProvider
+	redisClient      *redis.Client
+}
+
+func NewMFAService(db *gorm.DB, identityProvider auth.IdentityProvider, redisClient *redis.Client) *MFAService {
+	return &MFAService{
+		db:               db,
+		identityProvider: identityProvider,
+		redisClient:      redisClient,
+	}
+}
+
+// GetPolicy returns the MFA policy of a tenant by name, cached in Redis
+func (s *MFAService) GetPolicy(ctx context.Context, tenantName string) (*domain.MFAPolicy, error) {
+	cacheKey := mfaPolicyCacheKey(tenantName)
+	if cached, err := s.redisClient.Get(ctx, cacheKey).Bytes(); err == nil {
+		var policy domain.MFAPolicy
+		if err := json.Unmarshal(cached, &policy); err == nil {
+			return &policy, nil
+		}
+	}
+
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).Select("id", "name", "settings").Where("name = ?", tenantName).First(&tenant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+
+	policy := tenant.Settings.MFA
+	if data, err := json.Marshal(policy); err == nil {
+		s.redisClient.Set(ctx, cacheKey, data, mfaPolicyCacheTTL)
+	}
+
+	return &policy, nil
+}
+
+// UpdatePolicy saves the tenant MFA policy and sets or clears the CONFIGURE_TOTP
+// required action of the tenant users accordingly
+func (s *MFAService) UpdatePolicy(ctx context.Context, tenantID uuid.UUID, actorID string, policy domain.MFAPolicy) (*MFAEnforcementResult, error) {
+	for _, role := range policy.Roles {
+		switch domain.UserRole(role) {
+		case domain.UserRoleAdmin, domain.UserRoleLawyer, domain.UserRoleSecretary, domain.UserRoleClient:
+		default:
+			return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidMFAPolicy, role)
+		}
+	}
+
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).First(&tenant, tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+
+	previous := tenant.Settings.MFA
+	tenant.Settings.MFA = policy
+	if err := s.db.WithContext(ctx).Model(&tenant).Select("settings").Updates(&tenant).Error; err != nil {
+		return nil, err
+	}
+	s.redisClient.Del(ctx, mfaPolicyCacheKey(tenant.Name))
+
+	result, err := s.enforce(ctx, &tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	audit := &domain.AuditLog{
+		TenantID:   tenantID,
+		Action:     "tenant.mfa_policy_updated",
+		Resource:   "tenant",
+		ResourceID: tenantID.String(),
+		Details: map[string]interface{}{
+			"actor":   actorID,
+			"from":    previous,
+			"to":      policy,
+			"flagged": result.Flagged,
+			"cleared": result.Cleared,
+			"failed":  result.Failed,
+		},
+	}
+	if err := s.db.WithContext(ctx).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+
+	return result, nil
+}
+
+// enforce applies the tenant policy to the Keycloak required actions of its users
+func (s *MFAService) enforce(ctx context.Context, tenant *domain.Tenant) (*MFAEnforcementResult, error) {
+	var users []*domain.User
+	err := s.db.WithContext(ctx).
+		Where("tenant_id = ? AND status IN ?", tenant.ID, []domain.UserStatus{domain.UserStatusActive, domain.UserStatusInvited}).
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MFAEnforcementResult{Policy: tenant.Settings.MFA}
+	for _, user := range users {
+		if user.KeycloakID == "" {
+			continue
+		}
+
+		required := tenant.Settings.MFA.AppliesTo(string(user.Role))
+		changed, err := s.identityProvider.SetTOTPRequired(ctx, user.KeycloakID, required)
+		if err != nil {
+			result.Failed++
+			logger.Warn("Failed to update TOTP required action",
+				zap.String("tenantID", tenant.ID.String()),
+				zap.String("userID", user.ID.String()),
+				zap.Error(err))
+			continue
+		}
+
+		if changed && required {
+			result.Flagged++
+		} else if changed {
+			result.Cleared++
+		}
+	}
+
+	return result, nil
+}
+
+func mfaPolicyCacheKey(tenantName string) string {
+	return fmt.Sprintf("mfa_policy:%s", tenantName)
+}
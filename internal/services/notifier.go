@@ -0,0 +1,40 @@
+package services
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Notification is a message to tenant users
+type Notification struct {
+	TenantID   uuid.UUID
+	Type       string
+	Recipients []string
+	Subject    string
+	Body       string
+}
+
+// Notifier delivers notifications; the notification service will provide email and
+// messaging channels
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// LogNotifier writes notifications to the log
+type LogNotifier struct{}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, notification Notification) error {
+	logger.Info("Notification",
+		zap.String("tenantID", notification.TenantID.String()),
+		zap.String("type", notification.Type),
+		zap.Strings("recipients", notification.Recipients),
+		zap.String("subject", notification.Subject))
+	return nil
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+)
+
+// overageMetricNames name the metrics on invoices
+var overageMetricNames = map[domain.UsageMetric]string{
+	domain.UsageMetricAIRequests: "AI requests",
+	domain.UsageMetricMessages:   "Messages",
+}
+
+// MetricOverage is the usage of a metric beyond its plan limit in the current
+// period, and where it is heading at the current pace
+type MetricOverage struct {
+	Metric      domain.UsageMetric `json:"metric"`
+	Limit       int64              `json:"limit"`
+	Used        int64              `json:"used"`
+	UnitPrice   float64            `json:"unit_price"`
+	SpendingCap float64            `json:"spending_cap"`
+	Overage     int64              `json:"overage"`
+	Amount      float64            `json:"amount"`
+	// Extrapolated to the end of the period from the usage so far
+	ProjectedUsed    int64   `json:"projected_used"`
+	ProjectedOverage int64   `json:"projected_overage"`
+	ProjectedAmount  float64 `json:"projected_amount"`
+}
+
+// OverageProjection is the overage a tenant is to be billed for the current usage
+// period, on the invoice of the next billing cycle
+type OverageProjection struct {
+	PeriodStart     time.Time       `json:"period_start"`
+	PeriodEnd       time.Time       `json:"period_end"`
+	Currency        string          `json:"currency"`
+	Metrics         []MetricOverage `json:"metrics"`
+	Amount          float64         `json:"amount"`
+	ProjectedAmount float64         `json:"projected_amount"`
+}
+
+// ProjectedOverage returns the overage of the tenant in the current usage period
+// for the metrics its plan bills beyond the limit
+func (s *QuotaService) ProjectedOverage(ctx context.Context, tenantID uuid.UUID) (*OverageProjection, error) {
+	plan, err := s.tenantPlan(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := s.usageMeter.CurrentUsage(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	projection := &OverageProjection{
+		PeriodStart: usage.PeriodStart,
+		PeriodEnd:   usage.PeriodEnd,
+		Currency:    plan.Currency,
+		Metrics:     []MetricOverage{},
+	}
+	elapsed, length := time.Since(usage.PeriodStart), usage.PeriodEnd.Sub(usage.PeriodStart)
+	for _, metric := range overageMetrics {
+		price, ok := plan.Limits.Overage[metric]
+		limit := metricLimit(&plan.Limits, metric)
+		if !ok || limit == domain.Unlimited {
+			continue
+		}
+
+		overage := projectOverage(int64(limit), usage.Metrics[metric], price, elapsed, length)
+		overage.Metric = metric
+		projection.Metrics = append(projection.Metrics, overage)
+		projection.Amount += overage.Amount
+		projection.ProjectedAmount += overage.ProjectedAmount
+	}
+	projection.Amount = roundCents(projection.Amount)
+	projection.ProjectedAmount = roundCents(projection.ProjectedAmount)
+	return projection, nil
+}
+
+// projectOverage prices the usage of a metric so far and extrapolates it linearly
+// over the period; the first hour is not extrapolated, it says too little
+func projectOverage(limit, used int64, price domain.OveragePrice, elapsed, length time.Duration) MetricOverage {
+	projected := used
+	if elapsed >= time.Hour && elapsed < length {
+		projected = int64(float64(used) * float64(length) / float64(elapsed))
+	}
+
+	overage := MetricOverage{
+		Limit:         limit,
+		Used:          used,
+		UnitPrice:     price.UnitPrice,
+		SpendingCap:   price.SpendingCap,
+		ProjectedUsed: projected,
+	}
+	overage.Overage, overage.Amount = overageCharge(limit, used, price)
+	overage.ProjectedOverage, overage.ProjectedAmount = overageCharge(limit, projected, price)
+	return overage
+}
+
+// overageCharge returns the units of usage billed beyond the limit and their
+// amount, both bounded by the spending cap
+func overageCharge(limit, used int64, price domain.OveragePrice) (int64, float64) {
+	units := used - limit
+	if units <= 0 {
+		return 0, 0
+	}
+	if maxUnits := price.MaxUnits(); units > maxUnits {
+		units = maxUnits
+	}
+	return units, roundCents(minFloat(float64(units)*price.UnitPrice, price.SpendingCap))
+}
+
+// planHistory is the plan of a subscription over time, read from its applied plan
+// changes ordered by EffectiveAt
+type planHistory struct {
+	current *domain.Plan
+	changes []domain.PlanChange
+	plans   map[uuid.UUID]*domain.Plan
+}
+
+// planAt returns the plan in force at the end of a period: the target of the last
+// change effective before end or, before the first change, the plan it replaced
+func (h *planHistory) planAt(end time.Time) *domain.Plan {
+	id := h.current.ID
+	for i, change := range h.changes {
+		if !change.EffectiveAt.Before(end) {
+			if i == 0 {
+				id = change.FromPlanID
+			}
+			break
+		}
+		id = change.ToPlanID
+	}
+	if plan, ok := h.plans[id]; ok {
+		return plan
+	}
+	return h.current
+}
+
+// overageLines bills the usage records of ended periods beyond the limits of the
+// plan in force in each period
+func overageLines(history *planHistory, records []domain.UsageRecord) []domain.InvoiceLine {
+	var lines []domain.InvoiceLine
+	for _, record := range records {
+		plan := history.planAt(record.PeriodEnd)
+		price, ok := plan.Limits.Overage[record.Metric]
+		limit := metricLimit(&plan.Limits, record.Metric)
+		if !ok || limit == domain.Unlimited {
+			continue
+		}
+		units, amount := overageCharge(int64(limit), record.Value, price)
+		if units == 0 {
+			continue
+		}
+		lines = append(lines, domain.InvoiceLine{
+			Kind: domain.InvoiceLineKindOverage,
+			Description: fmt.Sprintf("%s beyond the limit of %d, %s to %s", overageMetricNames[record.Metric], limit,
+				record.PeriodStart.Format("02/01/2006"), record.PeriodEnd.Format("02/01/2006")),
+			Quantity:  units,
+			UnitPrice: price.UnitPrice,
+			Amount:    amount,
+		})
+	}
+	return lines
+}
+
+// metricLimit returns the plan limit of a monthly usage metric, or
+// domain.Unlimited for metrics without a quota
+func metricLimit(limits *domain.PlanLimits, metric domain.UsageMetric) int {
+	quota, ok := QuotaForUsageMetric(metric)
+	if !ok {
+		return domain.Unlimited
+	}
+	limit, err := quotaLimit(limits, quota)
+	if err != nil {
+		return domain.Unlimited
+	}
+	return limit
+}
+
+// validateOverage checks the overage prices of plan limits: only AI requests and
+// messages with a limit can be billed beyond it
+func validateOverage(limits *domain.PlanLimits) error {
+	for metric, price := range limits.Overage {
+		if _, ok := overageMetricNames[metric]; !ok {
+			return fmt.Errorf("%w: overage can only be billed for ai_requests and messages, not %q", ErrInvalidPlan, metric)
+		}
+		if metricLimit(limits, metric) == domain.Unlimited {
+			return fmt.Errorf("%w: overage of %s needs a limit to bill beyond", ErrInvalidPlan, metric)
+		}
+		if price.UnitPrice <= 0 || price.SpendingCap < price.UnitPrice {
+			return fmt.Errorf("%w: overage of %s needs a positive unit_price and a spending_cap of at least one unit", ErrInvalidPlan, metric)
+		}
+	}
+	return nil
+}
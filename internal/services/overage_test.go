@@ -0,0 +1,105 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+)
+
+func TestOverageCharge(t *testing.T) {
+	price := domain.OveragePrice{UnitPrice: 0.30, SpendingCap: 60}
+	if got := price.MaxUnits(); got != 200 {
+		t.Fatalf("MaxUnits() = %d, want 200", got)
+	}
+
+	tests := []struct {
+		used       int64
+		wantUnits  int64
+		wantAmount float64
+	}{
+		{80, 0, 0},
+		{100, 0, 0},
+		{150, 50, 15},
+		{300, 200, 60},
+		{1000, 200, 60}, // usage past the cap is not billed
+	}
+	for _, tt := range tests {
+		units, amount := overageCharge(100, tt.used, price)
+		if units != tt.wantUnits || amount != tt.wantAmount {
+			t.Errorf("overageCharge(100, %d) = %d, %v, want %d, %v", tt.used, units, amount, tt.wantUnits, tt.wantAmount)
+		}
+	}
+}
+
+func TestOverageLines(t *testing.T) {
+	plan := &domain.Plan{Limits: domain.PlanLimits{
+		AIRequestsMonth: 500,
+		MessagesMonth:   1000,
+		Overage: map[domain.UsageMetric]domain.OveragePrice{
+			domain.UsageMetricAIRequests: {UnitPrice: 0.25, SpendingCap: 50},
+		},
+	}}
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	records := []domain.UsageRecord{
+		{PeriodStart: start, PeriodEnd: start.AddDate(0, 1, 0), Metric: domain.UsageMetricAIRequests, Value: 620},
+		// Messages are not billed beyond the limit by this plan
+		{PeriodStart: start, PeriodEnd: start.AddDate(0, 1, 0), Metric: domain.UsageMetricMessages, Value: 1500},
+	}
+
+	lines := overageLines(&planHistory{current: plan}, records)
+	if len(lines) != 1 {
+		t.Fatalf("overageLines() = %+v, want one line", lines)
+	}
+	line := lines[0]
+	if line.Kind != domain.InvoiceLineKindOverage || line.Quantity != 120 || line.UnitPrice != 0.25 || line.Amount != 30 {
+		t.Errorf("line = %+v", line)
+	}
+}
+
+func TestOverageLinesPlanHistory(t *testing.T) {
+	priced := &domain.Plan{BaseModel: domain.BaseModel{ID: uuid.New()}, Limits: domain.PlanLimits{
+		AIRequestsMonth: 500,
+		Overage: map[domain.UsageMetric]domain.OveragePrice{
+			domain.UsageMetricAIRequests: {UnitPrice: 0.25, SpendingCap: 50},
+		},
+	}}
+	unlimited := &domain.Plan{BaseModel: domain.BaseModel{ID: uuid.New()}, Limits: domain.PlanLimits{AIRequestsMonth: domain.Unlimited}}
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := march.AddDate(0, 1, 0)
+	history := &planHistory{
+		current: unlimited,
+		changes: []domain.PlanChange{{FromPlanID: priced.ID, ToPlanID: unlimited.ID, EffectiveAt: april}},
+		plans:   map[uuid.UUID]*domain.Plan{priced.ID: priced, unlimited.ID: unlimited},
+	}
+	records := []domain.UsageRecord{
+		{PeriodStart: march, PeriodEnd: april, Metric: domain.UsageMetricAIRequests, Value: 620},
+		{PeriodStart: april, PeriodEnd: april.AddDate(0, 1, 0), Metric: domain.UsageMetricAIRequests, Value: 620},
+	}
+
+	// March was on the plan billing overage, April on the unlimited one
+	lines := overageLines(history, records)
+	if len(lines) != 1 || lines[0].Amount != 30 || !strings.Contains(lines[0].Description, "01/03/2024") {
+		t.Errorf("overageLines() = %+v, want the March overage only", lines)
+	}
+}
+
+func TestProjectOverage(t *testing.T) {
+	price := domain.OveragePrice{UnitPrice: 0.5, SpendingCap: 100}
+	length := 30 * 24 * time.Hour
+
+	// A third into the period, at 600 of 1000: 1800 by the end of the period
+	overage := projectOverage(1000, 600, price, length/3, length)
+	if overage.Overage != 0 || overage.Amount != 0 {
+		t.Errorf("overage so far = %d, %v, want none", overage.Overage, overage.Amount)
+	}
+	if overage.ProjectedUsed != 1800 || overage.ProjectedOverage != 200 || overage.ProjectedAmount != 100 {
+		t.Errorf("projection = %+v", overage)
+	}
+
+	if overage := projectOverage(1000, 50, price, time.Minute, length); overage.ProjectedUsed != 50 {
+		t.Errorf("first hour extrapolated: ProjectedUsed = %d, want 50", overage.ProjectedUsed)
+	}
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/payments"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"github.com/opiagile/direito-lux/pkg/qrcode"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPaymentMethodDisabled = errors.New("payment method not configured")
+	ErrInvoiceNotPayable     = errors.New("invoice cannot be paid")
+)
+
+// Subscription payment methods
+const (
+	PaymentMethodCard   = "card"
+	PaymentMethodPix    = payments.MethodPix
+	PaymentMethodBoleto = payments.MethodBoleto
+)
+
+// qrCodeScale is the size in pixels of a module of the Pix QR code images
+const qrCodeScale = 8
+
+// InvoicePix is the Pix charge of an invoice
+type InvoicePix struct {
+	InvoiceID uuid.UUID `json:"invoice_id"`
+	Number    string    `json:"number"`
+	TxID      string    `json:"txid"`
+	Amount    float64   `json:"amount"`
+	Payload   string    `json:"payload"` // Pix copia e cola, also encoded in the QR code
+}
+
+// InvoiceBoleto is the boleto of an invoice
+type InvoiceBoleto struct {
+	InvoiceID      uuid.UUID `json:"invoice_id"`
+	Number         string    `json:"number"`
+	BankCode       string    `json:"bank_code"`
+	OurNumber      string    `json:"our_number"`
+	Amount         float64   `json:"amount"`
+	DueDate        time.Time `json:"due_date"`
+	Barcode        string    `json:"barcode"`
+	LinhaDigitavel string    `json:"linha_digitavel"`
+}
+
+// SetPaymentMethodRequest selects how a tenant pays its invoices; cards are set
+// up with SetupPayment
+type SetPaymentMethodRequest struct {
+	Method string `json:"method" binding:"required,oneof=pix boleto"`
+}
+
+// SetPaymentMethod makes a tenant pay by Pix or boleto. A card subscription at
+// the gateway is canceled so that the tenant is not charged twice.
+func (s *PaymentService) SetPaymentMethod(ctx context.Context, tenantID uuid.UUID, req *SetPaymentMethodRequest, actorID string) (*domain.Subscription, error) {
+	if !s.methodEnabled(req.Method) {
+		return nil, ErrPaymentMethodDisabled
+	}
+
+	ctx = domain.WithTenantID(ctx, tenantID)
+	subscription, err := s.subscriptions.GetSubscription(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	from := subscription.PaymentMethod
+	if subscription.StripeSubID != "" && s.gateway != nil {
+		if err := s.gateway.CancelSubscription(ctx, subscription.StripeSubID); err != nil {
+			return nil, err
+		}
+		subscription.StripeSubID = ""
+	}
+	subscription.PaymentMethod = req.Method
+	if err := s.db.WithContext(ctx).Model(subscription).Select("payment_method", "stripe_sub_id").Updates(subscription).Error; err != nil {
+		return nil, err
+	}
+
+	s.subscriptions.audit(ctx, subscription, "subscription.payment_method_changed", map[string]interface{}{
+		"from":  from,
+		"to":    req.Method,
+		"actor": actorID,
+	})
+	return subscription, nil
+}
+
+// InvoicePix returns the Pix charge of an open invoice
+func (s *PaymentService) InvoicePix(ctx context.Context, tenantID, invoiceID uuid.UUID) (*InvoicePix, error) {
+	if !s.methodEnabled(PaymentMethodPix) {
+		return nil, ErrPaymentMethodDisabled
+	}
+	invoice, err := s.payableInvoice(ctx, tenantID, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	charge := &payments.PixCharge{
+		Key:          s.cfg.Pix.Key,
+		MerchantName: s.cfg.Pix.MerchantName,
+		MerchantCity: s.cfg.Pix.MerchantCity,
+		Amount:       invoiceCents(invoice),
+		TxID:         invoice.PixTxID,
+		Description:  "Fatura " + invoice.Number,
+	}
+	payload, err := charge.Payload()
+	if err != nil {
+		return nil, err
+	}
+
+	return &InvoicePix{
+		InvoiceID: invoice.ID,
+		Number:    invoice.Number,
+		TxID:      invoice.PixTxID,
+		Amount:    invoice.Total,
+		Payload:   payload,
+	}, nil
+}
+
+// InvoicePixQRCode renders the Pix charge of an open invoice as a PNG QR code
+func (s *PaymentService) InvoicePixQRCode(ctx context.Context, tenantID, invoiceID uuid.UUID) (*InvoicePix, []byte, error) {
+	pix, err := s.InvoicePix(ctx, tenantID, invoiceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	code, err := qrcode.Encode([]byte(pix.Payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	image, err := code.PNG(qrCodeScale)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pix, image, nil
+}
+
+// InvoiceBoleto returns the boleto of an open invoice, due on the invoice due date
+func (s *PaymentService) InvoiceBoleto(ctx context.Context, tenantID, invoiceID uuid.UUID) (*InvoiceBoleto, error) {
+	if !s.methodEnabled(PaymentMethodBoleto) {
+		return nil, ErrPaymentMethodDisabled
+	}
+	invoice, err := s.payableInvoice(ctx, tenantID, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	ourNumber, err := strconv.ParseInt(invoice.BoletoNumber, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid boleto number %q: %w", invoice.BoletoNumber, err)
+	}
+	freeField, err := payments.BradescoFreeField(s.cfg.Boleto.Agency, s.cfg.Boleto.Wallet, ourNumber, s.cfg.Boleto.Account)
+	if err != nil {
+		return nil, err
+	}
+	boleto := &payments.Boleto{
+		BankCode:  s.cfg.Boleto.BankCode,
+		DueDate:   *invoice.DueAt,
+		Amount:    invoiceCents(invoice),
+		FreeField: freeField,
+	}
+	barcode, err := boleto.Barcode()
+	if err != nil {
+		return nil, err
+	}
+	line, err := payments.LinhaDigitavel(barcode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &InvoiceBoleto{
+		InvoiceID:      invoice.ID,
+		Number:         invoice.Number,
+		BankCode:       boleto.BankCode,
+		OurNumber:      invoice.BoletoNumber,
+		Amount:         invoice.Total,
+		DueDate:        boleto.DueDate,
+		Barcode:        barcode,
+		LinhaDigitavel: line,
+	}, nil
+}
+
+// HandleConfirmation applies a Pix or boleto payment reported by the bank or PSP.
+// Confirmations already processed are acknowledged without being applied again.
+func (s *PaymentService) HandleConfirmation(ctx context.Context, payload []byte, header http.Header) error {
+	if s.confirmations == nil {
+		return ErrPaymentsDisabled
+	}
+
+	confirmation, err := s.confirmations.ParseConfirmation(payload, header)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWebhookEvent, err)
+	}
+
+	return s.processOnce(ctx, s.confirmations.Name(), confirmation.ID, confirmation.Method, func(tx *PaymentService) error {
+		return tx.processConfirmation(ctx, confirmation)
+	})
+}
+
+func (s *PaymentService) processConfirmation(ctx context.Context, confirmation *payments.Confirmation) error {
+	column := "pix_tx_id"
+	if confirmation.Method == payments.MethodBoleto {
+		column = "boleto_number"
+	}
+
+	var invoice domain.Invoice
+	err := s.db.WithContext(domain.CrossTenant(ctx)).
+		Where(column+" = ?", confirmation.Reference).
+		Order("issued_at DESC").
+		First(&invoice).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Warn("Payment confirmation for unknown invoice",
+			zap.String("method", confirmation.Method),
+			zap.String("reference", confirmation.Reference))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	source := s.confirmations.Name()
+	if confirmation.Amount < invoiceCents(&invoice) {
+		// Left open for the finance team to settle by hand
+		s.invoices.audit(ctx, &invoice, "invoice.underpaid", map[string]interface{}{
+			"number":  invoice.Number,
+			"method":  confirmation.Method,
+			"amount":  float64(confirmation.Amount) / 100,
+			"payment": confirmation.ID,
+			"actor":   source,
+		})
+		logger.Warn("Payment below the invoice total",
+			zap.String("number", invoice.Number),
+			zap.Int64("amount", confirmation.Amount))
+		return nil
+	}
+
+	if _, err := s.invoices.MarkPaid(ctx, invoice.TenantID, invoice.ID, source+":"+confirmation.Method); err != nil {
+		if errors.Is(err, ErrInvalidInvoiceTransition) {
+			logger.Warn("Payment confirmation for an invoice not open",
+				zap.String("number", invoice.Number),
+				zap.String("status", string(invoice.Status)))
+			return nil
+		}
+		return err
+	}
+
+	// The subscription stays past due while other invoices are overdue
+	var overdue int64
+	err = s.db.WithContext(domain.WithTenantID(ctx, invoice.TenantID)).Model(&domain.Invoice{}).
+		Where("tenant_id = ? AND status = ? AND due_at < ?", invoice.TenantID, domain.InvoiceStatusOpen, time.Now()).
+		Count(&overdue).Error
+	if err != nil || overdue > 0 {
+		return err
+	}
+
+	subscription, err := s.subscriptions.GetSubscription(ctx, invoice.TenantID)
+	if err != nil {
+		return err
+	}
+	return s.restoreSubscription(ctx, subscription, "payment:"+confirmation.Method, source)
+}
+
+func (s *PaymentService) methodEnabled(method string) bool {
+	switch method {
+	case PaymentMethodPix:
+		return s.cfg.Pix.Key != ""
+	case PaymentMethodBoleto:
+		return s.cfg.Boleto.Agency != "" && s.cfg.Boleto.Account != ""
+	}
+	return false
+}
+
+// payableInvoice returns an open BRL invoice of the tenant, with its payment
+// references set
+func (s *PaymentService) payableInvoice(ctx context.Context, tenantID, invoiceID uuid.UUID) (*domain.Invoice, error) {
+	invoice, err := s.invoices.GetInvoice(ctx, tenantID, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.Status != domain.InvoiceStatusOpen {
+		return nil, fmt.Errorf("%w: invoice is %s", ErrInvoiceNotPayable, invoice.Status)
+	}
+	if invoice.Currency != "BRL" {
+		return nil, fmt.Errorf("%w: Pix and boleto only charge BRL", ErrInvoiceNotPayable)
+	}
+	if invoice.ChargedBy != "" {
+		return nil, fmt.Errorf("%w: invoice is charged to the card", ErrInvoiceNotPayable)
+	}
+	if invoice.PixTxID == "" {
+		invoice.PixTxID, invoice.BoletoNumber = invoicePaymentReferences(invoice.Number)
+		err := s.db.WithContext(domain.WithTenantID(ctx, tenantID)).Model(invoice).
+			Select("pix_tx_id", "boleto_number").
+			Updates(invoice).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return invoice, nil
+}
+
+func invoiceCents(invoice *domain.Invoice) int64 {
+	return int64(math.Round(invoice.Total * 100))
+}
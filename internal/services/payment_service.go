@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/config"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/payments"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPaymentsDisabled    = errors.New("payments are not enabled")
+	ErrPaymentAlreadySetUp = errors.New("subscription already billed by the payment gateway")
+	ErrPlanNotPayable      = errors.New("plan has no payment gateway price")
+	ErrInvalidWebhookEvent = errors.New("invalid webhook event")
+)
+
+// gatewaySubscriptionStatuses maps the gateway subscription statuses to ours;
+// incomplete subscriptions failed their first charge
+var gatewaySubscriptionStatuses = map[string]domain.SubscriptionStatus{
+	"trialing":           domain.SubscriptionStatusTrialing,
+	"active":             domain.SubscriptionStatusActive,
+	"past_due":           domain.SubscriptionStatusPastDue,
+	"unpaid":             domain.SubscriptionStatusUnpaid,
+	"canceled":           domain.SubscriptionStatusCanceled,
+	"incomplete":         domain.SubscriptionStatusPastDue,
+	"incomplete_expired": domain.SubscriptionStatusCanceled,
+}
+
+// PaymentService charges subscriptions by card through a payment gateway, and
+// invoices by Pix or boleto, and applies the payments the webhooks report
+type PaymentService struct {
+	db            *gorm.DB
+	gateway       payments.Gateway
+	confirmations payments.ConfirmationProvider
+	subscriptions *SubscriptionService
+	invoices      *InvoiceService
+	cfg           config.PaymentsConfig
+}
+
+// NewPaymentService creates the service; a nil gateway disables card payments and
+// a nil confirmation provider the Pix and boleto confirmations
+func NewPaymentService(db *gorm.DB, gateway payments.Gateway, confirmations payments.ConfirmationProvider, subscriptions *SubscriptionService, invoices *InvoiceService, cfg *config.PaymentsConfig) *PaymentService {
+	return &PaymentService{
+		db:            db,
+		gateway:       gateway,
+		confirmations: confirmations,
+		subscriptions: subscriptions,
+		invoices:      invoices,
+		cfg:           *cfg,
+	}
+}
+
+// SetupPaymentRequest sets the card charging a subscription, tokenized by the
+// gateway in the browser
+type SetupPaymentRequest struct {
+	PaymentMethodID string `json:"payment_method_id" binding:"required"`
+}
+
+// SetupPayment creates the gateway customer and subscription of a tenant, charged
+// to the payment method. Trials are charged when they end.
+func (s *PaymentService) SetupPayment(ctx context.Context, tenantID uuid.UUID, req *SetupPaymentRequest, actorID string) (*domain.Subscription, error) {
+	if s.gateway == nil {
+		return nil, ErrPaymentsDisabled
+	}
+
+	ctx = domain.WithTenantID(ctx, tenantID)
+	subscription, err := s.subscriptions.GetSubscription(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.StripeSubID != "" && subscription.Status != domain.SubscriptionStatusCanceled {
+		return nil, ErrPaymentAlreadySetUp
+	}
+
+	var plan domain.Plan
+	if err := s.db.WithContext(ctx).First(&plan, subscription.PlanID).Error; err != nil {
+		return nil, err
+	}
+	if plan.StripePriceID == "" {
+		return nil, ErrPlanNotPayable
+	}
+
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).First(&tenant, tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+
+	if subscription.StripeCustomerID == "" {
+		customerID, err := s.gateway.CreateCustomer(ctx, s.customerParams(ctx, &tenant))
+		if err != nil {
+			return nil, err
+		}
+		// Saved at once so that a failure below does not create another customer
+		subscription.StripeCustomerID = customerID
+		if err := s.db.WithContext(ctx).Model(subscription).Select("stripe_customer_id").Updates(subscription).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.gateway.AttachPaymentMethod(ctx, subscription.StripeCustomerID, req.PaymentMethodID); err != nil {
+		return nil, err
+	}
+
+	params := payments.SubscriptionParams{
+		CustomerID:      subscription.StripeCustomerID,
+		PriceID:         plan.StripePriceID,
+		PaymentMethodID: req.PaymentMethodID,
+		TenantID:        tenantID.String(),
+		IdempotencyKey:  fmt.Sprintf("subscription-%s-%s", subscription.ID, req.PaymentMethodID),
+	}
+	if subscription.Status == domain.SubscriptionStatusTrialing {
+		params.TrialEnd = subscription.TrialEndsAt
+	}
+	gatewaySubscription, err := s.gateway.CreateSubscription(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription.StripeSubID = gatewaySubscription.ID
+	subscription.PaymentMethod = PaymentMethodCard
+	if err := s.db.WithContext(ctx).Model(subscription).Select("stripe_sub_id", "payment_method").Updates(subscription).Error; err != nil {
+		return nil, err
+	}
+
+	s.subscriptions.audit(ctx, subscription, "subscription.payment_setup", map[string]interface{}{
+		"gateway":                 s.gateway.Name(),
+		"gateway_subscription_id": gatewaySubscription.ID,
+		"actor":                   actorID,
+	})
+
+	if updated, err := s.applyGatewayStatus(ctx, subscription, gatewaySubscription.Status, "payment_setup"); err == nil && updated != nil {
+		subscription = updated
+	}
+	return subscription, nil
+}
+
+func (s *PaymentService) customerParams(ctx context.Context, tenant *domain.Tenant) payments.CustomerParams {
+	params := payments.CustomerParams{
+		TenantID:       tenant.ID.String(),
+		Name:           tenant.LegalEntity.LegalName,
+		Email:          tenant.LegalEntity.Email,
+		IdempotencyKey: "customer-" + tenant.ID.String(),
+	}
+	if params.Name == "" {
+		params.Name = tenant.DisplayName
+	}
+	if params.Email == "" {
+		if admins, err := s.subscriptions.tenantAdmins(ctx, tenant.ID); err == nil && len(admins) > 0 {
+			params.Email = admins[0]
+		}
+	}
+	return params
+}
+
+// HandleWebhook verifies and applies a gateway webhook event. Events already
+// processed are acknowledged without being applied again.
+func (s *PaymentService) HandleWebhook(ctx context.Context, payload []byte, header http.Header) error {
+	if s.gateway == nil {
+		return ErrPaymentsDisabled
+	}
+
+	event, err := s.gateway.ParseWebhook(payload, header)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWebhookEvent, err)
+	}
+	if event.ID == "" {
+		return fmt.Errorf("%w: missing event id", ErrInvalidWebhookEvent)
+	}
+
+	return s.processOnce(ctx, s.gateway.Name(), event.ID, event.RawType, func(tx *PaymentService) error {
+		return tx.processEvent(ctx, event)
+	})
+}
+
+// processOnce runs process unless the event was already processed. The event is
+// recorded in the transaction applying it, so an event that fails is rolled back
+// with its effects and its redelivery retries it.
+func (s *PaymentService) processOnce(ctx context.Context, source, eventID, eventType string, process func(tx *PaymentService) error) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		record := &domain.PaymentEvent{Gateway: source, EventID: eventID, Type: eventType}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			logger.Info("Duplicate payment event ignored",
+				zap.String("gateway", source),
+				zap.String("eventID", eventID))
+			return nil
+		}
+		return process(s.withDB(tx))
+	})
+}
+
+// withDB returns a copy of the service, and of the services it writes through,
+// bound to db, e.g. a transaction
+func (s *PaymentService) withDB(db *gorm.DB) *PaymentService {
+	subscriptions := *s.subscriptions
+	subscriptions.db = db
+	invoices := *s.invoices
+	invoices.db = db
+	invoices.subscriptions = &subscriptions
+
+	service := *s
+	service.db = db
+	service.subscriptions = &subscriptions
+	service.invoices = &invoices
+	return &service
+}
+
+func (s *PaymentService) processEvent(ctx context.Context, event *payments.Event) error {
+	switch event.Type {
+	case payments.EventSubscriptionUpdated, payments.EventSubscriptionDeleted:
+		subscription, err := s.subscriptionByGatewayID(ctx, event.Subscription.ID)
+		if err != nil || subscription == nil {
+			return err
+		}
+		status := event.Subscription.Status
+		if event.Type == payments.EventSubscriptionDeleted {
+			status = "canceled"
+		}
+		_, err = s.applyGatewayStatus(ctx, subscription, status, event.RawType)
+		return err
+
+	case payments.EventInvoicePaid:
+		subscription, err := s.subscriptionByGatewayID(ctx, event.Payment.SubscriptionID)
+		if err != nil || subscription == nil {
+			return err
+		}
+		if err := s.restoreSubscription(ctx, subscription, "gateway:"+event.RawType, s.gateway.Name()); err != nil {
+			return err
+		}
+		if event.Payment.Amount > 0 {
+			settled, err := s.invoices.SettleOpenInvoices(ctx, subscription.TenantID, float64(event.Payment.Amount)/100, event.Payment.Currency, s.gateway.Name())
+			if err != nil {
+				return err
+			}
+			logger.Info("Gateway payment received",
+				zap.String("tenantID", subscription.TenantID.String()),
+				zap.String("gatewayInvoiceID", event.Payment.InvoiceID),
+				zap.Int64("amount", event.Payment.Amount),
+				zap.Int("invoicesSettled", len(settled)))
+		}
+		return nil
+
+	case payments.EventInvoicePaymentFailed:
+		subscription, err := s.subscriptionByGatewayID(ctx, event.Payment.SubscriptionID)
+		if err != nil || subscription == nil {
+			return err
+		}
+		if subscription.Status == domain.SubscriptionStatusActive || subscription.Status == domain.SubscriptionStatusTrialing {
+			updated, err := s.applyGatewayStatus(ctx, subscription, "past_due", event.RawType)
+			if err != nil {
+				return err
+			}
+			if updated != nil {
+				subscription = updated
+			}
+		}
+		// Retried by dunning while the subscription is past due
+		if event.Payment.InvoiceID != "" && subscription.PastDueSince != nil {
+			subscription.StripeInvoiceID = event.Payment.InvoiceID
+			return s.db.WithContext(domain.WithTenantID(ctx, subscription.TenantID)).Model(subscription).
+				Select("stripe_invoice_id").
+				Updates(subscription).Error
+		}
+		return nil
+	}
+	return nil
+}
+
+// subscriptionByGatewayID returns the subscription billed by a gateway
+// subscription, or nil for subscriptions unknown here, e.g. of another environment
+func (s *PaymentService) subscriptionByGatewayID(ctx context.Context, gatewayID string) (*domain.Subscription, error) {
+	if gatewayID == "" {
+		return nil, nil
+	}
+	var subscription domain.Subscription
+	err := s.db.WithContext(domain.CrossTenant(ctx)).Where("stripe_sub_id = ?", gatewayID).First(&subscription).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Warn("Payment event for unknown subscription", zap.String("gatewaySubscriptionID", gatewayID))
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// restoreSubscription returns a past due or unpaid subscription to good standing
+// once a payment arrives
+func (s *PaymentService) restoreSubscription(ctx context.Context, subscription *domain.Subscription, reason, actorID string) error {
+	if subscription.Status != domain.SubscriptionStatusPastDue && subscription.Status != domain.SubscriptionStatusUnpaid {
+		return nil
+	}
+	_, err := s.applyStatus(ctx, subscription, domain.SubscriptionStatusActive, reason, actorID)
+	return err
+}
+
+// applyGatewayStatus moves a subscription to the status reported by the gateway.
+// Moves our lifecycle does not allow are logged and skipped rather than failing
+// the event, since the gateway would retry it forever.
+func (s *PaymentService) applyGatewayStatus(ctx context.Context, subscription *domain.Subscription, gatewayStatus, reason string) (*domain.Subscription, error) {
+	to, ok := gatewaySubscriptionStatuses[gatewayStatus]
+	if !ok {
+		return nil, nil
+	}
+	return s.applyStatus(ctx, subscription, to, "gateway:"+reason, s.gateway.Name())
+}
+
+func (s *PaymentService) applyStatus(ctx context.Context, subscription *domain.Subscription, to domain.SubscriptionStatus, reason, actorID string) (*domain.Subscription, error) {
+	if to == subscription.Status {
+		return nil, nil
+	}
+	updated, err := s.subscriptions.Transition(ctx, subscription.TenantID, to, reason, actorID)
+	if errors.Is(err, ErrInvalidSubscriptionTransition) {
+		logger.Warn("Ignoring payment subscription status",
+			zap.String("tenantID", subscription.TenantID.String()),
+			zap.String("from", string(subscription.Status)),
+			zap.String("to", string(to)),
+			zap.Error(err))
+		return nil, nil
+	}
+	return updated, err
+}
@@ -0,0 +1,548 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSamePlan              = errors.New("tenant is already on this plan")
+	ErrPlanLimitExceeded     = errors.New("current usage exceeds the plan limits")
+	ErrPlanChangeNotFound    = errors.New("no scheduled plan change")
+	ErrSubscriptionNotActive = errors.New("subscription does not allow plan changes")
+	ErrPlanCurrencyMismatch  = errors.New("plan is billed in another currency")
+)
+
+// ChangePlanRequest asks for a plan change, immediate unless timing is cycle_end
+type ChangePlanRequest struct {
+	PlanID string                  `json:"plan_id" binding:"required,uuid"`
+	Timing domain.PlanChangeTiming `json:"timing" binding:"omitempty,oneof=immediate cycle_end"`
+}
+
+// PlanLimitViolation is a limit of the target plan the current usage exceeds
+type PlanLimitViolation struct {
+	Limit   string `json:"limit"`
+	Current int64  `json:"current"`
+	Allowed int    `json:"allowed"`
+}
+
+// PlanLimitError lists the limits that block a downgrade
+type PlanLimitError struct {
+	Plan       string
+	Violations []PlanLimitViolation
+}
+
+func (e *PlanLimitError) Error() string {
+	return fmt.Sprintf("usage exceeds plan %s limits: %s", e.Plan, e.describeViolations())
+}
+
+func (e *PlanLimitError) describeViolations() string {
+	limits := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		limits[i] = fmt.Sprintf("%s %d/%d", v.Limit, v.Current, v.Allowed)
+	}
+	return strings.Join(limits, ", ")
+}
+
+func (e *PlanLimitError) Unwrap() error {
+	return ErrPlanLimitExceeded
+}
+
+// planChangeableStatuses are the subscription statuses a plan can be changed in;
+// unpaid and canceled subscriptions must be settled first
+var planChangeableStatuses = map[domain.SubscriptionStatus]bool{
+	domain.SubscriptionStatusTrialing: true,
+	domain.SubscriptionStatusActive:   true,
+	domain.SubscriptionStatusPastDue:  true,
+}
+
+// billingPeriod is the span of a subscription charge
+type billingPeriod struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ChangePlan moves a tenant to another plan, prorating the rest of the current
+// billing period, or schedules the move for the end of the period. Downgrades
+// are refused while the usage exceeds the target plan limits.
+func (s *SubscriptionService) ChangePlan(ctx context.Context, tenantID uuid.UUID, req *ChangePlanRequest, actorID string) (*domain.PlanChange, error) {
+	ctx = domain.WithTenantID(ctx, tenantID)
+
+	planID, err := uuid.Parse(req.PlanID)
+	if err != nil {
+		return nil, ErrPlanNotFound
+	}
+	timing := req.Timing
+	if timing == "" {
+		timing = domain.PlanChangeImmediate
+	}
+
+	tenant, subscription, err := s.tenantSubscription(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if !planChangeableStatuses[subscription.Status] {
+		return nil, fmt.Errorf("%w: %s", ErrSubscriptionNotActive, subscription.Status)
+	}
+
+	var target domain.Plan
+	if err := s.db.WithContext(ctx).Where("id = ? AND is_active = ?", planID, true).First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlanNotFound
+		}
+		return nil, err
+	}
+	if target.ID == tenant.PlanID {
+		return nil, ErrSamePlan
+	}
+	// Credits and charges of the change are netted on one invoice
+	if target.Currency != tenant.Plan.Currency {
+		return nil, fmt.Errorf("%w: %s to %s", ErrPlanCurrencyMismatch, tenant.Plan.Currency, target.Currency)
+	}
+
+	immediate := timing == domain.PlanChangeImmediate || subscription.Status == domain.SubscriptionStatusTrialing
+	if err := s.checkPlanLimits(ctx, tenant, &target, immediate); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	period := currentBillingPeriod(subscription, tenant.Plan.BillingCycle, now)
+	change := &domain.PlanChange{
+		TenantID:       tenantID,
+		SubscriptionID: subscription.ID,
+		FromPlanID:     tenant.PlanID,
+		ToPlanID:       target.ID,
+		Timing:         timing,
+		Currency:       target.Currency,
+		RequestedBy:    actorID,
+	}
+
+	// Trials are not charged, the new plan simply replaces the old one
+	if timing == domain.PlanChangeCycleEnd && subscription.Status != domain.SubscriptionStatusTrialing {
+		change.Status = domain.PlanChangeStatusScheduled
+		change.EffectiveAt = period.End
+		if err := s.schedulePlanChange(ctx, subscription, change); err != nil {
+			return nil, err
+		}
+
+		s.audit(ctx, subscription, "subscription.plan_change_scheduled", map[string]interface{}{
+			"from_plan":    tenant.Plan.Name,
+			"to_plan":      target.Name,
+			"effective_at": change.EffectiveAt,
+			"actor":        actorID,
+		})
+		return change, nil
+	}
+
+	change.Timing = domain.PlanChangeImmediate
+	change.EffectiveAt = now
+	newPeriod := period
+	if subscription.Status != domain.SubscriptionStatusTrialing {
+		var proratedPeriod billingPeriod
+		change.Credit, change.Charge, proratedPeriod = prorate(&tenant.Plan, &target, period, now)
+		change.Amount = roundCents(change.Charge - change.Credit)
+		newPeriod = proratedPeriod
+	}
+
+	if err := s.applyPlanChange(ctx, tenant, subscription, &target, change, newPeriod, subscription.Status != domain.SubscriptionStatusTrialing); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// CancelScheduledPlanChange drops the plan change waiting for the end of the period
+func (s *SubscriptionService) CancelScheduledPlanChange(ctx context.Context, tenantID uuid.UUID, actorID string) error {
+	ctx = domain.WithTenantID(ctx, tenantID)
+	subscription, err := s.GetSubscription(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+
+	var change domain.PlanChange
+	err = s.db.WithContext(ctx).
+		Where("tenant_id = ? AND status = ?", tenantID, domain.PlanChangeStatusScheduled).
+		First(&change).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPlanChangeNotFound
+		}
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&change).Update("status", domain.PlanChangeStatusCanceled).Error; err != nil {
+			return err
+		}
+		subscription.PendingPlanID = nil
+		return tx.Model(subscription).Select("pending_plan_id").Updates(subscription).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.audit(ctx, subscription, "subscription.plan_change_canceled", map[string]interface{}{
+		"plan_change_id": change.ID,
+		"actor":          actorID,
+	})
+	return nil
+}
+
+// ListPlanChanges returns the plan changes of a tenant, newest first
+func (s *SubscriptionService) ListPlanChanges(ctx context.Context, tenantID uuid.UUID) ([]domain.PlanChange, error) {
+	var changes []domain.PlanChange
+	err := s.db.WithContext(domain.WithTenantID(ctx, tenantID)).
+		Where("tenant_id = ?", tenantID).
+		Order("created_at DESC").
+		Find(&changes).Error
+	return changes, err
+}
+
+// ApplyScheduledPlanChanges applies the plan changes whose period ended. A tenant
+// that outgrew the target plan meanwhile keeps its plan and its admins are notified.
+func (s *SubscriptionService) ApplyScheduledPlanChanges(ctx context.Context) error {
+	var changes []domain.PlanChange
+	err := s.db.WithContext(domain.CrossTenant(ctx)).
+		Where("status = ? AND effective_at <= ?", domain.PlanChangeStatusScheduled, time.Now()).
+		Find(&changes).Error
+	if err != nil {
+		return fmt.Errorf("failed to list scheduled plan changes: %w", err)
+	}
+
+	for i := range changes {
+		if err := s.applyScheduledPlanChange(ctx, &changes[i]); err != nil {
+			logger.Error("Failed to apply scheduled plan change",
+				zap.String("tenantID", changes[i].TenantID.String()),
+				zap.String("planChangeID", changes[i].ID.String()),
+				zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (s *SubscriptionService) applyScheduledPlanChange(ctx context.Context, change *domain.PlanChange) error {
+	ctx = domain.WithTenantID(ctx, change.TenantID)
+	tenant, subscription, err := s.tenantSubscription(ctx, change.TenantID)
+	if err != nil {
+		return err
+	}
+
+	var target domain.Plan
+	if err := s.db.WithContext(ctx).First(&target, change.ToPlanID).Error; err != nil {
+		return err
+	}
+
+	if err := s.checkPlanLimits(ctx, tenant, &target, false); err != nil {
+		var limitErr *PlanLimitError
+		if !errors.As(err, &limitErr) {
+			return err
+		}
+		return s.failPlanChange(ctx, subscription, change, limitErr)
+	}
+
+	// The new plan is billed for a full period from the end of the old one
+	period := billingPeriod{Start: change.EffectiveAt, End: addBillingCycle(change.EffectiveAt, target.BillingCycle)}
+	return s.applyPlanChange(ctx, tenant, subscription, &target, change, period, true)
+}
+
+func (s *SubscriptionService) failPlanChange(ctx context.Context, subscription *domain.Subscription, change *domain.PlanChange, limitErr *PlanLimitError) error {
+	change.Status = domain.PlanChangeStatusFailed
+	change.Error = limitErr.Error()
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(change).Select("status", "error").Updates(change).Error; err != nil {
+			return err
+		}
+		subscription.PendingPlanID = nil
+		return tx.Model(subscription).Select("pending_plan_id").Updates(subscription).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.audit(ctx, subscription, "subscription.plan_change_failed", map[string]interface{}{
+		"plan_change_id": change.ID,
+		"violations":     limitErr.Violations,
+	})
+
+	admins, err := s.tenantAdmins(ctx, change.TenantID)
+	if err != nil || s.notifier == nil || len(admins) == 0 {
+		return err
+	}
+	return s.notifier.Notify(ctx, Notification{
+		TenantID:   change.TenantID,
+		Type:       "subscription.plan_change_failed",
+		Recipients: admins,
+		Subject:    "Your plan change could not be applied",
+		Body: fmt.Sprintf("The scheduled change to the %s plan was not applied because the current usage exceeds its limits (%s). Reduce the usage and request the change again.",
+			limitErr.Plan, limitErr.describeViolations()),
+	})
+}
+
+// schedulePlanChange records a change for the end of the period, replacing a
+// change scheduled before
+func (s *SubscriptionService) schedulePlanChange(ctx context.Context, subscription *domain.Subscription, change *domain.PlanChange) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&domain.PlanChange{}).
+			Where("tenant_id = ? AND status = ?", change.TenantID, domain.PlanChangeStatusScheduled).
+			Update("status", domain.PlanChangeStatusCanceled).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(change).Error; err != nil {
+			return err
+		}
+		subscription.PendingPlanID = &change.ToPlanID
+		return tx.Model(subscription).Select("pending_plan_id").Updates(subscription).Error
+	})
+}
+
+// applyPlanChange switches the tenant and its subscription to the target plan and
+// refreshes the plan seen by Keycloak tokens and the tenant cache
+func (s *SubscriptionService) applyPlanChange(ctx context.Context, tenant *domain.Tenant, subscription *domain.Subscription, target *domain.Plan, change *domain.PlanChange, period billingPeriod, setPeriod bool) error {
+	now := time.Now()
+	change.Status = domain.PlanChangeStatusApplied
+	change.AppliedAt = &now
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(tenant).Update("plan_id", target.ID).Error; err != nil {
+			return err
+		}
+
+		subscription.PlanID = target.ID
+		subscription.PendingPlanID = nil
+		columns := []string{"plan_id", "pending_plan_id"}
+		if setPeriod {
+			subscription.CurrentPeriodStart = &period.Start
+			subscription.CurrentPeriodEnd = &period.End
+			columns = append(columns, "current_period_start", "current_period_end")
+		}
+		if err := tx.Model(subscription).Select(columns).Updates(subscription).Error; err != nil {
+			return err
+		}
+
+		// Any other change waiting for the end of the period is superseded
+		query := tx.Model(&domain.PlanChange{}).Where("tenant_id = ? AND status = ?", tenant.ID, domain.PlanChangeStatusScheduled)
+		if change.ID != uuid.Nil {
+			query = query.Where("id <> ?", change.ID)
+		}
+		if err := query.Update("status", domain.PlanChangeStatusCanceled).Error; err != nil {
+			return err
+		}
+
+		if change.ID == uuid.Nil {
+			if err := tx.Create(change).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Model(change).Select("status", "applied_at").Updates(change).Error; err != nil {
+			return err
+		}
+
+		// The gateway bills the next cycles at the new price; a failure rolls the change back
+		if s.gatewayBilled(subscription) && target.StripePriceID != "" {
+			return s.gateway.ChangeSubscriptionPrice(ctx, subscription.StripeSubID, target.StripePriceID, "plan-change-"+change.ID.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fromPlan := tenant.Plan.Name
+	tenant.PlanID = target.ID
+	tenant.Plan = *target
+	s.syncPlanClaims(ctx, tenant)
+
+	s.audit(ctx, subscription, "subscription.plan_changed", map[string]interface{}{
+		"plan_change_id": change.ID,
+		"from_plan":      fromPlan,
+		"to_plan":        target.Name,
+		"timing":         change.Timing,
+		"amount":         change.Amount,
+		"actor":          change.RequestedBy,
+	})
+
+	logger.Info("Tenant plan changed",
+		zap.String("tenantID", tenant.ID.String()),
+		zap.String("from", fromPlan),
+		zap.String("to", target.Name),
+		zap.Float64("amount", change.Amount))
+
+	return nil
+}
+
+// syncPlanClaims publishes the tenant plan to the Keycloak group, read into the
+// tenant_plan claim of new tokens, and drops the cached TenantRef read by the
+// authorization middleware
+func (s *SubscriptionService) syncPlanClaims(ctx context.Context, tenant *domain.Tenant) {
+	if s.identityProvider != nil {
+		if err := s.identityProvider.SetTenantPlan(ctx, tenant.KeycloakGroupID, tenant.Plan.Name); err != nil {
+			logger.Error("Failed to update tenant plan in Keycloak",
+				zap.String("tenantID", tenant.ID.String()),
+				zap.String("plan", tenant.Plan.Name),
+				zap.Error(err))
+		}
+	}
+	if s.lifecycle != nil {
+		s.lifecycle.forgetTenant(ctx, tenant)
+	}
+}
+
+// quotaPlanLimits names the plan limit of each quota in plan limit violations
+var quotaPlanLimits = map[Quota]string{
+	QuotaAPICalls:   "max_api_calls_month",
+	QuotaAIRequests: "ai_requests_month",
+	QuotaMessages:   "messages_month",
+	QuotaCases:      "max_cases",
+	QuotaClients:    "max_clients",
+	QuotaStorageGB:  "max_storage_gb",
+}
+
+// checkPlanLimits refuses a plan the tenant already outgrew. Monthly allowances
+// only count for changes taking effect now, a change at the end of the period
+// starts them over.
+func (s *SubscriptionService) checkPlanLimits(ctx context.Context, tenant *domain.Tenant, plan *domain.Plan, immediate bool) error {
+	var users int64
+	err := s.db.WithContext(ctx).Model(&domain.User{}).
+		Where("tenant_id = ? AND status <> ?", tenant.ID, domain.UserStatusInactive).
+		Count(&users).Error
+	if err != nil {
+		return err
+	}
+
+	var violations []PlanLimitViolation
+	if !domain.WithinLimit(users, plan.Limits.MaxUsers) {
+		violations = append(violations, PlanLimitViolation{Limit: "max_users", Current: users, Allowed: plan.Limits.MaxUsers})
+	}
+	if tenant.Domain != "" && !plan.Limits.AllowCustomDomain {
+		violations = append(violations, PlanLimitViolation{Limit: "allow_custom_domain", Current: 1, Allowed: 0})
+	}
+	if s.quotas != nil {
+		usage, err := s.quotas.usage(ctx, tenant.ID)
+		if err != nil {
+			return err
+		}
+		violations = append(violations, quotaViolations(&plan.Limits, usage, immediate)...)
+	}
+
+	if len(violations) > 0 {
+		return &PlanLimitError{Plan: plan.Name, Violations: violations}
+	}
+	return nil
+}
+
+// quotaViolations lists the quotas whose usage exceeds the plan limits. Monthly
+// allowances are skipped unless includeMonthly, and so are the ones the plan bills
+// beyond the limit.
+func quotaViolations(limits *domain.PlanLimits, usage map[Quota]int64, includeMonthly bool) []PlanLimitViolation {
+	var violations []PlanLimitViolation
+	for _, quota := range quotas {
+		if quota.Monthly() {
+			if _, billed := limits.Overage[quotaUsageMetrics[quota]]; !includeMonthly || billed {
+				continue
+			}
+		}
+		limit, err := quotaLimit(limits, quota)
+		if err != nil {
+			continue
+		}
+		if !domain.WithinLimit(usage[quota], limit) {
+			violations = append(violations, PlanLimitViolation{Limit: quotaPlanLimits[quota], Current: usage[quota], Allowed: limit})
+		}
+	}
+	return violations
+}
+
+func (s *SubscriptionService) tenantSubscription(ctx context.Context, tenantID uuid.UUID) (*domain.Tenant, *domain.Subscription, error) {
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).Preload("Plan").First(&tenant, tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrTenantNotFound
+		}
+		return nil, nil, err
+	}
+
+	subscription, err := s.GetSubscription(ctx, tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &tenant, subscription, nil
+}
+
+// currentBillingPeriod returns the period containing now. Subscriptions without a
+// recorded period are billed in cycles from the end of the trial, or from the start.
+func currentBillingPeriod(subscription *domain.Subscription, cycle domain.BillingCycle, now time.Time) billingPeriod {
+	if subscription.CurrentPeriodStart != nil && subscription.CurrentPeriodEnd != nil && subscription.CurrentPeriodEnd.After(now) {
+		return billingPeriod{Start: *subscription.CurrentPeriodStart, End: *subscription.CurrentPeriodEnd}
+	}
+
+	start := subscription.StartDate
+	switch {
+	case subscription.CurrentPeriodEnd != nil:
+		start = *subscription.CurrentPeriodEnd
+	case subscription.TrialEndsAt != nil && subscription.TrialEndsAt.After(start):
+		start = *subscription.TrialEndsAt
+	}
+
+	// Cycles are added to the anchor, not chained, so that day 31 anchors keep their day
+	anchor := start
+	for i := 0; ; i++ {
+		start, end := addBillingCycles(anchor, cycle, i), addBillingCycles(anchor, cycle, i+1)
+		if end.After(now) {
+			return billingPeriod{Start: start, End: end}
+		}
+	}
+}
+
+// addBillingCycle returns the end of a period started at t; one-time plans are
+// tracked in monthly periods
+func addBillingCycle(t time.Time, cycle domain.BillingCycle) time.Time {
+	return addBillingCycles(t, cycle, 1)
+}
+
+// addBillingCycles returns the start of the nth period after one started at t
+func addBillingCycles(t time.Time, cycle domain.BillingCycle, n int) time.Time {
+	if cycle == domain.BillingCycleYearly {
+		return addMonths(t, 12*n)
+	}
+	return addMonths(t, n)
+}
+
+// addMonths adds months to t, clamping the day to the end of shorter months: a
+// month after January 31 is February 28 (or 29), where AddDate gives March 2 or 3
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// prorate credits the unused share of the current plan and charges the new plan
+// for the rest of the period. When the billing cycle changes the new plan starts
+// a full period of its own instead.
+func prorate(from, to *domain.Plan, period billingPeriod, now time.Time) (credit, charge float64, newPeriod billingPeriod) {
+	remaining := 0.0
+	if total := period.End.Sub(period.Start); total > 0 {
+		remaining = math.Min(math.Max(float64(period.End.Sub(now))/float64(total), 0), 1)
+	}
+
+	credit = roundCents(from.Price * remaining)
+	if from.BillingCycle != to.BillingCycle {
+		return credit, roundCents(to.Price), billingPeriod{Start: now, End: addBillingCycle(now, to.BillingCycle)}
+	}
+	return credit, roundCents(to.Price * remaining), period
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/opiagile/direito-lux/internal/domain"
+)
+
+func TestProrate(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	period := billingPeriod{Start: start, End: start.AddDate(0, 1, 0)}
+	halfway := start.Add(period.End.Sub(start) / 2)
+
+	starter := &domain.Plan{Price: 100, BillingCycle: domain.BillingCycleMonthly}
+	professional := &domain.Plan{Price: 300, BillingCycle: domain.BillingCycleMonthly}
+	yearly := &domain.Plan{Price: 3000, BillingCycle: domain.BillingCycleYearly}
+
+	credit, charge, newPeriod := prorate(starter, professional, period, halfway)
+	if credit != 50 || charge != 150 || newPeriod != period {
+		t.Errorf("upgrade halfway: credit = %v, charge = %v, period = %v", credit, charge, newPeriod)
+	}
+
+	credit, charge, _ = prorate(professional, starter, period, halfway)
+	if amount := roundCents(charge - credit); amount != -100 {
+		t.Errorf("downgrade halfway: amount = %v, want -100", amount)
+	}
+
+	credit, charge, newPeriod = prorate(starter, yearly, period, halfway)
+	if credit != 50 || charge != 3000 {
+		t.Errorf("cycle change: credit = %v, charge = %v", credit, charge)
+	}
+	if !newPeriod.Start.Equal(halfway) || !newPeriod.End.Equal(halfway.AddDate(1, 0, 0)) {
+		t.Errorf("cycle change period = %v", newPeriod)
+	}
+}
+
+func TestCurrentBillingPeriod(t *testing.T) {
+	start := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	trialEnd := start.AddDate(0, 0, 14)
+	subscription := &domain.Subscription{StartDate: start, TrialEndsAt: &trialEnd}
+
+	period := currentBillingPeriod(subscription, domain.BillingCycleMonthly, time.Date(2024, 3, 30, 0, 0, 0, 0, time.UTC))
+	wantStart := trialEnd.AddDate(0, 2, 0)
+	if !period.Start.Equal(wantStart) || !period.End.Equal(wantStart.AddDate(0, 1, 0)) {
+		t.Errorf("period = %v - %v, want start %v", period.Start, period.End, wantStart)
+	}
+}
+
+func TestAddBillingCycleClampsToMonthEnd(t *testing.T) {
+	anchor := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		cycle domain.BillingCycle
+		n     int
+		want  time.Time
+	}{
+		{domain.BillingCycleMonthly, 1, time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{domain.BillingCycleMonthly, 2, time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)},
+		{domain.BillingCycleMonthly, 3, time.Date(2024, 4, 30, 12, 0, 0, 0, time.UTC)},
+		{domain.BillingCycleYearly, 1, time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := addBillingCycles(anchor, tt.cycle, tt.n); !got.Equal(tt.want) {
+			t.Errorf("addBillingCycles(%s, %d) = %v, want %v", tt.cycle, tt.n, got, tt.want)
+		}
+	}
+
+	leapDay := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	if got := addBillingCycle(leapDay, domain.BillingCycleYearly); !got.Equal(time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("addBillingCycle(leap day) = %v, want 2025-02-28", got)
+	}
+
+	// Periods keep the anchor day instead of drifting to the shortest month
+	subscription := &domain.Subscription{StartDate: anchor}
+	period := currentBillingPeriod(subscription, domain.BillingCycleMonthly, time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC))
+	if !period.Start.Equal(tests[0].want) || !period.End.Equal(tests[1].want) {
+		t.Errorf("period = %v - %v, want %v - %v", period.Start, period.End, tests[0].want, tests[1].want)
+	}
+}
+
+func TestQuotaViolations(t *testing.T) {
+	limits := &domain.PlanLimits{
+		MaxCases:         100,
+		MaxClients:       domain.Unlimited,
+		MaxStorageGB:     10,
+		MaxAPICallsMonth: 1000,
+		AIRequestsMonth:  50,
+		MessagesMonth:    200,
+		Overage: map[domain.UsageMetric]domain.OveragePrice{
+			domain.UsageMetricAIRequests: {UnitPrice: 0.1, SpendingCap: 10},
+		},
+	}
+	usage := map[Quota]int64{
+		QuotaCases:      120,
+		QuotaClients:    5000,
+		QuotaStorageGB:  10,
+		QuotaAPICalls:   1500,
+		QuotaAIRequests: 80,
+		QuotaMessages:   100,
+	}
+
+	limitNames := func(violations []PlanLimitViolation) []string {
+		var names []string
+		for _, v := range violations {
+			names = append(names, v.Limit)
+		}
+		return names
+	}
+
+	// Resources only, monthly allowances start over with the next period
+	if got := limitNames(quotaViolations(limits, usage, false)); !reflect.DeepEqual(got, []string{"max_cases"}) {
+		t.Errorf("quotaViolations() at period end = %v, want [max_cases]", got)
+	}
+	// AI requests beyond the limit are billed as overage, not refused
+	if got := limitNames(quotaViolations(limits, usage, true)); !reflect.DeepEqual(got, []string{"max_api_calls_month", "max_cases"}) {
+		t.Errorf("quotaViolations() now = %v, want [max_api_calls_month max_cases]", got)
+	}
+}
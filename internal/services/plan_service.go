@@ -0,0 +1,516 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidPlan    = errors.New("invalid plan")
+	ErrPlanNameExists = errors.New("plan name already exists")
+	ErrPlanInUse      = errors.New("plan version has subscribers")
+	ErrPlanInactive   = errors.New("plan version is not active")
+)
+
+var featureKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// CreatePlanRequest creates the first version of a plan
+type CreatePlanRequest struct {
+	Name         string                 `json:"name" binding:"required"`
+	DisplayName  string                 `json:"display_name" binding:"required"`
+	Description  string                 `json:"description"`
+	Price        float64                `json:"price"`
+	Currency     string                 `json:"currency"`
+	BillingCycle domain.BillingCycle    `json:"billing_cycle" binding:"required"`
+	Features     map[string]interface{} `json:"features"`
+	Limits       *domain.PlanLimits     `json:"limits" binding:"required"`
+	// Gateway price charging the plan by card
+	StripePriceID string `json:"stripe_price_id"`
+}
+
+// UpdatePlanRequest changes the given fields of a plan version, or of the new
+// version created from it
+type UpdatePlanRequest struct {
+	DisplayName   *string                `json:"display_name"`
+	Description   *string                `json:"description"`
+	Price         *float64               `json:"price"`
+	Currency      *string                `json:"currency"`
+	BillingCycle  *domain.BillingCycle   `json:"billing_cycle"`
+	Features      map[string]interface{} `json:"features"`
+	Limits        *domain.PlanLimits     `json:"limits"`
+	StripePriceID *string                `json:"stripe_price_id"`
+}
+
+// MigratePlanRequest selects the tenants moved to a plan version; all tenants on
+// older versions of the plan when empty
+type MigratePlanRequest struct {
+	TenantIDs []uuid.UUID `json:"tenant_ids"`
+}
+
+// PlanDetails is a plan version with the number of tenants on it
+type PlanDetails struct {
+	domain.Plan
+	Subscribers int64 `json:"subscribers"`
+}
+
+// PlanMigrationResult reports the tenants moved to a plan version and the ones
+// left on their version because their usage exceeds the new limits
+type PlanMigrationResult struct {
+	Migrated int                 `json:"migrated"`
+	Skipped  []PlanMigrationSkip `json:"skipped"`
+}
+
+type PlanMigrationSkip struct {
+	TenantID uuid.UUID `json:"tenant_id"`
+	Reason   string    `json:"reason"`
+}
+
+// PlanService manages plans and their versions. A version with subscribers is
+// frozen: changes go into a new version, and subscribers move to it only when
+// migrated.
+type PlanService struct {
+	db            *gorm.DB
+	subscriptions *SubscriptionService
+}
+
+func NewPlanService(db *gorm.DB, subscriptions *SubscriptionService) *PlanService {
+	return &PlanService{
+		db:            db,
+		subscriptions: subscriptions,
+	}
+}
+
+// ListPlans returns every plan version, filtered by name and active state when given
+func (s *PlanService) ListPlans(ctx context.Context, name string, active *bool) ([]PlanDetails, error) {
+	query := s.db.WithContext(ctx).Order("name, version")
+	if name != "" {
+		query = query.Where("name = ?", name)
+	}
+	if active != nil {
+		query = query.Where("is_active = ?", *active)
+	}
+
+	var plans []domain.Plan
+	if err := query.Find(&plans).Error; err != nil {
+		return nil, err
+	}
+
+	details := make([]PlanDetails, len(plans))
+	for i := range plans {
+		subscribers, err := s.countSubscribers(ctx, plans[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		details[i] = PlanDetails{Plan: plans[i], Subscribers: subscribers}
+	}
+	return details, nil
+}
+
+// GetPlan returns a plan version
+func (s *PlanService) GetPlan(ctx context.Context, planID uuid.UUID) (*PlanDetails, error) {
+	plan, err := s.getPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+	subscribers, err := s.countSubscribers(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+	return &PlanDetails{Plan: *plan, Subscribers: subscribers}, nil
+}
+
+// CreatePlan creates version 1 of a new plan, inactive until activated
+func (s *PlanService) CreatePlan(ctx context.Context, req *CreatePlanRequest, actorID string) (*domain.Plan, error) {
+	plan := &domain.Plan{
+		Name:         strings.ToLower(strings.TrimSpace(req.Name)),
+		Version:      1,
+		DisplayName:  req.DisplayName,
+		Description:  req.Description,
+		Price:        req.Price,
+		Currency:     req.Currency,
+		BillingCycle: req.BillingCycle,
+		Features:     req.Features,
+		Limits:       *req.Limits,
+
+		StripePriceID: strings.TrimSpace(req.StripePriceID),
+	}
+	if plan.Currency == "" {
+		plan.Currency = "BRL"
+	}
+	if err := validatePlan(plan); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&domain.Plan{}).Where("name = ?", plan.Name).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrPlanNameExists
+	}
+
+	if err := s.createDraft(ctx, plan); err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, plan, "plan.created", map[string]interface{}{"actor": actorID})
+	return plan, nil
+}
+
+// UpdatePlan edits a plan version nobody is on yet
+func (s *PlanService) UpdatePlan(ctx context.Context, planID uuid.UUID, req *UpdatePlanRequest, actorID string) (*domain.Plan, error) {
+	plan, err := s.getPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureUnused(ctx, plan); err != nil {
+		return nil, err
+	}
+
+	req.applyTo(plan)
+	if err := validatePlan(plan); err != nil {
+		return nil, err
+	}
+
+	err = s.db.WithContext(ctx).Model(plan).
+		Select("display_name", "description", "price", "currency", "billing_cycle", "features", "limits", "stripe_price_id").
+		Updates(plan).Error
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, plan, "plan.updated", map[string]interface{}{"actor": actorID})
+	return plan, nil
+}
+
+// CreateVersion creates the next version of a plan from one of its versions with
+// the requested changes, inactive until activated
+func (s *PlanService) CreateVersion(ctx context.Context, planID uuid.UUID, req *UpdatePlanRequest, actorID string) (*domain.Plan, error) {
+	base, err := s.getPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest int
+	err = s.db.WithContext(ctx).Model(&domain.Plan{}).
+		Select("COALESCE(MAX(version), 0)").
+		Where("name = ?", base.Name).
+		Scan(&latest).Error
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &domain.Plan{
+		Name:         base.Name,
+		Version:      latest + 1,
+		DisplayName:  base.DisplayName,
+		Description:  base.Description,
+		Price:        base.Price,
+		Currency:     base.Currency,
+		BillingCycle: base.BillingCycle,
+		Features:     copyFeatures(base.Features),
+		Limits:       base.Limits,
+		// The gateway price is not carried over: a new version usually charges a new price
+	}
+	req.applyTo(plan)
+	if err := validatePlan(plan); err != nil {
+		return nil, err
+	}
+
+	if err := s.createDraft(ctx, plan); err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, plan, "plan.version_created", map[string]interface{}{
+		"from_version": base.Version,
+		"actor":        actorID,
+	})
+	return plan, nil
+}
+
+// ActivatePlan offers a plan version to new tenants and plan changes. The other
+// versions of the plan stop being offered; their subscribers keep them.
+func (s *PlanService) ActivatePlan(ctx context.Context, planID uuid.UUID, actorID string) (*domain.Plan, error) {
+	plan, err := s.getPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&domain.Plan{}).
+			Where("name = ? AND id <> ?", plan.Name, plan.ID).
+			Update("is_active", false).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(plan).Update("is_active", true).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, plan, "plan.activated", map[string]interface{}{"actor": actorID})
+	return plan, nil
+}
+
+// DeactivatePlan stops offering a plan version; its subscribers keep it
+func (s *PlanService) DeactivatePlan(ctx context.Context, planID uuid.UUID, actorID string) (*domain.Plan, error) {
+	plan, err := s.getPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Model(plan).Update("is_active", false).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, plan, "plan.deactivated", map[string]interface{}{"actor": actorID})
+	return plan, nil
+}
+
+// MigrateSubscribers moves tenants on older versions of a plan to the given active
+// version. The new price applies from the next billing period, so nothing is
+// prorated; tenants whose usage exceeds the new limits stay where they are.
+func (s *PlanService) MigrateSubscribers(ctx context.Context, planID uuid.UUID, req *MigratePlanRequest, actorID string) (*PlanMigrationResult, error) {
+	target, err := s.getPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+	if !target.IsActive {
+		return nil, ErrPlanInactive
+	}
+
+	var olderVersions []uuid.UUID
+	err = s.db.WithContext(ctx).Model(&domain.Plan{}).
+		Where("name = ? AND version < ?", target.Name, target.Version).
+		Pluck("id", &olderVersions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PlanMigrationResult{Skipped: []PlanMigrationSkip{}}
+	if len(olderVersions) == 0 {
+		return result, nil
+	}
+
+	query := s.db.WithContext(ctx).Model(&domain.Tenant{}).Where("plan_id IN ?", olderVersions)
+	if req != nil && len(req.TenantIDs) > 0 {
+		query = query.Where("id IN ?", req.TenantIDs)
+	}
+	var tenantIDs []uuid.UUID
+	if err := query.Pluck("id", &tenantIDs).Error; err != nil {
+		return nil, err
+	}
+
+	for _, tenantID := range tenantIDs {
+		if err := s.migrateTenant(ctx, tenantID, target, actorID); err != nil {
+			var limitErr *PlanLimitError
+			if !errors.As(err, &limitErr) {
+				logger.Error("Failed to migrate tenant plan version",
+					zap.String("tenantID", tenantID.String()),
+					zap.String("planID", target.ID.String()),
+					zap.Error(err))
+			}
+			result.Skipped = append(result.Skipped, PlanMigrationSkip{TenantID: tenantID, Reason: err.Error()})
+			continue
+		}
+		result.Migrated++
+	}
+
+	s.audit(ctx, target, "plan.subscribers_migrated", map[string]interface{}{
+		"migrated": result.Migrated,
+		"skipped":  len(result.Skipped),
+		"actor":    actorID,
+	})
+	return result, nil
+}
+
+func (s *PlanService) migrateTenant(ctx context.Context, tenantID uuid.UUID, target *domain.Plan, actorID string) error {
+	ctx = domain.WithTenantID(ctx, tenantID)
+	tenant, subscription, err := s.subscriptions.tenantSubscription(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	if err := s.subscriptions.checkPlanLimits(ctx, tenant, target, true); err != nil {
+		return err
+	}
+
+	change := &domain.PlanChange{
+		TenantID:       tenantID,
+		SubscriptionID: subscription.ID,
+		FromPlanID:     tenant.PlanID,
+		ToPlanID:       target.ID,
+		Timing:         domain.PlanChangeImmediate,
+		EffectiveAt:    time.Now(),
+		Currency:       target.Currency,
+		RequestedBy:    actorID,
+	}
+	return s.subscriptions.applyPlanChange(ctx, tenant, subscription, target, change, billingPeriod{}, false)
+}
+
+// createDraft writes a plan as inactive; the column defaults to true
+func (s *PlanService) createDraft(ctx context.Context, plan *domain.Plan) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(plan).Error; err != nil {
+			return err
+		}
+		plan.IsActive = false
+		return tx.Model(plan).Update("is_active", false).Error
+	})
+}
+
+// ensureUnused refuses to edit a version tenants are on or are scheduled to move to
+func (s *PlanService) ensureUnused(ctx context.Context, plan *domain.Plan) error {
+	subscribers, err := s.countSubscribers(ctx, plan.ID)
+	if err != nil {
+		return err
+	}
+	var scheduled int64
+	err = s.db.WithContext(domain.CrossTenant(ctx)).Model(&domain.PlanChange{}).
+		Where("to_plan_id = ? AND status = ?", plan.ID, domain.PlanChangeStatusScheduled).
+		Count(&scheduled).Error
+	if err != nil {
+		return err
+	}
+	if subscribers > 0 || scheduled > 0 {
+		return ErrPlanInUse
+	}
+	return nil
+}
+
+func (s *PlanService) countSubscribers(ctx context.Context, planID uuid.UUID) (int64, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&domain.Tenant{}).Where("plan_id = ?", planID).Count(&count).Error
+	return count, err
+}
+
+func (s *PlanService) getPlan(ctx context.Context, planID uuid.UUID) (*domain.Plan, error) {
+	var plan domain.Plan
+	if err := s.db.WithContext(ctx).First(&plan, planID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlanNotFound
+		}
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (s *PlanService) audit(ctx context.Context, plan *domain.Plan, action string, details map[string]interface{}) {
+	details["name"] = plan.Name
+	details["version"] = plan.Version
+	audit := &domain.AuditLog{
+		Action:     action,
+		Resource:   "plan",
+		ResourceID: plan.ID.String(),
+		Details:    details,
+	}
+	// Plans belong to no tenant
+	if err := s.db.WithContext(domain.CrossTenant(ctx)).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+}
+
+func (r *UpdatePlanRequest) applyTo(plan *domain.Plan) {
+	if r.DisplayName != nil {
+		plan.DisplayName = *r.DisplayName
+	}
+	if r.Description != nil {
+		plan.Description = *r.Description
+	}
+	if r.Price != nil {
+		plan.Price = *r.Price
+	}
+	if r.Currency != nil {
+		plan.Currency = *r.Currency
+	}
+	if r.BillingCycle != nil {
+		plan.BillingCycle = *r.BillingCycle
+	}
+	if r.Features != nil {
+		plan.Features = r.Features
+	}
+	if r.Limits != nil {
+		plan.Limits = *r.Limits
+	}
+	if r.StripePriceID != nil {
+		plan.StripePriceID = strings.TrimSpace(*r.StripePriceID)
+	}
+}
+
+// validatePlan checks a plan before it is written. Limits are counts or
+// domain.Unlimited; any other negative value is rejected.
+func validatePlan(plan *domain.Plan) error {
+	// Plan names follow the tenant name rules: lowercase letters, digits and hyphens
+	if !isValidTenantName(plan.Name) {
+		return fmt.Errorf("%w: name must be 3-50 lowercase letters, digits or hyphens", ErrInvalidPlan)
+	}
+	if strings.TrimSpace(plan.DisplayName) == "" {
+		return fmt.Errorf("%w: display_name is required", ErrInvalidPlan)
+	}
+	if plan.Price < 0 {
+		return fmt.Errorf("%w: price must not be negative", ErrInvalidPlan)
+	}
+	if len(plan.Currency) != 3 || strings.ToUpper(plan.Currency) != plan.Currency {
+		return fmt.Errorf("%w: currency must be an ISO 4217 code such as BRL", ErrInvalidPlan)
+	}
+	switch plan.BillingCycle {
+	case domain.BillingCycleMonthly, domain.BillingCycleYearly, domain.BillingCycleOneTime:
+	default:
+		return fmt.Errorf("%w: billing_cycle must be monthly, yearly or one_time", ErrInvalidPlan)
+	}
+
+	limits := []struct {
+		name  string
+		value int
+	}{
+		{"max_users", plan.Limits.MaxUsers},
+		{"max_clients", plan.Limits.MaxClients},
+		{"max_cases", plan.Limits.MaxCases},
+		{"max_storage_gb", plan.Limits.MaxStorageGB},
+		{"max_api_calls_month", plan.Limits.MaxAPICallsMonth},
+		{"ai_requests_month", plan.Limits.AIRequestsMonth},
+		{"messages_month", plan.Limits.MessagesMonth},
+	}
+	for _, limit := range limits {
+		if limit.value < 0 && limit.value != domain.Unlimited {
+			return fmt.Errorf("%w: %s must be %d (unlimited) or zero or more", ErrInvalidPlan, limit.name, domain.Unlimited)
+		}
+	}
+
+	for _, percent := range plan.Limits.QuotaWarnings {
+		if percent < 1 || percent > 100 {
+			return fmt.Errorf("%w: quota_warnings must be percentages from 1 to 100", ErrInvalidPlan)
+		}
+	}
+	if err := validateOverage(&plan.Limits); err != nil {
+		return err
+	}
+
+	for key, value := range plan.Features {
+		if !featureKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: feature %q must be lowercase letters, digits or underscores", ErrInvalidPlan, key)
+		}
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%w: feature %q must be true or false", ErrInvalidPlan, key)
+		}
+	}
+	return nil
+}
+
+func copyFeatures(features map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(features))
+	for key, value := range features {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/opiagile/direito-lux/internal/domain"
+)
+
+func TestValidatePlan(t *testing.T) {
+	valid := func() *domain.Plan {
+		return &domain.Plan{
+			Name:         "starter",
+			DisplayName:  "Starter",
+			Price:        99.90,
+			Currency:     "BRL",
+			BillingCycle: domain.BillingCycleMonthly,
+			Features:     map[string]interface{}{"email_support": true},
+			Limits: domain.PlanLimits{
+				MaxUsers:        1,
+				MaxCases:        domain.Unlimited,
+				AIRequestsMonth: 0,
+			},
+		}
+	}
+
+	if err := validatePlan(valid()); err != nil {
+		t.Fatalf("validatePlan() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*domain.Plan)
+	}{
+		{"negative limit", func(p *domain.Plan) { p.Limits.MaxUsers = -2 }},
+		{"negative price", func(p *domain.Plan) { p.Price = -1 }},
+		{"unknown billing cycle", func(p *domain.Plan) { p.BillingCycle = "weekly" }},
+		{"lowercase currency", func(p *domain.Plan) { p.Currency = "brl" }},
+		{"invalid name", func(p *domain.Plan) { p.Name = "Starter Plan" }},
+		{"non boolean feature", func(p *domain.Plan) { p.Features["api_access"] = "yes" }},
+		{"invalid feature key", func(p *domain.Plan) { p.Features["API Access"] = true }},
+		{"quota warning over 100%", func(p *domain.Plan) { p.Limits.QuotaWarnings = []int{80, 120} }},
+		{"overage of api calls", func(p *domain.Plan) {
+			p.Limits.Overage = map[domain.UsageMetric]domain.OveragePrice{domain.UsageMetricAPICalls: {UnitPrice: 0.01, SpendingCap: 10}}
+		}},
+		{"overage without a limit", func(p *domain.Plan) {
+			p.Limits.AIRequestsMonth = domain.Unlimited
+			p.Limits.Overage = map[domain.UsageMetric]domain.OveragePrice{domain.UsageMetricAIRequests: {UnitPrice: 0.5, SpendingCap: 100}}
+		}},
+		{"overage cap below one unit", func(p *domain.Plan) {
+			p.Limits.Overage = map[domain.UsageMetric]domain.OveragePrice{domain.UsageMetricMessages: {UnitPrice: 0.5, SpendingCap: 0.1}}
+		}},
+	}
+
+	for _, tt := range tests {
+		plan := valid()
+		tt.mutate(plan)
+		if err := validatePlan(plan); !errors.Is(err, ErrInvalidPlan) {
+			t.Errorf("%s: validatePlan() error = %v, want ErrInvalidPlan", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrUnknownQuota  = errors.New("unknown quota")
+)
+
+// Quota is a plan limit enforced on tenant usage
+type Quota string
+
+const (
+	QuotaAPICalls   Quota = "api_calls"
+	QuotaAIRequests Quota = "ai_requests"
+	QuotaMessages   Quota = "messages"
+	QuotaCases      Quota = "cases"
+	QuotaClients    Quota = "clients"
+	QuotaStorageGB  Quota = "storage_gb" // reported in whole gigabytes, rounded up
+)
+
+var quotas = []Quota{QuotaAPICalls, QuotaAIRequests, QuotaMessages, QuotaCases, QuotaClients, QuotaStorageGB}
+
+// quotaUsageMetrics maps the monthly quotas to the metric they limit
+var quotaUsageMetrics = map[Quota]domain.UsageMetric{
+	QuotaAPICalls:   domain.UsageMetricAPICalls,
+	QuotaAIRequests: domain.UsageMetricAIRequests,
+	QuotaMessages:   domain.UsageMetricMessages,
+}
+
+// overageMetrics are the metrics a plan may bill beyond their limit
+var overageMetrics = []domain.UsageMetric{domain.UsageMetricAIRequests, domain.UsageMetricMessages}
+
+// Cases, clients and stored files are kept by other services, which report their
+// counts; the counts live in a Redis hash per tenant.
+const (
+	quotaLimitsKeyPrefix    = "quota_limits:"
+	quotaResourcesKeyPrefix = "quota_resources:"
+	quotaWarningKeyPrefix   = "quota_warning:"
+	// Plan changes reach the quotas once the cached limits expire
+	quotaLimitsCacheTTL = time.Minute
+	// Warnings of resource quotas are repeated monthly while the tenant stays over
+	resourceQuotaWarningInterval = 30 * 24 * time.Hour
+)
+
+var defaultQuotaWarnings = []int{80, 100}
+
+// Monthly reports whether the quota is an allowance starting over every usage
+// period, rather than a cap on resources the tenant keeps
+func (q Quota) Monthly() bool {
+	_, ok := quotaUsageMetrics[q]
+	return ok
+}
+
+// IsQuota reports whether a quota is enforced
+func IsQuota(quota Quota) bool {
+	for _, known := range quotas {
+		if quota == known {
+			return true
+		}
+	}
+	return false
+}
+
+// QuotaForUsageMetric returns the quota limiting a usage metric, if any
+func QuotaForUsageMetric(metric domain.UsageMetric) (Quota, bool) {
+	for quota, limited := range quotaUsageMetrics {
+		if limited == metric {
+			return quota, true
+		}
+	}
+	return "", false
+}
+
+// QuotaStatus is the usage of a tenant against one quota
+type QuotaStatus struct {
+	Quota     Quota      `json:"quota"`
+	Limit     int64      `json:"limit"` // domain.Unlimited when the plan sets no cap
+	Used      int64      `json:"used"`
+	Remaining int64      `json:"remaining"`
+	ResetsAt  *time.Time `json:"resets_at,omitempty"` // end of the usage period of monthly quotas
+	// Units the plan bills beyond the limit, up to its spending cap, and the units used so far
+	OverageLimit int64 `json:"overage_limit,omitempty"`
+	Overage      int64 `json:"overage,omitempty"`
+}
+
+// Unlimited reports whether the plan sets no cap on the quota
+func (s *QuotaStatus) Unlimited() bool {
+	return s.Limit == domain.Unlimited
+}
+
+// QuotaExceededError reports the quota a request would go over
+type QuotaExceededError struct {
+	Status QuotaStatus
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota %s exceeded: %d of %d used", e.Status.Quota, e.Status.Used, e.Status.Limit)
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// QuotaService checks tenant usage against the limits of the tenant's plan and
+// warns tenant admins as the usage approaches them
+type QuotaService struct {
+	db            *gorm.DB
+	redisClient   *redis.Client
+	usageMeter    *UsageMeter
+	subscriptions *SubscriptionService
+}
+
+func NewQuotaService(db *gorm.DB, redisClient *redis.Client, usageMeter *UsageMeter, subscriptions *SubscriptionService) *QuotaService {
+	return &QuotaService{
+		db:            db,
+		redisClient:   redisClient,
+		usageMeter:    usageMeter,
+		subscriptions: subscriptions,
+	}
+}
+
+// Check reports whether the tenant may use quantity more of a quota, beyond the
+// limit when the plan bills overage and its spending cap is not reached. It returns
+// a *QuotaExceededError when it may not; otherwise the status counts the quantity.
+func (s *QuotaService) Check(ctx context.Context, tenantID uuid.UUID, quota Quota, quantity int64) (*QuotaStatus, error) {
+	limits, err := s.planLimits(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	status, err := s.status(ctx, tenantID, quota, limits)
+	if err != nil {
+		return nil, err
+	}
+	if status.Unlimited() {
+		return status, nil
+	}
+
+	if status.Used+quantity > status.Limit+status.OverageLimit {
+		s.warn(ctx, tenantID, status, limits.QuotaWarnings)
+		return status, &QuotaExceededError{Status: *status}
+	}
+
+	status.Used += quantity
+	status.Remaining = maxInt64(status.Limit-status.Used, 0)
+	status.Overage = maxInt64(status.Used-status.Limit, 0)
+	s.warn(ctx, tenantID, status, limits.QuotaWarnings)
+	return status, nil
+}
+
+// Statuses returns the usage of the tenant against every quota
+func (s *QuotaService) Statuses(ctx context.Context, tenantID uuid.UUID) ([]QuotaStatus, error) {
+	limits, err := s.planLimits(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]QuotaStatus, 0, len(quotas))
+	for _, quota := range quotas {
+		status, err := s.status(ctx, tenantID, quota, limits)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, *status)
+	}
+	return statuses, nil
+}
+
+// SetResourceCount records how many resources limited by a quota, such as cases,
+// the tenant keeps; the services owning the resources report it before the count
+// changes. A count growing past the plan limit is refused with a *QuotaExceededError,
+// while lower counts are always recorded so tenants over a downgraded limit can shrink.
+func (s *QuotaService) SetResourceCount(ctx context.Context, tenantID uuid.UUID, quota Quota, count int64) error {
+	if !IsQuota(quota) || quota.Monthly() {
+		return fmt.Errorf("%w: %s", ErrUnknownQuota, quota)
+	}
+
+	limits, err := s.planLimits(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	status, err := s.status(ctx, tenantID, quota, limits)
+	if err != nil {
+		return err
+	}
+	if !status.Unlimited() && count > status.Limit && count > status.Used {
+		return &QuotaExceededError{Status: *status}
+	}
+
+	return s.redisClient.HSet(ctx, quotaResourcesKeyPrefix+tenantID.String(), string(quota), count).Err()
+}
+
+// usage returns what the tenant uses of every quota: the current usage period of
+// monthly quotas and the reported count of resources
+func (s *QuotaService) usage(ctx context.Context, tenantID uuid.UUID) (map[Quota]int64, error) {
+	current, err := s.usageMeter.CurrentUsage(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.redisClient.HGetAll(ctx, quotaResourcesKeyPrefix+tenantID.String()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[Quota]int64, len(quotas))
+	for _, quota := range quotas {
+		if metric, ok := quotaUsageMetrics[quota]; ok {
+			usage[quota] = current.Metrics[metric]
+			continue
+		}
+		// Tenants whose count was never reported have none
+		if count, ok := counts[string(quota)]; ok {
+			if usage[quota], err = strconv.ParseInt(count, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid %s count: %w", quota, err)
+			}
+		}
+	}
+	return usage, nil
+}
+
+func (s *QuotaService) status(ctx context.Context, tenantID uuid.UUID, quota Quota, limits *domain.PlanLimits) (*QuotaStatus, error) {
+	limit, err := quotaLimit(limits, quota)
+	if err != nil {
+		return nil, err
+	}
+	status := &QuotaStatus{Quota: quota, Limit: int64(limit)}
+
+	if metric, ok := quotaUsageMetrics[quota]; ok {
+		usage, err := s.usageMeter.CurrentUsage(ctx, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		status.Used = usage.Metrics[metric]
+		status.ResetsAt = &usage.PeriodEnd
+	} else {
+		// Tenants whose count was never reported have none
+		count, err := s.redisClient.HGet(ctx, quotaResourcesKeyPrefix+tenantID.String(), string(quota)).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+		status.Used = count
+	}
+
+	if status.Unlimited() {
+		status.Remaining = domain.Unlimited
+		return status, nil
+	}
+	if status.Used < status.Limit {
+		status.Remaining = status.Limit - status.Used
+	}
+	if metric, ok := quotaUsageMetrics[quota]; ok {
+		status.OverageLimit = limits.Overage[metric].MaxUnits()
+		status.Overage = maxInt64(status.Used-status.Limit, 0)
+	}
+	return status, nil
+}
+
+// planLimits returns the limits of the tenant's plan, cached for a minute
+func (s *QuotaService) planLimits(ctx context.Context, tenantID uuid.UUID) (*domain.PlanLimits, error) {
+	cacheKey := quotaLimitsKeyPrefix + tenantID.String()
+	if cached, err := s.redisClient.Get(ctx, cacheKey).Bytes(); err == nil {
+		var limits domain.PlanLimits
+		if err := json.Unmarshal(cached, &limits); err == nil {
+			return &limits, nil
+		}
+	}
+
+	plan, err := s.tenantPlan(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(plan.Limits); err == nil {
+		s.redisClient.Set(ctx, cacheKey, data, quotaLimitsCacheTTL)
+	}
+	return &plan.Limits, nil
+}
+
+func (s *QuotaService) tenantPlan(ctx context.Context, tenantID uuid.UUID) (*domain.Plan, error) {
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).Preload("Plan").Select("id", "plan_id").First(&tenant, tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+	if tenant.Plan.ID == uuid.Nil {
+		return nil, fmt.Errorf("plan %s of tenant %s not found", tenant.PlanID, tenantID)
+	}
+	return &tenant.Plan, nil
+}
+
+// warn notifies the tenant admins of the highest warning threshold the usage
+// reached for the first time in the period
+func (s *QuotaService) warn(ctx context.Context, tenantID uuid.UUID, status *QuotaStatus, thresholds []int) {
+	if len(thresholds) == 0 {
+		thresholds = defaultQuotaWarnings
+	}
+
+	period, ttl := "resources", resourceQuotaWarningInterval
+	if status.ResetsAt != nil {
+		period, ttl = strconv.FormatInt(status.ResetsAt.Unix(), 10), time.Until(*status.ResetsAt)
+	}
+
+	warning := 0
+	for _, threshold := range reachedQuotaWarnings(thresholds, status.Used, status.Limit) {
+		key := fmt.Sprintf("%s%s:%s:%s:%d", quotaWarningKeyPrefix, tenantID, status.Quota, period, threshold)
+		first, err := s.redisClient.SetNX(ctx, key, 1, ttl).Result()
+		if err != nil {
+			logger.Warn("Failed to record quota warning",
+				zap.String("tenantID", tenantID.String()),
+				zap.String("quota", string(status.Quota)),
+				zap.Error(err))
+			return
+		}
+		if first {
+			warning = threshold
+		}
+	}
+	if warning == 0 || s.subscriptions == nil || s.subscriptions.notifier == nil {
+		return
+	}
+
+	admins, err := s.subscriptions.tenantAdmins(ctx, tenantID)
+	if err != nil || len(admins) == 0 {
+		return
+	}
+
+	body := fmt.Sprintf("Your tenant has used %d of the %d %s its plan allows.", status.Used, status.Limit, status.Quota)
+	if status.ResetsAt != nil {
+		body += fmt.Sprintf(" The quota starts over on %s.", status.ResetsAt.Format("02/01/2006"))
+	}
+	if status.OverageLimit > 0 {
+		body += " Usage beyond the limit is billed as overage, up to the spending cap of your plan."
+	} else {
+		body += " Upgrade the plan to raise the limit."
+	}
+
+	err = s.subscriptions.notifier.Notify(ctx, Notification{
+		TenantID:   tenantID,
+		Type:       "quota.warning",
+		Recipients: admins,
+		Subject:    fmt.Sprintf("You have used %d%% of your %s quota", warning, status.Quota),
+		Body:       body,
+	})
+	if err != nil {
+		logger.Error("Failed to send quota warning",
+			zap.String("tenantID", tenantID.String()),
+			zap.String("quota", string(status.Quota)),
+			zap.Error(err))
+	}
+}
+
+// reachedQuotaWarnings returns the warning thresholds, in percent of the limit,
+// that the usage reached, lowest first
+func reachedQuotaWarnings(thresholds []int, used, limit int64) []int {
+	if limit <= 0 {
+		return nil
+	}
+
+	var reached []int
+	for _, threshold := range thresholds {
+		if used*100 >= int64(threshold)*limit {
+			reached = append(reached, threshold)
+		}
+	}
+	sort.Ints(reached)
+	return reached
+}
+
+func quotaLimit(limits *domain.PlanLimits, quota Quota) (int, error) {
+	switch quota {
+	case QuotaAPICalls:
+		return limits.MaxAPICallsMonth, nil
+	case QuotaAIRequests:
+		return limits.AIRequestsMonth, nil
+	case QuotaMessages:
+		return limits.MessagesMonth, nil
+	case QuotaCases:
+		return limits.MaxCases, nil
+	case QuotaClients:
+		return limits.MaxClients, nil
+	case QuotaStorageGB:
+		return limits.MaxStorageGB, nil
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownQuota, quota)
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
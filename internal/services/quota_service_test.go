@@ -0,0 +1,28 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReachedQuotaWarnings(t *testing.T) {
+	tests := []struct {
+		name  string
+		used  int64
+		limit int64
+		want  []int
+	}{
+		{"below", 799, 1000, nil},
+		{"soft limit", 800, 1000, []int{80}},
+		{"at limit", 1000, 1000, []int{80, 100}},
+		{"over limit", 1500, 1000, []int{80, 100}},
+		{"no allowance", 0, 0, nil},
+	}
+
+	for _, tt := range tests {
+		got := reachedQuotaWarnings([]int{100, 80}, tt.used, tt.limit)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: reachedQuotaWarnings() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrReconciliationRunning = errors.New("reconciliation already running")
+	ErrInvalidPolicy         = errors.New("invalid reconciliation policy")
+)
+
+// ReconciliationPolicy decides which side is fixed when drift is found
+type ReconciliationPolicy string
+
+const (
+	// ReconciliationPolicyReport only reports drift
+	ReconciliationPolicyReport ReconciliationPolicy = "report"
+	// ReconciliationPolicyKeycloak updates the database to match Keycloak
+	ReconciliationPolicyKeycloak ReconciliationPolicy = "keycloak"
+	// ReconciliationPolicyDatabase updates Keycloak to match the database
+	ReconciliationPolicyDatabase ReconciliationPolicy = "database"
+)
+
+// DriftType classifies a difference between Keycloak and the database
+type DriftType string
+
+const (
+	DriftMissingInDatabase DriftType = "missing_in_database"
+	DriftMissingInKeycloak DriftType = "missing_in_keycloak"
+	DriftRoleMismatch      DriftType = "role_mismatch"
+	DriftEnabledMismatch   DriftType = "enabled_mismatch"
+)
+
+// Drift describes one inconsistency for a user
+type Drift struct {
+	Type          DriftType `json:"type"`
+	Email         string    `json:"email"`
+	KeycloakID    string    `json:"keycloak_id,omitempty"`
+	UserID        string    `json:"user_id,omitempty"`
+	KeycloakValue string    `json:"keycloak_value,omitempty"`
+	DatabaseValue string    `json:"database_value,omitempty"`
+	Fixed         bool      `json:"fixed"`
+	FixError      string    `json:"fix_error,omitempty"`
+}
+
+// TenantReconciliation is the result for a single tenant
+type TenantReconciliation struct {
+	TenantID   uuid.UUID `json:"tenant_id"`
+	TenantName string    `json:"tenant_name"`
+	Drifts     []Drift   `json:"drifts"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// ReconciliationReport is the result of a reconciliation run
+type ReconciliationReport struct {
+	Policy     ReconciliationPolicy   `json:"policy"`
+	StartedAt  time.Time              `json:"started_at"`
+	FinishedAt time.Time              `json:"finished_at"`
+	DriftCount int                    `json:"drift_count"`
+	FixedCount int                    `json:"fixed_count"`
+	Tenants    []TenantReconciliation `json:"tenants"`
+}
+
+// keycloakMember is the Keycloak side of a user
+type keycloakMember struct {
+	user    *gocloak.User
+	role    domain.UserRole
+	enabled bool
+}
+
+type ReconciliationService struct {
+	db               *gorm.DB
+	identityProvider auth.IdentityProvider
+	policy           ReconciliationPolicy
+
+	runMutex   sync.Mutex
+	reportLock sync.RWMutex
+	lastReport *ReconciliationReport
+}
+
+func NewReconciliationService(db *gorm.DB, identityProvider auth.IdentityProvider, policy string) (*ReconciliationService, error) {
+	p, err := ParseReconciliationPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReconciliationService{
+		db:               db,
+		identityProvider: identityProvider,
+		policy:           p,
+	}, nil
+}
+
+// ParseReconciliationPolicy validates a policy name, defaulting to report
+func ParseReconciliationPolicy(policy string) (ReconciliationPolicy, error) {
+	switch ReconciliationPolicy(policy) {
+	case "":
+		return ReconciliationPolicyReport, nil
+	case ReconciliationPolicyReport, ReconciliationPolicyKeycloak, ReconciliationPolicyDatabase:
+		return ReconciliationPolicy(policy), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrInvalidPolicy, policy)
+}
+
+// Policy returns the configured policy
+func (rs *ReconciliationService) Policy() ReconciliationPolicy {
+	return rs.policy
+}
+
+// Start runs reconciliation periodically until the context is canceled
+func (rs *ReconciliationService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger.Info("Reconciliation job started",
+		zap.Duration("interval", interval),
+		zap.String("policy", string(rs.policy)))
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Reconciliation job stopped")
+			return
+		case <-ticker.C:
+			if _, err := rs.Reconcile(ctx, nil, rs.policy); err != nil && !errors.Is(err, ErrReconciliationRunning) {
+				logger.Error("Scheduled reconciliation failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// LastReport returns the report of the last completed run
+func (rs *ReconciliationService) LastReport() *ReconciliationReport {
+	rs.reportLock.RLock()
+	defer rs.reportLock.RUnlock()
+	return rs.lastReport
+}
+
+// Reconcile compares Keycloak group members with the users table for one tenant
+// (or all tenants when tenantID is nil) and applies the given policy
+func (rs *ReconciliationService) Reconcile(ctx context.Context, tenantID *uuid.UUID, policy ReconciliationPolicy) (*ReconciliationReport, error) {
+	if !rs.runMutex.TryLock() {
+		return nil, ErrReconciliationRunning
+	}
+	defer rs.runMutex.Unlock()
+
+	// Users of suspended and offboarding tenants are disabled in Keycloak on purpose
+	var tenants []domain.Tenant
+	query := rs.db.WithContext(ctx).
+		Where("status NOT IN ?", []domain.TenantStatus{domain.TenantStatusSuspended, domain.TenantStatusOffboarding})
+	if tenantID != nil {
+		query = query.Where("id = ?", *tenantID)
+	}
+	if err := query.Find(&tenants).Error; err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	if tenantID != nil && len(tenants) == 0 {
+		return nil, ErrTenantNotFound
+	}
+
+	report := &ReconciliationReport{
+		Policy:    policy,
+		StartedAt: time.Now(),
+		Tenants:   make([]TenantReconciliation, 0, len(tenants)),
+	}
+
+	for i := range tenants {
+		result := rs.reconcileTenant(ctx, &tenants[i], policy)
+		for _, drift := range result.Drifts {
+			report.DriftCount++
+			if drift.Fixed {
+				report.FixedCount++
+			}
+		}
+		report.Tenants = append(report.Tenants, result)
+	}
+
+	report.FinishedAt = time.Now()
+
+	rs.reportLock.Lock()
+	rs.lastReport = report
+	rs.reportLock.Unlock()
+
+	logger.Info("Reconciliation completed",
+		zap.String("policy", string(policy)),
+		zap.Int("tenants", len(report.Tenants)),
+		zap.Int("drifts", report.DriftCount),
+		zap.Int("fixed", report.FixedCount),
+		zap.Duration("duration", report.FinishedAt.Sub(report.StartedAt)))
+
+	return report, nil
+}
+
+func (rs *ReconciliationService) reconcileTenant(ctx context.Context, tenant *domain.Tenant, policy ReconciliationPolicy) TenantReconciliation {
+	result := TenantReconciliation{
+		TenantID:   tenant.ID,
+		TenantName: tenant.Name,
+		Drifts:     []Drift{},
+	}
+	ctx = domain.WithTenantID(ctx, tenant.ID)
+
+	members, err := rs.loadKeycloakMembers(ctx, tenant.KeycloakGroupID)
+	if err != nil {
+		result.Error = err.Error()
+		logger.Error("Failed to load Keycloak members",
+			zap.String("tenant", tenant.Name),
+			zap.Error(err))
+		return result
+	}
+
+	var users []*domain.User
+	if err := rs.db.WithContext(ctx).Where("tenant_id = ?", tenant.ID).Find(&users).Error; err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	seen := make(map[string]bool)
+	for _, user := range users {
+		member, exists := members[user.KeycloakID]
+		if !exists {
+			// Users already out of service do not need a Keycloak account
+			if user.Status == domain.UserStatusInactive {
+				continue
+			}
+			drift := Drift{
+				Type:          DriftMissingInKeycloak,
+				Email:         user.Email,
+				UserID:        user.ID.String(),
+				KeycloakID:    user.KeycloakID,
+				DatabaseValue: string(user.Status),
+			}
+			// A Keycloak account cannot be recreated without credentials, both policies deactivate the row
+			deactivate := func() error { return rs.deactivateUser(ctx, user) }
+			rs.fix(&drift, policy, deactivate, deactivate)
+			result.Drifts = append(result.Drifts, drift)
+			continue
+		}
+		seen[user.KeycloakID] = true
+
+		if member.role != "" && member.role != user.Role {
+			drift := Drift{
+				Type:          DriftRoleMismatch,
+				Email:         user.Email,
+				UserID:        user.ID.String(),
+				KeycloakID:    user.KeycloakID,
+				KeycloakValue: string(member.role),
+				DatabaseValue: string(user.Role),
+			}
+			rs.fix(&drift, policy,
+				func() error { return rs.db.WithContext(ctx).Model(user).Update("role", member.role).Error },
+				func() error {
+					return rs.identityProvider.ChangeUserRole(ctx, user.KeycloakID, string(member.role), string(user.Role))
+				})
+			result.Drifts = append(result.Drifts, drift)
+		}
+
+		// Invited users are enabled in Keycloak until they accept, blocked and inactive ones must be disabled
+		dbEnabled := user.Status == domain.UserStatusActive || user.Status == domain.UserStatusInvited
+		if member.enabled != dbEnabled {
+			drift := Drift{
+				Type:          DriftEnabledMismatch,
+				Email:         user.Email,
+				UserID:        user.ID.String(),
+				KeycloakID:    user.KeycloakID,
+				KeycloakValue: fmt.Sprintf("enabled=%t", member.enabled),
+				DatabaseValue: string(user.Status),
+			}
+			status := domain.UserStatusBlocked
+			if member.enabled {
+				status = domain.UserStatusActive
+			}
+			rs.fix(&drift, policy,
+				func() error { return rs.db.WithContext(ctx).Model(user).Update("status", status).Error },
+				func() error { return rs.identityProvider.SetUserEnabled(ctx, user.KeycloakID, dbEnabled) })
+			result.Drifts = append(result.Drifts, drift)
+		}
+	}
+
+	for keycloakID, member := range members {
+		if seen[keycloakID] {
+			continue
+		}
+		email := gocloak.PString(member.user.Email)
+		drift := Drift{
+			Type:          DriftMissingInDatabase,
+			Email:         email,
+			KeycloakID:    keycloakID,
+			KeycloakValue: string(member.role),
+		}
+		rs.fix(&drift, policy,
+			func() error { return rs.createUserFromKeycloak(ctx, tenant, member) },
+			func() error { return rs.identityProvider.SetUserEnabled(ctx, keycloakID, false) })
+		result.Drifts = append(result.Drifts, drift)
+	}
+
+	for _, drift := range result.Drifts {
+		rs.auditDrift(tenant.ID, policy, drift)
+	}
+
+	return result
+}
+
+// fix applies the side-specific fix for the policy and records the outcome on the drift
+func (rs *ReconciliationService) fix(drift *Drift, policy ReconciliationPolicy, fixDatabase, fixKeycloak func() error) {
+	var fixFunc func() error
+	switch policy {
+	case ReconciliationPolicyKeycloak:
+		fixFunc = fixDatabase
+	case ReconciliationPolicyDatabase:
+		fixFunc = fixKeycloak
+	default:
+		return
+	}
+
+	if err := fixFunc(); err != nil {
+		drift.FixError = err.Error()
+		logger.Warn("Failed to fix reconciliation drift",
+			zap.String("type", string(drift.Type)),
+			zap.String("email", drift.Email),
+			zap.Error(err))
+		return
+	}
+	drift.Fixed = true
+}
+
+// loadKeycloakMembers loads group members with their domain role, indexed by Keycloak ID
+func (rs *ReconciliationService) loadKeycloakMembers(ctx context.Context, groupID string) (map[string]keycloakMember, error) {
+	users, err := rs.identityProvider.GetUsersByTenant(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]keycloakMember, len(users))
+	for _, user := range users {
+		userID := gocloak.PString(user.ID)
+		roles, err := rs.identityProvider.GetUserRoles(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		members[userID] = keycloakMember{
+			user:    user,
+			role:    domainRole(roles),
+			enabled: gocloak.PBool(user.Enabled),
+		}
+	}
+
+	return members, nil
+}
+
+func (rs *ReconciliationService) createUserFromKeycloak(ctx context.Context, tenant *domain.Tenant, member keycloakMember) error {
+	role := member.role
+	if role == "" {
+		role = domain.UserRoleClient
+	}
+
+	status := domain.UserStatusActive
+	if !member.enabled {
+		status = domain.UserStatusBlocked
+	}
+
+	user := &domain.User{
+		KeycloakID: gocloak.PString(member.user.ID),
+		TenantID:   tenant.ID,
+		Email:      strings.ToLower(gocloak.PString(member.user.Email)),
+		FirstName:  gocloak.PString(member.user.FirstName),
+		LastName:   gocloak.PString(member.user.LastName),
+		Role:       role,
+		Status:     status,
+		Preferences: domain.UserPreferences{
+			Language:        tenant.Settings.Language,
+			Timezone:        tenant.Settings.Timezone,
+			NotificationsOn: true,
+		},
+	}
+
+	return rs.db.WithContext(ctx).Create(user).Error
+}
+
+func (rs *ReconciliationService) deactivateUser(ctx context.Context, user *domain.User) error {
+	return rs.db.WithContext(ctx).Model(user).Update("status", domain.UserStatusInactive).Error
+}
+
+func (rs *ReconciliationService) auditDrift(tenantID uuid.UUID, policy ReconciliationPolicy, drift Drift) {
+	audit := &domain.AuditLog{
+		TenantID:   tenantID,
+		Action:     "user.reconciliation_drift",
+		Resource:   "user",
+		ResourceID: drift.UserID,
+		Details: map[string]interface{}{
+			"type":           drift.Type,
+			"email":          drift.Email,
+			"keycloak_id":    drift.KeycloakID,
+			"keycloak_value": drift.KeycloakValue,
+			"database_value": drift.DatabaseValue,
+			"policy":         policy,
+			"fixed":          drift.Fixed,
+			"fix_error":      drift.FixError,
+		},
+	}
+	if err := rs.db.WithContext(domain.WithTenantID(context.Background(), tenantID)).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+}
+
+// domainRole picks the application role from a user's realm roles
+func domainRole(roles []*gocloak.Role) domain.UserRole {
+	for _, candidate := range []domain.UserRole{domain.UserRoleAdmin, domain.UserRoleLawyer, domain.UserRoleSecretary, domain.UserRoleClient} {
+		for _, role := range roles {
+			if gocloak.PString(role.Name) == string(candidate) {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
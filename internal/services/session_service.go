@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// UserSession is an active Keycloak session of a user
+type UserSession struct {
+	ID         string    `json:"id"`
+	IPAddress  string    `json:"ip_address"`
+	StartedAt  time.Time `json:"started_at"`
+	LastAccess time.Time `json:"last_access"`
+	Clients    []string  `json:"clients"`
+}
+
+type SessionService struct {
+	db               *gorm.DB
+	identityProvider auth.IdentityProvider
+	redisClient      *redis.Client
+}
+
+func NewSessionService(db *gorm.DB, identityProvider auth.IdentityProvider, redisClient *redis.Client) *SessionService {
+	return &SessionService{
+		db:               db,
+		identityProvider: identityProvider,
+		redisClient:      redisClient,
+	}
+}
+
+// ListSessions lists the active sessions of a tenant user, most recently used first
+func (s *SessionService) ListSessions(ctx context.Context, tenantID, userID uuid.UUID) ([]*UserSession, error) {
+	user, err := s.getTenantUser(ctx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.listKeycloakSessions(ctx, user)
+}
+
+// RevokeSession ends one session of a tenant user and purges its cached tokens
+func (s *SessionService) RevokeSession(ctx context.Context, tenantID, userID uuid.UUID, sessionID, actorID string) error {
+	user, err := s.getTenantUser(ctx, tenantID, userID)
+	if err != nil {
+		return err
+	}
+
+	// The session must belong to the user, otherwise admins could end sessions of other tenants
+	sessions, err := s.listKeycloakSessions(ctx, user)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, session := range sessions {
+		if session.ID == sessionID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrSessionNotFound
+	}
+
+	if err := s.identityProvider.LogoutSession(ctx, sessionID); err != nil {
+		return err
+	}
+
+	purged := s.purgeTokens(ctx, sessionID)
+	s.audit(ctx, user, "user.session_revoked", map[string]interface{}{
+		"actor_id":      actorID,
+		"session_ids":   []string{sessionID},
+		"purged_tokens": purged,
+	})
+
+	return nil
+}
+
+// RevokeAllSessions ends every session of a tenant user and returns how many were active
+func (s *SessionService) RevokeAllSessions(ctx context.Context, tenantID, userID uuid.UUID, actorID string) (int, error) {
+	user, err := s.getTenantUser(ctx, tenantID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	sessions, err := s.listKeycloakSessions(ctx, user)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.identityProvider.LogoutAllSessions(ctx, user.KeycloakID); err != nil {
+		return 0, err
+	}
+
+	sessionIDs := make([]string, 0, len(sessions))
+	purged := 0
+	for _, session := range sessions {
+		sessionIDs = append(sessionIDs, session.ID)
+		purged += s.purgeTokens(ctx, session.ID)
+	}
+
+	s.audit(ctx, user, "user.session_revoked", map[string]interface{}{
+		"actor_id":      actorID,
+		"session_ids":   sessionIDs,
+		"purged_tokens": purged,
+	})
+
+	return len(sessions), nil
+}
+
+func (s *SessionService) listKeycloakSessions(ctx context.Context, user *domain.User) ([]*UserSession, error) {
+	if user.KeycloakID == "" {
+		return []*UserSession{}, nil
+	}
+
+	kcSessions, err := s.identityProvider.GetUserSessions(ctx, user.KeycloakID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*UserSession, 0, len(kcSessions))
+	for _, kcSession := range kcSessions {
+		if kcSession.ID == nil {
+			continue
+		}
+
+		session := &UserSession{ID: *kcSession.ID, Clients: []string{}}
+		if kcSession.IPAddress != nil {
+			session.IPAddress = *kcSession.IPAddress
+		}
+		// Keycloak reports times in epoch milliseconds
+		if kcSession.Start != nil {
+			session.StartedAt = time.UnixMilli(*kcSession.Start)
+		}
+		if kcSession.LastAccess != nil {
+			session.LastAccess = time.UnixMilli(*kcSession.LastAccess)
+		}
+		if kcSession.Clients != nil {
+			for _, clientID := range *kcSession.Clients {
+				session.Clients = append(session.Clients, clientID)
+			}
+			sort.Strings(session.Clients)
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastAccess.After(sessions[j].LastAccess)
+	})
+
+	return sessions, nil
+}
+
+// purgeTokens makes middleware.Auth stop accepting the session tokens immediately
+func (s *SessionService) purgeTokens(ctx context.Context, sessionID string) int {
+	purged, err := auth.PurgeSessionTokens(ctx, s.redisClient, sessionID)
+	if err != nil {
+		logger.Error("Failed to purge session tokens",
+			zap.String("sessionID", sessionID),
+			zap.Error(err))
+	}
+	return purged
+}
+
+func (s *SessionService) getTenantUser(ctx context.Context, tenantID, userID uuid.UUID) (*domain.User, error) {
+	var user domain.User
+	err := s.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", userID, tenantID).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *SessionService) audit(ctx context.Context, user *domain.User, action string, details map[string]interface{}) {
+	audit := &domain.AuditLog{
+		TenantID:   user.TenantID,
+		UserID:     user.ID,
+		Action:     action,
+		Resource:   "user",
+		ResourceID: user.ID.String(),
+		Details:    details,
+	}
+	if err := s.db.WithContext(ctx).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+}
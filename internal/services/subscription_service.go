@@ -0,0 +1,435 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/config"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/internal/payments"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSubscriptionNotFound          = errors.New("subscription not found")
+	ErrInvalidSubscriptionTransition = errors.New("invalid subscription status transition")
+)
+
+// subscriptionTransitions lists the statuses each subscription status may move to
+var subscriptionTransitions = map[domain.SubscriptionStatus][]domain.SubscriptionStatus{
+	domain.SubscriptionStatusTrialing: {
+		domain.SubscriptionStatusActive,
+		domain.SubscriptionStatusPastDue,
+		domain.SubscriptionStatusUnpaid,
+		domain.SubscriptionStatusCanceled,
+	},
+	domain.SubscriptionStatusActive: {
+		domain.SubscriptionStatusPastDue,
+		domain.SubscriptionStatusCanceled,
+	},
+	domain.SubscriptionStatusPastDue: {
+		domain.SubscriptionStatusActive,
+		domain.SubscriptionStatusUnpaid,
+		domain.SubscriptionStatusCanceled,
+	},
+	domain.SubscriptionStatusUnpaid: {
+		domain.SubscriptionStatusActive,
+		domain.SubscriptionStatusCanceled,
+	},
+	// A canceled tenant may subscribe again
+	domain.SubscriptionStatusCanceled: {
+		domain.SubscriptionStatusActive,
+	},
+}
+
+// canTransitionSubscription reports whether a subscription may move from one status to another
+func canTransitionSubscription(from, to domain.SubscriptionStatus) bool {
+	for _, status := range subscriptionTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptionTenantStatuses is the tenant status each subscription status leads
+// to. Past due and unpaid subscriptions were never paid for the period, so they
+// leave a trial tenant in trial and an active one active; dunning restricts them.
+var subscriptionTenantStatuses = map[domain.SubscriptionStatus]domain.TenantStatus{
+	domain.SubscriptionStatusTrialing: domain.TenantStatusTrial,
+	domain.SubscriptionStatusActive:   domain.TenantStatusActive,
+	domain.SubscriptionStatusCanceled: domain.TenantStatusInactive,
+}
+
+// tenantStatusForSubscription returns the status a tenant moves to with its
+// subscription, and false when it keeps its status. Only trial, active and
+// inactive tenants follow the subscription.
+func tenantStatusForSubscription(current domain.TenantStatus, status domain.SubscriptionStatus) (domain.TenantStatus, bool) {
+	switch current {
+	case domain.TenantStatusTrial, domain.TenantStatusActive, domain.TenantStatusInactive:
+	default:
+		return current, false
+	}
+	target, ok := subscriptionTenantStatuses[status]
+	if !ok || target == current {
+		return current, false
+	}
+	return target, true
+}
+
+// subscriptionUnpaidReason marks tenants suspended because of an unpaid subscription,
+// the only suspensions a subscription coming back to good standing lifts
+const subscriptionUnpaidReason = "subscription unpaid"
+
+// SubscriptionReasonTrialExpired is the reason recorded when the scheduler ends a trial
+const SubscriptionReasonTrialExpired = "trial_expired"
+
+// SubscriptionService moves subscriptions through their lifecycle, expires trials
+// and keeps the tenant status in step with the subscription
+type SubscriptionService struct {
+	db               *gorm.DB
+	identityProvider auth.IdentityProvider
+	lifecycle        *TenantLifecycleService
+	notifier         Notifier
+	quotas           *QuotaService
+	gateway          payments.Gateway
+	cfg              config.BillingConfig
+}
+
+func NewSubscriptionService(db *gorm.DB, identityProvider auth.IdentityProvider, lifecycle *TenantLifecycleService, notifier Notifier, cfg *config.BillingConfig) *SubscriptionService {
+	return &SubscriptionService{
+		db:               db,
+		identityProvider: identityProvider,
+		lifecycle:        lifecycle,
+		notifier:         notifier,
+		cfg:              *cfg,
+	}
+}
+
+// EnableQuotaChecks refuses plan changes while the quota usage, such as the cases
+// reported by other services, exceeds the target plan limits
+func (s *SubscriptionService) EnableQuotaChecks(quotas *QuotaService) {
+	s.quotas = quotas
+}
+
+// EnableGatewayBilling leaves card subscriptions to be charged by the payment
+// gateway: plan changes move the gateway subscription to the new price, and
+// prorations and overage are billed as gateway invoice items
+func (s *SubscriptionService) EnableGatewayBilling(gateway payments.Gateway) {
+	s.gateway = gateway
+}
+
+// gatewayBilled reports whether the payment gateway charges the subscription
+func (s *SubscriptionService) gatewayBilled(subscription *domain.Subscription) bool {
+	return s.gateway != nil &&
+		subscription.PaymentMethod == PaymentMethodCard &&
+		subscription.StripeSubID != "" &&
+		subscription.Status != domain.SubscriptionStatusCanceled
+}
+
+// GetSubscription returns the subscription of a tenant
+func (s *SubscriptionService) GetSubscription(ctx context.Context, tenantID uuid.UUID) (*domain.Subscription, error) {
+	var subscription domain.Subscription
+	err := s.db.WithContext(domain.WithTenantID(ctx, tenantID)).Where("tenant_id = ?", tenantID).First(&subscription).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSubscriptionNotFound
+		}
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// Transition moves the subscription of a tenant to a new status, if the move is legal,
+// and updates the tenant status to match
+func (s *SubscriptionService) Transition(ctx context.Context, tenantID uuid.UUID, to domain.SubscriptionStatus, reason, actorID string) (*domain.Subscription, error) {
+	ctx = domain.WithTenantID(ctx, tenantID)
+	subscription, err := s.GetSubscription(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	from := subscription.Status
+	if !canTransitionSubscription(from, to) {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidSubscriptionTransition, from, to)
+	}
+
+	now := time.Now()
+	subscription.Status = to
+	switch to {
+	case domain.SubscriptionStatusCanceled:
+		subscription.CancelledAt = &now
+		subscription.EndDate = &now
+	case domain.SubscriptionStatusActive:
+		subscription.CancelledAt = nil
+		subscription.EndDate = nil
+	}
+	// Dunning runs from the first missed payment until the subscription is paid or canceled
+	switch to {
+	case domain.SubscriptionStatusPastDue, domain.SubscriptionStatusUnpaid:
+		if subscription.PastDueSince == nil {
+			subscription.PastDueSince = &now
+		}
+	default:
+		subscription.PastDueSince = nil
+		subscription.DunningRetriesDone = nil
+		subscription.StripeInvoiceID = ""
+	}
+
+	// Only move if nobody changed the status meanwhile, e.g. the scheduler and a webhook
+	result := s.db.WithContext(ctx).Model(subscription).
+		Where("status = ?", from).
+		Select("status", "cancelled_at", "end_date", "past_due_since", "dunning_retries_done", "stripe_invoice_id").
+		Updates(subscription)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: status changed concurrently", ErrInvalidSubscriptionTransition)
+	}
+
+	s.audit(ctx, subscription, "subscription.status_changed", map[string]interface{}{
+		"from":   from,
+		"to":     to,
+		"reason": reason,
+		"actor":  actorID,
+	})
+
+	logger.Info("Subscription status changed",
+		zap.String("tenantID", tenantID.String()),
+		zap.String("from", string(from)),
+		zap.String("to", string(to)),
+		zap.String("reason", reason))
+
+	if err := s.syncTenantStatus(ctx, tenantID, to, actorID); err != nil {
+		// The subscription is the source of truth; the next change retries the tenant
+		logger.Error("Failed to update tenant status for subscription",
+			zap.String("tenantID", tenantID.String()),
+			zap.String("subscriptionStatus", string(to)),
+			zap.Error(err))
+	}
+
+	return subscription, nil
+}
+
+// syncTenantStatus derives the tenant status from the subscription, as listed in
+// subscriptionTenantStatuses: unpaid makes the tenant read-only, good standing lifts
+// that and the suspension for payment, canceled deactivates it. The dunning
+// scheduler suspends unpaid tenants once the grace period ends. Tenants suspended
+// for other reasons or being offboarded are left alone.
+func (s *SubscriptionService) syncTenantStatus(ctx context.Context, tenantID uuid.UUID, status domain.SubscriptionStatus, actorID string) error {
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).First(&tenant, tenantID).Error; err != nil {
+		return err
+	}
+
+	suspendedForPayment := tenant.Status == domain.TenantStatusSuspended && tenant.StatusReason == subscriptionUnpaidReason
+
+	switch status {
+	case domain.SubscriptionStatusTrialing, domain.SubscriptionStatusActive, domain.SubscriptionStatusPastDue:
+		if err := s.lifecycle.SetReadOnly(ctx, tenantID, false, string(status), actorID); err != nil {
+			return err
+		}
+		if suspendedForPayment {
+			_, err := s.lifecycle.Reactivate(ctx, tenantID, actorID)
+			return err
+		}
+		if target, ok := tenantStatusForSubscription(tenant.Status, status); ok {
+			return s.setTenantStatus(ctx, &tenant, target)
+		}
+
+	case domain.SubscriptionStatusUnpaid:
+		if tenant.Status == domain.TenantStatusActive || tenant.Status == domain.TenantStatusTrial {
+			return s.lifecycle.SetReadOnly(ctx, tenantID, true, subscriptionUnpaidReason, actorID)
+		}
+
+	case domain.SubscriptionStatusCanceled:
+		if err := s.lifecycle.SetReadOnly(ctx, tenantID, false, string(status), actorID); err != nil {
+			return err
+		}
+		if target, ok := tenantStatusForSubscription(tenant.Status, status); ok {
+			return s.setTenantStatus(ctx, &tenant, target)
+		}
+	}
+	return nil
+}
+
+func (s *SubscriptionService) setTenantStatus(ctx context.Context, tenant *domain.Tenant, status domain.TenantStatus) error {
+	if tenant.Status == status {
+		return nil
+	}
+	tenant.Status = status
+	return s.lifecycle.save(ctx, tenant, "status")
+}
+
+// Start runs the trial scheduler and applies the scheduled plan changes every
+// interval until ctx is cancelled
+func (s *SubscriptionService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger.Info("Subscription scheduler started", zap.Duration("interval", interval))
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Subscription scheduler stopped")
+			return
+		case <-ticker.C:
+			if err := s.RunTrialScheduler(ctx); err != nil {
+				logger.Error("Subscription scheduler failed", zap.Error(err))
+			}
+			if err := s.ApplyScheduledPlanChanges(ctx); err != nil {
+				logger.Error("Subscription scheduler failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// RunTrialScheduler sends the reminders of trials about to end and expires the
+// trials that ended: subscriptions with a payment method become active, the
+// others past due
+func (s *SubscriptionService) RunTrialScheduler(ctx context.Context) error {
+	now := time.Now()
+	horizon := now.AddDate(0, 0, maxReminderDay(s.cfg.TrialReminderDays))
+
+	var trials []domain.Subscription
+	err := s.db.WithContext(domain.CrossTenant(ctx)).
+		Where("status = ? AND trial_ends_at <= ?", domain.SubscriptionStatusTrialing, horizon).
+		Find(&trials).Error
+	if err != nil {
+		return fmt.Errorf("failed to list trials: %w", err)
+	}
+
+	for i := range trials {
+		subscription := &trials[i]
+		if subscription.TrialEndsAt == nil {
+			continue
+		}
+
+		if !subscription.TrialEndsAt.After(now) {
+			to := domain.SubscriptionStatusPastDue
+			if subscription.PaymentMethod != "" {
+				to = domain.SubscriptionStatusActive
+			}
+			if _, err := s.Transition(ctx, subscription.TenantID, to, SubscriptionReasonTrialExpired, "system"); err != nil {
+				logger.Error("Failed to expire trial",
+					zap.String("tenantID", subscription.TenantID.String()),
+					zap.Error(err))
+			}
+			continue
+		}
+
+		s.sendTrialReminder(ctx, subscription, now)
+	}
+	return nil
+}
+
+// sendTrialReminder sends one notification for the reminders a trial has reached
+func (s *SubscriptionService) sendTrialReminder(ctx context.Context, subscription *domain.Subscription, now time.Time) {
+	daysLeft := int(math.Ceil(subscription.TrialEndsAt.Sub(now).Hours() / 24))
+	due, sent := dueTrialReminders(s.cfg.TrialReminderDays, subscription.TrialRemindersSent, daysLeft)
+	if len(due) == 0 {
+		return
+	}
+
+	ctx = domain.WithTenantID(ctx, subscription.TenantID)
+	admins, err := s.tenantAdmins(ctx, subscription.TenantID)
+	if err != nil {
+		logger.Error("Failed to load tenant admins for trial reminder",
+			zap.String("tenantID", subscription.TenantID.String()),
+			zap.Error(err))
+		return
+	}
+
+	if s.notifier != nil && len(admins) > 0 {
+		err = s.notifier.Notify(ctx, Notification{
+			TenantID:   subscription.TenantID,
+			Type:       "subscription.trial_ending",
+			Recipients: admins,
+			Subject:    fmt.Sprintf("Your trial ends in %d day(s)", daysLeft),
+			Body: fmt.Sprintf("The trial of your subscription ends on %s. Add a payment method to keep using the platform.",
+				subscription.TrialEndsAt.Format("02/01/2006")),
+		})
+		if err != nil {
+			logger.Error("Failed to send trial reminder",
+				zap.String("tenantID", subscription.TenantID.String()),
+				zap.Error(err))
+			return
+		}
+	}
+
+	// Reminders skipped while the scheduler was down are not sent late
+	subscription.TrialRemindersSent = sent
+	if err := s.db.WithContext(ctx).Model(subscription).Select("trial_reminders_sent").Updates(subscription).Error; err != nil {
+		logger.Error("Failed to record trial reminder",
+			zap.String("tenantID", subscription.TenantID.String()),
+			zap.Error(err))
+		return
+	}
+
+	s.audit(ctx, subscription, "subscription.trial_reminder_sent", map[string]interface{}{
+		"days_left":     daysLeft,
+		"trial_ends_at": subscription.TrialEndsAt,
+		"recipients":    len(admins),
+	})
+}
+
+// dueTrialReminders returns the configured reminder days reached by daysLeft that
+// were not sent yet, and the updated list of sent reminders
+func dueTrialReminders(configured, sent []int, daysLeft int) (due, updated []int) {
+	alreadySent := make(map[int]bool, len(sent))
+	for _, day := range sent {
+		alreadySent[day] = true
+	}
+
+	updated = append(updated, sent...)
+	for _, day := range configured {
+		if day >= daysLeft && !alreadySent[day] {
+			due = append(due, day)
+			updated = append(updated, day)
+			alreadySent[day] = true
+		}
+	}
+	sort.Ints(updated)
+	return due, updated
+}
+
+func maxReminderDay(days []int) int {
+	maxDay := 0
+	for _, day := range days {
+		if day > maxDay {
+			maxDay = day
+		}
+	}
+	return maxDay
+}
+
+func (s *SubscriptionService) tenantAdmins(ctx context.Context, tenantID uuid.UUID) ([]string, error) {
+	var admins []string
+	err := s.db.WithContext(ctx).Model(&domain.User{}).
+		Where("tenant_id = ? AND role = ? AND status = ?", tenantID, domain.UserRoleAdmin, domain.UserStatusActive).
+		Pluck("email", &admins).Error
+	return admins, err
+}
+
+func (s *SubscriptionService) audit(ctx context.Context, subscription *domain.Subscription, action string, details map[string]interface{}) {
+	audit := &domain.AuditLog{
+		TenantID:   subscription.TenantID,
+		Action:     action,
+		Resource:   "subscription",
+		ResourceID: subscription.ID.String(),
+		Details:    details,
+	}
+	if err := s.db.WithContext(domain.WithTenantID(ctx, subscription.TenantID)).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+}
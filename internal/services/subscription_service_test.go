@@ -0,0 +1,79 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/opiagile/direito-lux/internal/domain"
+)
+
+func TestCanTransitionSubscription(t *testing.T) {
+	tests := []struct {
+		from domain.SubscriptionStatus
+		to   domain.SubscriptionStatus
+		want bool
+	}{
+		{domain.SubscriptionStatusTrialing, domain.SubscriptionStatusActive, true},
+		{domain.SubscriptionStatusTrialing, domain.SubscriptionStatusPastDue, true},
+		{domain.SubscriptionStatusActive, domain.SubscriptionStatusPastDue, true},
+		{domain.SubscriptionStatusActive, domain.SubscriptionStatusTrialing, false},
+		{domain.SubscriptionStatusActive, domain.SubscriptionStatusUnpaid, false},
+		{domain.SubscriptionStatusPastDue, domain.SubscriptionStatusUnpaid, true},
+		{domain.SubscriptionStatusUnpaid, domain.SubscriptionStatusActive, true},
+		{domain.SubscriptionStatusUnpaid, domain.SubscriptionStatusPastDue, false},
+		{domain.SubscriptionStatusCanceled, domain.SubscriptionStatusActive, true},
+		{domain.SubscriptionStatusCanceled, domain.SubscriptionStatusTrialing, false},
+		{domain.SubscriptionStatusActive, domain.SubscriptionStatusActive, false},
+	}
+
+	for _, tt := range tests {
+		if got := canTransitionSubscription(tt.from, tt.to); got != tt.want {
+			t.Errorf("canTransitionSubscription(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestTenantStatusForSubscription(t *testing.T) {
+	tests := []struct {
+		current domain.TenantStatus
+		status  domain.SubscriptionStatus
+		want    domain.TenantStatus
+		changed bool
+	}{
+		{domain.TenantStatusTrial, domain.SubscriptionStatusActive, domain.TenantStatusActive, true},
+		// A trial that ends without payment is not an active tenant
+		{domain.TenantStatusTrial, domain.SubscriptionStatusPastDue, domain.TenantStatusTrial, false},
+		{domain.TenantStatusActive, domain.SubscriptionStatusPastDue, domain.TenantStatusActive, false},
+		{domain.TenantStatusActive, domain.SubscriptionStatusUnpaid, domain.TenantStatusActive, false},
+		{domain.TenantStatusActive, domain.SubscriptionStatusCanceled, domain.TenantStatusInactive, true},
+		{domain.TenantStatusInactive, domain.SubscriptionStatusActive, domain.TenantStatusActive, true},
+		{domain.TenantStatusSuspended, domain.SubscriptionStatusActive, domain.TenantStatusSuspended, false},
+		{domain.TenantStatusOffboarding, domain.SubscriptionStatusCanceled, domain.TenantStatusOffboarding, false},
+	}
+
+	for _, tt := range tests {
+		got, changed := tenantStatusForSubscription(tt.current, tt.status)
+		if got != tt.want || changed != tt.changed {
+			t.Errorf("tenantStatusForSubscription(%q, %q) = %q, %v, want %q, %v", tt.current, tt.status, got, changed, tt.want, tt.changed)
+		}
+	}
+}
+
+func TestDueTrialReminders(t *testing.T) {
+	configured := []int{7, 3, 1}
+
+	due, sent := dueTrialReminders(configured, nil, 5)
+	if !reflect.DeepEqual(due, []int{7}) || !reflect.DeepEqual(sent, []int{7}) {
+		t.Errorf("5 days left: due = %v, sent = %v", due, sent)
+	}
+
+	// The scheduler was down through the 3 day reminder
+	due, sent = dueTrialReminders(configured, sent, 1)
+	if !reflect.DeepEqual(due, []int{3, 1}) || !reflect.DeepEqual(sent, []int{1, 3, 7}) {
+		t.Errorf("1 day left: due = %v, sent = %v", due, sent)
+	}
+
+	if due, _ = dueTrialReminders(configured, sent, 1); len(due) != 0 {
+		t.Errorf("reminders sent twice: %v", due)
+	}
+}
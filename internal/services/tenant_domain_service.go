@@ -0,0 +1,418 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/config"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidDomain            = errors.New("invalid domain")
+	ErrDomainTaken              = errors.New("domain already in use")
+	ErrCustomDomainNotAllowed   = errors.New("plan does not allow custom domains")
+	ErrNoCustomDomain           = errors.New("tenant has no custom domain")
+	ErrDomainVerificationFailed = errors.New("domain verification record not found")
+)
+
+const (
+	// domainVerificationPrefix names the TXT record checked for a custom domain
+	domainVerificationPrefix = "_direito-lux-challenge"
+	// domainVerificationValuePrefix starts the expected TXT record value
+	domainVerificationValuePrefix = "direito-lux-verification="
+	hostTenantCacheTTL            = 5 * time.Minute
+	tenantRefCacheTTL             = time.Hour
+)
+
+var domainNameRegex = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// DomainVerification describes a custom domain and the DNS record proving its ownership
+type DomainVerification struct {
+	Domain      string     `json:"domain"`
+	Verified    bool       `json:"verified"`
+	VerifiedAt  *time.Time `json:"verified_at,omitempty"`
+	RecordType  string     `json:"record_type"`
+	RecordName  string     `json:"record_name"`
+	RecordValue string     `json:"record_value"`
+}
+
+// TenantRef identifies a tenant, its lifecycle status and access mode, and the
+// name of its plan
+type TenantRef struct {
+	ID       uuid.UUID           `json:"id"`
+	Status   domain.TenantStatus `json:"status"`
+	ReadOnly bool                `json:"read_only"`
+	Plan     string              `json:"plan"`
+}
+
+// TenantDomainService resolves tenants from request hosts, subdomains of the base
+// domain or verified custom domains, and manages custom domain verification
+type TenantDomainService struct {
+	db          *gorm.DB
+	redisClient *redis.Client
+	cfg         config.TenancyConfig
+	lookupTXT   func(ctx context.Context, name string) ([]string, error)
+}
+
+func NewTenantDomainService(db *gorm.DB, redisClient *redis.Client, cfg *config.TenancyConfig) *TenantDomainService {
+	return &TenantDomainService{
+		db:          db,
+		redisClient: redisClient,
+		cfg:         *cfg,
+		lookupTXT:   net.DefaultResolver.LookupTXT,
+	}
+}
+
+// ResolveHost returns the tenant served at a host. Platform hosts (the base domain,
+// reserved subdomains and unknown hosts) return nil; unknown tenant subdomains
+// return ErrTenantNotFound.
+func (s *TenantDomainService) ResolveHost(ctx context.Context, host string) (*domain.Tenant, error) {
+	host = normalizeHost(host)
+	if host == "" {
+		return nil, nil
+	}
+
+	subdomain, isSubdomain := s.tenantSubdomain(host)
+	if !isSubdomain && (host == s.baseDomain() || net.ParseIP(host) != nil || !strings.Contains(host, ".")) {
+		return nil, nil
+	}
+
+	cacheKey := hostTenantCacheKey(host)
+	if cached, err := s.redisClient.Get(ctx, cacheKey).Bytes(); err == nil {
+		var tenant domain.Tenant
+		if err := json.Unmarshal(cached, &tenant); err == nil {
+			return &tenant, nil
+		}
+	}
+
+	query := s.db.WithContext(ctx).Select("id", "name", "display_name", "domain", "domain_verified_at", "status", "settings")
+	if isSubdomain {
+		query = query.Where("name = ?", subdomain)
+	} else {
+		query = query.Where("domain = ? AND domain_verified_at IS NOT NULL", host)
+	}
+
+	var tenant domain.Tenant
+	if err := query.First(&tenant).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if isSubdomain {
+			return nil, ErrTenantNotFound
+		}
+		// Not a custom domain we serve, e.g. the API host itself
+		return nil, nil
+	}
+
+	if data, err := json.Marshal(&tenant); err == nil {
+		s.redisClient.Set(ctx, cacheKey, data, hostTenantCacheTTL)
+	}
+
+	return &tenant, nil
+}
+
+// ResolveName returns the ID, status and plan of a tenant by name, cached in Redis;
+// TenantLifecycleService drops the cache entry when the status or the plan changes
+func (s *TenantDomainService) ResolveName(ctx context.Context, name string) (*TenantRef, error) {
+	cacheKey := tenantRefCacheKey(name)
+	if cached, err := s.redisClient.Get(ctx, cacheKey).Bytes(); err == nil {
+		var ref TenantRef
+		if err := json.Unmarshal(cached, &ref); err == nil {
+			return &ref, nil
+		}
+	}
+
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).Select("id", "status", "read_only", "plan_id").Where("name = ?", name).First(&tenant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+
+	ref := &TenantRef{ID: tenant.ID, Status: tenant.Status, ReadOnly: tenant.ReadOnly}
+	if err := s.db.WithContext(ctx).Model(&domain.Plan{}).Select("name").Where("id = ?", tenant.PlanID).Scan(&ref.Plan).Error; err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(ref); err == nil {
+		s.redisClient.Set(ctx, cacheKey, data, tenantRefCacheTTL)
+	}
+	return ref, nil
+}
+
+// GetCustomDomain returns the custom domain of a tenant and its verification record
+func (s *TenantDomainService) GetCustomDomain(ctx context.Context, tenantID uuid.UUID) (*DomainVerification, error) {
+	tenant, err := s.getTenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if tenant.Domain == "" {
+		return nil, ErrNoCustomDomain
+	}
+
+	return domainVerification(tenant), nil
+}
+
+// SetCustomDomain assigns a custom domain to a tenant. The domain is served only
+// after VerifyCustomDomain finds the returned TXT record.
+func (s *TenantDomainService) SetCustomDomain(ctx context.Context, tenantID uuid.UUID, domainName, actorID string) (*DomainVerification, error) {
+	domainName, err := s.normalizeCustomDomain(domainName)
+	if err != nil {
+		return nil, err
+	}
+
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).Preload("Plan").First(&tenant, tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+
+	if !tenant.Plan.Limits.AllowCustomDomain {
+		return nil, ErrCustomDomainNotAllowed
+	}
+	if tenant.Domain == domainName {
+		return domainVerification(&tenant), nil
+	}
+
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&domain.Tenant{}).
+		Where("domain = ? AND id <> ?", domainName, tenantID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrDomainTaken
+	}
+
+	token, err := newDomainVerificationToken()
+	if err != nil {
+		return nil, err
+	}
+
+	previous := tenant.Domain
+	tenant.Domain = domainName
+	tenant.DomainVerificationToken = token
+	tenant.DomainVerifiedAt = nil
+	if err := s.db.WithContext(ctx).Model(&tenant).
+		Select("domain", "domain_verification_token", "domain_verified_at").Updates(&tenant).Error; err != nil {
+		return nil, err
+	}
+	s.forgetHost(ctx, previous)
+
+	s.audit(ctx, &tenant, "tenant.domain_set", map[string]interface{}{
+		"actor":    actorID,
+		"from":     previous,
+		"to":       domainName,
+		"verified": false,
+	})
+
+	return domainVerification(&tenant), nil
+}
+
+// VerifyCustomDomain looks up the TXT record of the tenant's custom domain and
+// activates the domain when it carries the verification token
+func (s *TenantDomainService) VerifyCustomDomain(ctx context.Context, tenantID uuid.UUID, actorID string) (*DomainVerification, error) {
+	tenant, err := s.getTenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if tenant.Domain == "" {
+		return nil, ErrNoCustomDomain
+	}
+	if tenant.DomainVerifiedAt != nil {
+		return domainVerification(tenant), nil
+	}
+
+	verification := domainVerification(tenant)
+	records, err := s.lookupTXT(ctx, verification.RecordName)
+	if err != nil {
+		logger.Info("Custom domain TXT lookup failed",
+			zap.String("tenantID", tenantID.String()),
+			zap.String("domain", tenant.Domain),
+			zap.Error(err))
+		return nil, ErrDomainVerificationFailed
+	}
+
+	found := false
+	for _, record := range records {
+		if strings.TrimSpace(record) == verification.RecordValue {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrDomainVerificationFailed
+	}
+
+	now := time.Now()
+	tenant.DomainVerifiedAt = &now
+	if err := s.db.WithContext(ctx).Model(tenant).Select("domain_verified_at").Updates(tenant).Error; err != nil {
+		return nil, err
+	}
+	s.forgetHost(ctx, tenant.Domain)
+
+	s.audit(ctx, tenant, "tenant.domain_verified", map[string]interface{}{
+		"actor":  actorID,
+		"domain": tenant.Domain,
+	})
+
+	return domainVerification(tenant), nil
+}
+
+// RemoveCustomDomain stops serving the tenant at its custom domain
+func (s *TenantDomainService) RemoveCustomDomain(ctx context.Context, tenantID uuid.UUID, actorID string) error {
+	tenant, err := s.getTenant(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	if tenant.Domain == "" {
+		return ErrNoCustomDomain
+	}
+
+	previous := tenant.Domain
+	tenant.Domain = ""
+	tenant.DomainVerificationToken = ""
+	tenant.DomainVerifiedAt = nil
+	if err := s.db.WithContext(ctx).Model(tenant).
+		Select("domain", "domain_verification_token", "domain_verified_at").Updates(tenant).Error; err != nil {
+		return err
+	}
+	s.forgetHost(ctx, previous)
+
+	s.audit(ctx, tenant, "tenant.domain_removed", map[string]interface{}{
+		"actor":  actorID,
+		"domain": previous,
+	})
+
+	return nil
+}
+
+// tenantSubdomain returns the tenant name of a "<name>.<base domain>" host
+func (s *TenantDomainService) tenantSubdomain(host string) (string, bool) {
+	base := s.baseDomain()
+	if base == "" || !strings.HasSuffix(host, "."+base) {
+		return "", false
+	}
+
+	subdomain := strings.TrimSuffix(host, "."+base)
+	if subdomain == "" || strings.Contains(subdomain, ".") {
+		return "", false
+	}
+	for _, reserved := range s.cfg.ReservedSubdomains {
+		if strings.EqualFold(subdomain, reserved) {
+			return "", false
+		}
+	}
+
+	return subdomain, true
+}
+
+func (s *TenantDomainService) baseDomain() string {
+	return strings.ToLower(strings.Trim(s.cfg.BaseDomain, "."))
+}
+
+// normalizeCustomDomain validates a custom domain; subdomains of the base domain
+// are assigned by tenant name and cannot be claimed
+func (s *TenantDomainService) normalizeCustomDomain(domainName string) (string, error) {
+	domainName, err := normalizeDomainName(domainName)
+	if err != nil {
+		return "", err
+	}
+
+	if base := s.baseDomain(); base != "" && (domainName == base || strings.HasSuffix(domainName, "."+base)) {
+		return "", ErrInvalidDomain
+	}
+
+	return domainName, nil
+}
+
+func (s *TenantDomainService) forgetHost(ctx context.Context, host string) {
+	if host == "" {
+		return
+	}
+	s.redisClient.Del(ctx, hostTenantCacheKey(host))
+}
+
+func (s *TenantDomainService) getTenant(ctx context.Context, tenantID uuid.UUID) (*domain.Tenant, error) {
+	var tenant domain.Tenant
+	if err := s.db.WithContext(ctx).First(&tenant, tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+func (s *TenantDomainService) audit(ctx context.Context, tenant *domain.Tenant, action string, details map[string]interface{}) {
+	audit := &domain.AuditLog{
+		TenantID:   tenant.ID,
+		Action:     action,
+		Resource:   "tenant",
+		ResourceID: tenant.ID.String(),
+		Details:    details,
+	}
+	if err := s.db.WithContext(ctx).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+}
+
+func domainVerification(tenant *domain.Tenant) *DomainVerification {
+	return &DomainVerification{
+		Domain:      tenant.Domain,
+		Verified:    tenant.DomainVerifiedAt != nil,
+		VerifiedAt:  tenant.DomainVerifiedAt,
+		RecordType:  "TXT",
+		RecordName:  domainVerificationPrefix + "." + tenant.Domain,
+		RecordValue: domainVerificationValuePrefix + tenant.DomainVerificationToken,
+	}
+}
+
+func newDomainVerificationToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate domain verification token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// normalizeDomainName lowercases a domain name and checks its syntax
+func normalizeDomainName(domainName string) (string, error) {
+	domainName = normalizeHost(domainName)
+	if len(domainName) > 253 || !domainNameRegex.MatchString(domainName) {
+		return "", ErrInvalidDomain
+	}
+	return domainName, nil
+}
+
+// normalizeHost lowercases a host and strips the port and trailing dot
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+func tenantRefCacheKey(name string) string {
+	return fmt.Sprintf("tenant_ref:%s", name)
+}
+
+func hostTenantCacheKey(host string) string {
+	return fmt.Sprintf("tenant_host:%s", host)
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/opiagile/direito-lux/internal/config"
+)
+
+func newTestDomainService() *TenantDomainService {
+	return &TenantDomainService{cfg: config.TenancyConfig{
+		BaseDomain:         "direitolux.com.br",
+		ReservedSubdomains: []string{"www", "api"},
+	}}
+}
+
+func TestTenantSubdomain(t *testing.T) {
+	s := newTestDomainService()
+
+	tests := []struct {
+		host   string
+		tenant string
+		ok     bool
+	}{
+		{"silva-advogados.direitolux.com.br", "silva-advogados", true},
+		{"direitolux.com.br", "", false},
+		{"www.direitolux.com.br", "", false},
+		{"a.b.direitolux.com.br", "", false},
+		{"silva.com.br", "", false},
+		{"evildirectolux.com.br", "", false},
+	}
+
+	for _, tt := range tests {
+		tenant, ok := s.tenantSubdomain(normalizeHost(tt.host))
+		if tenant != tt.tenant || ok != tt.ok {
+			t.Errorf("tenantSubdomain(%q) = %q, %v, want %q, %v", tt.host, tenant, ok, tt.tenant, tt.ok)
+		}
+	}
+}
+
+func TestNormalizeCustomDomain(t *testing.T) {
+	s := newTestDomainService()
+
+	tests := []struct {
+		domain string
+		want   string
+		valid  bool
+	}{
+		{"Portal.SilvaAdvogados.com.br", "portal.silvaadvogados.com.br", true},
+		{"silva.adv.br.", "silva.adv.br", true},
+		{"silva.adv.br:443", "silva.adv.br", true},
+		{"localhost", "", false},
+		{"-silva.com.br", "", false},
+		{"silva_adv.com.br", "", false},
+		{"silva.direitolux.com.br", "", false},
+		{"direitolux.com.br", "", false},
+	}
+
+	for _, tt := range tests {
+		got, err := s.normalizeCustomDomain(tt.domain)
+		if (err == nil) != tt.valid || got != tt.want {
+			t.Errorf("normalizeCustomDomain(%q) = %q, %v, want %q (valid %v)", tt.domain, got, err, tt.want, tt.valid)
+		}
+	}
+}
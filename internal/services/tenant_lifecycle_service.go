@@ -0,0 +1,532 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/config"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidTenantTransition = errors.New("invalid tenant status transition")
+	ErrNoDataExport            = errors.New("tenant has no data export")
+)
+
+// Lifecycle operations, with the statuses each one may start from
+const (
+	TenantActionSuspend    = "suspend"
+	TenantActionReactivate = "reactivate"
+	TenantActionOffboard   = "offboard"
+	TenantActionDelete     = "delete"
+)
+
+var tenantTransitions = map[string][]domain.TenantStatus{
+	TenantActionSuspend:    {domain.TenantStatusActive, domain.TenantStatusTrial},
+	TenantActionReactivate: {domain.TenantStatusSuspended, domain.TenantStatusOffboarding},
+	TenantActionOffboard:   {domain.TenantStatusActive, domain.TenantStatusTrial, domain.TenantStatusSuspended, domain.TenantStatusInactive},
+	TenantActionDelete:     {domain.TenantStatusOffboarding},
+}
+
+// canTransition reports whether action may run on a tenant in the given status
+func canTransition(action string, from domain.TenantStatus) bool {
+	for _, status := range tenantTransitions[action] {
+		if status == from {
+			return true
+		}
+	}
+	return false
+}
+
+// TenantTransition is the outcome of a lifecycle operation. Identity updates are
+// best effort once the status changed; failed users are listed for follow-up.
+type TenantTransition struct {
+	Tenant       *domain.Tenant `json:"tenant"`
+	UsersUpdated int            `json:"users_updated"`
+	UserFailures []string       `json:"user_failures,omitempty"`
+}
+
+// TenantDataExport is the data handed over to an offboarded tenant
+type TenantDataExport struct {
+	ExportedAt   time.Time            `json:"exported_at"`
+	Tenant       *domain.Tenant       `json:"tenant"`
+	Subscription *domain.Subscription `json:"subscription,omitempty"`
+	Users        []domain.User        `json:"users"`
+	APIKeys      []domain.APIKey      `json:"api_keys"`
+	AuditLogs    []domain.AuditLog    `json:"audit_logs"`
+	Invoices     []domain.Invoice     `json:"invoices"` // with their lines
+}
+
+// TenantLifecycleService suspends, reactivates, offboards and deletes tenants,
+// keeping the Keycloak users of the tenant in step with its status
+type TenantLifecycleService struct {
+	db               *gorm.DB
+	identityProvider auth.IdentityProvider
+	redisClient      *redis.Client
+	cfg              config.TenancyConfig
+}
+
+func NewTenantLifecycleService(db *gorm.DB, identityProvider auth.IdentityProvider, redisClient *redis.Client, cfg *config.TenancyConfig) *TenantLifecycleService {
+	return &TenantLifecycleService{
+		db:               db,
+		identityProvider: identityProvider,
+		redisClient:      redisClient,
+		cfg:              *cfg,
+	}
+}
+
+// Start hard deletes offboarded tenants whose grace period ended, until ctx is cancelled
+func (s *TenantLifecycleService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger.Info("Tenant purge job started", zap.Duration("interval", interval))
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Tenant purge job stopped")
+			return
+		case <-ticker.C:
+			if _, err := s.PurgeDue(ctx); err != nil {
+				logger.Error("Scheduled tenant purge failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Suspend blocks a tenant: its API access is refused and its Keycloak users are disabled
+func (s *TenantLifecycleService) Suspend(ctx context.Context, tenantID uuid.UUID, reason, actorID string) (*TenantTransition, error) {
+	ctx = domain.WithTenantID(ctx, tenantID)
+	tenant, err := s.getTenant(ctx, tenantID, TenantActionSuspend)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	previousStatus := tenant.Status
+	tenant.Status = domain.TenantStatusSuspended
+	tenant.StatusReason = reason
+	tenant.SuspendedAt = &now
+	if err := s.save(ctx, tenant, "status", "status_reason", "suspended_at"); err != nil {
+		return nil, err
+	}
+
+	result := s.setUsersEnabled(ctx, tenant, false)
+	s.audit(ctx, tenant, "tenant.suspended", map[string]interface{}{
+		"from":          previousStatus,
+		"reason":        reason,
+		"actor":         actorID,
+		"users_updated": result.UsersUpdated,
+		"user_failures": result.UserFailures,
+	})
+
+	logger.Info("Tenant suspended",
+		zap.String("tenantID", tenant.ID.String()),
+		zap.String("reason", reason))
+
+	return result, nil
+}
+
+// Reactivate restores a suspended or offboarding tenant, re-enabling the Keycloak
+// users that are active in the database and cancelling any scheduled deletion
+func (s *TenantLifecycleService) Reactivate(ctx context.Context, tenantID uuid.UUID, actorID string) (*TenantTransition, error) {
+	ctx = domain.WithTenantID(ctx, tenantID)
+	tenant, err := s.getTenant(ctx, tenantID, TenantActionReactivate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tenants still in their trial go back to it
+	previousStatus := tenant.Status
+	tenant.Status = domain.TenantStatusActive
+	if tenant.Subscription != nil && tenant.Subscription.Status == domain.SubscriptionStatusTrialing {
+		tenant.Status = domain.TenantStatusTrial
+	}
+	exportPath := tenant.DataExportPath
+	tenant.StatusReason = ""
+	tenant.SuspendedAt = nil
+	tenant.OffboardedAt = nil
+	tenant.DeletionScheduledAt = nil
+	tenant.DataExportPath = ""
+	if err := s.save(ctx, tenant, "status", "status_reason", "suspended_at", "offboarded_at", "deletion_scheduled_at", "data_export_path"); err != nil {
+		return nil, err
+	}
+	s.removeExport(exportPath)
+
+	result := s.setUsersEnabled(ctx, tenant, true)
+	s.audit(ctx, tenant, "tenant.reactivated", map[string]interface{}{
+		"from":          previousStatus,
+		"to":            tenant.Status,
+		"actor":         actorID,
+		"users_updated": result.UsersUpdated,
+		"user_failures": result.UserFailures,
+	})
+
+	logger.Info("Tenant reactivated",
+		zap.String("tenantID", tenant.ID.String()),
+		zap.String("status", string(tenant.Status)))
+
+	return result, nil
+}
+
+// SetReadOnly lets the users of a tenant read its data without changing it, or
+// lifts that restriction; setting the current mode again does nothing
+func (s *TenantLifecycleService) SetReadOnly(ctx context.Context, tenantID uuid.UUID, readOnly bool, reason, actorID string) error {
+	ctx = domain.WithTenantID(ctx, tenantID)
+	tenant, err := s.getTenant(ctx, tenantID, "")
+	if err != nil {
+		return err
+	}
+	if tenant.ReadOnly == readOnly {
+		return nil
+	}
+
+	tenant.ReadOnly = readOnly
+	if err := s.save(ctx, tenant, "read_only"); err != nil {
+		return err
+	}
+
+	action := "tenant.read_only"
+	if !readOnly {
+		action = "tenant.read_write"
+	}
+	s.audit(ctx, tenant, action, map[string]interface{}{
+		"reason": reason,
+		"actor":  actorID,
+	})
+
+	logger.Info("Tenant access mode changed",
+		zap.String("tenantID", tenant.ID.String()),
+		zap.Bool("readOnly", readOnly),
+		zap.String("reason", reason))
+	return nil
+}
+
+// Offboard exports the tenant data, disables its users and schedules the hard
+// delete for the end of the grace period
+func (s *TenantLifecycleService) Offboard(ctx context.Context, tenantID uuid.UUID, reason, actorID string) (*TenantTransition, error) {
+	ctx = domain.WithTenantID(ctx, tenantID)
+	tenant, err := s.getTenant(ctx, tenantID, TenantActionOffboard)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	exportPath, err := s.writeExport(ctx, tenant, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export tenant data: %w", err)
+	}
+
+	previousStatus := tenant.Status
+	deletionAt := now.Add(s.cfg.OffboardingGracePeriod)
+	tenant.Status = domain.TenantStatusOffboarding
+	tenant.StatusReason = reason
+	tenant.OffboardedAt = &now
+	tenant.DeletionScheduledAt = &deletionAt
+	tenant.DataExportPath = exportPath
+	if err := s.save(ctx, tenant, "status", "status_reason", "offboarded_at", "deletion_scheduled_at", "data_export_path"); err != nil {
+		s.removeExport(exportPath)
+		return nil, err
+	}
+
+	result := s.setUsersEnabled(ctx, tenant, false)
+	s.audit(ctx, tenant, "tenant.offboarded", map[string]interface{}{
+		"from":                  previousStatus,
+		"reason":                reason,
+		"actor":                 actorID,
+		"deletion_scheduled_at": deletionAt,
+		"users_updated":         result.UsersUpdated,
+		"user_failures":         result.UserFailures,
+	})
+
+	logger.Info("Tenant offboarded",
+		zap.String("tenantID", tenant.ID.String()),
+		zap.Time("deletionScheduledAt", deletionAt))
+
+	return result, nil
+}
+
+// ExportPath returns the data export file of an offboarding tenant
+func (s *TenantLifecycleService) ExportPath(ctx context.Context, tenantID uuid.UUID) (string, error) {
+	tenant, err := s.getTenant(domain.WithTenantID(ctx, tenantID), tenantID, "")
+	if err != nil {
+		return "", err
+	}
+	if tenant.Status != domain.TenantStatusOffboarding || tenant.DataExportPath == "" {
+		return "", ErrNoDataExport
+	}
+	return tenant.DataExportPath, nil
+}
+
+// Delete hard deletes an offboarding tenant now, without waiting for the grace period
+func (s *TenantLifecycleService) Delete(ctx context.Context, tenantID uuid.UUID, actorID string) error {
+	ctx = domain.WithTenantID(ctx, tenantID)
+	tenant, err := s.getTenant(ctx, tenantID, TenantActionDelete)
+	if err != nil {
+		return err
+	}
+	return s.hardDelete(ctx, tenant, actorID)
+}
+
+// PurgeDue hard deletes the offboarding tenants whose deletion date has passed and
+// returns how many were deleted; failed tenants are retried on the next run
+func (s *TenantLifecycleService) PurgeDue(ctx context.Context) (int, error) {
+	var tenants []domain.Tenant
+	err := s.db.WithContext(ctx).
+		Where("status = ? AND deletion_scheduled_at <= ?", domain.TenantStatusOffboarding, time.Now()).
+		Find(&tenants).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to list tenants due for deletion: %w", err)
+	}
+
+	purged := 0
+	for i := range tenants {
+		if err := s.hardDelete(ctx, &tenants[i], "system"); err != nil {
+			logger.Error("Failed to purge tenant",
+				zap.String("tenantID", tenants[i].ID.String()),
+				zap.Error(err))
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// hardDelete removes the Keycloak users and group of the tenant, then all of its rows
+// and the usage it has in Redis
+func (s *TenantLifecycleService) hardDelete(ctx context.Context, tenant *domain.Tenant, actorID string) error {
+	ctx = domain.WithTenantID(ctx, tenant.ID)
+
+	var users []domain.User
+	if err := s.db.WithContext(ctx).Unscoped().Where("tenant_id = ?", tenant.ID).Find(&users).Error; err != nil {
+		return err
+	}
+
+	// Identities go first: if Keycloak fails the tenant is still there to retry
+	for _, user := range users {
+		if user.KeycloakID == "" {
+			continue
+		}
+		if err := s.identityProvider.DeleteUser(ctx, user.KeycloakID); err != nil && !errors.Is(err, auth.ErrIdentityNotFound) {
+			return fmt.Errorf("failed to delete user %s: %w", user.Email, err)
+		}
+	}
+	if tenant.KeycloakGroupID != "" {
+		if err := s.identityProvider.DeleteTenantGroup(ctx, tenant.KeycloakGroupID); err != nil && !errors.Is(err, auth.ErrIdentityNotFound) {
+			return err
+		}
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Invoices, which hold their lines, usage records and plan changes reference the subscription
+		models := []interface{}{
+			&domain.APIKey{}, &domain.User{}, &domain.Invoice{}, &domain.UsageRecord{}, &domain.PlanChange{},
+			&domain.Subscription{}, &domain.AuditLog{},
+		}
+		for _, model := range models {
+			if err := tx.Unscoped().Where("tenant_id = ?", tenant.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&domain.Tenant{}, "id = ?", tenant.ID).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete tenant data: %w", err)
+	}
+
+	s.removeExport(tenant.DataExportPath)
+	s.forgetTenant(ctx, tenant)
+	s.purgeTenantUsage(ctx, tenant)
+
+	// The only record left of the tenant
+	s.audit(ctx, tenant, "tenant.deleted", map[string]interface{}{
+		"name":          tenant.Name,
+		"actor":         actorID,
+		"users_deleted": len(users),
+	})
+
+	logger.Info("Tenant deleted",
+		zap.String("tenantID", tenant.ID.String()),
+		zap.String("name", tenant.Name),
+		zap.String("actor", actorID))
+
+	return nil
+}
+
+// setUsersEnabled enables or disables in Keycloak the users that are active or
+// invited in the database; blocked and inactive users stay disabled
+func (s *TenantLifecycleService) setUsersEnabled(ctx context.Context, tenant *domain.Tenant, enabled bool) *TenantTransition {
+	result := &TenantTransition{Tenant: tenant}
+
+	var users []domain.User
+	err := s.db.WithContext(ctx).
+		Where("tenant_id = ? AND status IN ?", tenant.ID, []domain.UserStatus{domain.UserStatusActive, domain.UserStatusInvited}).
+		Find(&users).Error
+	if err != nil {
+		logger.Error("Failed to list tenant users",
+			zap.String("tenantID", tenant.ID.String()),
+			zap.Error(err))
+		result.UserFailures = append(result.UserFailures, "all")
+		return result
+	}
+
+	for _, user := range users {
+		if user.KeycloakID == "" {
+			continue
+		}
+		if err := s.identityProvider.SetUserEnabled(ctx, user.KeycloakID, enabled); err != nil {
+			logger.Error("Failed to update tenant user in identity provider",
+				zap.String("tenantID", tenant.ID.String()),
+				zap.String("userID", user.ID.String()),
+				zap.Bool("enabled", enabled),
+				zap.Error(err))
+			result.UserFailures = append(result.UserFailures, user.Email)
+			continue
+		}
+		result.UsersUpdated++
+	}
+	return result
+}
+
+// writeExport writes the tenant data to a JSON file under the export directory
+func (s *TenantLifecycleService) writeExport(ctx context.Context, tenant *domain.Tenant, now time.Time) (string, error) {
+	export := &TenantDataExport{
+		ExportedAt:   now.UTC(),
+		Tenant:       tenant,
+		Subscription: tenant.Subscription,
+	}
+	db := s.db.WithContext(ctx)
+	if err := db.Where("tenant_id = ?", tenant.ID).Find(&export.Users).Error; err != nil {
+		return "", err
+	}
+	if err := db.Where("tenant_id = ?", tenant.ID).Find(&export.APIKeys).Error; err != nil {
+		return "", err
+	}
+	if err := db.Where("tenant_id = ?", tenant.ID).Order("created_at").Find(&export.AuditLogs).Error; err != nil {
+		return "", err
+	}
+	if err := db.Where("tenant_id = ?", tenant.ID).Order("period_start").Find(&export.Invoices).Error; err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(s.cfg.ExportDir, 0o750); err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.cfg.ExportDir, fmt.Sprintf("%s-%s.json", tenant.Name, now.UTC().Format("20060102T150405Z")))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (s *TenantLifecycleService) removeExport(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn("Failed to remove tenant data export",
+			zap.String("path", path),
+			zap.Error(err))
+	}
+}
+
+// getTenant loads the tenant and, for a lifecycle action, checks it may start from its status
+func (s *TenantLifecycleService) getTenant(ctx context.Context, tenantID uuid.UUID, action string) (*domain.Tenant, error) {
+	var tenant domain.Tenant
+	err := s.db.WithContext(ctx).Preload("Subscription").First(&tenant, tenantID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+
+	if action != "" && !canTransition(action, tenant.Status) {
+		return nil, fmt.Errorf("%w: cannot %s a tenant that is %s", ErrInvalidTenantTransition, action, tenant.Status)
+	}
+	return &tenant, nil
+}
+
+// save writes the lifecycle columns and drops the cached tenant status
+func (s *TenantLifecycleService) save(ctx context.Context, tenant *domain.Tenant, columns ...string) error {
+	if err := s.db.WithContext(ctx).Model(tenant).Select(columns).Updates(tenant).Error; err != nil {
+		return err
+	}
+	s.forgetTenant(ctx, tenant)
+	return nil
+}
+
+// forgetTenant drops the Redis entries TenantDomainService caches for the tenant
+func (s *TenantLifecycleService) forgetTenant(ctx context.Context, tenant *domain.Tenant) {
+	if s.redisClient == nil {
+		return
+	}
+	keys := []string{tenantRefCacheKey(tenant.Name)}
+	if s.cfg.BaseDomain != "" {
+		keys = append(keys, hostTenantCacheKey(tenant.Name+"."+normalizeHost(s.cfg.BaseDomain)))
+	}
+	if tenant.Domain != "" {
+		keys = append(keys, hostTenantCacheKey(tenant.Domain))
+	}
+	if err := s.redisClient.Del(ctx, keys...).Err(); err != nil {
+		logger.Warn("Failed to drop cached tenant",
+			zap.String("tenantID", tenant.ID.String()),
+			zap.Error(err))
+	}
+}
+
+// purgeTenantUsage drops the usage counters, resource counts and quota state
+// UsageMeter and QuotaService keep in Redis for the tenant
+func (s *TenantLifecycleService) purgeTenantUsage(ctx context.Context, tenant *domain.Tenant) {
+	if s.redisClient == nil {
+		return
+	}
+	id := tenant.ID.String()
+	keys := []string{quotaResourcesKeyPrefix + id, quotaLimitsKeyPrefix + id}
+	for _, pattern := range []string{usageKeyPrefix + id + ":*", quotaWarningKeyPrefix + id + ":*"} {
+		iter := s.redisClient.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			logger.Warn("Failed to list tenant usage keys",
+				zap.String("tenantID", id),
+				zap.Error(err))
+		}
+	}
+	if err := s.redisClient.Del(ctx, keys...).Err(); err != nil {
+		logger.Warn("Failed to drop tenant usage",
+			zap.String("tenantID", id),
+			zap.Error(err))
+	}
+}
+
+func (s *TenantLifecycleService) audit(ctx context.Context, tenant *domain.Tenant, action string, details map[string]interface{}) {
+	audit := &domain.AuditLog{
+		TenantID:   tenant.ID,
+		Action:     action,
+		Resource:   "tenant",
+		ResourceID: tenant.ID.String(),
+		Details:    details,
+	}
+	if err := s.db.WithContext(ctx).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/opiagile/direito-lux/internal/domain"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		action string
+		from   domain.TenantStatus
+		want   bool
+	}{
+		{TenantActionSuspend, domain.TenantStatusActive, true},
+		{TenantActionSuspend, domain.TenantStatusTrial, true},
+		{TenantActionSuspend, domain.TenantStatusSuspended, false},
+		{TenantActionSuspend, domain.TenantStatusOffboarding, false},
+		{TenantActionReactivate, domain.TenantStatusSuspended, true},
+		{TenantActionReactivate, domain.TenantStatusOffboarding, true},
+		{TenantActionReactivate, domain.TenantStatusActive, false},
+		{TenantActionOffboard, domain.TenantStatusSuspended, true},
+		{TenantActionOffboard, domain.TenantStatusOffboarding, false},
+		{TenantActionDelete, domain.TenantStatusOffboarding, true},
+		{TenantActionDelete, domain.TenantStatusActive, false},
+		{"archive", domain.TenantStatusActive, false},
+	}
+
+	for _, tt := range tests {
+		if got := canTransition(tt.action, tt.from); got != tt.want {
+			t.Errorf("canTransition(%q, %q) = %v, want %v", tt.action, tt.from, got, tt.want)
+		}
+	}
+}
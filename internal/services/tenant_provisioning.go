@@ -0,0 +1,463 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrProvisioningNotFound     = errors.New("tenant provisioning not found")
+	ErrProvisioningPending      = errors.New("a provisioning for this tenant name is unfinished")
+	ErrProvisioningNotResumable = errors.New("tenant provisioning cannot be resumed")
+)
+
+// Provisioning steps, run in this order and compensated in reverse
+const (
+	stepCreateGroup      = "create_keycloak_group"
+	stepCreateAdminUser  = "create_admin_user"
+	stepSetAdminPassword = "set_admin_password"
+	stepCreateTenantRows = "create_tenant_records"
+)
+
+var provisioningStepNames = []string{stepCreateGroup, stepCreateAdminUser, stepSetAdminPassword, stepCreateTenantRows}
+
+// unfinishedProvisioningStatuses may still hold Keycloak resources
+var unfinishedProvisioningStatuses = []domain.ProvisioningStatus{
+	domain.ProvisioningStatusInProgress,
+	domain.ProvisioningStatusFailed,
+	domain.ProvisioningStatusCompensating,
+}
+
+// ProvisioningError reports the step a tenant provisioning failed at
+type ProvisioningError struct {
+	ProvisioningID uuid.UUID
+	Step           string
+	RolledBack     bool // false when some resources could not be compensated
+	Err            error
+}
+
+func (e *ProvisioningError) Error() string {
+	return fmt.Sprintf("tenant provisioning %s failed at %s: %v", e.ProvisioningID, e.Step, e.Err)
+}
+
+func (e *ProvisioningError) Unwrap() error {
+	return e.Err
+}
+
+func newProvisioningSteps() []domain.ProvisioningStep {
+	steps := make([]domain.ProvisioningStep, len(provisioningStepNames))
+	for i, name := range provisioningStepNames {
+		steps[i] = domain.ProvisioningStep{Name: name, Status: domain.ProvisioningStepPending}
+	}
+	return steps
+}
+
+// runProvisioning runs the steps not completed yet. An empty password means the
+// admin password is unknown (a resumed provisioning): the admin gets a reset email instead.
+func (ts *TenantService) runProvisioning(ctx context.Context, p *domain.TenantProvisioning, password string) (*domain.Tenant, error) {
+	for i := range p.Steps {
+		step := &p.Steps[i]
+		if step.Status == domain.ProvisioningStepCompleted {
+			continue
+		}
+
+		if err := ts.runProvisioningStep(ctx, p, step.Name, password); err != nil {
+			setStepStatus(step, domain.ProvisioningStepFailed, err)
+			p.Status = domain.ProvisioningStatusFailed
+			p.Error = err.Error()
+			ts.saveProvisioning(ctx, p)
+
+			logger.Error("Tenant provisioning step failed",
+				zap.String("provisioningID", p.ID.String()),
+				zap.String("tenant", p.TenantName),
+				zap.String("step", step.Name),
+				zap.Error(err))
+
+			compensateErr := ts.compensateProvisioning(ctx, p)
+			return nil, &ProvisioningError{
+				ProvisioningID: p.ID,
+				Step:           step.Name,
+				RolledBack:     compensateErr == nil && p.Status == domain.ProvisioningStatusRolledBack,
+				Err:            err,
+			}
+		}
+
+		setStepStatus(step, domain.ProvisioningStepCompleted, nil)
+		ts.saveProvisioning(ctx, p)
+	}
+
+	now := time.Now()
+	p.Status = domain.ProvisioningStatusCompleted
+	p.Error = ""
+	p.CompletedAt = &now
+	ts.saveProvisioning(ctx, p)
+
+	logger.Info("Tenant created successfully",
+		zap.String("tenantID", p.TenantID.String()),
+		zap.String("name", p.TenantName),
+		zap.String("adminUser", p.Request.AdminEmail),
+		zap.String("provisioningID", p.ID.String()))
+
+	return ts.GetTenant(ctx, p.TenantID)
+}
+
+func (ts *TenantService) runProvisioningStep(ctx context.Context, p *domain.TenantProvisioning, name, password string) error {
+	switch name {
+	case stepCreateGroup:
+		groupID, err := ts.identityProvider.CreateTenantGroup(ctx, p.TenantName)
+		if err != nil {
+			return fmt.Errorf("failed to create Keycloak group: %w", err)
+		}
+		p.GroupID = groupID
+
+		// The plan claim is a convenience, ScopeTenant resolves the plan from the database
+		if p.Request.PlanName != "" {
+			if err := ts.identityProvider.SetTenantPlan(ctx, groupID, p.Request.PlanName); err != nil {
+				logger.Warn("Failed to set tenant group plan",
+					zap.String("tenant", p.TenantName),
+					zap.Error(err))
+			}
+		}
+		return nil
+
+	case stepCreateAdminUser:
+		userID, err := ts.identityProvider.CreateUser(ctx,
+			p.Request.AdminEmail,
+			p.Request.AdminFirstName,
+			p.Request.AdminLastName,
+			p.GroupID,
+			string(domain.UserRoleAdmin))
+		if err != nil {
+			return fmt.Errorf("failed to create admin user in Keycloak: %w", err)
+		}
+		p.AdminUserID = userID
+		return nil
+
+	case stepSetAdminPassword:
+		if password == "" {
+			if err := ts.identityProvider.ResetPassword(ctx, p.AdminUserID); err != nil {
+				return fmt.Errorf("failed to send admin password reset: %w", err)
+			}
+			return nil
+		}
+		if err := ts.identityProvider.SetUserPassword(ctx, p.AdminUserID, password, false); err != nil {
+			return fmt.Errorf("failed to set admin user password: %w", err)
+		}
+		return nil
+
+	case stepCreateTenantRows:
+		return ts.createTenantRecords(ctx, p)
+	}
+
+	return fmt.Errorf("unknown provisioning step %q", name)
+}
+
+// createTenantRecords writes the tenant, its subscription and admin user in one transaction
+func (ts *TenantService) createTenantRecords(ctx context.Context, p *domain.TenantProvisioning) error {
+	var domainToken string
+	if p.Request.Domain != "" {
+		token, err := newDomainVerificationToken()
+		if err != nil {
+			return err
+		}
+		domainToken = token
+	}
+
+	return ts.db.WithContext(domain.WithTenantID(ctx, p.TenantID)).Transaction(func(tx *gorm.DB) error {
+		tenant := &domain.Tenant{
+			BaseModel:               domain.BaseModel{ID: p.TenantID},
+			Name:                    p.TenantName,
+			DisplayName:             p.Request.DisplayName,
+			Domain:                  p.Request.Domain,
+			KeycloakGroupID:         p.GroupID,
+			DomainVerificationToken: domainToken,
+			PlanID:                  p.Request.PlanID,
+			Status:                  domain.TenantStatusTrial,
+			Settings:                p.Request.Settings,
+		}
+		if err := tx.Create(tenant).Error; err != nil {
+			return fmt.Errorf("failed to create tenant: %w", err)
+		}
+
+		trialEndsAt := tenant.CreatedAt.AddDate(0, 0, ts.trialDays)
+		subscription := &domain.Subscription{
+			TenantID:    tenant.ID,
+			PlanID:      p.Request.PlanID,
+			Status:      domain.SubscriptionStatusTrialing,
+			StartDate:   tenant.CreatedAt,
+			TrialEndsAt: &trialEndsAt,
+			Usage:       make(map[string]int),
+		}
+		if err := tx.Create(subscription).Error; err != nil {
+			return fmt.Errorf("failed to create subscription: %w", err)
+		}
+
+		user := &domain.User{
+			KeycloakID: p.AdminUserID,
+			TenantID:   tenant.ID,
+			Email:      p.Request.AdminEmail,
+			FirstName:  p.Request.AdminFirstName,
+			LastName:   p.Request.AdminLastName,
+			Role:       domain.UserRoleAdmin,
+			Status:     domain.UserStatusActive,
+			Preferences: domain.UserPreferences{
+				Language:        tenant.Settings.Language,
+				Timezone:        tenant.Settings.Timezone,
+				NotificationsOn: true,
+			},
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return fmt.Errorf("failed to create user record: %w", err)
+		}
+
+		audit := &domain.AuditLog{
+			TenantID:   tenant.ID,
+			UserID:     user.ID,
+			Action:     "tenant.created",
+			Resource:   "tenant",
+			ResourceID: tenant.ID.String(),
+			Details: map[string]interface{}{
+				"plan_id":         p.Request.PlanID.String(),
+				"admin_user":      p.Request.AdminEmail,
+				"provisioning_id": p.ID.String(),
+			},
+		}
+		// A failed statement aborts the Postgres transaction, so this one cannot be skipped
+		if err := tx.Create(audit).Error; err != nil {
+			return fmt.Errorf("failed to create audit log: %w", err)
+		}
+		return nil
+	})
+}
+
+// compensateProvisioning undoes the completed steps in reverse order. Steps that
+// cannot be undone leave the provisioning failed, for the cleanup job to retry.
+func (ts *TenantService) compensateProvisioning(ctx context.Context, p *domain.TenantProvisioning) error {
+	// The records are the last step: if they exist the provisioning did finish
+	var count int64
+	if err := ts.db.WithContext(ctx).Model(&domain.Tenant{}).Where("id = ?", p.TenantID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		now := time.Now()
+		for i := range p.Steps {
+			setStepStatus(&p.Steps[i], domain.ProvisioningStepCompleted, nil)
+		}
+		p.Status = domain.ProvisioningStatusCompleted
+		p.Error = ""
+		p.CompletedAt = &now
+		ts.saveProvisioning(ctx, p)
+		return nil
+	}
+
+	for i := len(p.Steps) - 1; i >= 0; i-- {
+		step := &p.Steps[i]
+		if step.Status != domain.ProvisioningStepCompleted {
+			continue
+		}
+
+		var err error
+		switch step.Name {
+		case stepCreateAdminUser:
+			err = ts.identityProvider.DeleteUser(ctx, p.AdminUserID)
+			if err == nil || errors.Is(err, auth.ErrIdentityNotFound) {
+				err = nil
+				p.AdminUserID = ""
+			}
+		case stepCreateGroup:
+			err = ts.identityProvider.DeleteTenantGroup(ctx, p.GroupID)
+			if err == nil || errors.Is(err, auth.ErrIdentityNotFound) {
+				err = nil
+				p.GroupID = ""
+			}
+		}
+		// Steps without a compensation are undone by the ones before them
+
+		if err != nil {
+			step.Error = "compensation failed: " + err.Error()
+			p.Status = domain.ProvisioningStatusFailed
+			ts.saveProvisioning(ctx, p)
+
+			logger.Error("Tenant provisioning compensation failed",
+				zap.String("provisioningID", p.ID.String()),
+				zap.String("tenant", p.TenantName),
+				zap.String("step", step.Name),
+				zap.Error(err))
+			return err
+		}
+		setStepStatus(step, domain.ProvisioningStepCompensated, nil)
+		ts.saveProvisioning(ctx, p)
+	}
+
+	p.Status = domain.ProvisioningStatusRolledBack
+	ts.saveProvisioning(ctx, p)
+
+	logger.Info("Tenant provisioning rolled back",
+		zap.String("provisioningID", p.ID.String()),
+		zap.String("tenant", p.TenantName))
+
+	return nil
+}
+
+// ResumeProvisioning runs a failed or rolled back provisioning again from its first
+// unfinished step. The admin password is not stored, so the admin receives a reset email.
+func (ts *TenantService) ResumeProvisioning(ctx context.Context, provisioningID uuid.UUID) (*domain.Tenant, error) {
+	p, err := ts.GetProvisioning(ctx, provisioningID)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing int64
+	if err := ts.db.WithContext(ctx).Model(&domain.Tenant{}).Where("name = ?", p.TenantName).Count(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, ErrTenantNameExists
+	}
+
+	resumable := []domain.ProvisioningStatus{domain.ProvisioningStatusFailed, domain.ProvisioningStatusRolledBack}
+	if !ts.claimProvisioning(ctx, p, resumable, domain.ProvisioningStatusInProgress) {
+		return nil, ErrProvisioningNotResumable
+	}
+
+	logger.Info("Resuming tenant provisioning",
+		zap.String("provisioningID", p.ID.String()),
+		zap.String("tenant", p.TenantName))
+
+	return ts.runProvisioning(ctx, p, "")
+}
+
+// CleanupStaleProvisionings compensates provisionings left failed, interrupted or
+// half compensated for longer than staleAfter, returning how many were rolled back
+func (ts *TenantService) CleanupStaleProvisionings(ctx context.Context, staleAfter time.Duration) (int, error) {
+	var stale []*domain.TenantProvisioning
+	err := ts.db.WithContext(ctx).
+		Where("status IN ? AND updated_at < ?", unfinishedProvisioningStatuses, time.Now().Add(-staleAfter)).
+		Find(&stale).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to list stale provisionings: %w", err)
+	}
+
+	rolledBack := 0
+	for _, p := range stale {
+		if !ts.claimProvisioning(ctx, p, []domain.ProvisioningStatus{p.Status}, domain.ProvisioningStatusCompensating) {
+			continue
+		}
+		if err := ts.compensateProvisioning(ctx, p); err != nil {
+			continue
+		}
+		if p.Status == domain.ProvisioningStatusRolledBack {
+			rolledBack++
+		}
+	}
+	return rolledBack, nil
+}
+
+// StartProvisioningCleanup runs CleanupStaleProvisionings every interval until ctx is cancelled
+func (ts *TenantService) StartProvisioningCleanup(ctx context.Context, interval, staleAfter time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger.Info("Tenant provisioning cleanup job started",
+		zap.Duration("interval", interval),
+		zap.Duration("staleAfter", staleAfter))
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Tenant provisioning cleanup job stopped")
+			return
+		case <-ticker.C:
+			if _, err := ts.CleanupStaleProvisionings(ctx, staleAfter); err != nil {
+				logger.Error("Tenant provisioning cleanup failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// GetProvisioning returns a tenant provisioning and the state of its steps
+func (ts *TenantService) GetProvisioning(ctx context.Context, provisioningID uuid.UUID) (*domain.TenantProvisioning, error) {
+	var p domain.TenantProvisioning
+	if err := ts.db.WithContext(ctx).First(&p, provisioningID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProvisioningNotFound
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListProvisionings lists tenant provisionings, newest first
+func (ts *TenantService) ListProvisionings(ctx context.Context, offset, limit int, status string) ([]*domain.TenantProvisioning, int64, error) {
+	var provisionings []*domain.TenantProvisioning
+	var total int64
+
+	query := ts.db.WithContext(ctx).Model(&domain.TenantProvisioning{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Offset(offset).
+		Limit(limit).
+		Order("created_at DESC").
+		Find(&provisionings).Error
+
+	return provisionings, total, err
+}
+
+// claimProvisioning moves a provisioning to status if it is still in one of from,
+// so a resume and the cleanup job never work on the same provisioning
+func (ts *TenantService) claimProvisioning(ctx context.Context, p *domain.TenantProvisioning, from []domain.ProvisioningStatus, status domain.ProvisioningStatus) bool {
+	now := time.Now()
+	result := ts.db.WithContext(ctx).Model(&domain.TenantProvisioning{}).
+		Where("id = ? AND status IN ? AND updated_at = ?", p.ID, from, p.UpdatedAt).
+		Updates(map[string]interface{}{"status": status, "updated_at": now})
+	if result.Error != nil {
+		logger.Error("Failed to claim tenant provisioning",
+			zap.String("provisioningID", p.ID.String()),
+			zap.Error(result.Error))
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+	p.Status = status
+	p.UpdatedAt = now
+	return true
+}
+
+// saveProvisioning records the progress; failures are logged since the saga itself already ran
+func (ts *TenantService) saveProvisioning(ctx context.Context, p *domain.TenantProvisioning) {
+	err := ts.db.WithContext(ctx).Model(p).
+		Select("status", "group_id", "admin_user_id", "steps", "error", "completed_at").
+		Updates(p).Error
+	if err != nil {
+		logger.Error("Failed to record tenant provisioning progress",
+			zap.String("provisioningID", p.ID.String()),
+			zap.String("status", string(p.Status)),
+			zap.Error(err))
+	}
+}
+
+func setStepStatus(step *domain.ProvisioningStep, status domain.ProvisioningStepStatus, err error) {
+	now := time.Now()
+	step.Status = status
+	step.UpdatedAt = &now
+	step.Error = ""
+	if err != nil {
+		step.Error = err.Error()
+	}
+}
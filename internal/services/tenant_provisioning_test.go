@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/config"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestCompensateProvisioningRemovesKeycloakResources(t *testing.T) {
+	// Dry run: progress is not persisted and the tenant lookup finds nothing
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost dbname=test"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open dry run database: %v", err)
+	}
+	provider, err := auth.NewMemoryIdentityProvider("http://localhost/realms/test", "test")
+	if err != nil {
+		t.Fatalf("failed to create identity provider: %v", err)
+	}
+
+	ts := NewTenantService(db, provider, nil, &config.BillingConfig{TrialDays: 14})
+	ctx := context.Background()
+	p := &domain.TenantProvisioning{
+		BaseModel:  domain.BaseModel{ID: uuid.New()},
+		TenantID:   uuid.New(),
+		TenantName: "silva-advogados",
+		Status:     domain.ProvisioningStatusInProgress,
+		Request: domain.ProvisioningRequest{
+			AdminEmail:     "ana@silva.adv.br",
+			AdminFirstName: "Ana",
+			AdminLastName:  "Silva",
+		},
+		Steps: newProvisioningSteps(),
+	}
+
+	// Everything but the database records
+	for i := 0; i < 3; i++ {
+		if err := ts.runProvisioningStep(ctx, p, p.Steps[i].Name, "s3cret-pass"); err != nil {
+			t.Fatalf("step %s failed: %v", p.Steps[i].Name, err)
+		}
+		setStepStatus(&p.Steps[i], domain.ProvisioningStepCompleted, nil)
+	}
+	adminUserID := p.AdminUserID
+
+	if err := ts.compensateProvisioning(ctx, p); err != nil {
+		t.Fatalf("compensateProvisioning failed: %v", err)
+	}
+
+	if p.Status != domain.ProvisioningStatusRolledBack {
+		t.Errorf("status = %s, want %s", p.Status, domain.ProvisioningStatusRolledBack)
+	}
+	for _, step := range p.Steps[:3] {
+		if step.Status != domain.ProvisioningStepCompensated {
+			t.Errorf("step %s = %s, want compensated", step.Name, step.Status)
+		}
+	}
+	if p.Steps[3].Status != domain.ProvisioningStepPending {
+		t.Errorf("step %s = %s, want pending", p.Steps[3].Name, p.Steps[3].Status)
+	}
+	if _, err := provider.GetUser(ctx, adminUserID); !errors.Is(err, auth.ErrIdentityNotFound) {
+		t.Errorf("admin user still exists: %v", err)
+	}
+	if _, err := provider.CreateTenantGroup(ctx, p.TenantName); err != nil {
+		t.Errorf("tenant group still exists: %v", err)
+	}
+}
@@ -8,9 +8,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/config"
 	"github.com/opiagile/direito-lux/internal/domain"
-	"github.com/opiagile/direito-lux/pkg/logger"
-	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
@@ -22,14 +21,18 @@ var (
 )
 
 type TenantService struct {
-	db             *gorm.DB
-	keycloakClient *auth.KeycloakClient
+	db               *gorm.DB
+	identityProvider auth.IdentityProvider
+	usageMeter       *UsageMeter
+	trialDays        int
 }
 
-func NewTenantService(db *gorm.DB, keycloakClient *auth.KeycloakClient) *TenantService {
+func NewTenantService(db *gorm.DB, identityProvider auth.IdentityProvider, usageMeter *UsageMeter, billing *config.BillingConfig) *TenantService {
 	return &TenantService{
-		db:             db,
-		keycloakClient: keycloakClient,
+		db:               db,
+		identityProvider: identityProvider,
+		usageMeter:       usageMeter,
+		trialDays:        billing.TrialDays,
 	}
 }
 
@@ -47,7 +50,9 @@ type CreateTenantRequest struct {
 	Settings domain.TenantSettings `json:"settings,omitempty"`
 }
 
-// CreateTenant creates a new tenant with Keycloak group and admin user
+// CreateTenant provisions a new tenant with its Keycloak group and admin user.
+// The steps run as a saga recorded in a TenantProvisioning: when one fails, the
+// completed ones are compensated and a *ProvisioningError is returned.
 func (ts *TenantService) CreateTenant(ctx context.Context, req *CreateTenantRequest) (*domain.Tenant, error) {
 	// Validate tenant name
 	req.Name = strings.ToLower(strings.TrimSpace(req.Name))
@@ -61,6 +66,18 @@ func (ts *TenantService) CreateTenant(ctx context.Context, req *CreateTenantRequ
 		return nil, ErrTenantNameExists
 	}
 
+	// A failed provisioning still holds the Keycloak group name until resumed or cleaned up
+	var pending int64
+	err := ts.db.WithContext(ctx).Model(&domain.TenantProvisioning{}).
+		Where("tenant_name = ? AND status IN ?", req.Name, unfinishedProvisioningStatuses).
+		Count(&pending).Error
+	if err != nil {
+		return nil, err
+	}
+	if pending > 0 {
+		return nil, ErrProvisioningPending
+	}
+
 	// Get plan
 	var plan domain.Plan
 	planID, err := uuid.Parse(req.PlanID)
@@ -71,139 +88,105 @@ func (ts *TenantService) CreateTenant(ctx context.Context, req *CreateTenantRequ
 		return nil, ErrPlanNotFound
 	}
 
-	// Start transaction
-	tx := ts.db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+	// A custom domain starts unverified; TenantDomainService activates it once its TXT record is found
+	if req.Domain != "" {
+		if !plan.Limits.AllowCustomDomain {
+			return nil, ErrCustomDomainNotAllowed
+		}
+		if req.Domain, err = normalizeDomainName(req.Domain); err != nil {
+			return nil, err
 		}
-	}()
-
-	// Create Keycloak group for tenant
-	groupID, err := ts.keycloakClient.CreateTenantGroup(ctx, req.Name)
-	if err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("failed to create Keycloak group: %w", err)
-	}
-
-	// Create tenant record
-	tenant := &domain.Tenant{
-		Name:            req.Name,
-		DisplayName:     req.DisplayName,
-		Domain:          req.Domain,
-		KeycloakGroupID: groupID,
-		PlanID:          planID,
-		Status:          domain.TenantStatusTrial,
-		Settings:        req.Settings,
 	}
 
 	// Set default settings
-	if tenant.Settings.Language == "" {
-		tenant.Settings.Language = "pt-BR"
-	}
-	if tenant.Settings.Timezone == "" {
-		tenant.Settings.Timezone = "America/Sao_Paulo"
+	if req.Settings.Language == "" {
+		req.Settings.Language = "pt-BR"
+	}
+	if req.Settings.Timezone == "" {
+		req.Settings.Timezone = "America/Sao_Paulo"
+	}
+	if req.Settings.CurrencyCode == "" {
+		req.Settings.CurrencyCode = "BRL"
+	}
+
+	provisioning := &domain.TenantProvisioning{
+		TenantID:   uuid.New(),
+		TenantName: req.Name,
+		Status:     domain.ProvisioningStatusInProgress,
+		Request: domain.ProvisioningRequest{
+			DisplayName:    req.DisplayName,
+			Domain:         req.Domain,
+			PlanID:         planID,
+			PlanName:       plan.Name,
+			AdminEmail:     req.AdminUser.Email,
+			AdminFirstName: req.AdminUser.FirstName,
+			AdminLastName:  req.AdminUser.LastName,
+			Settings:       req.Settings,
+		},
+		Steps: newProvisioningSteps(),
 	}
-	if tenant.Settings.CurrencyCode == "" {
-		tenant.Settings.CurrencyCode = "BRL"
+	if err := ts.db.WithContext(ctx).Create(provisioning).Error; err != nil {
+		return nil, fmt.Errorf("failed to record tenant provisioning: %w", err)
 	}
 
-	if err := tx.Create(tenant).Error; err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("failed to create tenant: %w", err)
-	}
+	return ts.runProvisioning(ctx, provisioning, req.AdminUser.Password)
+}
 
-	// Create subscription
-	subscription := &domain.Subscription{
-		TenantID:  tenant.ID,
-		PlanID:    planID,
-		Status:    domain.SubscriptionStatusTrialing,
-		StartDate: tenant.CreatedAt,
-		Usage:     make(map[string]int),
-	}
+// SeedTenant creates an active tenant on the most complete plan for a group seeded
+// in the identity provider, for local setups. An existing tenant keeps its data and
+// is relinked to the group, which in-memory providers recreate on every start.
+func (ts *TenantService) SeedTenant(ctx context.Context, name, groupID string) (*domain.Tenant, error) {
+	ctx = domain.CrossTenant(ctx)
 
-	if err := tx.Create(subscription).Error; err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("failed to create subscription: %w", err)
+	var tenant domain.Tenant
+	err := ts.db.WithContext(ctx).Where("name = ?", name).First(&tenant).Error
+	if err == nil {
+		if tenant.KeycloakGroupID != groupID {
+			if err := ts.db.WithContext(ctx).Model(&tenant).Update("keycloak_group_id", groupID).Error; err != nil {
+				return nil, err
+			}
+		}
+		return &tenant, nil
 	}
-
-	// Create admin user in Keycloak
-	userID, err := ts.keycloakClient.CreateUser(ctx,
-		req.AdminUser.Email,
-		req.AdminUser.FirstName,
-		req.AdminUser.LastName,
-		groupID,
-		string(domain.UserRoleAdmin))
-	if err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("failed to create admin user in Keycloak: %w", err)
-	}
-
-	// Set password
-	if err := ts.keycloakClient.SetUserPassword(ctx, userID, req.AdminUser.Password, false); err != nil {
-		tx.Rollback()
-		logger.Error("Failed to set admin user password",
-			zap.String("userID", userID),
-			zap.Error(err))
-	}
-
-	// Create user record
-	user := &domain.User{
-		KeycloakID: userID,
-		TenantID:   tenant.ID,
-		Email:      req.AdminUser.Email,
-		FirstName:  req.AdminUser.FirstName,
-		LastName:   req.AdminUser.LastName,
-		Role:       domain.UserRoleAdmin,
-		Status:     domain.UserStatusActive,
-		Preferences: domain.UserPreferences{
-			Language:        tenant.Settings.Language,
-			Timezone:        tenant.Settings.Timezone,
-			NotificationsOn: true,
-		},
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
 	}
 
-	if err := tx.Create(user).Error; err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("failed to create user record: %w", err)
+	var plan domain.Plan
+	if err := ts.db.WithContext(ctx).Where("is_active = ?", true).Order("price DESC").First(&plan).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlanNotFound
+		}
+		return nil, err
 	}
 
-	// Log audit
-	audit := &domain.AuditLog{
-		TenantID:   tenant.ID,
-		UserID:     user.ID,
-		Action:     "tenant.created",
-		Resource:   "tenant",
-		ResourceID: tenant.ID.String(),
-		Details: map[string]interface{}{
-			"plan_id":    planID.String(),
-			"admin_user": req.AdminUser.Email,
+	tenant = domain.Tenant{
+		Name:            name,
+		DisplayName:     name,
+		KeycloakGroupID: groupID,
+		PlanID:          plan.ID,
+		Status:          domain.TenantStatusActive,
+		Settings: domain.TenantSettings{
+			Language:     "pt-BR",
+			Timezone:     "America/Sao_Paulo",
+			CurrencyCode: plan.Currency,
 		},
 	}
-	if err := tx.Create(audit).Error; err != nil {
-		logger.Error("Failed to create audit log", zap.Error(err))
+	if err := ts.db.WithContext(ctx).Create(&tenant).Error; err != nil {
+		return nil, fmt.Errorf("failed to seed tenant: %w", err)
 	}
 
-	// Commit transaction
-	if err := tx.Commit().Error; err != nil {
-		return nil, fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
-	logger.Info("Tenant created successfully",
-		zap.String("tenantID", tenant.ID.String()),
-		zap.String("name", tenant.Name),
-		zap.String("adminUser", req.AdminUser.Email))
-
-	// Load associations
-	ts.db.Preload("Plan").Preload("Subscription").First(tenant, tenant.ID)
-
-	return tenant, nil
+	return &tenant, nil
 }
 
 // GetTenant retrieves tenant by ID
 func (ts *TenantService) GetTenant(ctx context.Context, tenantID uuid.UUID) (*domain.Tenant, error) {
+	// The subscription is part of the tenant being read, whoever reads it;
+	// callers check access to the tenant itself
+	ctx = domain.WithTenantID(ctx, tenantID)
+
 	var tenant domain.Tenant
-	err := ts.db.Preload("Plan").Preload("Subscription").Where("id = ?", tenantID).First(&tenant).Error
+	err := ts.db.WithContext(ctx).Preload("Plan").Preload("Subscription").Where("id = ?", tenantID).First(&tenant).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrTenantNotFound
@@ -216,20 +199,20 @@ func (ts *TenantService) GetTenant(ctx context.Context, tenantID uuid.UUID) (*do
 // GetTenantByName retrieves tenant by name
 func (ts *TenantService) GetTenantByName(ctx context.Context, name string) (*domain.Tenant, error) {
 	var tenant domain.Tenant
-	err := ts.db.Preload("Plan").Preload("Subscription").Where("name = ?", name).First(&tenant).Error
+	err := ts.db.WithContext(ctx).Select("id").Where("name = ?", name).First(&tenant).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrTenantNotFound
 		}
 		return nil, err
 	}
-	return &tenant, nil
+	return ts.GetTenant(ctx, tenant.ID)
 }
 
 // UpdateTenant updates tenant information
 func (ts *TenantService) UpdateTenant(ctx context.Context, tenantID uuid.UUID, updates map[string]interface{}) (*domain.Tenant, error) {
 	var tenant domain.Tenant
-	if err := ts.db.First(&tenant, tenantID).Error; err != nil {
+	if err := ts.db.WithContext(ctx).First(&tenant, tenantID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrTenantNotFound
 		}
@@ -237,11 +220,11 @@ func (ts *TenantService) UpdateTenant(ctx context.Context, tenantID uuid.UUID, u
 	}
 
 	// Only allow certain fields to be updated
+	// Custom domains are changed through TenantDomainService, which verifies them,
+	// and the status through TenantLifecycleService, which also updates Keycloak
 	allowedFields := map[string]bool{
 		"display_name": true,
-		"domain":       true,
 		"settings":     true,
-		"status":       true,
 	}
 
 	filteredUpdates := make(map[string]interface{})
@@ -251,7 +234,12 @@ func (ts *TenantService) UpdateTenant(ctx context.Context, tenantID uuid.UUID, u
 		}
 	}
 
-	if err := ts.db.Model(&tenant).Updates(filteredUpdates).Error; err != nil {
+	// The MFA policy is only changed through MFAService, which also updates Keycloak
+	if settings, ok := filteredUpdates["settings"].(map[string]interface{}); ok {
+		settings["mfa"] = tenant.Settings.MFA
+	}
+
+	if err := ts.db.WithContext(ctx).Model(&tenant).Updates(filteredUpdates).Error; err != nil {
 		return nil, err
 	}
 
@@ -263,20 +251,18 @@ func (ts *TenantService) UpdateTenant(ctx context.Context, tenantID uuid.UUID, u
 		ResourceID: tenantID.String(),
 		Details:    filteredUpdates,
 	}
-	ts.db.Create(audit)
+	ts.db.WithContext(ctx).Create(audit)
 
 	// Reload with associations
-	ts.db.Preload("Plan").Preload("Subscription").First(&tenant, tenantID)
-
-	return &tenant, nil
+	return ts.GetTenant(ctx, tenantID)
 }
 
-// ListTenants lists all tenants with pagination
+// ListTenants lists all tenants with pagination, for super admins
 func (ts *TenantService) ListTenants(ctx context.Context, offset, limit int, status string) ([]*domain.Tenant, int64, error) {
 	var tenants []*domain.Tenant
 	var total int64
 
-	query := ts.db.Model(&domain.Tenant{})
+	query := ts.db.WithContext(domain.CrossTenant(ctx)).Model(&domain.Tenant{})
 
 	if status != "" {
 		query = query.Where("status = ?", status)
@@ -296,44 +282,52 @@ func (ts *TenantService) ListTenants(ctx context.Context, offset, limit int, sta
 	return tenants, total, err
 }
 
-// GetTenantUsage retrieves current usage statistics for a tenant
+// GetTenantUsage retrieves the usage of the current period against the plan
+// limits, and the usage of the previous periods. Resources reported by other
+// services, such as storage, are listed with the quotas.
 func (ts *TenantService) GetTenantUsage(ctx context.Context, tenantID uuid.UUID) (map[string]interface{}, error) {
-	var tenant domain.Tenant
-	if err := ts.db.Preload("Plan").Preload("Subscription").First(&tenant, tenantID).Error; err != nil {
-		return nil, ErrTenantNotFound
+	tenant, err := ts.GetTenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
 	}
 
 	// Count users
 	var userCount int64
-	ts.db.Model(&domain.User{}).Where("tenant_id = ?", tenantID).Count(&userCount)
-
-	// Get storage usage (placeholder - implement actual storage calculation)
-	storageGB := 0.0
+	if err := ts.db.WithContext(ctx).Model(&domain.User{}).Where("tenant_id = ?", tenantID).Count(&userCount).Error; err != nil {
+		return nil, err
+	}
 
-	// Get API calls this month (placeholder - implement actual API tracking)
-	apiCalls := 0
+	history, err := ts.usageMeter.History(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	current := history[0]
 
 	usage := map[string]interface{}{
+		"period": map[string]interface{}{
+			"start": current.PeriodStart,
+			"end":   current.PeriodEnd,
+		},
 		"users": map[string]interface{}{
 			"current": userCount,
 			"limit":   tenant.Plan.Limits.MaxUsers,
 		},
-		"storage_gb": map[string]interface{}{
-			"current": storageGB,
-			"limit":   tenant.Plan.Limits.MaxStorageGB,
-		},
 		"api_calls_month": map[string]interface{}{
-			"current": apiCalls,
+			"current": current.Metrics[domain.UsageMetricAPICalls],
 			"limit":   tenant.Plan.Limits.MaxAPICallsMonth,
 		},
+		"consultas_month": map[string]interface{}{
+			"current": current.Metrics[domain.UsageMetricConsultas],
+		},
 		"ai_requests_month": map[string]interface{}{
-			"current": tenant.Subscription.Usage["ai_requests"],
+			"current": current.Metrics[domain.UsageMetricAIRequests],
 			"limit":   tenant.Plan.Limits.AIRequestsMonth,
 		},
 		"messages_month": map[string]interface{}{
-			"current": tenant.Subscription.Usage["messages"],
+			"current": current.Metrics[domain.UsageMetricMessages],
 			"limit":   tenant.Plan.Limits.MessagesMonth,
 		},
+		"history": history,
 	}
 
 	return usage, nil
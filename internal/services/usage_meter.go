@@ -0,0 +1,407 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/config"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrUnknownUsageMetric = errors.New("unknown usage metric")
+
+// Usage counters are Redis hashes, one per tenant and period, holding a field per
+// metric and the period end. Hashes written since the last flush are listed in
+// usageDirtyKey.
+const (
+	usageKeyPrefix       = "usage:"
+	usageDirtyKey        = "usage:dirty"
+	usagePeriodEndField  = "_end"
+	usagePeriodKeyPrefix = "usage_period:"
+	// Counters outlive their period long enough for the last flush
+	usageCounterRetention = 7 * 24 * time.Hour
+	usageFlushBatch       = 100
+)
+
+// usageMetrics are the metrics a tenant is metered on
+var usageMetrics = []domain.UsageMetric{
+	domain.UsageMetricAPICalls,
+	domain.UsageMetricConsultas,
+	domain.UsageMetricAIRequests,
+	domain.UsageMetricMessages,
+}
+
+// incrementUsageScript increments a counter of an existing hash and marks it dirty;
+// it returns nil when the hash must first be seeded from Postgres
+var incrementUsageScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return false
+end
+local value = redis.call('HINCRBY', KEYS[1], ARGV[1], ARGV[2])
+redis.call('SADD', KEYS[2], KEYS[1])
+return value
+`)
+
+// PeriodUsage is the usage of a tenant in one usage period
+type PeriodUsage struct {
+	PeriodStart time.Time                    `json:"period_start"`
+	PeriodEnd   time.Time                    `json:"period_end"`
+	Metrics     map[domain.UsageMetric]int64 `json:"metrics"`
+}
+
+// UsageMeter counts tenant usage per monthly period. Requests increment Redis
+// counters; Start copies them to Postgres, which holds the history and seeds the
+// counters again when Redis loses them.
+type UsageMeter struct {
+	db          *gorm.DB
+	redisClient *redis.Client
+	cfg         config.BillingConfig
+}
+
+func NewUsageMeter(db *gorm.DB, redisClient *redis.Client, cfg *config.BillingConfig) *UsageMeter {
+	return &UsageMeter{
+		db:          db,
+		redisClient: redisClient,
+		cfg:         *cfg,
+	}
+}
+
+// IsUsageMetric reports whether a metric is metered
+func IsUsageMetric(metric domain.UsageMetric) bool {
+	for _, known := range usageMetrics {
+		if metric == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Record adds quantity to a usage metric of the tenant in the current period
+func (m *UsageMeter) Record(ctx context.Context, tenantID uuid.UUID, metric domain.UsageMetric, quantity int64) error {
+	if !IsUsageMetric(metric) {
+		return fmt.Errorf("%w: %s", ErrUnknownUsageMetric, metric)
+	}
+
+	period, err := m.currentPeriod(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	key := usageKey(tenantID, period.Start)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		err := incrementUsageScript.Run(ctx, m.redisClient, []string{key, usageDirtyKey}, string(metric), quantity).Err()
+		if !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if err := m.seedCounters(ctx, tenantID, period); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("usage counters of tenant %s could not be seeded", tenantID)
+}
+
+// CurrentUsage returns the live counters of the tenant's current period
+func (m *UsageMeter) CurrentUsage(ctx context.Context, tenantID uuid.UUID) (*PeriodUsage, error) {
+	period, err := m.currentPeriod(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	key := usageKey(tenantID, period.Start)
+
+	values, err := m.redisClient.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		if err := m.seedCounters(ctx, tenantID, period); err != nil {
+			return nil, err
+		}
+		if values, err = m.redisClient.HGetAll(ctx, key).Result(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &PeriodUsage{
+		PeriodStart: period.Start,
+		PeriodEnd:   period.End,
+		Metrics:     parseUsageCounters(values),
+	}, nil
+}
+
+// History returns the usage of the tenant's latest periods, newest first; the
+// current period comes from the live counters
+func (m *UsageMeter) History(ctx context.Context, tenantID uuid.UUID) ([]PeriodUsage, error) {
+	current, err := m.CurrentUsage(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	periods := m.cfg.UsageHistoryPeriods
+	if periods <= 0 {
+		periods = 12
+	}
+
+	var records []domain.UsageRecord
+	err = m.db.WithContext(domain.WithTenantID(ctx, tenantID)).
+		Where("tenant_id = ? AND period_start < ?", tenantID, current.PeriodStart).
+		Order("period_start DESC").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	history := []PeriodUsage{*current}
+	byStart := make(map[time.Time]int)
+	for _, record := range records {
+		start := record.PeriodStart.UTC()
+		i, ok := byStart[start]
+		if !ok {
+			if len(history) == periods {
+				break
+			}
+			history = append(history, PeriodUsage{
+				PeriodStart: start,
+				PeriodEnd:   record.PeriodEnd.UTC(),
+				Metrics:     emptyUsageMetrics(),
+			})
+			i = len(history) - 1
+			byStart[start] = i
+		}
+		history[i].Metrics[record.Metric] = record.Value
+	}
+	return history, nil
+}
+
+// Start flushes the usage counters to Postgres every interval until ctx is
+// cancelled, and once more on the way out
+func (m *UsageMeter) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger.Info("Usage meter started", zap.Duration("flushInterval", interval))
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := m.Flush(context.WithoutCancel(ctx)); err != nil {
+				logger.Error("Usage flush failed", zap.Error(err))
+			}
+			logger.Info("Usage meter stopped")
+			return
+		case <-ticker.C:
+			if err := m.Flush(ctx); err != nil {
+				logger.Error("Usage flush failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Flush copies the counters written since the last flush to Postgres. Counters
+// only grow, so a row never goes back to a smaller value.
+func (m *UsageMeter) Flush(ctx context.Context) error {
+	// Counters that failed are flushed again on the next run
+	var failed []interface{}
+	defer func() {
+		if len(failed) > 0 {
+			m.redisClient.SAdd(ctx, usageDirtyKey, failed...)
+		}
+	}()
+
+	for {
+		keys, err := m.redisClient.SPopN(ctx, usageDirtyKey, usageFlushBatch).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		for _, key := range keys {
+			if err := m.flushKey(ctx, key); err != nil {
+				logger.Error("Failed to flush usage counters",
+					zap.String("key", key),
+					zap.Error(err))
+				failed = append(failed, key)
+			}
+		}
+	}
+}
+
+func (m *UsageMeter) flushKey(ctx context.Context, key string) error {
+	tenantID, periodStart, err := parseUsageKey(key)
+	if err != nil {
+		// Not a counter this version wrote, there is nothing to retry
+		logger.Warn("Skipping invalid usage key", zap.String("key", key), zap.Error(err))
+		return nil
+	}
+
+	values, err := m.redisClient.HGetAll(ctx, key).Result()
+	if err != nil || len(values) == 0 {
+		return err
+	}
+	endUnix, err := strconv.ParseInt(values[usagePeriodEndField], 10, 64)
+	if err != nil {
+		return fmt.Errorf("usage counters %s have no period end", key)
+	}
+	periodEnd := time.Unix(endUnix, 0).UTC()
+
+	metrics := parseUsageCounters(values)
+	records := make([]domain.UsageRecord, 0, len(metrics))
+	for metric, value := range metrics {
+		records = append(records, domain.UsageRecord{
+			TenantID:    tenantID,
+			PeriodStart: periodStart,
+			PeriodEnd:   periodEnd,
+			Metric:      metric,
+			Value:       value,
+		})
+	}
+
+	scoped := m.db.WithContext(domain.WithTenantID(ctx, tenantID))
+	err = scoped.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "tenant_id"}, {Name: "period_start"}, {Name: "metric"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "value"}, Value: gorm.Expr("GREATEST(usage_records.value, EXCLUDED.value)")},
+			{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("EXCLUDED.updated_at")},
+		},
+	}).Create(&records).Error
+	if err != nil {
+		return err
+	}
+
+	// The subscription carries the usage of the period being billed
+	if now := time.Now(); !periodStart.After(now) && periodEnd.After(now) {
+		usage := make(map[string]int, len(metrics))
+		for metric, value := range metrics {
+			usage[string(metric)] = int(value)
+		}
+		err = scoped.Model(&domain.Subscription{}).Where("tenant_id = ?", tenantID).
+			Select("usage").Updates(&domain.Subscription{Usage: usage}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedCounters creates the counters of a period from the values last flushed to
+// Postgres; counters created concurrently are left as they are
+func (m *UsageMeter) seedCounters(ctx context.Context, tenantID uuid.UUID, period billingPeriod) error {
+	var records []domain.UsageRecord
+	err := m.db.WithContext(domain.WithTenantID(ctx, tenantID)).
+		Where("tenant_id = ? AND period_start = ?", tenantID, period.Start).
+		Find(&records).Error
+	if err != nil {
+		return err
+	}
+
+	key := usageKey(tenantID, period.Start)
+	pipe := m.redisClient.TxPipeline()
+	for _, record := range records {
+		pipe.HSetNX(ctx, key, string(record.Metric), record.Value)
+	}
+	pipe.HSetNX(ctx, key, usagePeriodEndField, period.End.Unix())
+	pipe.ExpireAt(ctx, key, period.End.Add(usageCounterRetention))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// currentPeriod returns the usage period of the tenant containing now, cached
+// until it ends
+func (m *UsageMeter) currentPeriod(ctx context.Context, tenantID uuid.UUID) (billingPeriod, error) {
+	now := time.Now()
+	cacheKey := usagePeriodKeyPrefix + tenantID.String()
+	if cached, err := m.redisClient.Get(ctx, cacheKey).Bytes(); err == nil {
+		var period billingPeriod
+		if err := json.Unmarshal(cached, &period); err == nil && !period.Start.After(now) && period.End.After(now) {
+			return period, nil
+		}
+	}
+
+	var subscription domain.Subscription
+	err := m.db.WithContext(domain.WithTenantID(ctx, tenantID)).Where("tenant_id = ?", tenantID).First(&subscription).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return billingPeriod{}, err
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Tenants without a subscription are metered by calendar month
+		subscription.StartDate = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	period := usagePeriod(&subscription, now)
+	if data, err := json.Marshal(period); err == nil {
+		m.redisClient.Set(ctx, cacheKey, data, period.End.Sub(now))
+	}
+	return period, nil
+}
+
+// usagePeriod returns the monthly usage period containing now. Periods follow the
+// billing anchor, the end of the trial or else the start of the subscription, so
+// monthly plans are metered by billing period and yearly plans month by month.
+func usagePeriod(subscription *domain.Subscription, now time.Time) billingPeriod {
+	anchor := subscription.StartDate.UTC()
+	if trialEnd := subscription.TrialEndsAt; trialEnd != nil && trialEnd.After(anchor) && !trialEnd.After(now) {
+		anchor = trialEnd.UTC()
+	}
+
+	// Months are added to the anchor, not chained, so that day 31 anchors keep their day
+	for i := 0; ; i++ {
+		start, end := addMonths(anchor, i), addMonths(anchor, i+1)
+		if end.After(now) {
+			return billingPeriod{Start: start, End: end}
+		}
+	}
+}
+
+func usageKey(tenantID uuid.UUID, periodStart time.Time) string {
+	return fmt.Sprintf("%s%s:%d", usageKeyPrefix, tenantID, periodStart.Unix())
+}
+
+func parseUsageKey(key string) (uuid.UUID, time.Time, error) {
+	parts := strings.Split(strings.TrimPrefix(key, usageKeyPrefix), ":")
+	if len(parts) != 2 {
+		return uuid.Nil, time.Time{}, fmt.Errorf("invalid usage key %q", key)
+	}
+	tenantID, err := uuid.Parse(parts[0])
+	if err != nil {
+		return uuid.Nil, time.Time{}, fmt.Errorf("invalid usage key %q: %w", key, err)
+	}
+	start, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return uuid.Nil, time.Time{}, fmt.Errorf("invalid usage key %q: %w", key, err)
+	}
+	return tenantID, time.Unix(start, 0).UTC(), nil
+}
+
+func parseUsageCounters(values map[string]string) map[domain.UsageMetric]int64 {
+	metrics := emptyUsageMetrics()
+	for field, raw := range values {
+		metric := domain.UsageMetric(field)
+		if !IsUsageMetric(metric) {
+			continue
+		}
+		if value, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			metrics[metric] = value
+		}
+	}
+	return metrics
+}
+
+func emptyUsageMetrics() map[domain.UsageMetric]int64 {
+	metrics := make(map[domain.UsageMetric]int64, len(usageMetrics))
+	for _, metric := range usageMetrics {
+		metrics[metric] = 0
+	}
+	return metrics
+}
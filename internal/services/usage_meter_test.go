@@ -0,0 +1,43 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/domain"
+)
+
+func TestUsagePeriod(t *testing.T) {
+	start := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	trialEnd := start.AddDate(0, 0, 14)
+	subscription := &domain.Subscription{StartDate: start, TrialEndsAt: &trialEnd}
+
+	// During the trial the period follows the start date
+	period := usagePeriod(subscription, start.AddDate(0, 0, 3))
+	if !period.Start.Equal(start) || !period.End.Equal(start.AddDate(0, 1, 0)) {
+		t.Errorf("trial period = %v", period)
+	}
+
+	// After the trial it is anchored on the trial end
+	period = usagePeriod(subscription, trialEnd.AddDate(0, 1, 2))
+	if !period.Start.Equal(trialEnd.AddDate(0, 1, 0)) || !period.End.Equal(trialEnd.AddDate(0, 2, 0)) {
+		t.Errorf("paid period = %v", period)
+	}
+}
+
+func TestParseUsageKey(t *testing.T) {
+	tenantID := uuid.New()
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	gotTenant, gotStart, err := parseUsageKey(usageKey(tenantID, start))
+	if err != nil || gotTenant != tenantID || !gotStart.Equal(start) {
+		t.Errorf("parseUsageKey = %v, %v, %v", gotTenant, gotStart, err)
+	}
+
+	for _, key := range []string{"usage:dirty", "usage:not-a-uuid:1", "usage:" + tenantID.String() + ":x"} {
+		if _, _, err := parseUsageKey(key); err == nil {
+			t.Errorf("parseUsageKey(%q) succeeded", key)
+		}
+	}
+}
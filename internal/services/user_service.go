@@ -0,0 +1,485 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezone validation must not depend on the host zoneinfo
+
+	"github.com/google/uuid"
+	"github.com/opiagile/direito-lux/internal/auth"
+	"github.com/opiagile/direito-lux/internal/domain"
+	"github.com/opiagile/direito-lux/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidProfileData = errors.New("invalid profile data")
+	ErrInvalidPassword    = errors.New("current password is incorrect")
+	ErrPasswordUnchanged  = errors.New("new password must differ from the current one")
+	ErrUserEmailExists    = errors.New("user email already exists")
+	ErrUserLimitReached   = errors.New("plan user limit reached")
+	ErrLastAdmin          = errors.New("tenant must keep at least one active admin")
+	ErrInvalidUserStatus  = errors.New("invalid user status transition")
+)
+
+var (
+	supportedLanguages    = map[string]bool{"pt-BR": true, "en-US": true, "es-ES": true}
+	supportedDateFormats  = map[string]bool{"DD/MM/YYYY": true, "MM/DD/YYYY": true, "YYYY-MM-DD": true}
+	supportedEmailDigests = map[string]bool{"daily": true, "weekly": true, "never": true}
+)
+
+type UserService struct {
+	db               *gorm.DB
+	identityProvider auth.IdentityProvider
+}
+
+func NewUserService(db *gorm.DB, identityProvider auth.IdentityProvider) *UserService {
+	return &UserService{
+		db:               db,
+		identityProvider: identityProvider,
+	}
+}
+
+type UpdateProfileRequest struct {
+	FirstName   *string                   `json:"first_name,omitempty" binding:"omitempty,min=1,max=100"`
+	LastName    *string                   `json:"last_name,omitempty" binding:"omitempty,min=1,max=100"`
+	Preferences *UpdatePreferencesRequest `json:"preferences,omitempty"`
+}
+
+type UpdatePreferencesRequest struct {
+	Language        *string `json:"language,omitempty"`
+	Timezone        *string `json:"timezone,omitempty"`
+	DateFormat      *string `json:"date_format,omitempty"`
+	NotificationsOn *bool   `json:"notifications_on,omitempty"`
+	EmailDigest     *string `json:"email_digest,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+	TOTP            string `json:"totp,omitempty"` // required for users with an authenticator app
+}
+
+// GetProfile retrieves the user, with tenant and preferences, by Keycloak subject
+func (us *UserService) GetProfile(ctx context.Context, keycloakID string) (*domain.User, error) {
+	var user domain.User
+	err := us.db.WithContext(ctx).Preload("Tenant").Where("keycloak_id = ?", keycloakID).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateProfile updates name and preferences, keeping the name in sync with Keycloak
+func (us *UserService) UpdateProfile(ctx context.Context, keycloakID string, req *UpdateProfileRequest) (*domain.User, error) {
+	user, err := us.GetProfile(ctx, keycloakID)
+	if err != nil {
+		return nil, err
+	}
+
+	preferences := user.Preferences
+	if req.Preferences != nil {
+		if err := applyPreferences(&preferences, req.Preferences); err != nil {
+			return nil, err
+		}
+	}
+
+	firstName, lastName := user.FirstName, user.LastName
+	if req.FirstName != nil {
+		firstName = strings.TrimSpace(*req.FirstName)
+	}
+	if req.LastName != nil {
+		lastName = strings.TrimSpace(*req.LastName)
+	}
+	if firstName == "" || lastName == "" {
+		return nil, fmt.Errorf("%w: name cannot be empty", ErrInvalidProfileData)
+	}
+
+	// Keycloak owns the identity, update it first so a failure leaves both sides unchanged
+	if firstName != user.FirstName || lastName != user.LastName {
+		kcUser, err := us.identityProvider.GetUser(ctx, keycloakID)
+		if err != nil {
+			return nil, err
+		}
+		kcUser.FirstName = &firstName
+		kcUser.LastName = &lastName
+		if err := us.identityProvider.UpdateUser(ctx, keycloakID, *kcUser); err != nil {
+			return nil, err
+		}
+	}
+
+	user.FirstName = firstName
+	user.LastName = lastName
+	user.Preferences = preferences
+	if err := us.db.WithContext(ctx).Model(user).Select("first_name", "last_name", "preferences").Updates(user).Error; err != nil {
+		logger.Error("Profile updated in Keycloak but not in database",
+			zap.String("keycloakID", keycloakID),
+			zap.Error(err))
+		return nil, err
+	}
+
+	audit := &domain.AuditLog{
+		TenantID:   user.TenantID,
+		UserID:     user.ID,
+		Action:     "user.profile_updated",
+		Resource:   "user",
+		ResourceID: user.ID.String(),
+	}
+	us.db.WithContext(ctx).Create(audit)
+
+	return us.GetProfile(ctx, keycloakID)
+}
+
+// ChangePassword verifies the current password and sets a new one in Keycloak
+func (us *UserService) ChangePassword(ctx context.Context, keycloakID string, req *ChangePasswordRequest) error {
+	user, err := us.GetProfile(ctx, keycloakID)
+	if err != nil {
+		return err
+	}
+
+	if req.CurrentPassword == req.NewPassword {
+		return ErrPasswordUnchanged
+	}
+
+	if err := us.identityProvider.VerifyPassword(ctx, user.Email, req.CurrentPassword, req.TOTP); err != nil {
+		return ErrInvalidPassword
+	}
+
+	if err := us.identityProvider.SetUserPassword(ctx, keycloakID, req.NewPassword, false); err != nil {
+		return err
+	}
+
+	audit := &domain.AuditLog{
+		TenantID:   user.TenantID,
+		UserID:     user.ID,
+		Action:     "user.password_changed",
+		Resource:   "user",
+		ResourceID: user.ID.String(),
+	}
+	us.db.WithContext(ctx).Create(audit)
+
+	return nil
+}
+
+type InviteUserRequest struct {
+	Email     string          `json:"email" binding:"required,email"`
+	FirstName string          `json:"first_name" binding:"required"`
+	LastName  string          `json:"last_name" binding:"required"`
+	Role      domain.UserRole `json:"role" binding:"required,oneof=admin lawyer secretary client"`
+}
+
+type ListUsersFilter struct {
+	Role   string
+	Status string
+	Search string
+	Offset int
+	Limit  int
+}
+
+// InviteUser creates an invited user in the tenant, enforcing the plan's MaxUsers
+func (us *UserService) InviteUser(ctx context.Context, tenantID uuid.UUID, actorID string, req *InviteUserRequest) (*domain.User, error) {
+	var tenant domain.Tenant
+	if err := us.db.WithContext(ctx).Preload("Plan").First(&tenant, tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	var existing int64
+	// Emails are unique across tenants
+	us.db.WithContext(domain.CrossTenant(ctx)).Model(&domain.User{}).Where("email = ?", email).Count(&existing)
+	if existing > 0 {
+		return nil, ErrUserEmailExists
+	}
+
+	if err := us.ensureSeatAvailable(ctx, &tenant); err != nil {
+		return nil, err
+	}
+
+	keycloakID, err := us.identityProvider.InviteUser(ctx, email, req.FirstName, req.LastName, tenant.KeycloakGroupID, string(req.Role))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user in Keycloak: %w", err)
+	}
+
+	user := &domain.User{
+		KeycloakID: keycloakID,
+		TenantID:   tenantID,
+		Email:      email,
+		FirstName:  req.FirstName,
+		LastName:   req.LastName,
+		Role:       req.Role,
+		Status:     domain.UserStatusInvited,
+		Preferences: domain.UserPreferences{
+			Language:        tenant.Settings.Language,
+			Timezone:        tenant.Settings.Timezone,
+			NotificationsOn: true,
+		},
+	}
+
+	if err := us.db.WithContext(ctx).Create(user).Error; err != nil {
+		// Do not leave an orphaned Keycloak user behind
+		if deleteErr := us.identityProvider.DeleteUser(ctx, keycloakID); deleteErr != nil {
+			logger.Error("Failed to remove Keycloak user after database failure",
+				zap.String("keycloakID", keycloakID),
+				zap.Error(deleteErr))
+		}
+		return nil, fmt.Errorf("failed to create user record: %w", err)
+	}
+
+	if tenant.Settings.MFA.AppliesTo(string(req.Role)) {
+		if _, err := us.identityProvider.SetTOTPRequired(ctx, keycloakID, true); err != nil {
+			logger.Warn("Failed to require TOTP for invited user",
+				zap.String("keycloakID", keycloakID),
+				zap.Error(err))
+		}
+	}
+
+	us.audit(ctx, tenantID, "user.invited", user, map[string]interface{}{
+		"role":  req.Role,
+		"actor": actorID,
+	})
+
+	return user, nil
+}
+
+// ListUsers lists tenant users with optional role, status and name/email filters
+func (us *UserService) ListUsers(ctx context.Context, tenantID uuid.UUID, filter ListUsersFilter) ([]*domain.User, int64, error) {
+	var users []*domain.User
+	var total int64
+
+	query := us.db.WithContext(ctx).Model(&domain.User{}).Where("tenant_id = ?", tenantID)
+
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Search != "" {
+		pattern := "%" + strings.ToLower(filter.Search) + "%"
+		query = query.Where("LOWER(email) LIKE ? OR LOWER(first_name) LIKE ? OR LOWER(last_name) LIKE ?", pattern, pattern, pattern)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Offset(filter.Offset).
+		Limit(filter.Limit).
+		Order("created_at DESC").
+		Find(&users).Error
+
+	return users, total, err
+}
+
+// ChangeUserRole reassigns the user's role in Keycloak and in the database
+func (us *UserService) ChangeUserRole(ctx context.Context, tenantID, userID uuid.UUID, actorID string, role domain.UserRole) (*domain.User, error) {
+	user, err := us.getTenantUser(ctx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Role == role {
+		return user, nil
+	}
+
+	if user.Role == domain.UserRoleAdmin {
+		if err := us.ensureAnotherAdmin(ctx, tenantID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := us.identityProvider.ChangeUserRole(ctx, user.KeycloakID, string(user.Role), string(role)); err != nil {
+		return nil, err
+	}
+
+	previousRole := user.Role
+	if err := us.db.WithContext(ctx).Model(user).Update("role", role).Error; err != nil {
+		return nil, err
+	}
+
+	// The new role may fall under the tenant MFA policy
+	var tenant domain.Tenant
+	if err := us.db.WithContext(ctx).Select("id", "settings").First(&tenant, tenantID).Error; err == nil {
+		required := tenant.Settings.MFA.AppliesTo(string(role))
+		if _, err := us.identityProvider.SetTOTPRequired(ctx, user.KeycloakID, required); err != nil {
+			logger.Warn("Failed to update TOTP required action",
+				zap.String("userID", user.ID.String()),
+				zap.Error(err))
+		}
+	}
+
+	us.audit(ctx, tenantID, "user.role_changed", user, map[string]interface{}{
+		"from":  previousRole,
+		"to":    role,
+		"actor": actorID,
+	})
+
+	return user, nil
+}
+
+// BlockUser disables the user in Keycloak and marks it blocked
+func (us *UserService) BlockUser(ctx context.Context, tenantID, userID uuid.UUID, actorID string) (*domain.User, error) {
+	user, err := us.getTenantUser(ctx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Status == domain.UserStatusBlocked {
+		return user, nil
+	}
+
+	if user.Role == domain.UserRoleAdmin {
+		if err := us.ensureAnotherAdmin(ctx, tenantID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	return us.setUserStatus(ctx, user, domain.UserStatusBlocked, actorID)
+}
+
+// ReactivateUser enables a blocked or inactive user again
+func (us *UserService) ReactivateUser(ctx context.Context, tenantID, userID uuid.UUID, actorID string) (*domain.User, error) {
+	user, err := us.getTenantUser(ctx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Status != domain.UserStatusBlocked && user.Status != domain.UserStatusInactive {
+		return nil, ErrInvalidUserStatus
+	}
+
+	// Reactivated users take a seat again
+	var tenant domain.Tenant
+	if err := us.db.WithContext(ctx).Preload("Plan").First(&tenant, tenantID).Error; err != nil {
+		return nil, err
+	}
+	if err := us.ensureSeatAvailable(ctx, &tenant); err != nil {
+		return nil, err
+	}
+
+	return us.setUserStatus(ctx, user, domain.UserStatusActive, actorID)
+}
+
+func (us *UserService) setUserStatus(ctx context.Context, user *domain.User, status domain.UserStatus, actorID string) (*domain.User, error) {
+	if err := us.identityProvider.SetUserEnabled(ctx, user.KeycloakID, status == domain.UserStatusActive); err != nil {
+		return nil, err
+	}
+
+	previousStatus := user.Status
+	if err := us.db.WithContext(ctx).Model(user).Update("status", status).Error; err != nil {
+		return nil, err
+	}
+
+	us.audit(ctx, user.TenantID, "user.status_changed", user, map[string]interface{}{
+		"from":  previousStatus,
+		"to":    status,
+		"actor": actorID,
+	})
+
+	return user, nil
+}
+
+// ensureSeatAvailable checks one more active or invited user fits the plan's MaxUsers
+func (us *UserService) ensureSeatAvailable(ctx context.Context, tenant *domain.Tenant) error {
+	var seats int64
+	err := us.db.WithContext(ctx).Model(&domain.User{}).
+		Where("tenant_id = ? AND status IN ?", tenant.ID, []domain.UserStatus{domain.UserStatusActive, domain.UserStatusInvited}).
+		Count(&seats).Error
+	if err != nil {
+		return err
+	}
+	if !domain.WithinLimit(seats+1, tenant.Plan.Limits.MaxUsers) {
+		return ErrUserLimitReached
+	}
+	return nil
+}
+
+// ensureAnotherAdmin refuses changes that would leave the tenant without an active admin
+func (us *UserService) ensureAnotherAdmin(ctx context.Context, tenantID, exceptUserID uuid.UUID) error {
+	var admins int64
+	err := us.db.WithContext(ctx).Model(&domain.User{}).
+		Where("tenant_id = ? AND role = ? AND status = ? AND id <> ?", tenantID, domain.UserRoleAdmin, domain.UserStatusActive, exceptUserID).
+		Count(&admins).Error
+	if err != nil {
+		return err
+	}
+	if admins == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+func (us *UserService) getTenantUser(ctx context.Context, tenantID, userID uuid.UUID) (*domain.User, error) {
+	var user domain.User
+	err := us.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", userID, tenantID).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (us *UserService) audit(ctx context.Context, tenantID uuid.UUID, action string, user *domain.User, details map[string]interface{}) {
+	audit := &domain.AuditLog{
+		TenantID:   tenantID,
+		UserID:     user.ID,
+		Action:     action,
+		Resource:   "user",
+		ResourceID: user.ID.String(),
+		Details:    details,
+	}
+	if err := us.db.WithContext(ctx).Create(audit).Error; err != nil {
+		logger.Error("Failed to create audit log", zap.Error(err))
+	}
+}
+
+// applyPreferences validates and applies preference changes
+func applyPreferences(preferences *domain.UserPreferences, req *UpdatePreferencesRequest) error {
+	if req.Language != nil {
+		if !supportedLanguages[*req.Language] {
+			return fmt.Errorf("%w: unsupported language %q", ErrInvalidProfileData, *req.Language)
+		}
+		preferences.Language = *req.Language
+	}
+
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" || *req.Timezone == "Local" {
+			return fmt.Errorf("%w: unknown timezone %q", ErrInvalidProfileData, *req.Timezone)
+		}
+		preferences.Timezone = *req.Timezone
+	}
+
+	if req.DateFormat != nil {
+		if !supportedDateFormats[*req.DateFormat] {
+			return fmt.Errorf("%w: unsupported date format %q", ErrInvalidProfileData, *req.DateFormat)
+		}
+		preferences.DateFormat = *req.DateFormat
+	}
+
+	if req.EmailDigest != nil {
+		if !supportedEmailDigests[*req.EmailDigest] {
+			return fmt.Errorf("%w: email digest must be daily, weekly or never", ErrInvalidProfileData)
+		}
+		preferences.EmailDigest = *req.EmailDigest
+	}
+
+	if req.NotificationsOn != nil {
+		preferences.NotificationsOn = *req.NotificationsOn
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestDocumentCrossReferences(t *testing.T) {
+	doc := New()
+	doc.Text(50, 70, HelveticaBold, 20, "Fatura (nº 1)")
+	doc.AddPage()
+	doc.Line(50, 100, 500, 100, 0.5)
+	out := doc.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatal("missing PDF header or trailer")
+	}
+	if !bytes.Contains(out, []byte(`(Fatura \(n`+"\xba"+` 1\))`)) {
+		t.Error("text not escaped in WinAnsiEncoding")
+	}
+
+	// Every object offset in the xref table must point at the object
+	xref := bytes.Index(out, []byte("\nxref\n")) + 1
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	if len(entries) != 8 {
+		t.Fatalf("xref has %d objects, want 8", len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if want := fmt.Sprintf("%d 0 obj", i+1); !bytes.HasPrefix(out[offset:], []byte(want)) {
+			t.Errorf("object %d offset %d does not point at it", i+1, offset)
+		}
+	}
+	if !bytes.Contains(out, []byte(fmt.Sprintf("startxref\n%d\n", xref))) {
+		t.Error("startxref does not point at the xref table")
+	}
+}
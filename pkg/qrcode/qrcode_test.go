@@ -0,0 +1,118 @@
+package qrcode
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at 1-M, from the worked example of the specification
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := reedSolomonRemainder(data, reedSolomonDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("reedSolomonRemainder() = %v, want %v", got, want)
+	}
+}
+
+func TestBlockLayout(t *testing.T) {
+	for version := 1; version <= len(levelM); version++ {
+		// Modules left for codewords once the function patterns are drawn
+		raw := (16*version+128)*version + 64
+		if version >= 2 {
+			count := version/7 + 2
+			raw -= (25*count-10)*count - 55
+			if version >= 7 {
+				raw -= 36
+			}
+		}
+
+		layout := levelM[version-1]
+		total := layout.dataCodewords() + (layout.blocks1+layout.blocks2)*layout.ecPerBlock
+		if total != raw/8 {
+			t.Errorf("version %d: layout holds %d codewords, want %d", version, total, raw/8)
+		}
+	}
+}
+
+func TestAlignmentPositions(t *testing.T) {
+	tests := map[int][]int{
+		2:  {6, 18},
+		7:  {6, 22, 38},
+		14: {6, 26, 46, 66},
+		20: {6, 34, 62, 90},
+	}
+	for version, want := range tests {
+		if got := alignmentPositions(version); !reflect.DeepEqual(got, want) {
+			t.Errorf("alignmentPositions(%d) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	payload := []byte("00020101021226830014br.gov.bcb.pix2561qrpix.example.com/qr/v2/cobv/9d36b84f-c70b-478f-b95c-12729b90ca255204000053039865406123.455802BR5905Teste6008BRASILIA62070503***6304ABCD")
+	code, err := Encode(payload)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	// Read the format information back and undo the mask
+	var format int
+	for i := 14; i >= 9; i-- {
+		format = format<<1 | bit(code.Dark(14-i, 8))
+	}
+	format = format<<1 | bit(code.Dark(7, 8))
+	format = format<<1 | bit(code.Dark(8, 8))
+	format = format<<1 | bit(code.Dark(8, 7))
+	for i := 5; i >= 0; i-- {
+		format = format<<1 | bit(code.Dark(8, i))
+	}
+	format ^= 0x5412
+	if level := format >> 13; level != 0 {
+		t.Fatalf("error correction level bits = %b, want 00 (M)", level)
+	}
+	mask := format >> 10 & 7
+	code.applyMask(mask)
+
+	// Read the codewords back in placement order
+	var bits bitBuffer
+	for right := code.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < code.Size; vert++ {
+			y := vert
+			if upward {
+				y = code.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				if x := right - j; !code.reserved[y][x] {
+					bits = append(bits, code.modules[y][x])
+				}
+			}
+		}
+	}
+	read := bits.bytes()
+
+	want := interleave(code.Version, encodeData(code.Version, payload))
+	if !bytes.Equal(read[:len(want)], want) {
+		t.Error("codewords read back differ from the codewords encoded")
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	if _, err := Encode(make([]byte, 667)); err != ErrTooLong {
+		t.Errorf("Encode() error = %v, want ErrTooLong", err)
+	}
+	if _, err := Encode(make([]byte, 666)); err != nil {
+		t.Errorf("Encode() of the version 20 capacity error = %v", err)
+	}
+}
+
+func bit(dark bool) int {
+	if dark {
+		return 1
+	}
+	return 0
+}
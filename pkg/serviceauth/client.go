@@ -0,0 +1,204 @@
+// Package serviceauth calls other Direito Lux services with OAuth2 client
+// credentials tokens, fetched from Keycloak and cached until shortly before expiry.
+package serviceauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TenantNameHeader carries the name (not the ID) of the tenant a service acts for;
+// the API only honours it for service principals whose client is registered with
+// the route scope and the tenant
+const TenantNameHeader = "X-Tenant-Name"
+
+var ErrTokenRequest = errors.New("service token request failed")
+
+// Config holds the client credentials of the calling service
+type Config struct {
+	TokenURL     string // e.g. http://keycloak:8080/realms/direito-lux/protocol/openid-connect/token
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// RefreshBefore renews the token this long before it expires (default 30s)
+	RefreshBefore time.Duration
+	// HTTPClient is used for token requests (default: 10s timeout client)
+	HTTPClient *http.Client
+}
+
+// KeycloakTokenURL builds the token endpoint of a Keycloak realm
+func KeycloakTokenURL(baseURL, realm string) string {
+	return fmt.Sprintf("%s/realms/%s/protocol/openid-connect/token", strings.TrimRight(baseURL, "/"), realm)
+}
+
+// TokenSource fetches client credentials tokens and caches them until they are
+// about to expire. It is safe for concurrent use.
+type TokenSource struct {
+	cfg Config
+
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func NewTokenSource(cfg Config) *TokenSource {
+	if cfg.RefreshBefore <= 0 {
+		cfg.RefreshBefore = 30 * time.Second
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &TokenSource{cfg: cfg}
+}
+
+// Token returns the cached access token, fetching a new one when it is missing
+// or about to expire
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != "" && time.Now().Add(s.cfg.RefreshBefore).Before(s.expiresAt) {
+		return s.token, nil
+	}
+
+	token, expiresIn, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	s.token = token
+	s.expiresAt = time.Now().Add(expiresIn)
+	return s.token, nil
+}
+
+// Invalidate drops the cached token, e.g. after the callee rejected it
+func (s *TokenSource) Invalidate() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.token = ""
+	s.expiresAt = time.Time{}
+}
+
+func (s *TokenSource) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {s.cfg.ClientID},
+		"client_secret": {s.cfg.ClientSecret},
+	}
+	if len(s.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.cfg.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %v", ErrTokenRequest, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", 0, fmt.Errorf("%w: status %d: %s", ErrTokenRequest, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var payload struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return "", 0, fmt.Errorf("%w: %v", ErrTokenRequest, err)
+	}
+	if payload.AccessToken == "" {
+		return "", 0, fmt.Errorf("%w: empty access token", ErrTokenRequest)
+	}
+
+	return payload.AccessToken, time.Duration(payload.ExpiresIn) * time.Second, nil
+}
+
+type tenantContextKey struct{}
+
+// WithTenant makes requests sent with ctx act for the tenant
+func WithTenant(ctx context.Context, tenantName string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantName)
+}
+
+// Transport adds the service token, and the tenant from the request context,
+// to outgoing requests. A 401 drops the cached token and retries once.
+type Transport struct {
+	Source *TokenSource
+	Base   http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// The token may have been revoked or the realm keys rotated; bodies that
+	// cannot be replayed are not retried
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+
+	resp.Body.Close()
+	t.Source.Invalidate()
+	return t.send(retry)
+}
+
+func (t *Transport) send(req *http.Request) (*http.Response, error) {
+	token, err := t.Source.Token(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	// RoundTrippers must not modify the caller's request
+	out := req.Clone(req.Context())
+	out.Header.Set("Authorization", "Bearer "+token)
+	if tenantName, ok := req.Context().Value(tenantContextKey{}).(string); ok && tenantName != "" {
+		out.Header.Set(TenantNameHeader, tenantName)
+	}
+
+	return t.base().RoundTrip(out)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// NewClient returns an HTTP client that authenticates with the service token
+func NewClient(source *TokenSource, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &Transport{Source: source},
+		Timeout:   timeout,
+	}
+}
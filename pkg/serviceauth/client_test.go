@@ -0,0 +1,116 @@
+package serviceauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTokenServer(t *testing.T, expiresIn int, issued *int32) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "consulta-service" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		n := atomic.AddInt32(issued, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("token-%d", n),
+			"expires_in":   expiresIn,
+		})
+	}))
+}
+
+func TestTokenSourceCachesUntilExpiry(t *testing.T) {
+	var issued int32
+	server := newTokenServer(t, 300, &issued)
+	defer server.Close()
+
+	source := NewTokenSource(Config{TokenURL: server.URL, ClientID: "consulta-service", ClientSecret: "secret"})
+
+	for i := 0; i < 3; i++ {
+		token, err := source.Token(context.Background())
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if token != "token-1" {
+			t.Fatalf("Token() = %q, want cached token-1", token)
+		}
+	}
+
+	source.Invalidate()
+	if token, _ := source.Token(context.Background()); token != "token-2" {
+		t.Fatalf("Token() after Invalidate = %q, want token-2", token)
+	}
+}
+
+func TestTokenSourceRefreshesBeforeExpiry(t *testing.T) {
+	var issued int32
+	// Expires within the refresh margin, so every call fetches a new token
+	server := newTokenServer(t, 10, &issued)
+	defer server.Close()
+
+	source := NewTokenSource(Config{TokenURL: server.URL, ClientID: "consulta-service", RefreshBefore: 30 * time.Second})
+	source.Token(context.Background())
+	source.Token(context.Background())
+
+	if got := atomic.LoadInt32(&issued); got != 2 {
+		t.Fatalf("issued %d tokens, want 2", got)
+	}
+}
+
+func TestTokenSourceError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"unauthorized_client"}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	source := NewTokenSource(Config{TokenURL: server.URL, ClientID: "consulta-service"})
+	if _, err := source.Token(context.Background()); err == nil || !strings.Contains(err.Error(), "unauthorized_client") {
+		t.Fatalf("Token() error = %v, want token request error", err)
+	}
+}
+
+func TestTransportRetriesOnceAfterUnauthorized(t *testing.T) {
+	var issued int32
+	tokenServer := newTokenServer(t, 300, &issued)
+	defer tokenServer.Close()
+
+	var calls int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get(TenantNameHeader) != "acme" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// The first token is rejected, as after a key rotation
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer api.Close()
+
+	client := NewClient(NewTokenSource(Config{TokenURL: tokenServer.URL, ClientID: "consulta-service"}), 5*time.Second)
+
+	req, _ := http.NewRequestWithContext(WithTenant(context.Background(), "acme"), http.MethodPost, api.URL, strings.NewReader(`{}`))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if calls != 2 || issued != 2 {
+		t.Fatalf("calls = %d, issued = %d, want 2 and 2", calls, issued)
+	}
+}